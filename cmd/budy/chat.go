@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/ai"
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// chatSession tracks the conversation that ? questions continue
+type chatSession struct {
+	store   *ai.ConversationStore
+	current *ai.Conversation
+
+	// prompt returns the system prompt for the next question
+	prompt func() string
+
+	// redact masks secrets in questions before they are asked and saved
+	redact func(string) string
+}
+
+// newChatSession starts a new conversation saved to store. prompt is
+// called for each question to build its system prompt, and redact masks
+// secrets in each question.
+func newChatSession(store storage.Storage, prompt func() string, redact func(string) string) *chatSession {
+	return &chatSession{
+		store:   ai.NewConversationStore(store),
+		current: ai.NewConversation(),
+		prompt:  prompt,
+		redact:  redact,
+	}
+}
+
+// ask sends a question as the next turn of the current conversation and
+// saves the exchange once answered
+func (c *chatSession) ask(client ai.Client, query string) (*ai.Response, error) {
+	query = c.redact(query)
+	resp, err := askAI(client, c.current.Request(c.prompt(), query))
+	if err != nil {
+		return nil, err
+	}
+
+	c.current.Add(query, resp.Text)
+	if err := c.store.Save(c.current); err != nil {
+		fmt.Printf("Warning: Failed to save conversation: %v\n", err)
+	}
+	return resp, nil
+}
+
+// processChatCommand handles the chat commands for managing conversations
+func processChatCommand(input string, chat *chatSession) {
+	parts := strings.Fields(input)
+	if len(parts) == 1 {
+		printConversation(chat.current)
+		return
+	}
+
+	switch parts[1] {
+	case "new":
+		chat.current = ai.NewConversation()
+		fmt.Println("Started a new conversation")
+
+	case "list":
+		summaries := chat.store.List()
+		if len(summaries) == 0 {
+			fmt.Println("No saved conversations")
+			return
+		}
+		for i, summary := range summaries {
+			marker := " "
+			if summary.ID == chat.current.ID {
+				marker = "*"
+			}
+			fmt.Printf("%s%3d  %s  %-50s  (%d messages)\n", marker, i+1,
+				summary.Updated.Format("2006-01-02 15:04"), summary.Title, summary.Messages)
+		}
+
+	case "resume":
+		if len(parts) < 3 {
+			fmt.Println("Usage: chat resume <number|id>")
+			return
+		}
+		conversation, err := chat.store.Load(parts[2])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		chat.current = conversation
+		printConversation(conversation)
+
+	default:
+		fmt.Println("Usage: chat [new|list|resume <number|id>]")
+	}
+}
+
+// printConversation describes a conversation and shows its latest exchange
+func printConversation(conversation *ai.Conversation) {
+	if len(conversation.Messages) == 0 {
+		fmt.Println("New conversation with no questions yet")
+		return
+	}
+
+	fmt.Printf("Conversation %s: %s (%d messages)\n", conversation.ID, conversation.Title, len(conversation.Messages))
+	messages := conversation.Messages
+	if len(messages) > 2 {
+		messages = messages[len(messages)-2:]
+	}
+	for _, message := range messages {
+		label := "?"
+		if message.Role == ai.RoleAssistant {
+			label = ">"
+		}
+		fmt.Printf("%s %s\n", label, message.Content)
+	}
+}
+
+// printContextPreview shows the system prompt exactly as it will be sent
+// with the next question, with secrets masked the way they are when sent
+func printContextPreview(w io.Writer, chat *chatSession) {
+	fmt.Fprintln(w, "System prompt sent with the next question:")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, chat.redact(chat.prompt()))
+
+	earlier := len(chat.current.Request("", "").Messages) - 1
+	if earlier > 0 {
+		fmt.Fprintf(w, "\nThe question is sent after %d earlier messages of the current conversation.\n", earlier)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/ai"
+	"github.com/sosadtsia/budy/internal/shell"
+)
+
+// commandMode turns ?! requests into shell commands and repairs failed
+// commands with fix, offering to run what the AI suggests. It also
+// explains commands with explain and ??.
+type commandMode struct {
+	// prompt returns the system prompt for the next request
+	prompt func() string
+
+	// reader asks whether to run a command and lets the user edit it
+	reader shell.TerminalReader
+
+	// docs looks up the documentation of a program, or returns nil
+	docs func(program string) *shell.Docs
+}
+
+// newCommandMode creates a command mode. prompt is called for each request
+// to build its system prompt.
+func newCommandMode(prompt func() string, reader shell.TerminalReader, docs func(program string) *shell.Docs) *commandMode {
+	return &commandMode{
+		prompt: prompt,
+		reader: reader,
+		docs:   docs,
+	}
+}
+
+// generate asks the AI for a command that does task, then offers to run
+// it. Ctrl-C stops the request.
+func (m *commandMode) generate(client ai.Client, task string, executor shell.Executor, history shell.HistoryManager) error {
+	fmt.Println("Generating a command...")
+	return m.suggest(client, task, executor, history)
+}
+
+// fix sends the last failed command, how it ended and its error output to
+// the AI, then offers to run the corrected command
+func (m *commandMode) fix(client ai.Client, executor shell.Executor, history shell.HistoryManager) error {
+	failed := ai.LastFailure(history.GetHistory())
+	if failed == nil {
+		fmt.Println("No failed command to fix")
+		return nil
+	}
+
+	fmt.Printf("Fixing: %s\n", failed.Command)
+	return m.suggest(client, ai.FixTask(failed), executor, history)
+}
+
+// suggest asks the AI for a command that does task and offers to run it
+func (m *commandMode) suggest(client ai.Client, task string, executor shell.Executor, history shell.HistoryManager) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	suggestion, err := ai.SuggestCommand(ctx, client, m.prompt(), task)
+	if err != nil {
+		return err
+	}
+	stop()
+
+	m.offer(suggestion, executor, history)
+	return nil
+}
+
+// offer shows a generated command and runs it if the user accepts it,
+// as is or after editing it
+func (m *commandMode) offer(suggestion *ai.CommandSuggestion, executor shell.Executor, history shell.HistoryManager) {
+	printSuggestion(suggestion)
+
+	for {
+		answer, err := shell.ReadReply(m.reader, "Run it? [y]es, [e]dit, [N]o: ")
+		if err != nil {
+			fmt.Println("Cancelled")
+			return
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			runCommand(suggestion.Command, shell.SourceAI, executor, history)
+			return
+
+		case "e", "edit":
+			command, err := shell.EditLine(m.reader, "$ ", suggestion.Command)
+			if err != nil || strings.TrimSpace(command) == "" {
+				fmt.Println("Cancelled")
+				return
+			}
+			runCommand(command, shell.SourceAI, executor, history)
+			return
+
+		case "", "n", "no":
+			fmt.Println("Cancelled")
+			return
+
+		default:
+			fmt.Println("Please answer y to run the command, e to edit it or n to cancel")
+		}
+	}
+}
+
+// printSuggestion shows a generated command with its explanation and risk
+func printSuggestion(suggestion *ai.CommandSuggestion) {
+	fmt.Printf("\n  $ %s\n\n", suggestion.Command)
+	if suggestion.Explanation != "" {
+		fmt.Printf("  %s\n", suggestion.Explanation)
+	}
+	fmt.Printf("  Risk: %s\n", suggestion.Risk)
+	switch suggestion.Risk {
+	case ai.RiskHigh:
+		fmt.Println("  Warning: this command may delete data or make changes that are hard to undo")
+	case ai.RiskUnknown:
+		fmt.Println("  The AI did not rate this command; read it carefully before running it")
+	}
+	fmt.Println()
+}
+
+// reportFinishedJobs tells the user about the jobs that finished since the
+// last prompt and records them in history along with how they finished
+func reportFinishedJobs(executor *shell.ShellExecutor, history shell.HistoryManager) {
+	for _, job := range executor.FinishedJobs() {
+		fmt.Println(job)
+		entry := shell.CommandEntry{
+			Command:   job.Command,
+			Timestamp: job.Started,
+			Directory: job.Dir,
+			Result:    job.Result,
+		}
+		if err := history.RecordEntry(entry); err != nil {
+			fmt.Printf("Warning: Failed to record job in history: %v\n", err)
+		}
+	}
+}
+
+// warnAboutJobs tells the user what becomes of their jobs when budy exits
+func warnAboutJobs(executor *shell.ShellExecutor, dataDir string) {
+	running, stopped := 0, 0
+	for _, job := range executor.Jobs() {
+		switch job.State {
+		case shell.JobRunning:
+			running++
+		case shell.JobStopped:
+			stopped++
+		}
+	}
+	if stopped > 0 {
+		fmt.Printf("Ending %d stopped job(s)\n", stopped)
+	}
+	if running > 0 {
+		fmt.Printf("%d job(s) keep running; the output of jobs started with & is logged in %s\n", running, filepath.Join(dataDir, "jobs"))
+	}
+}
+
+// runCommand executes a command and records it in history along with how
+// it finished. source tells where the command came from when the user
+// didn't type it.
+func runCommand(command, source string, executor shell.Executor, history shell.HistoryManager) {
+	// The directory is captured first since builtins like cd change it
+	entry := shell.CommandEntry{
+		Command:   command,
+		Timestamp: time.Now(),
+		Source:    source,
+	}
+	entry.Directory, _ = os.Getwd()
+
+	// Commands the guard stops never ran, so they aren't recorded
+	result, err := executor.Execute(command)
+	if errors.Is(err, shell.ErrCommandBlocked) {
+		return
+	}
+	if errors.Is(err, shell.ErrCommandDeclined) {
+		fmt.Println("Cancelled")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error executing command: %v\n", err)
+	}
+	// Commands that became jobs are recorded once they finish
+	if result != nil && result.Job > 0 {
+		return
+	}
+	entry.Result = result
+
+	if err := history.RecordEntry(entry); err != nil {
+		fmt.Printf("Warning: Failed to record command in history: %v\n", err)
+	}
+}
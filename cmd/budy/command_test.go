@@ -0,0 +1,214 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/ai"
+	"github.com/sosadtsia/budy/internal/shell"
+)
+
+// scriptedReader answers prompts with prepared lines
+type scriptedReader struct {
+	lines  []string
+	edited []string
+}
+
+func (r *scriptedReader) ReadLine(prompt string) (string, error) {
+	if len(r.lines) == 0 {
+		return "", io.EOF
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	return line, nil
+}
+
+// ReadLineWithText records the text offered for editing
+func (r *scriptedReader) ReadLineWithText(prompt, text string) (string, error) {
+	r.edited = append(r.edited, text)
+	return r.ReadLine(prompt)
+}
+
+// TestCommandMode tests running, editing and cancelling generated commands
+func TestCommandMode(t *testing.T) {
+	reply := "```json\n" + `{"command":"find . -name '*.go' -mtime -7","explanation":"Finds Go files changed in the last week","risk":"low"}` + "\n```"
+
+	tests := []struct {
+		name     string
+		answers  []string
+		expected []string
+	}{
+		{"run", []string{"y"}, []string{"find . -name '*.go' -mtime -7"}},
+		{"edit", []string{"e", "find . -name '*.go' -mtime -1"}, []string{"find . -name '*.go' -mtime -1"}},
+		{"cancel", []string{"n"}, nil},
+		{"default is no", []string{""}, nil},
+		{"end of input", nil, nil},
+		{"edit to nothing", []string{"e", ""}, nil},
+		{"asks again", []string{"maybe", "yes"}, []string{"find . -name '*.go' -mtime -7"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAI := &MockAIClient{reply: reply}
+			executor := &MockExecutor{}
+			history := &MockHistoryManager{}
+			reader := &scriptedReader{lines: tt.answers}
+			commands := newCommandMode(func() string { return ai.CommandSystemPrompt }, reader, noDocs)
+
+			if err := commands.generate(mockAI, "find go files changed this week", executor, history); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if len(executor.executedCommands) != len(tt.expected) {
+				t.Fatalf("Expected %v to run, got %v", tt.expected, executor.executedCommands)
+			}
+			for i, command := range tt.expected {
+				if executor.executedCommands[i] != command {
+					t.Errorf("Expected %q to run, got %q", command, executor.executedCommands[i])
+				}
+				entry := history.entries[i]
+				if entry.Command != command || entry.Source != shell.SourceAI || entry.Result == nil {
+					t.Errorf("Expected %q recorded as AI-generated with its result, got %+v", command, entry)
+				}
+			}
+
+			if req := mockAI.requests[0]; !req.JSON || req.LastUserMessage() != "find go files changed this week" {
+				t.Errorf("Expected a JSON request for the task, got %+v", req)
+			}
+		})
+	}
+}
+
+// TestCommandModeBadReply tests that prose answers are reported, not run
+func TestCommandModeBadReply(t *testing.T) {
+	mockAI := &MockAIClient{reply: "Use find with -mtime"}
+	executor := &MockExecutor{}
+	commands := newCommandMode(func() string { return ai.CommandSystemPrompt }, &scriptedReader{lines: []string{"y"}}, noDocs)
+
+	if err := commands.generate(mockAI, "find files", executor, &MockHistoryManager{}); err == nil {
+		t.Error("Expected an error for an answer without a command, got nil")
+	}
+	if len(executor.executedCommands) != 0 {
+		t.Errorf("Expected nothing to run, got %v", executor.executedCommands)
+	}
+}
+
+// failingExecutor fails commands listed in failures with their error
+// output, and runs everything else successfully
+type failingExecutor struct {
+	MockExecutor
+	failures map[string]string
+}
+
+func (f *failingExecutor) Execute(command string) (*shell.ExecResult, error) {
+	f.executedCommands = append(f.executedCommands, command)
+	if stderr, ok := f.failures[command]; ok {
+		return &shell.ExecResult{ExitCode: 1, StderrBytes: int64(len(stderr)), StderrTail: stderr}, nil
+	}
+	return &shell.ExecResult{}, nil
+}
+
+// TestCommandModeFix tests repairing the last failed command
+func TestCommandModeFix(t *testing.T) {
+	mockAI := &MockAIClient{reply: `{"command":"git push --set-upstream origin main","explanation":"Pushes and sets the upstream branch","risk":"medium"}`}
+	executor := &failingExecutor{failures: map[string]string{
+		"git push": "fatal: The current branch main has no upstream branch.\n",
+	}}
+	history := &MockHistoryManager{}
+	commands := newCommandMode(func() string { return ai.CommandSystemPrompt }, &scriptedReader{lines: []string{"y"}}, noDocs)
+
+	// Nothing has failed yet
+	if err := commands.fix(mockAI, executor, history); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockAI.requests) != 0 {
+		t.Errorf("Expected no AI request without a failure, got %d", len(mockAI.requests))
+	}
+
+	runCommand("git status", "", executor, history)
+	runCommand("git push", "", executor, history)
+	runCommand("ls", "", executor, history)
+
+	if err := commands.fix(mockAI, executor, history); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The failed command, its exit code and its error output are sent
+	task := mockAI.requests[0].LastUserMessage()
+	for _, want := range []string{"$ git push", "exit code 1", "has no upstream branch"} {
+		if !strings.Contains(task, want) {
+			t.Errorf("Expected the request to contain %q, got:\n%s", want, task)
+		}
+	}
+
+	last := history.entries[len(history.entries)-1]
+	if last.Command != "git push --set-upstream origin main" || last.Source != shell.SourceAI {
+		t.Errorf("Expected the fix to run and be recorded as AI-generated, got %+v", last)
+	}
+	if !last.Result.Success() {
+		t.Errorf("Expected the fix to succeed, got %+v", last.Result)
+	}
+}
+
+// TestJobsRecordedWhenFinished tests that background commands are
+// recorded in history with how they exited once they finish
+func TestJobsRecordedWhenFinished(t *testing.T) {
+	executor := shell.NewExecutorWithShell("sh")
+	executor.SetJobDir(t.TempDir())
+	history := &MockHistoryManager{}
+
+	runCommand("echo started; exit 4 &", "", executor, history)
+	if len(history.entries) != 0 {
+		t.Fatalf("Expected the job not to be recorded before it finishes, got %+v", history.entries)
+	}
+
+	if _, err := executor.Execute("wait"); err != nil {
+		t.Fatalf("Failed to wait for the job: %v", err)
+	}
+	reportFinishedJobs(executor, history)
+	if len(history.entries) != 1 {
+		t.Fatalf("Expected the finished job to be recorded, got %+v", history.entries)
+	}
+	entry := history.entries[0]
+	if entry.Command != "echo started; exit 4 &" || entry.Result == nil || entry.Result.ExitCode != 4 {
+		t.Errorf("Expected the job to be recorded with exit code 4, got %+v", entry)
+	}
+	if entry.Result != nil && !strings.Contains(entry.Result.StderrTail, "started") {
+		t.Errorf("Expected the end of the job's output to be kept, got %q", entry.Result.StderrTail)
+	}
+
+	// Each job is reported once
+	reportFinishedJobs(executor, history)
+	if len(history.entries) != 1 {
+		t.Errorf("Expected the job to be recorded once, got %d entries", len(history.entries))
+	}
+}
+
+// noDocs finds no documentation for any program
+func noDocs(program string) *shell.Docs {
+	return nil
+}
+
+// TestCommandModeExplain tests that explanations are grounded in the
+// documentation found for the command's programs
+func TestCommandModeExplain(t *testing.T) {
+	mockAI := &MockAIClient{reply: `{"summary":"Removes build","parts":[{"token":"rm","meaning":"Removes files"},{"token":"-rf","meaning":"Recursively, without asking"}],"side_effects":"Deletes build","destructive":true}`}
+	docs := func(program string) *shell.Docs {
+		return &shell.Docs{Program: program, Source: shell.DocsMan, Text: "RM(1)\n  -r, --recursive\n      remove directories"}
+	}
+	commands := newCommandMode(func() string { return ai.CommandSystemPrompt }, &scriptedReader{}, docs)
+
+	if err := commands.explain(mockAI, "rm -rf build"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	task := mockAI.requests[0].LastUserMessage()
+	if !strings.Contains(task, "$ rm -rf build") || !strings.Contains(task, "remove directories") {
+		t.Errorf("Expected the command and its documentation to be sent, got:\n%s", task)
+	}
+
+	mockAI.reply = "rm removes files"
+	if err := commands.explain(mockAI, "rm -rf build"); err == nil {
+		t.Error("Expected an error for an answer without an explanation, got nil")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/ai"
+	"github.com/sosadtsia/budy/internal/shell"
+)
+
+// maxTokenWidth limits the column of tokens in an explanation
+const maxTokenWidth = 24
+
+// explain asks the AI to break a command down part by part, grounded in
+// the documentation of the programs it runs. Ctrl-C stops the request.
+func (m *commandMode) explain(client ai.Client, command string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Println("Explaining...")
+	explanation, docs, err := ai.ExplainCommand(ctx, client, ai.ExplainSystemPrompt, command, m.docs)
+	if err != nil {
+		return err
+	}
+
+	printExplanation(command, explanation, docs)
+	return nil
+}
+
+// printExplanation shows what a command does, what each of its parts
+// means and where the documentation came from
+func printExplanation(command string, explanation *ai.Explanation, docs []*shell.Docs) {
+	fmt.Printf("\n  $ %s\n\n", command)
+	if explanation.Summary != "" {
+		fmt.Printf("  %s\n\n", explanation.Summary)
+	}
+
+	width := 0
+	for _, part := range explanation.Parts {
+		if n := len(part.Token); n > width && n <= maxTokenWidth {
+			width = n
+		}
+	}
+	for _, part := range explanation.Parts {
+		if len(part.Token) > maxTokenWidth {
+			fmt.Printf("  %s\n  %*s  %s\n", part.Token, width, "", part.Meaning)
+			continue
+		}
+		fmt.Printf("  %-*s  %s\n", width, part.Token, part.Meaning)
+	}
+	if len(explanation.Parts) > 0 {
+		fmt.Println()
+	}
+
+	if explanation.SideEffects != "" {
+		fmt.Printf("  Side effects: %s\n", explanation.SideEffects)
+	}
+	if explanation.Destructive {
+		fmt.Println("  Warning: this command is destructive; it may delete or overwrite data")
+	} else {
+		fmt.Println("  Destructive: no")
+	}
+
+	if len(docs) == 0 {
+		fmt.Println("  No documentation was found, so this explanation is from the AI alone")
+		return
+	}
+	sources := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		switch doc.Source {
+		case shell.DocsMan:
+			sources = append(sources, "man "+doc.Program)
+		case shell.DocsHelp:
+			sources = append(sources, doc.Program+" --help")
+		default:
+			sources = append(sources, doc.Program+" ("+doc.Source+")")
+		}
+	}
+	fmt.Printf("  Based on: %s\n", strings.Join(sources, ", "))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/shell"
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// processGuardCommand handles the commands that show and change how
+// dangerous commands are treated before they run
+func processGuardCommand(input string, guard *shell.Guard, dataDir string, config *storage.Config) {
+	command, arg, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(input, "guard")), " ")
+	arg = strings.TrimSpace(arg)
+	levels := strings.Join(shell.RiskLevels(), "|")
+
+	switch command {
+	case "", "list":
+		printGuard(guard, config)
+
+	case "set":
+		fields := strings.Fields(arg)
+		if len(fields) != 2 {
+			fmt.Printf("Usage: guard set <%s> <%s>\n", levels, strings.Join(shell.GuardActions(), "|"))
+			return
+		}
+		level, action := strings.ToLower(fields[0]), strings.ToLower(fields[1])
+		settings := config.GuardSettings()
+		policy := map[string]string{level: action}
+		for name, value := range settings.Policy {
+			if name != level {
+				policy[name] = value
+			}
+		}
+		settings.Policy = policy
+		if setGuardConfig(settings, guard, dataDir, config) {
+			fmt.Printf("Commands with %s risk will %s\n", level, describeAction(action))
+		}
+
+	case "add":
+		fields := strings.Fields(arg)
+		if len(fields) < 2 {
+			fmt.Printf("Usage: guard add <%s> <regex> [reason]\n", levels)
+			return
+		}
+		rule := storage.GuardRule{
+			Level:   strings.ToLower(fields[0]),
+			Pattern: fields[1],
+			Reason:  strings.Join(fields[2:], " "),
+		}
+		settings := config.GuardSettings()
+		settings.Rules = append(append([]storage.GuardRule{}, settings.Rules...), rule)
+		if setGuardConfig(settings, guard, dataDir, config) {
+			fmt.Printf("Commands matching %s now have %s risk\n", rule.Pattern, rule.Level)
+		}
+
+	case "rm", "remove":
+		settings := config.GuardSettings()
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(settings.Rules) {
+			fmt.Println("Usage: guard rm <n>, with n as numbered by 'guard list'")
+			return
+		}
+		rules := append([]storage.GuardRule{}, settings.Rules[:n-1]...)
+		settings.Rules = append(rules, settings.Rules[n:]...)
+		if setGuardConfig(settings, guard, dataDir, config) {
+			fmt.Printf("Removed rule %d\n", n)
+		}
+
+	case "test":
+		if arg == "" {
+			fmt.Println("Usage: guard test <command>")
+			return
+		}
+		risks := guard.Analyze(arg)
+		if len(risks) == 0 {
+			fmt.Println("No risks found; the command would run")
+			return
+		}
+		for _, risk := range risks {
+			fmt.Printf("  %s risk (%s): %s\n", risk.Level, risk.Rule, risk.Reason)
+		}
+		fmt.Printf("The command would %s\n", describeAction(guard.Decide(risks)))
+
+	default:
+		fmt.Println("Usage: guard [list|set <level> <action>|add <level> <regex> [reason]|rm <n>|test <command>]")
+	}
+}
+
+// printGuard shows the policy, the built-in rules and the user's rules
+func printGuard(guard *shell.Guard, config *storage.Config) {
+	fmt.Println("Policy:")
+	for _, level := range shell.RiskLevels() {
+		fmt.Printf("  %-9s %s\n", level, guard.Action(level))
+	}
+
+	fmt.Println("Built-in rules:")
+	for _, rule := range shell.BuiltinRiskRules() {
+		fmt.Printf("  %-9s %s\n", rule.Level, rule.Rule)
+	}
+
+	rules := config.GuardSettings().Rules
+	if len(rules) == 0 {
+		fmt.Println("Your rules: none; add one with 'guard add <level> <regex> [reason]'")
+		return
+	}
+	fmt.Println("Your rules:")
+	for i, rule := range rules {
+		fmt.Printf("  %d. %-9s %s", i+1, rule.Level, rule.Pattern)
+		if rule.Reason != "" {
+			fmt.Printf(" - %s", rule.Reason)
+		}
+		fmt.Println()
+	}
+}
+
+// describeAction says what happens to a command for a policy action
+func describeAction(action string) string {
+	switch action {
+	case shell.ActionWarn:
+		return "run with a warning"
+	case shell.ActionConfirm:
+		return "run once you confirm"
+	case shell.ActionBlock:
+		return "be blocked"
+	}
+	return "run"
+}
+
+// setGuardConfig checks and applies new guard settings, then saves them.
+// It reports whether they were saved.
+func setGuardConfig(settings storage.GuardConfig, guard *shell.Guard, dataDir string, config *storage.Config) bool {
+	if err := guard.SetConfig(settings); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return false
+	}
+	if err := storage.SetGuardConfig(dataDir, config, func(g *storage.GuardConfig) {
+		*g = settings
+	}); err != nil {
+		fmt.Printf("Error saving configuration: %v\n", err)
+		return false
+	}
+	return true
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sosadtsia/budy/internal/ai"
 	"github.com/sosadtsia/budy/internal/learning"
+	"github.com/sosadtsia/budy/internal/redact"
 	"github.com/sosadtsia/budy/internal/shell"
 	"github.com/sosadtsia/budy/internal/storage"
 )
@@ -34,55 +39,108 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Initialize shell executor
-	executor := shell.NewExecutor()
-
 	// Initialize history manager
 	history := shell.NewHistoryManager(store)
+	history.SetShared(config.ShareHistory)
 
-	// Initialize AI client based on configuration
-	var aiClient ai.Client
-	if config.AIProvider == storage.ProviderOpenAI {
-		apiKey := storage.GetOpenAIKey(config)
-		if apiKey == "" {
-			fmt.Println("Warning: OpenAI API key not set, falling back to Ollama")
-			aiClient = ai.NewOllamaClient(config.OllamaURL, config.OllamaModel)
-			fmt.Printf("Using Ollama AI provider with model: %s\n", config.OllamaModel)
-		} else {
-			aiClient = ai.NewOpenAIClient(apiKey)
-			fmt.Printf("Using OpenAI API\n")
-		}
-	} else {
-		// Default to Ollama
-		aiClient = ai.NewOllamaClient(config.OllamaURL, config.OllamaModel)
-		fmt.Printf("Using Ollama AI provider with model: %s\n", config.OllamaModel)
+	// Secrets are masked before commands are saved to history and before
+	// anything is sent to the AI
+	redactor, err := redact.New(config.RedactPatterns)
+	if err != nil {
+		fmt.Printf("Warning: %v; masking with the built-in detectors only\n", err)
+		redactor = redact.Default()
 	}
+	history.SetRedactor(redactor)
+
+	// Initialize shell executor
+	executor := shell.NewExecutorWithShell(config.Shell)
+	executor.SetHistory(history)
+
+	// Dangerous commands, typed or suggested by the AI, are checked before
+	// they run
+	guard, err := shell.NewGuard(config.GuardSettings())
+	if err != nil {
+		fmt.Printf("Warning: %v; using the default guard policy\n", err)
+		guard, _ = shell.NewGuard(storage.GuardConfig{})
+	}
+	executor.SetGuard(guard)
+
+	// Ctrl-C and Ctrl-Z go to the running command rather than budy, and
+	// commands left stopped are ended on exit. Background jobs log their
+	// output to the data directory.
+	shell.HandleSignals()
+	executor.SetJobDir(filepath.Join(store.GetDataDir(), "jobs"))
+	defer executor.Close()
+
+	// Follow-up questions continue the current conversation, and each
+	// question describes the terminal it was asked from
+	contextBuilder := ai.NewContextBuilder(executor, history, config)
+	chat := newChatSession(store, contextBuilder.SystemPrompt, redactor.Redact)
 
 	// Initialize suggestion engine
 	suggestionEngine := learning.NewSuggestionEngine(history)
 
-	// Create a platform-specific terminal reader with history support
-	terminal := shell.NewTerminalReader(history)
+	// Create a platform-specific terminal reader with history support and
+	// Tab completion of commands, paths and config options
+	completer := shell.NewCompleter(executor, history, newConfigCompleter(config))
+	terminal := shell.NewTerminalReader(history, completer)
+	readSecret = func(prompt string) (string, error) {
+		return shell.ReadSecret(terminal, prompt)
+	}
+
+	// API keys encrypted with a passphrase are unlocked before the AI
+	// client is set up
+	if storage.SecretsLocked(store.GetDataDir()) {
+		unlockSecrets(store.GetDataDir(), config)
+	}
+	protectSecrets(store.GetDataDir(), config)
+
+	// Initialize AI client based on configuration. Answers are cached, so
+	// asking again in the same context is answered at once.
+	cache := ai.NewCache(filepath.Join(store.GetDataDir(), "cache"), config.CacheSettings)
+	aiClient := ai.NewCachedClient(ai.NewRedactedClient(newAIClient(config), redactor.Redact), cache, config)
+	aiClient.Notify = func(message string) {
+		fmt.Println(message)
+	}
+
+	// ?! turns a task into a command, confirmed and edited at the prompt,
+	// and explanations are grounded in man pages and --help output
+	commands := newCommandMode(contextBuilder.CommandPrompt, terminal, executor.Documentation)
 
 	fmt.Printf("%s v%s - Your AI Terminal Assistant\n", appName, appVersion)
 	fmt.Println("Type commands normally or prefix with '?' to ask questions")
-	fmt.Println("Type 'config set ai_provider <openai|ollama>' to switch between providers")
+	fmt.Println("Prefix with '?!' to have the AI write a command for a task, e.g. '?! find go files changed this week'")
+	fmt.Println("Type 'fix' after a command fails to have the AI suggest a corrected one")
+	fmt.Println("Type 'explain <command>' or '?? <command>' to have each part of a command explained")
+	fmt.Println("Follow-up questions continue the conversation; type 'chat new' to start over, 'chat list' and 'chat resume <n>' to go back to one")
+	fmt.Println("Type 'context' to see what is sent to the AI with each question")
+	fmt.Println("Secrets are masked in history and AI prompts; type 'redact' to see how, or 'redact add <regex>' to mask more")
+	fmt.Println("Dangerous commands are confirmed or blocked first; type 'guard' to see the rules, or 'guard set <level> <action>' to change them")
+	fmt.Println("Type 'help' to list builtin commands such as cd, export and alias")
+	fmt.Println("End a command with '&' to run it in the background; 'jobs', 'fg', 'bg', 'kill %n' and 'wait' manage it")
+	fmt.Printf("Type 'config set ai_provider <%s>' to switch between providers\n", strings.Join(ai.ProviderNames(), "|"))
+	fmt.Println("Type 'config show' to see each provider's settings, and 'secrets' to see how API keys are stored")
 	fmt.Println("Type 'config set ollama_model <model_name>' to change the Ollama model")
-	if config.AIProvider == storage.ProviderOpenAI {
-		fmt.Println("Type 'config set openai_key <your_key>' to configure your OpenAI API key")
-	}
+	fmt.Println("Type 'models' to list Ollama models, 'models pull <name>' to download one, 'models show' and 'models rm' to inspect or remove one")
+	fmt.Println("Type 'config set shell <bash|sh|zsh|default>' to choose the shell that runs commands")
 
 	// Help text for history navigation
 	fmt.Println("\nHistory navigation shortcuts:")
-	fmt.Println("  !! - Repeat the most recent command")
-	fmt.Println("  !1 - Execute the most recent command")
-	fmt.Println("  !2 - Execute the second most recent command")
-	fmt.Println("  !n - Execute the nth most recent command")
+	fmt.Println("  !!         - Repeat the most recent command")
+	fmt.Println("  !n         - Execute command n as numbered by 'history'")
+	fmt.Println("  !-n        - Execute the nth most recent command")
+	fmt.Println("  !prefix    - Execute the most recent command starting with prefix")
+	fmt.Println("  !?text?    - Execute the most recent command containing text")
+	fmt.Println("  !$ and !*  - Reuse the last argument or all arguments of the previous command")
+	fmt.Println("  ^old^new   - Repeat the previous command with old replaced by new")
 
 	fmt.Println("\nType 'exit' to quit")
 
 	// Main interaction loop
 	for {
+		// Report background jobs that finished since the last prompt
+		reportFinishedJobs(executor, history)
+
 		// Show suggestions
 		suggestions := suggestionEngine.GetSuggestions()
 		for _, suggestion := range suggestions {
@@ -98,6 +156,7 @@ func main() {
 
 		// Handle exit command
 		if input == "exit" {
+			warnAboutJobs(executor, store.GetDataDir())
 			break
 		}
 
@@ -107,43 +166,79 @@ func main() {
 		}
 
 		// Process the input
-		newClient := processInput(input, aiClient, executor, history, store.GetDataDir(), config)
-		if newClient != nil {
-			aiClient = newClient
+		processInput(input, aiClient, executor, history, chat, commands, redactor, guard, store.GetDataDir(), config)
+	}
+}
+
+// newConfigCompleter completes "config set" options and their values,
+// including the options of every AI provider. Values such as Ollama's
+// models are looked up on each Tab.
+func newConfigCompleter(config *storage.Config) *shell.ConfigCompleter {
+	options := map[string]func() []string{
+		"ai_provider":    shell.Values(ai.ProviderNames()...),
+		"ai_fallback":    shell.Values(append([]string{"none", "default"}, ai.ProviderNames()...)...),
+		"cache_ttl":      shell.Values("off", "1h", "24h", "168h"),
+		"cache_max_size": shell.Values("1MB", "10MB", "100MB"),
+		"shell":          shell.Values(storage.ShellBash, storage.ShellSh, storage.ShellZsh, "default"),
+		"share_history":  shell.Values("on", "off"),
+
+		"context_directory":    shell.Values("on", "off"),
+		"context_listing":      shell.Values("on", "off"),
+		"context_system":       shell.Values("on", "off"),
+		"context_shell":        shell.Values("on", "off"),
+		"context_last_failure": shell.Values("on", "off"),
+		"context_history":      nil,
+	}
+	for _, provider := range ai.Providers() {
+		for i := range provider.Settings {
+			setting := &provider.Settings[i]
+			options[setting.Name] = nil
+			if setting.Values != nil {
+				options[setting.Name] = func() []string { return setting.Values(config) }
+			}
 		}
 	}
+	return shell.NewConfigCompleter(options)
 }
 
-// checkOllamaConnection tries to check if Ollama is running correctly
-func checkOllamaConnection(url string) bool {
-	client := &http.Client{
-		Timeout: 2 * time.Second, // Short timeout to avoid hanging
+// newAIClient creates a client that asks the configured provider, and the
+// fallback providers when it fails, telling the user as it moves on
+func newAIClient(config *storage.Config) *ai.Chain {
+	if _, err := ai.NewClient(config); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	} else if provider, ok := ai.LookupProvider(config.AIProvider); ok {
+		fmt.Printf("Using %s\n", provider.Describe(config))
 	}
-	resp, err := client.Get(url + "/api/tags")
-	if err != nil {
-		return false
+	chain := ai.NewChain(config)
+	if fallback := chain.Providers()[1:]; len(fallback) > 0 {
+		fmt.Printf("Falling back to %s when %s fails\n", strings.Join(fallback, ", then "), config.AIProvider)
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
+	chain.Notify = func(message string) {
+		fmt.Println(message)
+	}
+	return chain
+}
 
-	return resp.StatusCode == http.StatusOK
+// checkProvider runs a provider's health check with a short timeout, so a
+// server that doesn't answer can't hang the prompt
+func checkProvider(provider *ai.Provider, config *storage.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return provider.Check(ctx, config)
 }
 
-// printOllamaConnectionError prints a helpful error message for Ollama connection issues
-func printOllamaConnectionError() {
+// printProviderHelp prints how to get a provider working after it could not
+// be reached
+func printProviderHelp(provider *ai.Provider) {
 	fmt.Println("")
 	fmt.Println("==================================================")
-	fmt.Println("ERROR: Could not connect to Ollama server.")
+	fmt.Printf("ERROR: Could not connect to %s.\n", provider.Name)
 	fmt.Println("==================================================")
-	fmt.Println("Make sure Ollama is installed and running:")
-	fmt.Println("1. Install from https://ollama.ai")
-	fmt.Println("2. Verify Ollama is running (it should start automatically after installation)")
-	fmt.Println("3. Check that the Ollama server URL is correct with 'config set ollama_url <url>'")
-	fmt.Println("")
-	fmt.Println("You can also switch to OpenAI API if you have an API key:")
-	fmt.Println("1. Set your API key with 'config set openai_key <your_key>'")
-	fmt.Println("2. Switch to OpenAI with 'config set ai_provider openai'")
+	if provider.Help != "" {
+		fmt.Println(provider.Help)
+		fmt.Println("")
+	}
+	fmt.Printf("You can also switch providers with 'config set ai_provider <%s>'\n", strings.Join(ai.ProviderNames(), "|"))
 	fmt.Println("==================================================")
 }
 
@@ -153,184 +248,603 @@ func processInput(
 	aiClient ai.Client,
 	executor shell.Executor,
 	history shell.HistoryManager,
+	chat *chatSession,
+	commands *commandMode,
+	redactor *redact.Redactor,
+	guard *shell.Guard,
 	dataDir string,
 	config *storage.Config,
-) ai.Client {
+) {
 	// Handle configuration commands
 	if strings.HasPrefix(input, "config") {
-		return processConfigCommand(input, aiClient, executor, history, dataDir, config)
+		processConfigCommand(input, executor, history, dataDir, config)
+		return
+	}
+
+	// Handle conversation commands
+	if input == "chat" || strings.HasPrefix(input, "chat ") {
+		processChatCommand(input, chat)
+		return
+	}
+
+	// Preview the prompt sent with questions
+	if input == "context" {
+		printContextPreview(os.Stdout, chat)
+		return
+	}
+
+	// Manage the models installed in Ollama
+	if input == "models" || strings.HasPrefix(input, "models ") {
+		processModelsCommand(input, config)
+		return
+	}
+
+	// Show and protect the saved API keys
+	if input == "secrets" || strings.HasPrefix(input, "secrets ") {
+		processSecretsCommand(input, dataDir, config)
+		return
+	}
+
+	// Show and change how secrets are masked
+	if input == "redact" || strings.HasPrefix(input, "redact ") {
+		processRedactCommand(input, redactor, dataDir, config)
+		return
+	}
+
+	// Show and change how dangerous commands are treated
+	if input == "guard" || strings.HasPrefix(input, "guard ") {
+		processGuardCommand(input, guard, dataDir, config)
+		return
+	}
+
+	// Show or empty the cache of answers
+	if input == "cache" || strings.HasPrefix(input, "cache ") {
+		processCacheCommand(input, aiClient, config)
+		return
+	}
+
+	// Explain what a command and each of its flags do
+	if strings.HasPrefix(input, "??") || input == "explain" || strings.HasPrefix(input, "explain ") {
+		command := strings.TrimPrefix(input, "explain")
+		if strings.HasPrefix(input, "??") {
+			command = input[2:]
+		}
+		client, command := withoutCache(aiClient, strings.TrimSpace(command))
+		if command == "" {
+			fmt.Println("Usage: explain <command>, or ?? <command>")
+			return
+		}
+		printCommandError(commands.explain(client, command))
+		return
+	}
+
+	// Generate a command for a task described in plain language
+	if strings.HasPrefix(input, "?!") {
+		client, task := withoutCache(aiClient, strings.TrimSpace(input[2:]))
+		if task == "" {
+			fmt.Println("Usage: ?! <what the command should do>")
+			return
+		}
+		printCommandError(commands.generate(client, task, executor, history))
+		return
+	}
+
+	// Ask for a corrected version of the last failed command
+	if input == "fix" || input == "fix --no-cache" {
+		client, _ := withoutCache(aiClient, strings.TrimSpace(input[3:]))
+		printCommandError(commands.fix(client, executor, history))
+		return
 	}
 
 	// Handle question or command
 	if strings.HasPrefix(input, "?") {
-		query := strings.TrimSpace(input[1:])
-		if err := aiClient.Ask(query); err != nil {
-			// Check if this is an Ollama connection error
-			if strings.Contains(err.Error(), "connection refused") &&
-				(config.AIProvider == storage.ProviderOllama ||
-					strings.Contains(err.Error(), "Ollama")) {
-				printOllamaConnectionError()
+		client, query := withoutCache(aiClient, strings.TrimSpace(input[1:]))
+		if _, err := chat.ask(client, query); err != nil {
+			// Ctrl-C stops the answer
+			if errors.Is(err, context.Canceled) {
+				fmt.Println("Cancelled")
+				return
+			}
+
+			provider, ok := ai.LookupProvider(config.AIProvider)
+			if ok && strings.Contains(err.Error(), "connection refused") {
+				printProviderHelp(provider)
 			} else {
 				fmt.Printf("Error: %v\n", err)
 			}
+		}
+		return
+	}
 
-			// If OpenAI fails, try to fall back to Ollama
-			if config.AIProvider == storage.ProviderOpenAI {
-				// Only try to fall back if Ollama appears to be running
-				if checkOllamaConnection(config.OllamaURL) {
-					fmt.Println("Trying fallback to Ollama...")
-					ollamaClient := ai.NewOllamaClient(config.OllamaURL, config.OllamaModel)
-					if err := ollamaClient.Ask(query); err != nil {
-						fmt.Printf("Fallback also failed: %v\n", err)
-					} else {
-						return ollamaClient // If successful, switch to Ollama
-					}
-				}
-			}
+	// Execute the command, then record it along with how it finished
+	runCommand(input, "", executor, history)
+}
+
+// withoutCache removes a leading --no-cache flag from a query. When it was
+// given, the client returned asks for a fresh answer instead of using the
+// cache.
+func withoutCache(client ai.Client, query string) (ai.Client, string) {
+	rest, ok := strings.CutPrefix(query, "--no-cache")
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return client, query
+	}
+	if cached, ok := client.(*ai.CachedClient); ok {
+		client = cached.Bypass()
+	}
+	return client, strings.TrimSpace(rest)
+}
+
+// processCacheCommand handles the cache commands
+func processCacheCommand(input string, client ai.Client, config *storage.Config) {
+	cached, ok := client.(*ai.CachedClient)
+	if !ok {
+		fmt.Println("Answers are not cached")
+		return
+	}
+	cache := cached.Cache()
+
+	switch strings.TrimSpace(strings.TrimPrefix(input, "cache")) {
+	case "", "stats":
+		stats, err := cache.Stats()
+		if err != nil {
+			fmt.Printf("Error reading the cache: %v\n", err)
+			return
+		}
+		settings := config.CacheSettings()
+		if !cache.Enabled() {
+			fmt.Println("The cache is off; turn it on with 'config set cache_ttl <duration>'")
 		}
-		return nil
-	} else {
-		// Record and execute command
-		if err := history.RecordCommand(input); err != nil {
-			fmt.Printf("Warning: Failed to record command in history: %v\n", err)
+		fmt.Printf("Cached answers: %d, %s of %s\n", stats.Entries, formatSize(stats.Size), formatSize(settings.MaxSize))
+		if stats.Entries > 0 {
+			fmt.Printf("Oldest answer: %s\n", stats.Oldest.Local().Format("2006-01-02 15:04"))
 		}
-		if err := executor.Execute(input); err != nil {
-			fmt.Printf("Error executing command: %v\n", err)
+		if cache.Enabled() {
+			fmt.Printf("Answers are reused for %s\n", formatMinutes(settings.TTLMinutes))
+		}
+		fmt.Printf("This session: %d hits, %d misses\n", stats.Hits, stats.Misses)
+
+	case "clear":
+		removed, err := cache.Clear()
+		if err != nil {
+			fmt.Printf("Error clearing the cache: %v\n", err)
+			return
 		}
-		return nil
+		fmt.Printf("Removed %d cached answers\n", removed)
+
+	default:
+		fmt.Println("Usage: cache [stats|clear]")
+	}
+}
+
+// formatSize describes a number of bytes, such as "1.5 MB"
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+// parseSize parses a size such as "512KB", "10MB" or a number of bytes
+func parseSize(value string) (int64, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(value, suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, suffix))
+			multiplier = m
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n * multiplier, true
+}
+
+// formatMinutes describes a number of minutes, such as "24h" or "90m"
+func formatMinutes(minutes int) string {
+	if minutes%60 == 0 {
+		return fmt.Sprintf("%dh", minutes/60)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// printCommandError reports an error from generating a command, if any
+func printCommandError(err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled):
+		fmt.Println("Cancelled")
+	default:
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+// parseOnOff parses an on/off setting value
+func parseOnOff(value string) (on bool, ok bool) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes":
+		return true, true
+	case "off", "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// askAI sends a request to the AI client and renders the answer as it
+// streams in, until it completes or the user presses Ctrl-C
+func askAI(client ai.Client, req ai.Request) (*ai.Response, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	render := newRenderer(os.Stdout)
+	req.OnToken = render.Token
+
+	resp, err := client.Complete(ctx, req)
+	_ = render.Finish(resp)
+
+	// Say so when the answer came from a fallback provider
+	if fallback, ok := client.(interface{ Primary() string }); ok && resp != nil && resp.Provider != fallback.Primary() {
+		fmt.Printf("(answered by %s)\n", resp.Provider)
 	}
+	return resp, err
 }
 
 // processConfigCommand handles configuration commands
 func processConfigCommand(
 	input string,
-	aiClient ai.Client,
 	executor shell.Executor,
 	history shell.HistoryManager,
 	dataDir string,
 	config *storage.Config,
-) ai.Client {
+) {
 	parts := strings.Fields(input)
+	if len(parts) == 2 && parts[1] == "show" {
+		printConfig(config)
+		return
+	}
 	if len(parts) < 3 {
-		fmt.Println("Usage: config set <option> <value>")
-		return nil
+		fmt.Println("Usage: config set <option> <value>, or config show")
+		return
 	}
 
 	if parts[1] != "set" {
-		fmt.Println("Unknown config command. Use 'config set <option> <value>'")
-		return nil
+		fmt.Println("Unknown config command. Use 'config set <option> <value>' or 'config show'")
+		return
 	}
 
 	switch parts[2] {
-	case "openai_key":
+	case "ai_provider":
 		if len(parts) < 4 {
-			fmt.Println("Usage: config set openai_key <your_api_key>")
-			return nil
+			fmt.Printf("Usage: config set ai_provider <%s>\n", strings.Join(ai.ProviderNames(), "|"))
+			return
 		}
 
-		key := parts[3]
-		if err := storage.SetOpenAIKey(dataDir, config, key); err != nil {
-			fmt.Printf("Error setting API key: %v\n", err)
-			return nil
+		name := strings.ToLower(parts[3])
+		provider, ok := ai.LookupProvider(name)
+		if !ok {
+			fmt.Printf("Invalid provider: %s. Use one of: %s\n", name, strings.Join(ai.ProviderNames(), ", "))
+			return
 		}
 
-		fmt.Println("OpenAI API key set successfully")
+		// Check the provider works before switching
+		if err := checkProvider(provider, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			printProviderHelp(provider)
+			fmt.Printf("Not switching to %s due to connection issues.\n", name)
+			return
+		}
 
-		// If we're using OpenAI, update the client
-		if config.AIProvider == storage.ProviderOpenAI {
-			return ai.NewOpenAIClient(key)
+		if err := storage.SetAIProvider(dataDir, config, name); err != nil {
+			fmt.Printf("Error setting AI provider: %v\n", err)
+			return
 		}
 
-	case "ai_provider":
-		if len(parts) < 4 {
-			fmt.Println("Usage: config set ai_provider <openai|ollama>")
-			return nil
+		fmt.Printf("AI provider set to %s\n", name)
+		if _, err := provider.New(config); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			return
 		}
+		fmt.Printf("Using %s\n", provider.Describe(config))
 
-		provider := strings.ToLower(parts[3])
-		if provider != storage.ProviderOpenAI && provider != storage.ProviderOllama {
-			fmt.Printf("Invalid provider: %s. Use 'openai' or 'ollama'\n", provider)
-			return nil
+	case "ai_fallback":
+		if len(parts) < 4 {
+			fmt.Printf("Usage: config set ai_fallback <%s,...|none|default>\n", strings.Join(ai.ProviderNames(), "|"))
+			return
 		}
 
-		if provider == storage.ProviderOllama {
-			// Check Ollama connection before switching
-			if !checkOllamaConnection(config.OllamaURL) {
-				printOllamaConnectionError()
-				fmt.Println("Not switching to Ollama due to connection issues.")
-				return nil
-			}
+		fallback, err := parseFallback(configValue(input, 3))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		if err := storage.SetAIFallback(dataDir, config, fallback); err != nil {
+			fmt.Printf("Error setting ai_fallback: %v\n", err)
+			return
 		}
 
-		if err := storage.SetAIProvider(dataDir, config, provider); err != nil {
-			fmt.Printf("Error setting AI provider: %v\n", err)
-			return nil
+		if names := config.FallbackProviders(); len(names) == 0 {
+			fmt.Println("Fallback turned off")
+		} else {
+			fmt.Printf("When %s fails, %s will be asked in that order\n", config.AIProvider, strings.Join(names, ", "))
 		}
 
-		fmt.Printf("AI provider set to %s\n", provider)
+	case "cache_ttl":
+		if len(parts) < 4 {
+			fmt.Println("Usage: config set cache_ttl <duration such as 24h or 30m|off>")
+			return
+		}
 
-		// Create and return new AI client based on provider
-		if provider == storage.ProviderOpenAI {
-			apiKey := storage.GetOpenAIKey(config)
-			if apiKey == "" {
-				fmt.Println("Warning: OpenAI API key not set. You need to set it with 'config set openai_key <your_key>'")
-				fmt.Println("Staying with Ollama for now...")
-				return ai.NewOllamaClient(config.OllamaURL, config.OllamaModel)
+		minutes := 0
+		if parts[3] != "off" && parts[3] != "0" {
+			ttl, err := time.ParseDuration(parts[3])
+			if err != nil || ttl < time.Minute {
+				fmt.Printf("Invalid duration: %s. Use a duration of at least a minute, such as 24h or 30m, or 'off'\n", parts[3])
+				return
 			}
-			return ai.NewOpenAIClient(apiKey)
+			minutes = int(ttl / time.Minute)
+		}
+
+		if err := storage.SetCacheConfig(dataDir, config, func(c *storage.CacheConfig) {
+			c.TTLMinutes = minutes
+		}); err != nil {
+			fmt.Printf("Error setting cache_ttl: %v\n", err)
+			return
+		}
+		if minutes == 0 {
+			fmt.Println("Answers are no longer cached")
 		} else {
-			return ai.NewOllamaClient(config.OllamaURL, config.OllamaModel)
+			fmt.Printf("Answers are reused for %s\n", formatMinutes(minutes))
 		}
 
-	case "ollama_url":
+	case "cache_max_size":
 		if len(parts) < 4 {
-			fmt.Println("Usage: config set ollama_url <url>")
-			return nil
+			fmt.Println("Usage: config set cache_max_size <size such as 10MB>")
+			return
 		}
 
-		url := parts[3]
+		size, ok := parseSize(parts[3])
+		if !ok {
+			fmt.Printf("Invalid size: %s. Use a size such as 512KB or 10MB\n", parts[3])
+			return
+		}
+		if err := storage.SetCacheConfig(dataDir, config, func(c *storage.CacheConfig) {
+			c.MaxSize = size
+		}); err != nil {
+			fmt.Printf("Error setting cache_max_size: %v\n", err)
+			return
+		}
+		fmt.Printf("The cache now keeps up to %s of answers\n", formatSize(size))
 
-		// Test the new URL before saving it
-		fmt.Printf("Testing connection to Ollama at %s...\n", url)
-		if !checkOllamaConnection(url) {
-			fmt.Println("Warning: Could not connect to Ollama at this URL.")
-			fmt.Println("Saving anyway, but you may need to correct it later.")
+	case "shell":
+		if len(parts) < 4 {
+			fmt.Println("Usage: config set shell <bash|sh|zsh|default>")
+			return
 		}
 
-		if err := storage.SetOllamaSettings(dataDir, config, url, ""); err != nil {
-			fmt.Printf("Error setting Ollama URL: %v\n", err)
-			return nil
+		name := strings.ToLower(parts[3])
+		switch name {
+		case storage.ShellBash, storage.ShellSh, storage.ShellZsh:
+		case "default":
+			name = ""
+		default:
+			fmt.Printf("Invalid shell: %s. Use 'bash', 'sh', 'zsh' or 'default'\n", name)
+			return
 		}
 
-		fmt.Printf("Ollama URL set to %s\n", url)
+		if err := storage.SetShell(dataDir, config, name); err != nil {
+			fmt.Printf("Error setting shell: %v\n", err)
+			return
+		}
 
-		// If we're using Ollama, update the client
-		if config.AIProvider == storage.ProviderOllama {
-			return ai.NewOllamaClient(url, config.OllamaModel)
+		// Switch the running executor over as well
+		if shellExecutor, ok := executor.(*shell.ShellExecutor); ok {
+			shellExecutor.SetShell(name)
+			fmt.Printf("Shell set to %s\n", shellExecutor.Shell())
+		} else {
+			fmt.Println("Shell updated")
 		}
 
-	case "ollama_model":
+	case "share_history":
 		if len(parts) < 4 {
-			fmt.Println("Usage: config set ollama_model <model_name>")
-			return nil
+			fmt.Println("Usage: config set share_history <on|off>")
+			return
+		}
+
+		share, ok := parseOnOff(parts[3])
+		if !ok {
+			fmt.Printf("Invalid value: %s. Use 'on' or 'off'\n", parts[3])
+			return
+		}
+
+		if err := storage.SetShareHistory(dataDir, config, share); err != nil {
+			fmt.Printf("Error setting share_history: %v\n", err)
+			return
 		}
 
-		model := parts[3]
-		if err := storage.SetOllamaSettings(dataDir, config, "", model); err != nil {
-			fmt.Printf("Error setting Ollama model: %v\n", err)
-			return nil
+		// Apply to the running session as well
+		if fileHistory, ok := history.(*shell.FileHistoryManager); ok {
+			fileHistory.SetShared(share)
 		}
+		fmt.Printf("History sharing between sessions turned %s\n", strings.ToLower(parts[3]))
 
-		fmt.Printf("Ollama model set to %s\n", model)
+	case "context_directory", "context_listing", "context_system", "context_shell", "context_last_failure":
+		if len(parts) < 4 {
+			fmt.Printf("Usage: config set %s <on|off>\n", parts[2])
+			return
+		}
 
-		// If we're using Ollama, update the client
-		if config.AIProvider == storage.ProviderOllama {
-			return ai.NewOllamaClient(config.OllamaURL, model)
+		on, ok := parseOnOff(parts[3])
+		if !ok {
+			fmt.Printf("Invalid value: %s. Use 'on' or 'off'\n", parts[3])
+			return
 		}
 
+		item := parts[2]
+		if err := storage.SetContextConfig(dataDir, config, func(c *storage.ContextConfig) {
+			switch item {
+			case "context_directory":
+				c.Directory = on
+			case "context_listing":
+				c.Listing = on
+			case "context_system":
+				c.System = on
+			case "context_shell":
+				c.Shell = on
+			case "context_last_failure":
+				c.LastFailure = on
+			}
+		}); err != nil {
+			fmt.Printf("Error setting %s: %v\n", item, err)
+			return
+		}
+		fmt.Printf("%s turned %s. Type 'context' to preview what is sent\n", item, strings.ToLower(parts[3]))
+
+	case "context_history":
+		if len(parts) < 4 {
+			fmt.Println("Usage: config set context_history <number of commands>")
+			return
+		}
+
+		count, err := strconv.Atoi(parts[3])
+		if err != nil || count < 0 {
+			fmt.Printf("Invalid value: %s. Use a number of commands, or 0 to turn it off\n", parts[3])
+			return
+		}
+
+		if err := storage.SetContextConfig(dataDir, config, func(c *storage.ContextConfig) {
+			c.History = count
+		}); err != nil {
+			fmt.Printf("Error setting context_history: %v\n", err)
+			return
+		}
+		fmt.Printf("Questions now include the last %d commands\n", count)
+
 	default:
+		if provider, setting, ok := ai.FindSetting(parts[2]); ok {
+			setProviderOption(provider, setting, configValue(input, 3), dataDir, config)
+			return
+		}
 		fmt.Printf("Unknown config option: %s\n", parts[2])
 	}
+}
+
+// parseFallback parses a list of providers separated by commas or spaces.
+// "none" means an empty list and "default" means nil.
+func parseFallback(value string) ([]string, error) {
+	switch value {
+	case "none":
+		return []string{}, nil
+	case "default":
+		return nil, nil
+	}
+
+	names := []string{}
+	for _, name := range strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		if _, ok := ai.LookupProvider(name); !ok {
+			return nil, fmt.Errorf("Invalid provider: %s. Use one of: %s", name, strings.Join(ai.ProviderNames(), ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// configValue returns what follows the first n words of a config command,
+// so values may contain spaces
+func configValue(input string, n int) string {
+	rest := strings.TrimSpace(input)
+	for i := 0; i < n; i++ {
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			return ""
+		}
+		rest = strings.TrimSpace(rest[end:])
+	}
+	return rest
+}
+
+// setProviderOption sets an option of an AI provider. When the provider is
+// in use it is checked with the new value.
+func setProviderOption(provider *ai.Provider, setting *ai.Setting, value string, dataDir string, config *storage.Config) {
+	typed := value != ""
+	if !typed && setting.Secret {
+		// Secrets are entered without being shown
+		var err error
+		if value, err = readSecret(fmt.Sprintf("%s (input hidden): ", setting.Name)); err != nil || value == "" {
+			fmt.Printf("Nothing entered; %s unchanged\n", setting.Name)
+			return
+		}
+	}
+	if value == "" {
+		fmt.Printf("Usage: config set %s %s\n", setting.Name, setting.Usage)
+		return
+	}
+
+	value, err := setting.Normalize(config, value)
+	if err != nil {
+		fmt.Printf("Invalid value for %s: %v\n", setting.Name, err)
+		return
+	}
 
-	return nil
+	if err := storage.UpdateConfig(dataDir, config, func(c *storage.Config) {
+		setting.Store(c, value)
+	}); err != nil {
+		fmt.Printf("Error setting %s: %v\n", setting.Name, err)
+		return
+	}
+
+	switch {
+	case setting.Secret:
+		fmt.Printf("%s set to %s\n", setting.Name, setting.Display(config))
+		if typed {
+			fmt.Printf("Tip: type 'config set %s' without a value to enter it without it being shown\n", setting.Name)
+		}
+	case value == "":
+		fmt.Printf("%s reset to its default\n", setting.Name)
+	default:
+		fmt.Printf("%s set to %s\n", setting.Name, value)
+	}
+
+	if provider.Name != config.AIProvider {
+		return
+	}
+	if _, err := provider.New(config); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+	fmt.Printf("Using %s\n", provider.Describe(config))
+	if err := checkProvider(provider, config); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		fmt.Println("Saved anyway, but you may need to correct it later.")
+	}
+}
+
+// printConfig lists the AI providers and their settings, with secrets
+// masked
+func printConfig(config *storage.Config) {
+	for _, provider := range ai.Providers() {
+		marker := " "
+		if provider.Name == config.AIProvider {
+			marker = "*"
+		}
+		fmt.Printf("%s %s - %s\n", marker, provider.Name, provider.Description)
+		for i := range provider.Settings {
+			setting := &provider.Settings[i]
+			fmt.Printf("    %-20s %s\n", setting.Name, setting.Display(config))
+		}
+	}
+	fallback := "none"
+	if names := config.FallbackProviders(); len(names) > 0 {
+		fallback = strings.Join(names, ", ")
+	}
+	fmt.Printf("\nFallback when %s fails: %s\n", config.AIProvider, fallback)
+	fmt.Println("* is the provider in use; change it with 'config set ai_provider <name>'")
 }
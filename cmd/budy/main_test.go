@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/sosadtsia/budy/internal/ai"
+	"github.com/sosadtsia/budy/internal/redact"
 	"github.com/sosadtsia/budy/internal/shell"
 	"github.com/sosadtsia/budy/internal/storage"
 )
@@ -13,14 +16,23 @@ import (
 // MockAIClient is a mock implementation of the AI client
 type MockAIClient struct {
 	askedQueries []string
+	requests     []ai.Request
+
+	// reply, if set, is returned instead of echoing the question
+	reply string
 }
 
 // Ensure MockAIClient implements the ai.Client interface
 var _ ai.Client = (*MockAIClient)(nil)
 
-func (m *MockAIClient) Ask(query string) error {
+func (m *MockAIClient) Complete(ctx context.Context, req ai.Request) (*ai.Response, error) {
+	query := req.LastUserMessage()
 	m.askedQueries = append(m.askedQueries, query)
-	return nil
+	m.requests = append(m.requests, req)
+	if m.reply != "" {
+		return &ai.Response{Text: m.reply}, nil
+	}
+	return &ai.Response{Text: "answer to " + query}, nil
 }
 
 // MockExecutor is a mock implementation for testing that matches shell.Executor's API
@@ -31,14 +43,15 @@ type MockExecutor struct {
 // Ensure MockExecutor implements the shell.Executor interface
 var _ shell.Executor = (*MockExecutor)(nil)
 
-func (m *MockExecutor) Execute(command string) error {
+func (m *MockExecutor) Execute(command string) (*shell.ExecResult, error) {
 	m.executedCommands = append(m.executedCommands, command)
-	return nil
+	return &shell.ExecResult{}, nil
 }
 
 // MockHistoryManager is a mock implementation for testing that matches shell.HistoryManager's API
 type MockHistoryManager struct {
 	recordedCommands []string
+	entries          []shell.CommandEntry
 }
 
 // Ensure MockHistoryManager implements the shell.HistoryManager interface
@@ -49,8 +62,14 @@ func (m *MockHistoryManager) RecordCommand(command string) error {
 	return nil
 }
 
+func (m *MockHistoryManager) RecordEntry(entry shell.CommandEntry) error {
+	m.recordedCommands = append(m.recordedCommands, entry.Command)
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
 func (m *MockHistoryManager) GetHistory() []shell.CommandEntry {
-	return []shell.CommandEntry{}
+	return m.entries
 }
 
 func (m *MockHistoryManager) GetRecentCommands(n int) []shell.CommandEntry {
@@ -180,17 +199,18 @@ func testHandleInput(input string, aiClient ai.Client, executor shell.Executor,
 		if len(query) > 0 && query[0] == ' ' {
 			query = query[1:]
 		}
-		if err := aiClient.Ask(query); err != nil {
+		if _, err := aiClient.Complete(context.Background(), ai.NewRequest(query)); err != nil {
 			panic(err) // In tests we can panic on errors
 		}
 		return
 	}
 
-	// If it's not a question, treat it as a command
-	if err := history.RecordCommand(input); err != nil {
+	// If it's not a question, run it as a command and record the result
+	result, err := executor.Execute(input)
+	if err != nil {
 		panic(err)
 	}
-	if err := executor.Execute(input); err != nil {
+	if err := history.RecordEntry(shell.CommandEntry{Command: input, Result: result}); err != nil {
 		panic(err)
 	}
 }
@@ -215,3 +235,234 @@ func testHandleConfigInput(input string, aiClient ai.Client, executor shell.Exec
 	key := input[len(prefix)+1:]
 	return true, key
 }
+
+func TestProviderConfigCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	config, err := storage.LoadConfig(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	executor := &MockExecutor{}
+	history := &MockHistoryManager{}
+
+	// Values keep their spaces
+	processConfigCommand("config set exec_command  llm -m mistral", executor, history, dataDir, config)
+	processConfigCommand("config set anthropic_base_url http://localhost:9000/", executor, history, dataDir, config)
+	processConfigCommand("config set anthropic_model not a url", executor, history, dataDir, config)
+	processConfigCommand("config set anthropic_base_url localhost", executor, history, dataDir, config)
+
+	loadedConfig, err := storage.LoadConfig(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := map[string]string{
+		"exec_command":       "llm -m mistral",
+		"anthropic_base_url": "http://localhost:9000",
+		"anthropic_model":    "not a url",
+	}
+	for name, value := range expected {
+		if got := loadedConfig.Setting(name); got != value {
+			t.Errorf("Expected %s to be %q, got %q", name, value, got)
+		}
+	}
+
+	// Switching to a provider that isn't ready is refused
+	processConfigCommand("config set exec_command budy-no-such-program", executor, history, dataDir, config)
+	processConfigCommand("config set ai_provider exec", executor, history, dataDir, config)
+	if config.AIProvider == "exec" {
+		t.Error("Expected the provider not to change while its command is missing")
+	}
+	processConfigCommand("config set exec_command cat", executor, history, dataDir, config)
+	processConfigCommand("config set ai_provider exec", executor, history, dataDir, config)
+	if config.AIProvider != "exec" {
+		t.Errorf("Expected to switch to the exec provider, got %s", config.AIProvider)
+	}
+
+	// The fallback list is checked against the known providers
+	processConfigCommand("config set ai_fallback anthropic, ollama", executor, history, dataDir, config)
+	processConfigCommand("config set ai_fallback nonexistent", executor, history, dataDir, config)
+	if got := strings.Join(config.FallbackProviders(), ","); got != "anthropic,ollama" {
+		t.Errorf("Expected the fallback to be anthropic,ollama, got %s", got)
+	}
+	processConfigCommand("config set ai_fallback none", executor, history, dataDir, config)
+	if config.AIFallback == nil || len(config.FallbackProviders()) != 0 {
+		t.Errorf("Expected fallback to be turned off, got %v", config.FallbackProviders())
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"config set exec_command llm -m mistral", "llm -m mistral"},
+		{"config set  openai_header\tapi-key  secret ", "api-key  secret"},
+		{"config set exec_command", ""},
+	}
+	for _, test := range tests {
+		if got := configValue(test.input, 3); got != test.expected {
+			t.Errorf("configValue(%q): expected %q, got %q", test.input, test.expected, got)
+		}
+	}
+}
+
+func TestWithoutCache(t *testing.T) {
+	cached := ai.NewCachedClient(&MockAIClient{}, ai.NewCache(t.TempDir(), storage.DefaultCacheConfig), &storage.Config{})
+
+	tests := []struct {
+		query    string
+		expected string
+		bypass   bool
+	}{
+		{"how do I list files", "how do I list files", false},
+		{"--no-cache how do I list files", "how do I list files", true},
+		{"--no-cache", "", true},
+		{"--no-caches are bad", "--no-caches are bad", false},
+	}
+	for _, test := range tests {
+		client, query := withoutCache(cached, test.query)
+		if query != test.expected || (client != ai.Client(cached)) != test.bypass {
+			t.Errorf("withoutCache(%q): expected %q with bypass %v, got %q with bypass %v",
+				test.query, test.expected, test.bypass, query, client != ai.Client(cached))
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		ok       bool
+	}{
+		{"1048576", 1 << 20, true},
+		{"512KB", 512 << 10, true},
+		{"10 mb", 10 << 20, true},
+		{"0", 0, false},
+		{"lots", 0, false},
+	}
+	for _, test := range tests {
+		size, ok := parseSize(test.value)
+		if size != test.expected || ok != test.ok {
+			t.Errorf("parseSize(%q): expected %d, %v, got %d, %v", test.value, test.expected, test.ok, size, ok)
+		}
+	}
+	if got := formatSize(10 << 20); got != "10.0 MB" {
+		t.Errorf("Expected 10.0 MB, got %s", got)
+	}
+}
+
+func TestRedactCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	config, err := storage.LoadConfig(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	redactor := redact.Default()
+
+	processRedactCommand("redact add ACME-[0-9]{6}", redactor, dataDir, config)
+	processRedactCommand("redact add employee=(\\d+)", redactor, dataDir, config)
+	processRedactCommand("redact add (", redactor, dataDir, config)
+	if got := redactor.Redact("ACME-123456 employee=42"); got != "[REDACTED] employee=[REDACTED]" {
+		t.Errorf("Expected the added patterns to be masked, got %q", got)
+	}
+
+	processRedactCommand("redact rm 1", redactor, dataDir, config)
+	processRedactCommand("redact rm 5", redactor, dataDir, config)
+	loadedConfig, err := storage.LoadConfig(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if strings.Join(loadedConfig.RedactPatterns, " ") != `employee=(\d+)` {
+		t.Errorf("Expected one saved pattern, got %v", loadedConfig.RedactPatterns)
+	}
+	if got := redactor.Redact("ACME-123456"); got != "ACME-123456" {
+		t.Errorf("Expected the removed pattern to be ignored, got %q", got)
+	}
+}
+
+func TestSecretCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	config, err := storage.LoadConfig(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	var answers []string
+	defer func(read func(string) (string, error)) {
+		readSecret = read
+	}(readSecret)
+	readSecret = func(prompt string) (string, error) {
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+
+	// Secrets typed without a value are read hidden, and saved apart from
+	// config.json
+	answers = []string{"sk-ant-hidden-key"}
+	processConfigCommand("config set anthropic_key", &MockExecutor{}, &MockHistoryManager{}, dataDir, config)
+	if config.Setting("anthropic_key") != "sk-ant-hidden-key" {
+		t.Errorf("Expected the key to be set, got %q", config.Setting("anthropic_key"))
+	}
+	data, err := os.ReadFile(filepath.Join(dataDir, "config.json"))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if strings.Contains(string(data), "sk-ant") {
+		t.Errorf("Expected no key in config.json, got %s", data)
+	}
+
+	// Encrypting needs the passphrase twice
+	answers = []string{"first", "second"}
+	processSecretsCommand("secrets encrypt", dataDir, config)
+	if storage.SecretsEncrypted(dataDir) {
+		t.Fatal("Expected mismatched passphrases to leave the secrets alone")
+	}
+	answers = []string{"passphrase", "passphrase"}
+	processSecretsCommand("secrets encrypt", dataDir, config)
+	if !storage.SecretsEncrypted(dataDir) || config.Setting("anthropic_key") != "sk-ant-hidden-key" {
+		t.Fatal("Expected the secrets to be encrypted and still loaded")
+	}
+
+	processSecretsCommand("secrets decrypt", dataDir, config)
+	if storage.SecretsEncrypted(dataDir) {
+		t.Error("Expected the secrets to be decrypted")
+	}
+}
+
+func TestGuardCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	config, err := storage.LoadConfig(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	guard, err := shell.NewGuard(config.GuardSettings())
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+
+	processGuardCommand("guard set critical confirm", guard, dataDir, config)
+	processGuardCommand("guard set high panic", guard, dataDir, config)
+	processGuardCommand("guard add high terraform\\s+destroy destroys infrastructure", guard, dataDir, config)
+	processGuardCommand("guard add high deploy", guard, dataDir, config)
+	processGuardCommand("guard add extreme deploy", guard, dataDir, config)
+	if guard.Action(shell.RiskCritical) != shell.ActionConfirm || guard.Action(shell.RiskHigh) != shell.ActionConfirm {
+		t.Errorf("Expected only the valid policy change, got critical %s and high %s", guard.Action(shell.RiskCritical), guard.Action(shell.RiskHigh))
+	}
+	if risks := guard.Analyze("terraform destroy"); len(risks) == 0 || risks[0].Reason != "destroys infrastructure" {
+		t.Errorf("Expected the added rule to match, got %+v", risks)
+	}
+
+	processGuardCommand("guard rm 1", guard, dataDir, config)
+	processGuardCommand("guard rm 5", guard, dataDir, config)
+	loadedConfig, err := storage.LoadConfig(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	settings := loadedConfig.GuardSettings()
+	if settings.Policy[shell.RiskCritical] != shell.ActionConfirm || len(settings.Rules) != 1 || settings.Rules[0].Pattern != "deploy" {
+		t.Errorf("Expected the saved policy and one rule, got %+v", settings)
+	}
+	if risks := guard.Analyze("terraform destroy"); len(risks) != 1 || risks[0].Rule != "infrastructure delete" {
+		t.Errorf("Expected only the built-in rule after removing yours, got %+v", risks)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/ai"
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// modelsTimeout limits the model commands that don't download anything
+const modelsTimeout = 10 * time.Second
+
+// processModelsCommand handles the commands that manage the models
+// installed in Ollama
+func processModelsCommand(input string, config *storage.Config) {
+	parts := strings.Fields(input)
+	command := "list"
+	if len(parts) > 1 {
+		command = parts[1]
+	}
+	name := ""
+	if len(parts) > 2 {
+		name = parts[2]
+	}
+
+	var err error
+	switch command {
+	case "list", "ls":
+		err = listModels(config)
+	case "pull":
+		if name == "" {
+			fmt.Println("Usage: models pull <name>")
+			return
+		}
+		err = pullModel(config, name)
+	case "show":
+		if name == "" {
+			name = config.OllamaModel
+		}
+		err = showModel(config, name)
+	case "rm", "remove":
+		if name == "" {
+			fmt.Println("Usage: models rm <name>")
+			return
+		}
+		err = removeModel(config, name)
+	default:
+		fmt.Println("Usage: models [list|pull <name>|show [name]|rm <name>]")
+		return
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled):
+		fmt.Println("Cancelled")
+	default:
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+// listModels prints the installed models, marking the configured one
+func listModels(config *storage.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), modelsTimeout)
+	defer cancel()
+
+	models, err := ai.OllamaModels(ctx, config.OllamaURL)
+	if err != nil {
+		return err
+	}
+	if len(models) == 0 {
+		fmt.Println("No models installed. Download one with 'models pull <name>', for example 'models pull llama3'")
+		return nil
+	}
+
+	fmt.Printf("  %-28s %-10s %-10s %-12s %s\n", "NAME", "SIZE", "PARAMS", "QUANT", "MODIFIED")
+	for _, model := range models {
+		marker := " "
+		if ai.SameOllamaModel(model.Name, config.OllamaModel) {
+			marker = "*"
+		}
+		fmt.Printf("%s %-28s %-10s %-10s %-12s %s\n", marker, model.Name, formatSize(model.Size),
+			model.Details.ParameterSize, model.Details.QuantizationLevel, formatModified(model.ModifiedAt))
+	}
+	fmt.Println("\n* is the model in use; change it with 'config set ollama_model <name>'")
+	return nil
+}
+
+// formatModified shortens the time a model was modified to its date
+func formatModified(modified string) string {
+	t, err := time.Parse(time.RFC3339Nano, modified)
+	if err != nil {
+		return modified
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+// pullModel downloads a model, showing its progress until it finishes or
+// the user presses Ctrl-C
+func pullModel(config *storage.Config, name string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	progress := &pullProgress{out: os.Stdout}
+	err := ai.PullOllamaModel(ctx, config.OllamaURL, name, progress.Update)
+	progress.Finish()
+	if err != nil {
+		return err
+	}
+
+	if !ai.SameOllamaModel(name, config.OllamaModel) {
+		fmt.Printf("Use it with 'config set ollama_model %s'\n", name)
+	}
+	return nil
+}
+
+// pullProgress shows the progress of a download. Steps without a size are
+// printed on a line each, and a layer being downloaded is redrawn in place
+// with its percentage.
+type pullProgress struct {
+	out     io.Writer
+	status  string
+	drawing bool
+}
+
+// Update shows a progress update
+func (p *pullProgress) Update(update ai.OllamaProgress) {
+	if update.Status != p.status {
+		p.Finish()
+		p.status = update.Status
+		if update.Total == 0 {
+			_, _ = fmt.Fprintln(p.out, update.Status)
+			return
+		}
+	}
+	if update.Total == 0 {
+		return
+	}
+
+	percent := update.Completed * 100 / update.Total
+	_, _ = fmt.Fprintf(p.out, "\r%s: %3d%% (%s of %s)", update.Status, percent,
+		formatSize(update.Completed), formatSize(update.Total))
+	p.drawing = true
+}
+
+// Finish ends a line being redrawn
+func (p *pullProgress) Finish() {
+	if p.drawing {
+		_, _ = fmt.Fprintln(p.out)
+		p.drawing = false
+	}
+}
+
+// showModel prints what Ollama knows about a model
+func showModel(config *storage.Config, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), modelsTimeout)
+	defer cancel()
+
+	info, err := ai.ShowOllamaModel(ctx, config.OllamaURL, name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Model: %s\n", name)
+	for _, field := range []struct{ label, value string }{
+		{"Family", info.Details.Family},
+		{"Parameters", info.Details.ParameterSize},
+		{"Quantization", info.Details.QuantizationLevel},
+		{"Format", info.Details.Format},
+		{"Modified", formatModified(info.ModifiedAt)},
+	} {
+		if field.value != "" {
+			fmt.Printf("  %-14s %s\n", field.label+":", field.value)
+		}
+	}
+	if parameters := strings.TrimSpace(info.Parameters); parameters != "" {
+		fmt.Println("  Settings:")
+		for _, line := range strings.Split(parameters, "\n") {
+			fmt.Printf("    %s\n", strings.Join(strings.Fields(line), " "))
+		}
+	}
+	if license := strings.TrimSpace(info.License); license != "" {
+		first, _, _ := strings.Cut(license, "\n")
+		fmt.Printf("  %-14s %s\n", "License:", strings.TrimSpace(first))
+	}
+	return nil
+}
+
+// removeModel deletes a model from Ollama
+func removeModel(config *storage.Config, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), modelsTimeout)
+	defer cancel()
+
+	if err := ai.DeleteOllamaModel(ctx, config.OllamaURL, name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %s\n", name)
+	if ai.SameOllamaModel(name, config.OllamaModel) {
+		fmt.Println("It was the model in use; pick another with 'config set ollama_model <name>'")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/ai"
+)
+
+func TestPullProgress(t *testing.T) {
+	var out strings.Builder
+	progress := &pullProgress{out: &out}
+	for _, update := range []ai.OllamaProgress{
+		{Status: "pulling manifest"},
+		{Status: "pulling 6a0746a1ec1a", Total: 4 << 30, Completed: 1 << 30},
+		{Status: "pulling 6a0746a1ec1a", Total: 4 << 30, Completed: 4 << 30},
+		{Status: "verifying sha256 digest"},
+		{Status: "success"},
+	} {
+		progress.Update(update)
+	}
+	progress.Finish()
+
+	expected := "pulling manifest\n" +
+		"\rpulling 6a0746a1ec1a:  25% (1.0 GB of 4.0 GB)" +
+		"\rpulling 6a0746a1ec1a: 100% (4.0 GB of 4.0 GB)\n" +
+		"verifying sha256 digest\n" +
+		"success\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
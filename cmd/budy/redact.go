@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/redact"
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// processRedactCommand handles the commands that show and change how
+// secrets are masked in history and AI prompts
+func processRedactCommand(input string, redactor *redact.Redactor, dataDir string, config *storage.Config) {
+	command, arg, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(input, "redact")), " ")
+	arg = strings.TrimSpace(arg)
+
+	switch command {
+	case "", "list":
+		fmt.Printf("Built-in detectors: %s\n", strings.Join(redact.Detectors(), ", "))
+		if len(config.RedactPatterns) == 0 {
+			fmt.Println("Your patterns: none; add one with 'redact add <regex>'")
+			return
+		}
+		fmt.Println("Your patterns:")
+		for i, pattern := range config.RedactPatterns {
+			fmt.Printf("  %d. %s\n", i+1, pattern)
+		}
+
+	case "add":
+		if arg == "" {
+			fmt.Println("Usage: redact add <regex>")
+			return
+		}
+		if _, err := redact.Compile(arg); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		setRedactPatterns(append(append([]string{}, config.RedactPatterns...), arg), redactor, dataDir, config)
+
+	case "rm", "remove":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(config.RedactPatterns) {
+			fmt.Println("Usage: redact rm <n>, with n as numbered by 'redact list'")
+			return
+		}
+		patterns := append([]string{}, config.RedactPatterns[:n-1]...)
+		setRedactPatterns(append(patterns, config.RedactPatterns[n:]...), redactor, dataDir, config)
+
+	case "test":
+		if arg == "" {
+			fmt.Println("Usage: redact test <text>")
+			return
+		}
+		findings := redactor.Find(arg)
+		if len(findings) == 0 {
+			fmt.Println("No secrets found")
+			return
+		}
+		fmt.Println(redactor.Redact(arg))
+		for _, finding := range findings {
+			fmt.Printf("  %s: %s\n", finding.Detector, arg[finding.Start:finding.End])
+		}
+
+	default:
+		fmt.Println("Usage: redact [list|add <regex>|rm <n>|test <text>]")
+	}
+}
+
+// setRedactPatterns saves the user patterns and starts masking with them
+func setRedactPatterns(patterns []string, redactor *redact.Redactor, dataDir string, config *storage.Config) {
+	if err := redactor.SetPatterns(patterns); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if err := storage.SetRedactPatterns(dataDir, config, patterns); err != nil {
+		fmt.Printf("Error saving configuration: %v\n", err)
+		return
+	}
+	fmt.Printf("Masking %d pattern(s) of your own along with the built-in detectors\n", len(patterns))
+}
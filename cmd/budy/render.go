@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/ai"
+)
+
+// renderer prints AI answers to the terminal as they stream in
+type renderer struct {
+	out     io.Writer
+	written bool
+	newline bool
+}
+
+// newRenderer creates a renderer writing to out
+func newRenderer(out io.Writer) *renderer {
+	return &renderer{out: out}
+}
+
+// Token prints a piece of an answer; it is used as ai.Request.OnToken
+func (r *renderer) Token(token string) error {
+	if token == "" {
+		return nil
+	}
+	if _, err := io.WriteString(r.out, token); err != nil {
+		return err
+	}
+	r.written = true
+	r.newline = strings.HasSuffix(token, "\n")
+	return nil
+}
+
+// Finish ends an answer. Answers that were not streamed are printed in
+// full, and streamed ones get a final newline if they lack one.
+func (r *renderer) Finish(resp *ai.Response) error {
+	if !r.written && resp != nil {
+		if err := r.Token(resp.Text); err != nil {
+			return err
+		}
+	}
+	if !r.written || r.newline {
+		return nil
+	}
+	r.newline = true
+	_, err := io.WriteString(r.out, "\n")
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/ai"
+)
+
+// TestRenderer tests that answers are printed in full and end with a newline
+func TestRenderer(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokens   []string
+		response *ai.Response
+		expected string
+	}{
+		{"Streamed", []string{"Hello", " world"}, &ai.Response{Text: "Hello world"}, "Hello world\n"},
+		{"StreamedNewline", []string{"Hello\n"}, &ai.Response{Text: "Hello\n"}, "Hello\n"},
+		{"NotStreamed", nil, &ai.Response{Text: "Hello"}, "Hello\n"},
+		{"Cancelled", []string{"Hel"}, nil, "Hel\n"},
+		{"Empty", nil, nil, ""},
+		{"EmptyTokens", []string{"", ""}, &ai.Response{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			render := newRenderer(&out)
+			for _, token := range tt.tokens {
+				if err := render.Token(token); err != nil {
+					t.Fatalf("Failed to render token: %v", err)
+				}
+			}
+			if err := render.Finish(tt.response); err != nil {
+				t.Fatalf("Failed to finish: %v", err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/ai"
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// readSecret reads a line without showing it, for API keys and
+// passphrases. main reads from the terminal; until then nothing can be
+// read.
+var readSecret = func(prompt string) (string, error) {
+	return "", errors.New("no terminal to read from")
+}
+
+// unlockSecrets asks for the passphrase of encrypted secrets and loads
+// them into config. An empty passphrase skips unlocking.
+func unlockSecrets(dataDir string, config *storage.Config) {
+	for attempt := 0; attempt < 3; attempt++ {
+		passphrase, err := readSecret("Passphrase for your API keys (Enter to skip): ")
+		if err != nil || passphrase == "" {
+			break
+		}
+
+		err = storage.Unlock(dataDir, passphrase)
+		if errors.Is(err, storage.ErrWrongPassphrase) {
+			fmt.Println("Wrong passphrase")
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Error unlocking secrets: %v\n", err)
+			return
+		}
+
+		loaded, err := storage.LoadConfig(dataDir)
+		if err != nil {
+			fmt.Printf("Error loading configuration: %v\n", err)
+			return
+		}
+		*config = *loaded
+		return
+	}
+	fmt.Println("Continuing without your API keys; type 'secrets unlock' to enter the passphrase later")
+}
+
+// protectSecrets moves API keys left in config.json by older versions into
+// the secrets file, and warns about files other users can read
+func protectSecrets(dataDir string, config *storage.Config) {
+	if moved, err := storage.MigrateSecrets(dataDir, config); err != nil {
+		fmt.Printf("Warning: Failed to move API keys out of config.json: %v\n", err)
+	} else if moved {
+		fmt.Printf("Moved your API keys from config.json to %s, which only you can read\n", filepath.Join(dataDir, "secrets.json"))
+	}
+
+	for _, warning := range storage.CheckPermissions(dataDir) {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+}
+
+// processSecretsCommand handles the commands that show and protect the
+// saved API keys
+func processSecretsCommand(input string, dataDir string, config *storage.Config) {
+	switch strings.TrimSpace(strings.TrimPrefix(input, "secrets")) {
+	case "", "status":
+		printSecrets(dataDir, config)
+
+	case "encrypt":
+		if storage.SecretsLocked(dataDir) {
+			fmt.Println("Your secrets are locked; type 'secrets unlock' first")
+			return
+		}
+		passphrase, err := readSecret("New passphrase: ")
+		if err != nil || passphrase == "" {
+			fmt.Println("No passphrase entered; nothing changed")
+			return
+		}
+		if again, err := readSecret("Repeat the passphrase: "); err != nil || again != passphrase {
+			fmt.Println("The passphrases don't match; nothing changed")
+			return
+		}
+		if err := storage.EncryptSecrets(dataDir, config, passphrase); err != nil {
+			fmt.Printf("Error encrypting secrets: %v\n", err)
+			return
+		}
+		fmt.Println("Secrets encrypted. budy will ask for the passphrase when it starts; there is no way to recover it if lost")
+
+	case "decrypt":
+		if !storage.SecretsEncrypted(dataDir) {
+			fmt.Println("Your secrets aren't encrypted")
+			return
+		}
+		if err := storage.DecryptSecrets(dataDir, config); err != nil {
+			fmt.Printf("Error decrypting secrets: %v\n", err)
+			return
+		}
+		fmt.Println("Secrets are no longer encrypted, and are still readable only by you")
+
+	case "unlock":
+		if !storage.SecretsLocked(dataDir) {
+			fmt.Println("Your secrets aren't locked")
+			return
+		}
+		unlockSecrets(dataDir, config)
+
+	default:
+		fmt.Println("Usage: secrets [status|encrypt|decrypt|unlock]")
+	}
+}
+
+// printSecrets describes where the secrets are kept and which are set,
+// masked
+func printSecrets(dataDir string, config *storage.Config) {
+	fmt.Printf("API keys are kept in %s, readable only by you\n", filepath.Join(dataDir, "secrets.json"))
+	switch {
+	case storage.SecretsLocked(dataDir):
+		fmt.Println("They are encrypted and locked; type 'secrets unlock' to enter the passphrase")
+		return
+	case storage.SecretsEncrypted(dataDir):
+		fmt.Println("They are encrypted with your passphrase")
+	default:
+		fmt.Println("They aren't encrypted; type 'secrets encrypt' to protect them with a passphrase")
+	}
+
+	for _, provider := range ai.Providers() {
+		for i := range provider.Settings {
+			if setting := &provider.Settings[i]; setting.Secret {
+				fmt.Printf("    %-20s %s\n", setting.Name, setting.Display(config))
+			}
+		}
+	}
+
+	// Extra OpenAI headers often carry credentials, so they are kept
+	// with the keys
+	names := make([]string, 0, len(config.OpenAIHeaders))
+	for name := range config.OpenAIHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("    %-20s %s\n", "openai_header "+name, ai.MaskSecret(config.OpenAIHeaders[name]))
+	}
+}
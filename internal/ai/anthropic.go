@@ -0,0 +1,346 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// Ensure AnthropicClient implements the Client interface
+var _ Client = (*AnthropicClient)(nil)
+
+// Anthropic provider name and defaults
+const (
+	ProviderAnthropic       = "anthropic"
+	DefaultAnthropicBaseURL = "https://api.anthropic.com"
+	DefaultAnthropicModel   = "claude-3-5-haiku-latest"
+
+	// anthropicVersion is the API version requests are written against
+	anthropicVersion = "2023-06-01"
+
+	// anthropicMaxTokens limits the length of an answer, which the
+	// Messages API requires
+	anthropicMaxTokens = 4096
+)
+
+// AnthropicClient handles interactions with Anthropic's Messages API
+type AnthropicClient struct {
+	apiKey  string
+	baseURL string
+	model   string
+}
+
+// AnthropicRequest represents a request to the Messages API
+type AnthropicRequest struct {
+	Model       string    `json:"model"`
+	System      string    `json:"system,omitempty"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   int       `json:"max_tokens"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+}
+
+// AnthropicUsage reports the tokens used by a request
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicResponse represents a complete response from the Messages API
+type AnthropicResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string          `json:"stop_reason"`
+	Usage      *AnthropicUsage `json:"usage,omitempty"`
+}
+
+// AnthropicStreamEvent represents one event of a streamed response. Which
+// fields are set depends on the type of event.
+type AnthropicStreamEvent struct {
+	Type    string             `json:"type"`
+	Message *AnthropicResponse `json:"message,omitempty"`
+	Delta   *struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta,omitempty"`
+	Usage *AnthropicUsage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// NewAnthropicClient creates a new Anthropic client. An empty baseURL or
+// model means Anthropic's own API and the default model.
+func NewAnthropicClient(apiKey, baseURL, model string) *AnthropicClient {
+	if baseURL == "" {
+		baseURL = DefaultAnthropicBaseURL
+	}
+	if model == "" {
+		model = DefaultAnthropicModel
+	}
+	return &AnthropicClient{
+		apiKey:  apiKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		model:   model,
+	}
+}
+
+// Complete sends a request to the Messages API and returns the response,
+// streaming it to req.OnToken when set
+func (c *AnthropicClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	if c.apiKey == "" {
+		return nil, fmt.Errorf("Anthropic API key not set (use export ANTHROPIC_API_KEY=your_key)")
+	}
+
+	model := req.Model
+	if model == "" {
+		model = c.model
+	}
+
+	system := req.System
+	if req.JSON {
+		// There is no JSON mode, so ask for it in the prompt as well
+		system = strings.TrimSpace(system + "\n\nRespond with only the JSON object.")
+	}
+	reqBody := AnthropicRequest{
+		Model:       model,
+		System:      system,
+		Messages:    req.Messages,
+		MaxTokens:   anthropicMaxTokens,
+		Temperature: req.Temperature,
+		Stream:      req.OnToken != nil,
+	}
+
+	reqData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/v1/messages", bytes.NewBuffer(reqData))
+	if err != nil {
+		return nil, err
+	}
+
+	c.setHeaders(httpReq)
+	httpReq.Header.Set("Content-Type", "application/json")
+	if reqBody.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+
+	// Send request
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, streamError(ctx, err)
+	}
+
+	// Use a closure to properly handle the error from Body.Close()
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing response body: %v\n", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, streamError(ctx, err)
+		}
+		return nil, newStatusError(resp, body)
+	}
+
+	var result *Response
+	if reqBody.Stream {
+		result, err = c.readStream(resp.Body, req)
+	} else {
+		result, err = c.readResponse(resp.Body)
+	}
+	if err != nil {
+		return nil, streamError(ctx, err)
+	}
+	if result.Model == "" {
+		result.Model = model
+	}
+
+	return result, nil
+}
+
+// Check makes sure the server answers and accepts the API key, by listing
+// its models
+func (c *AnthropicClient) Check(ctx context.Context) error {
+	if c.apiKey == "" {
+		return fmt.Errorf("Anthropic API key not set")
+	}
+
+	headers := map[string]string{"X-Api-Key": c.apiKey, "Anthropic-Version": anthropicVersion}
+	status, err := checkHTTP(ctx, c.baseURL+"/v1/models", headers)
+	if err != nil {
+		return fmt.Errorf("could not reach %s: %v", c.baseURL, err)
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return fmt.Errorf("%s rejected the API key (status %d)", c.baseURL, status)
+	}
+	return nil
+}
+
+// setHeaders adds the authentication and version headers to a request
+func (c *AnthropicClient) setHeaders(req *http.Request) {
+	req.Header.Set("X-Api-Key", c.apiKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+}
+
+// readResponse parses a complete message
+func (c *AnthropicClient) readResponse(body io.Reader) (*Response, error) {
+	var anthropicResp AnthropicResponse
+	if err := json.NewDecoder(body).Decode(&anthropicResp); err != nil {
+		return nil, err
+	}
+
+	result := &Response{
+		Model:        anthropicResp.Model,
+		FinishReason: anthropicResp.StopReason,
+	}
+	var text strings.Builder
+	for _, block := range anthropicResp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	result.Text = text.String()
+	if anthropicResp.Usage != nil {
+		result.Usage = anthropicUsage(*anthropicResp.Usage)
+	}
+	return result, nil
+}
+
+// readStream parses a streamed message, passing each text delta to the
+// request's callback until the message_stop event
+func (c *AnthropicClient) readStream(body io.Reader, req Request) (*Response, error) {
+	result := &Response{}
+	var usage AnthropicUsage
+	var text strings.Builder
+
+	err := readSSE(body, func(data string) error {
+		var event AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case "error":
+			if event.Error != nil {
+				return fmt.Errorf("API error: %s", event.Error.Message)
+			}
+			return fmt.Errorf("API error: %s", data)
+		case "message_start":
+			if event.Message != nil {
+				result.Model = event.Message.Model
+				if event.Message.Usage != nil {
+					usage.InputTokens = event.Message.Usage.InputTokens
+				}
+			}
+		case "content_block_delta":
+			if event.Delta != nil && event.Delta.Type == "text_delta" {
+				text.WriteString(event.Delta.Text)
+				return req.token(event.Delta.Text)
+			}
+		case "message_delta":
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				result.FinishReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	result.Text = text.String()
+	result.Usage = anthropicUsage(usage)
+	return result, nil
+}
+
+// anthropicUsage converts Anthropic's token counts
+func anthropicUsage(usage AnthropicUsage) Usage {
+	return Usage{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      usage.InputTokens + usage.OutputTokens,
+	}
+}
+
+// anthropicKey returns the Anthropic API key from the environment or the
+// config
+func anthropicKey(c *storage.Config) string {
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		return key
+	}
+	return c.Setting("anthropic_key")
+}
+
+// newAnthropicClient creates a client from the configuration
+func newAnthropicClient(c *storage.Config) *AnthropicClient {
+	return NewAnthropicClient(anthropicKey(c), c.Setting("anthropic_base_url"), c.Setting("anthropic_model"))
+}
+
+func init() {
+	RegisterProvider(Provider{
+		Name:        ProviderAnthropic,
+		Description: "Anthropic's Messages API",
+		Settings: []Setting{
+			{
+				Name:   "anthropic_key",
+				Usage:  "<your_api_key>",
+				Secret: true,
+			},
+			{
+				Name:   "anthropic_model",
+				Usage:  "<model|default>",
+				Values: staticValues("default", "claude-3-5-haiku-latest", "claude-3-5-sonnet-latest"),
+				Parse:  orDefault("default", nil),
+			},
+			{
+				Name:   "anthropic_base_url",
+				Usage:  "<url|default>",
+				Values: staticValues("default"),
+				Parse:  orDefault("default", parseServerURL),
+			},
+		},
+		New: func(c *storage.Config) (Client, error) {
+			if anthropicKey(c) == "" {
+				return nil, fmt.Errorf("Anthropic API key not set. You need to set it with 'config set anthropic_key <your_key>'")
+			}
+			return newAnthropicClient(c), nil
+		},
+		Check: func(ctx context.Context, c *storage.Config) error {
+			return newAnthropicClient(c).Check(ctx)
+		},
+		Describe: func(c *storage.Config) string {
+			client := newAnthropicClient(c)
+			return fmt.Sprintf("Anthropic API at %s with model: %s", client.baseURL, client.model)
+		},
+		Model: func(c *storage.Config) string {
+			return newAnthropicClient(c).model
+		},
+		Help: "Set your API key with 'config set anthropic_key <your_key>' or export ANTHROPIC_API_KEY",
+	})
+}
@@ -0,0 +1,134 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnthropicClientStreaming(t *testing.T) {
+	var got AnthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("Expected /v1/messages endpoint, got %s", r.URL.Path)
+		}
+		if key := r.Header.Get("X-Api-Key"); key != "test-key" {
+			t.Errorf("Expected the API key header, got %q", key)
+		}
+		if version := r.Header.Get("Anthropic-Version"); version != anthropicVersion {
+			t.Errorf("Expected the version header, got %q", version)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeChunks(t, w,
+			"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-test\",\"usage\":{\"input_tokens\":12}}}\n\n",
+			"event: ping\ndata: {\"type\":\"ping\"}\n\n",
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Use \"}}\n\n",
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"ls\"}}\n\n",
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":3}}\n\n",
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+		)
+	}))
+	defer server.Close()
+
+	client := NewAnthropicClient("test-key", server.URL, "")
+	var tokens []string
+	req := NewRequest("How do I list files?")
+	req.System = "Be brief"
+	req.OnToken = func(token string) error {
+		tokens = append(tokens, token)
+		return nil
+	}
+
+	resp, err := client.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !got.Stream || got.Model != DefaultAnthropicModel || got.System != "Be brief" || got.MaxTokens != anthropicMaxTokens {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Role != RoleUser {
+		t.Errorf("Expected only the question in messages, got %+v", got.Messages)
+	}
+	if strings.Join(tokens, "|") != "Use |ls" {
+		t.Errorf("Expected tokens [Use  ls], got %q", tokens)
+	}
+
+	expected := Response{
+		Text:         "Use ls",
+		Model:        "claude-test",
+		FinishReason: "end_turn",
+		Usage:        Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+	}
+	if *resp != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *resp)
+	}
+}
+
+func TestAnthropicClientComplete(t *testing.T) {
+	var got AnthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"model":"claude-test","content":[{"type":"text","text":"{\"command\":\"ls\"}"}],"stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	client := NewAnthropicClient("test-key", server.URL+"/", "claude-test")
+	resp, err := client.Complete(context.Background(), Request{
+		System:      "Suggest a command",
+		Messages:    []Message{{Role: RoleUser, Content: "list files"}},
+		Temperature: Temperature(0),
+		JSON:        true,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got.Stream || got.Model != "claude-test" || got.Temperature == nil || *got.Temperature != 0 {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+	if !strings.HasPrefix(got.System, "Suggest a command") || !strings.Contains(got.System, "JSON") {
+		t.Errorf("Expected JSON to be asked for in the system prompt, got %q", got.System)
+	}
+	if resp.Text != `{"command":"ls"}` || resp.Usage.TotalTokens != 9 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+}
+
+func TestAnthropicClientErrors(t *testing.T) {
+	if _, err := NewAnthropicClient("", "", "").Complete(context.Background(), NewRequest("hi")); err == nil {
+		t.Error("Expected an error without an API key")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/models" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeChunks(t, w,
+			"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n",
+		)
+	}))
+	defer server.Close()
+
+	client := NewAnthropicClient("bad-key", server.URL, "")
+	req := NewRequest("hi")
+	req.OnToken = func(string) error { return nil }
+	if _, err := client.Complete(context.Background(), req); err == nil || !strings.Contains(err.Error(), "Overloaded") {
+		t.Errorf("Expected the stream error, got %v", err)
+	}
+	if err := client.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("Expected the key to be rejected, got %v", err)
+	}
+}
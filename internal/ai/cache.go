@@ -0,0 +1,375 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// Ensure CachedClient implements the Client interface
+var _ Client = (*CachedClient)(nil)
+
+// CacheEntry is an answer saved in the cache
+type CacheEntry struct {
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	Created  time.Time `json:"created"`
+	Response Response  `json:"response"`
+}
+
+// CacheStats describes what the cache holds
+type CacheStats struct {
+	Entries int
+	Size    int64
+	Oldest  time.Time
+
+	// Hits and Misses count lookups since the cache was opened
+	Hits   int
+	Misses int
+}
+
+// Cache keeps AI answers on disk, one file per answer, so that asking the
+// same question in the same context again is answered at once. Answers
+// expire after the configured time, and the oldest are removed when the
+// cache grows past its size limit.
+type Cache struct {
+	dir      string
+	settings func() storage.CacheConfig
+	now      func() time.Time
+
+	mu     sync.Mutex
+	hits   int
+	misses int
+}
+
+// NewCache creates a cache in dir. settings is called on each use, so
+// changes to the limits apply at once.
+func NewCache(dir string, settings func() storage.CacheConfig) *Cache {
+	return &Cache{
+		dir:      dir,
+		settings: settings,
+		now:      time.Now,
+	}
+}
+
+// Enabled reports whether answers are cached
+func (c *Cache) Enabled() bool {
+	return c.settings().TTLMinutes > 0
+}
+
+// ttl returns how long answers are reused
+func (c *Cache) ttl() time.Duration {
+	return time.Duration(c.settings().TTLMinutes) * time.Minute
+}
+
+// CacheKey identifies an answer by provider, model, the user's last
+// question and the parts of the system prompt that don't change from one
+// command to the next: the prompt itself, the system, shell and directory.
+// Recent commands, the directory listing and earlier messages are left
+// out, so that asking the same question again reuses the answer.
+// Whitespace in the question is normalized.
+func CacheKey(provider, model string, req Request) string {
+	fingerprint := sha256.Sum256([]byte(stableContext(req.System)))
+
+	var b strings.Builder
+	b.WriteString(provider + "\x00" + model + "\x00")
+	b.WriteString(hex.EncodeToString(fingerprint[:]) + "\x00")
+	b.WriteString(strconv.FormatBool(req.JSON) + "\x00")
+	if req.Temperature != nil {
+		b.WriteString(strconv.FormatFloat(*req.Temperature, 'g', -1, 64))
+	}
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == RoleUser {
+			b.WriteString("\x00" + normalizePrompt(req.Messages[i].Content))
+			break
+		}
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizePrompt collapses runs of whitespace, so that answers are reused
+// for prompts that differ only in spacing
+func normalizePrompt(prompt string) string {
+	return strings.Join(strings.Fields(prompt), " ")
+}
+
+// path returns the file holding the answer for key
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// Get returns the answer saved under key, unless it has expired
+func (c *Cache) Get(key string) (*CacheEntry, bool) {
+	entry, err := c.read(c.path(key))
+	if err == nil && c.now().Sub(entry.Created) >= c.ttl() {
+		_ = os.Remove(c.path(key))
+		err = os.ErrNotExist
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return entry, true
+}
+
+// read loads a cache entry from a file
+func (c *Cache) read(path string) (*CacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Put saves an answer under key, then removes expired answers and the
+// oldest ones beyond the size limit
+func (c *Cache) Put(key, provider, model string, resp *Response) error {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+
+	saved := *resp
+	saved.Provider = provider
+	entry := CacheEntry{
+		Provider: provider,
+		Model:    model,
+		Created:  c.now(),
+		Response: saved,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a reader never sees half
+	// an entry
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	// The file's time is the entry's, so pruning needn't read entries
+	if err := os.Chtimes(tmp, entry.Created, entry.Created); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		return err
+	}
+	return c.prune()
+}
+
+// cacheFile is an entry file found in the cache directory
+type cacheFile struct {
+	path    string
+	size    int64
+	created time.Time
+}
+
+// files lists the entries in the cache directory, oldest first. Only the
+// directory is read: an entry's file has the time it was created.
+func (c *Cache) files() ([]cacheFile, error) {
+	entries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]cacheFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{
+			path:    filepath.Join(c.dir, entry.Name()),
+			size:    info.Size(),
+			created: info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].created.Before(files[j].created)
+	})
+	return files, nil
+}
+
+// prune removes expired entries, then the oldest entries until the cache
+// fits its size limit
+func (c *Cache) prune() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+
+	now := c.now()
+	limit := c.settings().MaxSize
+	for _, file := range files {
+		if now.Sub(file.created) < c.ttl() && total <= limit {
+			break
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= file.size
+	}
+	return nil
+}
+
+// Stats describes the entries in the cache
+func (c *Cache) Stats() (CacheStats, error) {
+	files, err := c.files()
+	if err != nil {
+		return CacheStats{}, err
+	}
+
+	c.mu.Lock()
+	stats := CacheStats{Entries: len(files), Hits: c.hits, Misses: c.misses}
+	c.mu.Unlock()
+	for _, file := range files {
+		stats.Size += file.size
+	}
+	if len(files) > 0 {
+		stats.Oldest = files[0].created
+	}
+	return stats, nil
+}
+
+// Clear removes every entry and returns how many there were
+func (c *Cache) Clear() (int, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	return len(files), nil
+}
+
+// CachedClient answers from the cache when it can, and asks its client
+// and saves the answer otherwise. Answers are cached under the provider
+// that gave them, and looked up under the configured provider.
+type CachedClient struct {
+	client Client
+	cache  *Cache
+	config *storage.Config
+	bypass bool
+
+	// Notify, if set, is told when an answer comes from the cache
+	Notify func(message string)
+}
+
+// NewCachedClient creates a client that caches the answers of client
+func NewCachedClient(client Client, cache *Cache, config *storage.Config) *CachedClient {
+	return &CachedClient{client: client, cache: cache, config: config}
+}
+
+// Bypass returns a client that always asks for a fresh answer, and saves it
+// in place of any cached one
+func (c *CachedClient) Bypass() *CachedClient {
+	bypass := *c
+	bypass.bypass = true
+	return &bypass
+}
+
+// Primary returns the name of the configured provider
+func (c *CachedClient) Primary() string {
+	return c.config.AIProvider
+}
+
+// Cache returns the cache the client uses
+func (c *CachedClient) Cache() *Cache {
+	return c.cache
+}
+
+// Complete returns a cached answer to the request if there is one, passing
+// it to req.OnToken at once, and otherwise asks the client
+func (c *CachedClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	if !c.cache.Enabled() {
+		return c.client.Complete(ctx, req)
+	}
+
+	if !c.bypass {
+		key := CacheKey(c.config.AIProvider, providerModel(c.config, c.config.AIProvider), req)
+		if entry, ok := c.cache.Get(key); ok {
+			resp := entry.Response
+			resp.Cached = true
+			if c.Notify != nil {
+				c.Notify(fmt.Sprintf("(cached answer from %s ago; add --no-cache for a fresh one)", age(c.cache.now().Sub(entry.Created))))
+			}
+			if err := req.token(resp.Text); err != nil {
+				return nil, err
+			}
+			return &resp, nil
+		}
+	}
+
+	resp, err := c.client.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := resp.Provider
+	if provider == "" {
+		provider = c.config.AIProvider
+	}
+	model := providerModel(c.config, provider)
+	if err := c.cache.Put(CacheKey(provider, model, req), provider, model, resp); err != nil && c.Notify != nil {
+		c.Notify(fmt.Sprintf("Warning: Failed to cache the answer: %v", err))
+	}
+	return resp, nil
+}
+
+// providerModel returns the model a registered provider answers with
+func providerModel(config *storage.Config, name string) string {
+	provider, ok := LookupProvider(name)
+	if !ok || provider.Model == nil {
+		return ""
+	}
+	return provider.Model(config)
+}
+
+// age describes a duration roughly, such as "5m" or "3h"
+func age(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
@@ -0,0 +1,238 @@
+package ai
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/shell"
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// newTestCache creates a cache in a temporary directory with a clock the
+// test controls
+func newTestCache(t *testing.T, settings storage.CacheConfig) (*Cache, *time.Time) {
+	t.Helper()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewCache(t.TempDir(), func() storage.CacheConfig { return settings })
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func TestCacheKey(t *testing.T) {
+	req := Request{System: "In /home/user", Messages: []Message{{Role: RoleUser, Content: "how do I  list files?"}}}
+	key := CacheKey("ollama", "llama3", req)
+
+	same := req
+	same.Messages = []Message{{Role: RoleUser, Content: " how do I list\nfiles? "}}
+	if CacheKey("ollama", "llama3", same) != key {
+		t.Error("Expected prompts differing only in whitespace to share a key")
+	}
+
+	different := []struct {
+		name     string
+		provider string
+		model    string
+		change   func(r *Request)
+	}{
+		{"provider", "openai", "llama3", func(r *Request) {}},
+		{"model", "ollama", "mistral", func(r *Request) {}},
+		{"context", "ollama", "llama3", func(r *Request) { r.System = "In /tmp" }},
+		{"prompt", "ollama", "llama3", func(r *Request) { r.Messages = []Message{{Role: RoleUser, Content: "how do I list Files?"}} }},
+		{"JSON", "ollama", "llama3", func(r *Request) { r.JSON = true }},
+		{"temperature", "ollama", "llama3", func(r *Request) { r.Temperature = Temperature(0) }},
+	}
+	for _, test := range different {
+		changed := req
+		test.change(&changed)
+		if CacheKey(test.provider, test.model, changed) == key {
+			t.Errorf("Expected a different %s to change the key", test.name)
+		}
+	}
+}
+
+// TestCacheKeyStableContext tests that the same question shares a key
+// after other commands were run or questions asked, but not in another
+// directory
+func TestCacheKeyStableContext(t *testing.T) {
+	builder, _, dir := newTestContextBuilder(t, shell.CommandEntry{Command: "ls", Result: &shell.ExecResult{}})
+	question := Message{Role: RoleUser, Content: "how do I find large files?"}
+	key := CacheKey("ollama", "llama3", Request{System: builder.SystemPrompt(), Messages: []Message{question}})
+
+	// A failed command changes the recent commands, the last failure and
+	// the listing
+	if err := builder.history.RecordEntry(shell.CommandEntry{Command: "make > build.log", Result: &shell.ExecResult{ExitCode: 2}}); err != nil {
+		t.Fatalf("Failed to record entry: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "build.log"), nil, 0600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	req := Request{System: builder.SystemPrompt(), Messages: []Message{question}}
+	if CacheKey("ollama", "llama3", req) != key {
+		t.Error("Expected the same question to share a key after another command")
+	}
+
+	// Earlier messages of the conversation don't matter
+	req.Messages = []Message{{Role: RoleUser, Content: "hi"}, {Role: RoleAssistant, Content: "Hello"}, question}
+	if CacheKey("ollama", "llama3", req) != key {
+		t.Error("Expected the same question to share a key later in a conversation")
+	}
+
+	// Answers for generating commands are kept apart
+	req.System = builder.CommandPrompt()
+	if CacheKey("ollama", "llama3", req) == key {
+		t.Error("Expected the command prompt to change the key")
+	}
+
+	if err := builder.executor.Session().Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	req = Request{System: builder.SystemPrompt(), Messages: []Message{question}}
+	if CacheKey("ollama", "llama3", req) == key {
+		t.Error("Expected another directory to change the key")
+	}
+}
+
+func TestCacheGetPut(t *testing.T) {
+	cache, now := newTestCache(t, storage.CacheConfig{TTLMinutes: 60, MaxSize: 1 << 20})
+
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Expected an empty cache")
+	}
+	if err := cache.Put("key", "ollama", "llama3", &Response{Text: "Use ls", Model: "llama3"}); err != nil {
+		t.Fatalf("Failed to save answer: %v", err)
+	}
+
+	entry, ok := cache.Get("key")
+	if !ok || entry.Response.Text != "Use ls" || entry.Provider != "ollama" || entry.Model != "llama3" {
+		t.Fatalf("Expected the saved answer, got %+v", entry)
+	}
+
+	// Answers expire
+	*now = now.Add(time.Hour)
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Expected the answer to expire")
+	}
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Entries != 0 || stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheSizeLimit(t *testing.T) {
+	cache, now := newTestCache(t, storage.CacheConfig{TTLMinutes: 60, MaxSize: 1000})
+
+	answer := strings.Repeat("x", 300)
+	for _, key := range []string{"first", "second", "third", "fourth"} {
+		if err := cache.Put(key, "ollama", "llama3", &Response{Text: answer}); err != nil {
+			t.Fatalf("Failed to save answer: %v", err)
+		}
+		*now = now.Add(time.Minute)
+	}
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Size > 1000 || stats.Entries >= 4 {
+		t.Errorf("Expected the cache to fit its limit, got %+v", stats)
+	}
+	if _, ok := cache.Get("first"); ok {
+		t.Error("Expected the oldest answer to be removed")
+	}
+	if _, ok := cache.Get("fourth"); !ok {
+		t.Error("Expected the newest answer to be kept")
+	}
+
+	// Entries are pruned by the time of their files, without reading them
+	stale := filepath.Join(cache.dir, "stale.json")
+	if err := os.WriteFile(stale, []byte("not an entry"), 0600); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+	past := now.Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, past, past); err != nil {
+		t.Fatalf("Failed to age entry: %v", err)
+	}
+	if err := cache.Put("fifth", "ollama", "llama3", &Response{Text: "short"}); err != nil {
+		t.Fatalf("Failed to save answer: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("Expected the expired entry to be removed")
+	}
+	stats, err = cache.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+
+	removed, err := cache.Clear()
+	if err != nil || removed != stats.Entries {
+		t.Errorf("Expected %d answers removed, got %d, %v", stats.Entries, removed, err)
+	}
+	if stats, _ := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("Expected an empty cache, got %+v", stats)
+	}
+}
+
+func TestCachedClient(t *testing.T) {
+	cache, now := newTestCache(t, storage.DefaultCacheConfig())
+	config := &storage.Config{AIProvider: storage.ProviderOllama, OllamaModel: "llama3"}
+	client := &fakeClient{text: "Use ls"}
+	cached := NewCachedClient(client, cache, config)
+	var messages []string
+	cached.Notify = func(message string) {
+		messages = append(messages, message)
+	}
+
+	if resp, err := cached.Complete(context.Background(), NewRequest("How do I list files?")); err != nil || resp.Cached {
+		t.Fatalf("Expected a fresh answer, got %+v, %v", resp, err)
+	}
+
+	*now = now.Add(5 * time.Minute)
+	var streamed string
+	req := NewRequest("How do I   list files?")
+	req.OnToken = func(token string) error {
+		streamed += token
+		return nil
+	}
+	resp, err := cached.Complete(context.Background(), req)
+	if err != nil || !resp.Cached || resp.Text != "Use ls" || streamed != "Use ls" {
+		t.Fatalf("Expected the cached answer to be streamed, got %+v, %v, %q", resp, err, streamed)
+	}
+	if len(client.requests) != 1 {
+		t.Errorf("Expected the client to be asked once, got %d", len(client.requests))
+	}
+	if len(messages) != 1 || !strings.Contains(messages[0], "cached answer from 5m ago") {
+		t.Errorf("Expected the answer to be marked as cached, got %q", messages)
+	}
+
+	// Bypassing asks again and replaces the saved answer
+	client.text = "Use ls -la"
+	if resp, err := cached.Bypass().Complete(context.Background(), NewRequest("How do I list files?")); err != nil || resp.Cached {
+		t.Fatalf("Expected a fresh answer, got %+v, %v", resp, err)
+	}
+	if resp, _ := cached.Complete(context.Background(), NewRequest("How do I list files?")); resp.Text != "Use ls -la" {
+		t.Errorf("Expected the fresh answer to be cached, got %q", resp.Text)
+	}
+
+	// A different model has its own answers
+	config.OllamaModel = "mistral"
+	if resp, _ := cached.Complete(context.Background(), NewRequest("How do I list files?")); resp.Cached {
+		t.Error("Expected no cached answer for another model")
+	}
+
+	// Turning the cache off always asks
+	cache.settings = func() storage.CacheConfig { return storage.CacheConfig{} }
+	if resp, _ := cached.Complete(context.Background(), NewRequest("How do I list files?")); resp.Cached {
+		t.Error("Expected no cached answer with the cache off")
+	}
+	if len(client.requests) != 4 {
+		t.Errorf("Expected 4 requests, got %d", len(client.requests))
+	}
+}
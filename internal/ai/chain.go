@@ -0,0 +1,319 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// Ensure Chain implements the Client interface
+var _ Client = (*Chain)(nil)
+
+// Circuit breaker and retry settings
+const (
+	// breakerThreshold is how many failures in a row open a provider's
+	// circuit, so that it is skipped
+	breakerThreshold = 3
+
+	// breakerCooldown is how long an open circuit skips its provider
+	// before it is given another chance
+	breakerCooldown = 30 * time.Second
+
+	// probeTimeout limits a provider's health check
+	probeTimeout = 2 * time.Second
+)
+
+// Backoff describes how a failed request is retried
+type Backoff struct {
+	// Attempts is the number of times a request is sent, including the
+	// first
+	Attempts int
+
+	// Delay is the wait before the first retry, doubled before each of
+	// the next ones up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultBackoff is the retry policy used by a Chain
+var DefaultBackoff = Backoff{Attempts: 3, Delay: 500 * time.Millisecond, MaxDelay: 8 * time.Second}
+
+// wait returns the delay before the given retry, counting from 1
+func (b Backoff) wait(retry int) time.Duration {
+	delay := b.Delay
+	for i := 1; i < retry && delay < b.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > b.MaxDelay {
+		delay = b.MaxDelay
+	}
+	return delay
+}
+
+// breaker tracks a provider's failures in a row. After breakerThreshold of
+// them its circuit opens and the provider is skipped until the cooldown
+// is over; then one request is let through, which closes the circuit
+// again if it succeeds.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// open reports whether the provider should be skipped, and for how long
+func (b *breaker) open(now time.Time) (bool, time.Duration) {
+	if b.failures < breakerThreshold || !now.Before(b.openUntil) {
+		return false, 0
+	}
+	return true, b.openUntil.Sub(now)
+}
+
+// recovering reports whether the circuit was open and the provider is
+// being given another chance
+func (b *breaker) recovering() bool {
+	return b.failures >= breakerThreshold
+}
+
+func (b *breaker) success() {
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+func (b *breaker) failure(now time.Time) {
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openUntil = now.Add(breakerCooldown)
+	}
+}
+
+// Chain is a client that asks the configured provider and, when it fails,
+// each of the fallback providers in order. Requests failing with a rate
+// limit or server error are retried with exponential backoff first, and a
+// provider that keeps failing is skipped for a while. Providers are looked
+// up from the config on every request, so changes to it apply at once.
+type Chain struct {
+	config *storage.Config
+
+	// Notify, if set, receives messages about retries and fallbacks as
+	// they happen
+	Notify func(message string)
+
+	backoff  Backoff
+	lookup   func(name string) (*Provider, bool)
+	now      func() time.Time
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewChain creates a client for the providers in config
+func NewChain(config *storage.Config) *Chain {
+	return &Chain{
+		config:   config,
+		backoff:  DefaultBackoff,
+		lookup:   LookupProvider,
+		now:      time.Now,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// Primary returns the name of the configured provider
+func (c *Chain) Primary() string {
+	return c.config.AIProvider
+}
+
+// Providers returns the names of the providers tried, in order: the
+// configured one and then its fallbacks, each once
+func (c *Chain) Providers() []string {
+	names := []string{c.config.AIProvider}
+	for _, name := range c.config.FallbackProviders() {
+		duplicate := false
+		for _, seen := range names {
+			duplicate = duplicate || seen == name
+		}
+		if !duplicate {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Complete sends the request to the first provider able to answer it. The
+// answer's Provider says which one did. Once part of an answer has been
+// streamed no other provider is tried, since the user has already seen it.
+func (c *Chain) Complete(ctx context.Context, req Request) (*Response, error) {
+	streamed := false
+	if req.OnToken != nil {
+		onToken := req.OnToken
+		req.OnToken = func(token string) error {
+			streamed = true
+			return onToken(token)
+		}
+	}
+
+	var failures []string
+	var lastErr error
+	for i, name := range c.Providers() {
+		provider, ok := c.lookup(name)
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s: unknown provider", name))
+			continue
+		}
+
+		b := c.breaker(name)
+		c.mu.Lock()
+		open, remaining := b.open(c.now())
+		recovering := b.recovering()
+		c.mu.Unlock()
+		if open {
+			reason := fmt.Sprintf("it failed %d times in a row, trying it again in %s", breakerThreshold, remaining.Round(time.Second))
+			c.skip(i, name, reason)
+			failures = append(failures, fmt.Sprintf("%s: skipped, %s", name, reason))
+			continue
+		}
+
+		client, err := provider.New(c.config)
+		if err != nil {
+			// A missing setting won't fix itself, so it doesn't count
+			// against the provider
+			c.skip(i, name, err.Error())
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			lastErr = err
+			continue
+		}
+
+		// Fallbacks, and providers that have been failing, are checked
+		// before being asked so that a dead server is skipped quickly
+		if (i > 0 || recovering) && provider.Check != nil {
+			if err := c.probe(ctx, provider); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				c.recordFailure(name)
+				c.skip(i, name, err.Error())
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				lastErr = err
+				continue
+			}
+		}
+
+		resp, err := c.complete(ctx, name, client, req, &streamed)
+		if err == nil {
+			c.recordSuccess(name)
+			resp.Provider = name
+			return resp, nil
+		}
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+
+		c.recordFailure(name)
+		if streamed {
+			return nil, err
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		lastErr = err
+		if next := c.next(i); next != "" {
+			c.notify("%s failed: %v", name, err)
+			c.notify("Asking %s instead...", next)
+		}
+	}
+
+	if len(failures) == 1 && lastErr != nil {
+		return nil, lastErr
+	}
+	if len(failures) == 0 {
+		return nil, fmt.Errorf("no AI provider configured")
+	}
+	return nil, fmt.Errorf("no AI provider could answer:\n  %s", strings.Join(failures, "\n  "))
+}
+
+// complete sends a request to one provider, retrying with backoff while it
+// fails with a temporary error and nothing has been streamed
+func (c *Chain) complete(ctx context.Context, name string, client Client, req Request, streamed *bool) (*Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Complete(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || !statusErr.Temporary() || *streamed || attempt >= c.backoff.Attempts {
+			return nil, err
+		}
+
+		delay := c.backoff.wait(attempt)
+		if statusErr.RetryAfter > delay && statusErr.RetryAfter <= c.backoff.MaxDelay {
+			delay = statusErr.RetryAfter
+		}
+		c.notify("%s is unavailable (status %d), retrying in %s...", name, statusErr.StatusCode, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// probe runs a provider's health check with a short timeout
+func (c *Chain) probe(ctx context.Context, provider *Provider) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	return provider.Check(ctx, c.config)
+}
+
+// skip tells the user why the provider at index i is passed over, when
+// there is another one to try
+func (c *Chain) skip(i int, name, reason string) {
+	if c.next(i) != "" {
+		c.notify("Skipping %s: %s", name, reason)
+	}
+}
+
+// next returns the provider tried after the one at index i, if any
+func (c *Chain) next(i int) string {
+	names := c.Providers()
+	if i+1 < len(names) {
+		return names[i+1]
+	}
+	return ""
+}
+
+// breaker returns the circuit breaker for a provider
+func (c *Chain) breaker(name string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[name]
+	if !ok {
+		b = &breaker{}
+		c.breakers[name] = b
+	}
+	return b
+}
+
+func (c *Chain) recordSuccess(name string) {
+	b := c.breaker(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b.success()
+}
+
+func (c *Chain) recordFailure(name string) {
+	b := c.breaker(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b.failure(c.now())
+}
+
+// notify passes a message to the Notify callback, if any
+func (c *Chain) notify(format string, args ...interface{}) {
+	if c.Notify != nil {
+		c.Notify(fmt.Sprintf(format, args...))
+	}
+}
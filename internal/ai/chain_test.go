@@ -0,0 +1,231 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// scriptedClient returns the errors given in order, then answers
+type scriptedClient struct {
+	name   string
+	errors []error
+	calls  int
+	tokens []string
+}
+
+func (s *scriptedClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	s.calls++
+	for _, token := range s.tokens {
+		if err := req.token(token); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.errors) > 0 {
+		err := s.errors[0]
+		s.errors = s.errors[1:]
+		return nil, err
+	}
+	return &Response{Text: "answer from " + s.name}, nil
+}
+
+// newTestChain creates a chain over the given clients, in order, with no
+// wait between retries and a clock the test controls
+func newTestChain(clients ...*scriptedClient) (*Chain, *time.Time, *[]string, map[string]error) {
+	names := make([]string, len(clients))
+	for i, client := range clients {
+		names[i] = client.name
+	}
+	fallback := names[1:]
+	config := &storage.Config{AIProvider: names[0], AIFallback: &fallback}
+
+	checks := make(map[string]error)
+	registry := make(map[string]*Provider)
+	for _, client := range clients {
+		client := client
+		registry[client.name] = &Provider{
+			Name: client.name,
+			New: func(*storage.Config) (Client, error) {
+				return client, nil
+			},
+			Check: func(context.Context, *storage.Config) error {
+				return checks[client.name]
+			},
+		}
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var messages []string
+	chain := NewChain(config)
+	chain.backoff = Backoff{Attempts: 3}
+	chain.now = func() time.Time { return now }
+	chain.lookup = func(name string) (*Provider, bool) {
+		provider, ok := registry[name]
+		return provider, ok
+	}
+	chain.Notify = func(message string) {
+		messages = append(messages, message)
+	}
+	return chain, &now, &messages, checks
+}
+
+func TestChainFallback(t *testing.T) {
+	primary := &scriptedClient{name: "primary", errors: []error{errors.New("connection refused")}}
+	secondary := &scriptedClient{name: "secondary"}
+	chain, _, messages, _ := newTestChain(primary, secondary)
+
+	resp, err := chain.Complete(context.Background(), NewRequest("hi"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if resp.Text != "answer from secondary" || resp.Provider != "secondary" {
+		t.Errorf("Expected the secondary provider to answer, got %+v", resp)
+	}
+	if primary.calls != 1 {
+		t.Errorf("Expected connection errors not to be retried, got %d calls", primary.calls)
+	}
+	expected := "primary failed: connection refused|Asking secondary instead..."
+	if got := strings.Join(*messages, "|"); got != expected {
+		t.Errorf("Expected messages %q, got %q", expected, got)
+	}
+
+	// The primary provider is asked first again next time
+	resp, err = chain.Complete(context.Background(), NewRequest("hi"))
+	if err != nil || resp.Provider != "primary" {
+		t.Errorf("Expected the primary provider to answer, got %+v, %v", resp, err)
+	}
+}
+
+func TestChainRetry(t *testing.T) {
+	busy := &StatusError{StatusCode: 429, Body: "rate limited"}
+	broken := &StatusError{StatusCode: 503, Body: "overloaded"}
+	primary := &scriptedClient{name: "primary", errors: []error{busy, broken}}
+	chain, _, messages, _ := newTestChain(primary, &scriptedClient{name: "secondary"})
+
+	resp, err := chain.Complete(context.Background(), NewRequest("hi"))
+	if err != nil || resp.Provider != "primary" {
+		t.Fatalf("Expected the primary provider to answer after retrying, got %+v, %v", resp, err)
+	}
+	if primary.calls != 3 || len(*messages) != 2 || !strings.Contains((*messages)[0], "status 429") {
+		t.Errorf("Expected two retries, got %d calls and messages %q", primary.calls, *messages)
+	}
+
+	// Client errors are not retried
+	primary.errors = []error{&StatusError{StatusCode: 400, Body: "bad request"}}
+	primary.calls = 0
+	resp, err = chain.Complete(context.Background(), NewRequest("hi"))
+	if err != nil || resp.Provider != "secondary" || primary.calls != 1 {
+		t.Errorf("Expected a fallback without retrying, got %+v, %v after %d calls", resp, err, primary.calls)
+	}
+}
+
+func TestBackoffWait(t *testing.T) {
+	backoff := Backoff{Attempts: 5, Delay: time.Second, MaxDelay: 3 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for i, want := range expected {
+		if got := backoff.wait(i + 1); got != want {
+			t.Errorf("Expected retry %d to wait %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestChainCircuitBreaker(t *testing.T) {
+	down := errors.New("connection refused")
+	primary := &scriptedClient{name: "primary", errors: []error{down, down, down, down}}
+	secondary := &scriptedClient{name: "secondary"}
+	chain, now, messages, checks := newTestChain(primary, secondary)
+
+	for i := 0; i < breakerThreshold; i++ {
+		if _, err := chain.Complete(context.Background(), NewRequest("hi")); err != nil {
+			t.Fatalf("Expected the secondary provider to answer, got %v", err)
+		}
+	}
+
+	// The primary provider is skipped while its circuit is open
+	*messages = nil
+	resp, err := chain.Complete(context.Background(), NewRequest("hi"))
+	if err != nil || resp.Provider != "secondary" || primary.calls != breakerThreshold {
+		t.Fatalf("Expected the primary provider to be skipped, got %+v, %v after %d calls", resp, err, primary.calls)
+	}
+	if len(*messages) != 1 || !strings.Contains((*messages)[0], "Skipping primary") {
+		t.Errorf("Expected a message about skipping, got %q", *messages)
+	}
+
+	// After the cooldown it is checked before being asked again
+	*now = now.Add(breakerCooldown)
+	checks["primary"] = errors.New("still down")
+	if resp, err := chain.Complete(context.Background(), NewRequest("hi")); err != nil || resp.Provider != "secondary" {
+		t.Fatalf("Expected the failing check to skip the primary provider, got %+v, %v", resp, err)
+	}
+	if primary.calls != breakerThreshold {
+		t.Errorf("Expected the primary provider not to be asked, got %d calls", primary.calls)
+	}
+
+	*now = now.Add(breakerCooldown)
+	delete(checks, "primary")
+	primary.errors = nil
+	if resp, err := chain.Complete(context.Background(), NewRequest("hi")); err != nil || resp.Provider != "primary" {
+		t.Fatalf("Expected the primary provider to recover, got %+v, %v", resp, err)
+	}
+	if b := chain.breaker("primary"); b.failures != 0 {
+		t.Errorf("Expected the circuit to close, got %d failures", b.failures)
+	}
+}
+
+func TestChainErrors(t *testing.T) {
+	// A failing fallback is reported along with the primary provider
+	primary := &scriptedClient{name: "primary", errors: []error{errors.New("connection refused")}}
+	secondary := &scriptedClient{name: "secondary"}
+	chain, _, _, checks := newTestChain(primary, secondary)
+	checks["secondary"] = errors.New("not running")
+
+	_, err := chain.Complete(context.Background(), NewRequest("hi"))
+	if err == nil || !strings.Contains(err.Error(), "primary: connection refused") || !strings.Contains(err.Error(), "secondary: not running") {
+		t.Errorf("Expected both failures, got %v", err)
+	}
+	if secondary.calls != 0 {
+		t.Errorf("Expected the failing check to skip the fallback, got %d calls", secondary.calls)
+	}
+
+	// Nothing else is tried once part of the answer has been shown
+	primary = &scriptedClient{name: "primary", tokens: []string{"Use "}, errors: []error{fmt.Errorf("stream broken")}}
+	secondary = &scriptedClient{name: "secondary"}
+	chain, _, _, _ = newTestChain(primary, secondary)
+	req := NewRequest("hi")
+	req.OnToken = func(string) error { return nil }
+	if _, err := chain.Complete(context.Background(), req); err == nil || err.Error() != "stream broken" || secondary.calls != 0 {
+		t.Errorf("Expected the stream error without a fallback, got %v after %d calls", err, secondary.calls)
+	}
+
+	// Cancelling stops without trying another provider
+	primary = &scriptedClient{name: "primary", errors: []error{context.Canceled}}
+	secondary = &scriptedClient{name: "secondary"}
+	chain, _, _, _ = newTestChain(primary, secondary)
+	if _, err := chain.Complete(context.Background(), NewRequest("hi")); !errors.Is(err, context.Canceled) || secondary.calls != 0 {
+		t.Errorf("Expected the cancellation, got %v after %d calls", err, secondary.calls)
+	}
+}
+
+func TestChainProviders(t *testing.T) {
+	config := &storage.Config{AIProvider: storage.ProviderOpenAI}
+	chain := NewChain(config)
+	if got := strings.Join(chain.Providers(), ","); got != "openai,ollama" {
+		t.Errorf("Expected Ollama as the default fallback, got %s", got)
+	}
+
+	fallback := []string{"anthropic", "openai", "ollama"}
+	config.AIFallback = &fallback
+	if got := strings.Join(chain.Providers(), ","); got != "openai,anthropic,ollama" {
+		t.Errorf("Expected each provider once, got %s", got)
+	}
+
+	config.AIFallback = &[]string{}
+	if got := strings.Join(chain.Providers(), ","); got != "openai" {
+		t.Errorf("Expected no fallback, got %s", got)
+	}
+}
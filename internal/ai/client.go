@@ -1,6 +1,142 @@
 package ai
 
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultSystemPrompt is the system prompt used for questions asked at the
+// budy prompt
+const DefaultSystemPrompt = "You are a helpful terminal assistant. Provide concise answers for command line usage."
+
+// Message roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
 // Client defines the interface for AI services
 type Client interface {
-	Ask(query string) error
+	// Complete sends a request and returns the model's answer. When the
+	// request has an OnToken callback the answer is streamed to it as it
+	// is generated. Cancelling ctx stops the request.
+	Complete(ctx context.Context, req Request) (*Response, error)
+}
+
+// Message is one turn of a conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request describes what to ask a model
+type Request struct {
+	// System is the system prompt, if any
+	System string
+
+	// Messages is the conversation so far, ending with the user's question
+	Messages []Message
+
+	// Model overrides the client's configured model when set
+	Model string
+
+	// Temperature overrides the provider's default sampling temperature
+	// when set
+	Temperature *float64
+
+	// JSON asks for the answer as a JSON object, where the provider
+	// supports it. The prompt should still describe the object wanted.
+	JSON bool
+
+	// OnToken, if set, receives the answer piece by piece as it streams
+	// in. Returning an error stops the request.
+	OnToken func(token string) error
+}
+
+// Response is a model's answer
+type Response struct {
+	Text         string
+	Model        string
+	Usage        Usage
+	FinishReason string
+
+	// Provider names the provider that answered, when the answer came
+	// through a Chain
+	Provider string
+
+	// Cached is set when the answer was taken from the cache
+	Cached bool
+}
+
+// Usage counts the tokens used by a request, where the provider reports them
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+// NewRequest creates a request asking a single question with the default
+// system prompt
+func NewRequest(query string) Request {
+	return Request{
+		System:   DefaultSystemPrompt,
+		Messages: []Message{{Role: RoleUser, Content: query}},
+	}
+}
+
+// Temperature returns a pointer to t, for setting Request.Temperature
+func Temperature(t float64) *float64 {
+	return &t
+}
+
+// LastUserMessage returns the content of the last user message in the
+// request, or an empty string
+func (r Request) LastUserMessage() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == RoleUser {
+			return r.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// token passes a piece of a streamed answer to the request's callback
+func (r Request) token(text string) error {
+	if r.OnToken == nil || text == "" {
+		return nil
+	}
+	return r.OnToken(text)
+}
+
+// StatusError is returned when a provider's API answers with an HTTP error
+type StatusError struct {
+	StatusCode int
+	Body       string
+
+	// RetryAfter is how long the server asked to wait before trying
+	// again, or zero
+	RetryAfter time.Duration
+}
+
+// newStatusError creates a StatusError from an HTTP response and its body
+func newStatusError(resp *http.Response, body []byte) *StatusError {
+	err := &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the request may succeed if tried again: the
+// server was rate limiting or had an error of its own
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
 }
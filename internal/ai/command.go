@@ -0,0 +1,113 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/shell"
+)
+
+// CommandSystemPrompt asks the model for a shell command instead of prose
+const CommandSystemPrompt = `You turn requests into a single shell command for the user's terminal. Reply with only a JSON object, without Markdown, of the form:
+{"command": "<the command>", "explanation": "<one or two sentences on what it does>", "risk": "<low|medium|high>"}
+The risk is "low" if the command only reads, "medium" if it changes files or installs software, and "high" if it deletes or overwrites data, changes permissions or system settings, or cannot be undone.`
+
+// Risk ratings of a generated command
+const (
+	RiskLow     = "low"
+	RiskMedium  = "medium"
+	RiskHigh    = "high"
+	RiskUnknown = "unknown"
+)
+
+// CommandSuggestion is a shell command generated by the model
+type CommandSuggestion struct {
+	Command     string `json:"command"`
+	Explanation string `json:"explanation"`
+	Risk        string `json:"risk"`
+}
+
+// NewCommandRequest creates a request for a command that does task. system
+// should start with CommandSystemPrompt.
+func NewCommandRequest(system, task string) Request {
+	return newJSONRequest(system, task)
+}
+
+// newJSONRequest creates a request for a JSON answer to a single message,
+// at temperature 0 so answers are as predictable as possible
+func newJSONRequest(system, content string) Request {
+	return Request{
+		System:      system,
+		Messages:    []Message{{Role: RoleUser, Content: content}},
+		Temperature: Temperature(0),
+		JSON:        true,
+	}
+}
+
+// jsonObject returns the JSON object in a model's answer, ignoring any text
+// around it
+func jsonObject(text string) (string, error) {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("expected a JSON object, got %q", strings.TrimSpace(text))
+	}
+	return text[start : end+1], nil
+}
+
+// FixTask describes a failed command as a task for SuggestCommand: the
+// command, how it ended and the end of its error output
+func FixTask(entry *shell.CommandEntry) string {
+	var task strings.Builder
+	fmt.Fprintf(&task, "This command failed with %s:\n$ %s\n", failureStatus(entry.Result), entry.Command)
+	if entry.Directory != "" {
+		fmt.Fprintf(&task, "It was run in %s.\n", entry.Directory)
+	}
+	if tail := strings.TrimSpace(entry.Result.StderrTail); tail != "" {
+		fmt.Fprintf(&task, "Its error output ended with:\n%s\n", tail)
+	} else if entry.Result.StderrBytes < 0 {
+		task.WriteString(errorsNotRecorded + "\n")
+	}
+	task.WriteString("Give a corrected command that does what the user meant.")
+	return task.String()
+}
+
+// SuggestCommand asks the model for a command that does task
+func SuggestCommand(ctx context.Context, client Client, system, task string) (*CommandSuggestion, error) {
+	resp, err := client.Complete(ctx, NewCommandRequest(system, task))
+	if err != nil {
+		return nil, err
+	}
+	return ParseCommandSuggestion(resp.Text)
+}
+
+// ParseCommandSuggestion reads a command suggestion from a model's answer.
+// Text around the JSON object, such as a Markdown code fence, is ignored.
+// A missing or unrecognized risk is reported as RiskUnknown.
+func ParseCommandSuggestion(text string) (*CommandSuggestion, error) {
+	object, err := jsonObject(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var suggestion CommandSuggestion
+	if err := json.Unmarshal([]byte(object), &suggestion); err != nil {
+		return nil, fmt.Errorf("error parsing command: %v", err)
+	}
+
+	suggestion.Command = strings.TrimSpace(suggestion.Command)
+	if suggestion.Command == "" {
+		return nil, fmt.Errorf("the answer did not include a command")
+	}
+	suggestion.Explanation = strings.TrimSpace(suggestion.Explanation)
+
+	switch risk := strings.ToLower(strings.TrimSpace(suggestion.Risk)); risk {
+	case RiskLow, RiskMedium, RiskHigh:
+		suggestion.Risk = risk
+	default:
+		suggestion.Risk = RiskUnknown
+	}
+	return &suggestion, nil
+}
@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/shell"
+)
+
+// fakeClient answers every request with the same text
+type fakeClient struct {
+	text     string
+	requests []Request
+}
+
+func (f *fakeClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	f.requests = append(f.requests, req)
+	return &Response{Text: f.text}, nil
+}
+
+func TestParseCommandSuggestion(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected CommandSuggestion
+	}{
+		{
+			name:     "plain JSON",
+			text:     `{"command":"ls -la","explanation":"Lists all files","risk":"low"}`,
+			expected: CommandSuggestion{Command: "ls -la", Explanation: "Lists all files", Risk: RiskLow},
+		},
+		{
+			name:     "code fence",
+			text:     "```json\n{\"command\": \"rm -rf build\", \"explanation\": \"Deletes build\", \"risk\": \"High\"}\n```",
+			expected: CommandSuggestion{Command: "rm -rf build", Explanation: "Deletes build", Risk: RiskHigh},
+		},
+		{
+			name:     "surrounding prose",
+			text:     `Here you go: {"command":" find . -name '*.go' -mtime -7 ","explanation":"Finds Go files","risk":"low"} Enjoy!`,
+			expected: CommandSuggestion{Command: "find . -name '*.go' -mtime -7", Explanation: "Finds Go files", Risk: RiskLow},
+		},
+		{
+			name:     "missing risk",
+			text:     `{"command":"make"}`,
+			expected: CommandSuggestion{Command: "make", Risk: RiskUnknown},
+		},
+		{
+			name:     "unknown risk",
+			text:     `{"command":"make","risk":"spicy"}`,
+			expected: CommandSuggestion{Command: "make", Risk: RiskUnknown},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestion, err := ParseCommandSuggestion(tt.text)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if *suggestion != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, *suggestion)
+			}
+		})
+	}
+}
+
+func TestParseCommandSuggestionErrors(t *testing.T) {
+	for _, text := range []string{
+		"Use ls to list files",
+		`{"command": "ls"`,
+		`{"command": "", "explanation": "nothing"}`,
+		`{"command": 42}`,
+	} {
+		if _, err := ParseCommandSuggestion(text); err == nil {
+			t.Errorf("Expected an error for %q, got nil", text)
+		}
+	}
+}
+
+func TestSuggestCommand(t *testing.T) {
+	client := &fakeClient{text: `{"command":"du -sh *","explanation":"Shows sizes","risk":"low"}`}
+
+	suggestion, err := SuggestCommand(context.Background(), client, CommandSystemPrompt, "show folder sizes")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if suggestion.Command != "du -sh *" {
+		t.Errorf("Expected 'du -sh *', got %q", suggestion.Command)
+	}
+
+	req := client.requests[0]
+	if !req.JSON || req.Temperature == nil || *req.Temperature != 0 {
+		t.Errorf("Expected a JSON request at temperature 0, got %+v", req)
+	}
+	if req.LastUserMessage() != "show folder sizes" || !strings.HasPrefix(req.System, CommandSystemPrompt) {
+		t.Errorf("Unexpected request: %+v", req)
+	}
+}
+
+func TestFixTask(t *testing.T) {
+	task := FixTask(&shell.CommandEntry{
+		Command:   "tar xf archive.tgz",
+		Directory: "/tmp",
+		Result:    &shell.ExecResult{ExitCode: 2, StderrTail: "tar: archive.tgz: Cannot open: No such file or directory\n"},
+	})
+	for _, want := range []string{"exit code 2", "$ tar xf archive.tgz", "run in /tmp", "Cannot open: No such file"} {
+		if !strings.Contains(task, want) {
+			t.Errorf("Expected the task to contain %q, got:\n%s", want, task)
+		}
+	}
+
+	task = FixTask(&shell.CommandEntry{Command: "sleep 100", Result: &shell.ExecResult{ExitCode: -1, Signal: "interrupt"}})
+	if !strings.Contains(task, "signal interrupt") || strings.Contains(task, "error output") {
+		t.Errorf("Unexpected task for a signalled command:\n%s", task)
+	}
+
+	// Error output that went to the terminal wasn't kept
+	task = FixTask(&shell.CommandEntry{Command: "git push", Result: &shell.ExecResult{ExitCode: 1, StdoutBytes: -1, StderrBytes: -1}})
+	if !strings.Contains(task, errorsNotRecorded) {
+		t.Errorf("Expected the task to say the error output wasn't recorded, got:\n%s", task)
+	}
+}
@@ -0,0 +1,199 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/shell"
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// maxListing is how many directory entries are named in the context
+const maxListing = 30
+
+// contextHeader starts the context appended to system prompts
+const contextHeader = "Context about the user's terminal:\n"
+
+// The context items that stay the same from one question to the next
+const (
+	systemItem    = "- Operating system: "
+	shellItem     = "- Shell: "
+	directoryItem = "- Current directory: "
+)
+
+// ContextBuilder describes the user's terminal to the model: where they
+// are, what system and shell they use, what they ran recently and how the
+// last failed command went. Which items are included follows the
+// context settings in the config.
+type ContextBuilder struct {
+	executor *shell.ShellExecutor
+	history  shell.HistoryManager
+	config   *storage.Config
+}
+
+// NewContextBuilder creates a context builder. The config is read on each
+// build, so changed settings apply to the next question.
+func NewContextBuilder(executor *shell.ShellExecutor, history shell.HistoryManager, config *storage.Config) *ContextBuilder {
+	return &ContextBuilder{
+		executor: executor,
+		history:  history,
+		config:   config,
+	}
+}
+
+// SystemPrompt returns the default system prompt followed by the context
+func (b *ContextBuilder) SystemPrompt() string {
+	return b.withContext(DefaultSystemPrompt)
+}
+
+// CommandPrompt returns the system prompt for generating commands followed
+// by the context
+func (b *ContextBuilder) CommandPrompt() string {
+	return b.withContext(CommandSystemPrompt)
+}
+
+// withContext appends the context to a system prompt
+func (b *ContextBuilder) withContext(prompt string) string {
+	context := b.Build()
+	if context == "" {
+		return prompt
+	}
+	return prompt + "\n\n" + context
+}
+
+// Build returns a description of the terminal context, or an empty string
+// when every item is turned off
+func (b *ContextBuilder) Build() string {
+	settings := b.config.ContextSettings()
+	var lines []string
+
+	if settings.System {
+		lines = append(lines, fmt.Sprintf("%s%s/%s", systemItem, runtime.GOOS, runtime.GOARCH))
+	}
+	if settings.Shell {
+		lines = append(lines, shellItem+filepath.Base(b.executor.Shell()))
+	}
+
+	dir := b.executor.Session().Dir()
+	if settings.Directory {
+		lines = append(lines, directoryItem+dir)
+	}
+	if settings.Listing {
+		if listing := directoryListing(dir); listing != "" {
+			lines = append(lines, "- Directory contents: "+listing)
+		}
+	}
+
+	if settings.History > 0 {
+		if recent := b.history.GetRecentCommands(settings.History); len(recent) > 0 {
+			lines = append(lines, "- Recent commands, oldest first:")
+			for _, entry := range recent {
+				lines = append(lines, "    $ "+entry.Command)
+			}
+		}
+	}
+
+	if settings.LastFailure {
+		if entry := LastFailure(b.history.GetHistory()); entry != nil {
+			lines = append(lines, describeFailure(entry)...)
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return contextHeader + strings.Join(lines, "\n")
+}
+
+// stableContext returns a system prompt without the context items that
+// change with every command run, such as recent commands and the directory
+// listing, keeping the prompt itself and the system, shell and directory
+func stableContext(system string) string {
+	prompt, context, found := strings.Cut(system, contextHeader)
+	if !found {
+		return system
+	}
+
+	var kept []string
+	for _, line := range strings.Split(context, "\n") {
+		for _, item := range []string{systemItem, shellItem, directoryItem} {
+			if strings.HasPrefix(line, item) {
+				kept = append(kept, line)
+			}
+		}
+	}
+	return prompt + contextHeader + strings.Join(kept, "\n")
+}
+
+// directoryListing names the visible entries of dir, marking directories
+// with a trailing slash
+func directoryListing(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "(empty)"
+	}
+	sort.Strings(names)
+
+	listing := strings.Join(names, ", ")
+	if len(names) > maxListing {
+		listing = fmt.Sprintf("%s and %d more", strings.Join(names[:maxListing], ", "), len(names)-maxListing)
+	}
+	return listing
+}
+
+// LastFailure returns the most recent command in history that failed, or
+// nil
+func LastFailure(history []shell.CommandEntry) *shell.CommandEntry {
+	for i := len(history) - 1; i >= 0; i-- {
+		if result := history[i].Result; result != nil && !result.Success() {
+			return &history[i]
+		}
+	}
+	return nil
+}
+
+// describeFailure describes how a failed command ended
+func describeFailure(entry *shell.CommandEntry) []string {
+	lines := []string{fmt.Sprintf("- Last failed command: %s (%s)", entry.Command, failureStatus(entry.Result))}
+	if tail := strings.TrimSpace(entry.Result.StderrTail); tail != "" {
+		lines = append(lines, "  Its error output ended with:")
+		for _, line := range strings.Split(tail, "\n") {
+			lines = append(lines, "    "+line)
+		}
+	} else if entry.Result.StderrBytes < 0 {
+		lines = append(lines, "  "+errorsNotRecorded)
+	}
+	return lines
+}
+
+// errorsNotRecorded says why a failed command has no error output to
+// show: it went straight to the terminal, where programs can use colors
+// and progress bars, so none of it was kept
+const errorsNotRecorded = "Its error output went to the terminal and wasn't recorded."
+
+// failureStatus describes how a failed command ended
+func failureStatus(result *shell.ExecResult) string {
+	if result.Signal != "" {
+		return "signal " + result.Signal
+	}
+	return fmt.Sprintf("exit code %d", result.ExitCode)
+}
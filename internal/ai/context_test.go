@@ -0,0 +1,137 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/shell"
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// newTestContextBuilder creates a context builder for a temporary directory
+// holding a few files, with the given commands in history
+func newTestContextBuilder(t *testing.T, entries ...shell.CommandEntry) (*ContextBuilder, *storage.Config, string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	dir := t.TempDir()
+	for _, name := range []string{"main.go", "README.md", ".env"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "cmd"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	executor := shell.NewExecutorWithShell("sh")
+	if err := executor.Session().Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+
+	store, err := storage.NewFileStorageWithDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	history := shell.NewHistoryManager(store)
+	for _, entry := range entries {
+		if err := history.RecordEntry(entry); err != nil {
+			t.Fatalf("Failed to record entry: %v", err)
+		}
+	}
+
+	config := &storage.Config{}
+	return NewContextBuilder(executor, history, config), config, executor.Session().Dir()
+}
+
+func TestContextBuilder(t *testing.T) {
+	builder, _, dir := newTestContextBuilder(t,
+		shell.CommandEntry{Command: "go build ./...", Result: &shell.ExecResult{}},
+		shell.CommandEntry{Command: "make deploy", Result: &shell.ExecResult{ExitCode: 2, StderrTail: "make: *** No rule to make target 'deploy'.  Stop.\n"}},
+		shell.CommandEntry{Command: "ls", Result: &shell.ExecResult{}},
+	)
+
+	context := builder.Build()
+	expected := []string{
+		"Context about the user's terminal:",
+		fmt.Sprintf("- Operating system: %s/%s", runtime.GOOS, runtime.GOARCH),
+		"- Shell: sh",
+		"- Current directory: " + dir,
+		"- Directory contents: README.md, cmd/, main.go",
+		"- Recent commands, oldest first:\n    $ go build ./...\n    $ make deploy\n    $ ls",
+		"- Last failed command: make deploy (exit code 2)",
+		"    make: *** No rule to make target 'deploy'.  Stop.",
+	}
+	for _, want := range expected {
+		if !strings.Contains(context, want) {
+			t.Errorf("Expected context to contain %q, got:\n%s", want, context)
+		}
+	}
+	if strings.Contains(context, ".env") {
+		t.Errorf("Expected hidden files to be left out, got:\n%s", context)
+	}
+
+	prompt := builder.SystemPrompt()
+	if !strings.HasPrefix(prompt, DefaultSystemPrompt+"\n\n") || !strings.HasSuffix(prompt, context) {
+		t.Errorf("Expected the context after the default prompt, got:\n%s", prompt)
+	}
+
+	prompt = builder.CommandPrompt()
+	if !strings.HasPrefix(prompt, CommandSystemPrompt+"\n\n") || !strings.HasSuffix(prompt, context) {
+		t.Errorf("Expected the context after the command prompt, got:\n%s", prompt)
+	}
+}
+
+func TestContextBuilderToggles(t *testing.T) {
+	builder, config, dir := newTestContextBuilder(t,
+		shell.CommandEntry{Command: "false", Result: &shell.ExecResult{ExitCode: 1}},
+		shell.CommandEntry{Command: "echo one", Result: &shell.ExecResult{}},
+		shell.CommandEntry{Command: "echo two", Result: &shell.ExecResult{}},
+	)
+
+	settings := storage.DefaultContextConfig()
+	settings.Listing = false
+	settings.System = false
+	settings.History = 1
+	config.Context = &settings
+
+	context := builder.Build()
+	if !strings.Contains(context, "- Current directory: "+dir) || !strings.Contains(context, "    $ echo two") {
+		t.Errorf("Expected directory and last command, got:\n%s", context)
+	}
+	for _, unwanted := range []string{"Directory contents", "Operating system", "echo one"} {
+		if strings.Contains(context, unwanted) {
+			t.Errorf("Expected %q to be left out, got:\n%s", unwanted, context)
+		}
+	}
+
+	// With everything off only the default prompt is sent
+	config.Context = &storage.ContextConfig{}
+	if context := builder.Build(); context != "" {
+		t.Errorf("Expected no context, got:\n%s", context)
+	}
+	if prompt := builder.SystemPrompt(); prompt != DefaultSystemPrompt {
+		t.Errorf("Expected the default prompt, got:\n%s", prompt)
+	}
+}
+
+func TestDirectoryListingLimit(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < maxListing+5; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%02d", i)), nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	listing := directoryListing(dir)
+	if !strings.HasSuffix(listing, "file29 and 5 more") {
+		t.Errorf("Expected a shortened listing, got %q", listing)
+	}
+	if listing := directoryListing(t.TempDir()); listing != "(empty)" {
+		t.Errorf("Expected an empty listing, got %q", listing)
+	}
+}
@@ -0,0 +1,220 @@
+package ai
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// DefaultConversationBudget is how many characters of earlier messages are
+// sent along with a question, roughly 3,000 tokens
+const DefaultConversationBudget = 12000
+
+// maxTitleLength limits the title taken from a conversation's first question
+const maxTitleLength = 60
+
+// Conversation is a multi-turn chat with a model. Each question is sent
+// along with as much of the earlier conversation as fits the budget, so
+// follow-up questions keep their context.
+type Conversation struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Messages []Message `json:"messages"`
+
+	// Budget is the number of characters of earlier messages sent with
+	// each question
+	Budget int `json:"-"`
+}
+
+// ConversationSummary describes a saved conversation without its messages
+type ConversationSummary struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Updated  time.Time `json:"updated"`
+	Messages int       `json:"messages"`
+}
+
+// NewConversation starts an empty conversation
+func NewConversation() *Conversation {
+	now := time.Now()
+	return &Conversation{
+		ID:      newConversationID(now),
+		Created: now,
+		Updated: now,
+		Budget:  DefaultConversationBudget,
+	}
+}
+
+// newConversationID returns an ID that sorts by creation time
+func newConversationID(now time.Time) string {
+	suffix := make([]byte, 2)
+	if _, err := rand.Read(suffix); err != nil {
+		return now.Format("20060102-150405")
+	}
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// Request builds a request asking query as the next turn of the
+// conversation, with the system prompt and the most recent messages that
+// fit the budget
+func (c *Conversation) Request(system, query string) Request {
+	history := TrimMessages(c.Messages, c.budget())
+	messages := make([]Message, 0, len(history)+1)
+	messages = append(messages, history...)
+	messages = append(messages, Message{Role: RoleUser, Content: query})
+	return Request{
+		System:   system,
+		Messages: messages,
+	}
+}
+
+// Add records a question and its answer
+func (c *Conversation) Add(query, answer string) {
+	if c.Title == "" {
+		c.Title = conversationTitle(query)
+	}
+	c.Messages = append(c.Messages,
+		Message{Role: RoleUser, Content: query},
+		Message{Role: RoleAssistant, Content: answer},
+	)
+	c.Updated = time.Now()
+}
+
+// Summary describes the conversation for listing
+func (c *Conversation) Summary() ConversationSummary {
+	return ConversationSummary{
+		ID:       c.ID,
+		Title:    c.Title,
+		Updated:  c.Updated,
+		Messages: len(c.Messages),
+	}
+}
+
+// budget returns the conversation's budget, or the default when unset
+func (c *Conversation) budget() int {
+	if c.Budget <= 0 {
+		return DefaultConversationBudget
+	}
+	return c.Budget
+}
+
+// conversationTitle makes a short title from a question
+func conversationTitle(query string) string {
+	title := strings.Join(strings.Fields(query), " ")
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength-3]) + "..."
+	}
+	return title
+}
+
+// TrimMessages returns the most recent messages whose combined length
+// fits within budget characters. Messages are dropped from the start in
+// question and answer pairs so the history never begins with an answer.
+func TrimMessages(messages []Message, budget int) []Message {
+	size := 0
+	start := len(messages)
+	for start > 0 {
+		next := size + len(messages[start-1].Content)
+		if next > budget {
+			break
+		}
+		size = next
+		start--
+	}
+
+	// Don't start with an assistant reply whose question was dropped
+	for start < len(messages) && messages[start].Role != RoleUser {
+		start++
+	}
+	return messages[start:]
+}
+
+// conversationIndexKey is the storage key of the list of conversations
+const conversationIndexKey = "conversations"
+
+// ConversationStore saves conversations through a storage.Storage. Each
+// conversation is stored under its own key, and an index lists them all.
+type ConversationStore struct {
+	storage storage.Storage
+}
+
+// NewConversationStore creates a conversation store
+func NewConversationStore(store storage.Storage) *ConversationStore {
+	return &ConversationStore{storage: store}
+}
+
+// conversationKey returns the storage key of a conversation
+func conversationKey(id string) string {
+	return "conversation-" + id
+}
+
+// Save stores a conversation and updates the index
+func (s *ConversationStore) Save(c *Conversation) error {
+	if err := s.storage.Save(conversationKey(c.ID), c); err != nil {
+		return err
+	}
+
+	summaries := s.List()
+	updated := []ConversationSummary{c.Summary()}
+	for _, summary := range summaries {
+		if summary.ID != c.ID {
+			updated = append(updated, summary)
+		}
+	}
+	return s.storage.Save(conversationIndexKey, updated)
+}
+
+// List returns the saved conversations, most recently updated first
+func (s *ConversationStore) List() []ConversationSummary {
+	var summaries []ConversationSummary
+	if err := s.storage.Load(conversationIndexKey, &summaries); err != nil {
+		// If error, there are no saved conversations yet
+		return nil
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Updated.After(summaries[j].Updated)
+	})
+	return summaries
+}
+
+// Load returns a saved conversation. ref is either a conversation ID, a
+// unique prefix of one, or a number from List starting at 1.
+func (s *ConversationStore) Load(ref string) (*Conversation, error) {
+	summaries := s.List()
+
+	id := ""
+	if n, err := strconv.Atoi(ref); err == nil && n >= 1 && n <= len(summaries) {
+		id = summaries[n-1].ID
+	} else {
+		for _, summary := range summaries {
+			if summary.ID == ref {
+				id = ref
+				break
+			}
+			if strings.HasPrefix(summary.ID, ref) {
+				if id != "" {
+					return nil, fmt.Errorf("conversation %q is ambiguous", ref)
+				}
+				id = summary.ID
+			}
+		}
+		if id == "" {
+			return nil, fmt.Errorf("no conversation %q", ref)
+		}
+	}
+
+	conversation := &Conversation{}
+	if err := s.storage.Load(conversationKey(id), conversation); err != nil {
+		return nil, err
+	}
+	conversation.Budget = DefaultConversationBudget
+	return conversation, nil
+}
@@ -0,0 +1,151 @@
+package ai
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+func TestTrimMessages(t *testing.T) {
+	messages := []Message{
+		{Role: RoleUser, Content: "aaaa"},
+		{Role: RoleAssistant, Content: "bbbb"},
+		{Role: RoleUser, Content: "cc"},
+		{Role: RoleAssistant, Content: "dddddd"},
+	}
+
+	tests := []struct {
+		name     string
+		budget   int
+		expected []Message
+	}{
+		{"Everything", 100, messages},
+		{"Exact", 16, messages},
+		{"DropsOldestPair", 15, messages[2:]},
+		{"DropsOrphanedAnswer", 12, messages[2:]},
+		{"OnlyLastAnswerFits", 6, []Message{}},
+		{"Nothing", 0, []Message{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trimmed := TrimMessages(messages, tt.budget)
+			if !reflect.DeepEqual(trimmed, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, trimmed)
+			}
+		})
+	}
+}
+
+func TestConversationRequest(t *testing.T) {
+	conversation := NewConversation()
+	conversation.Add("how do I list files?", "Use ls")
+	conversation.Add("and hidden ones?", "Use ls -a")
+
+	req := conversation.Request("Be brief", "now recursively")
+	expected := []Message{
+		{Role: RoleUser, Content: "how do I list files?"},
+		{Role: RoleAssistant, Content: "Use ls"},
+		{Role: RoleUser, Content: "and hidden ones?"},
+		{Role: RoleAssistant, Content: "Use ls -a"},
+		{Role: RoleUser, Content: "now recursively"},
+	}
+	if req.System != "Be brief" || !reflect.DeepEqual(req.Messages, expected) {
+		t.Errorf("Unexpected request: %+v", req)
+	}
+
+	// A small budget keeps only the latest exchange
+	conversation.Budget = 30
+	req = conversation.Request("", "now recursively")
+	if !reflect.DeepEqual(req.Messages, expected[2:]) {
+		t.Errorf("Expected %+v, got %+v", expected[2:], req.Messages)
+	}
+
+	// Building a request doesn't change the conversation
+	if len(conversation.Messages) != 4 {
+		t.Errorf("Expected 4 messages, got %d", len(conversation.Messages))
+	}
+	if conversation.Title != "how do I list files?" {
+		t.Errorf("Expected the first question as title, got %q", conversation.Title)
+	}
+}
+
+func TestConversationTitle(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+	title := conversationTitle(long)
+	if len([]rune(title)) != maxTitleLength || !strings.HasSuffix(title, "...") {
+		t.Errorf("Expected a shortened title, got %q", title)
+	}
+	if title := conversationTitle("  what\n is   this "); title != "what is this" {
+		t.Errorf("Expected whitespace to be collapsed, got %q", title)
+	}
+}
+
+func TestConversationStore(t *testing.T) {
+	fileStorage, err := storage.NewFileStorageWithDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	store := NewConversationStore(fileStorage)
+
+	if summaries := store.List(); len(summaries) != 0 {
+		t.Errorf("Expected no conversations, got %v", summaries)
+	}
+
+	// Save three conversations, updated in order
+	var ids []string
+	for i := 0; i < 3; i++ {
+		conversation := NewConversation()
+		conversation.ID = fmt.Sprintf("2026010%d-120000-abcd", i+1)
+		conversation.Add(fmt.Sprintf("question %d", i), "answer")
+		conversation.Updated = time.Date(2026, 1, i+1, 12, 0, 0, 0, time.UTC)
+		if err := store.Save(conversation); err != nil {
+			t.Fatalf("Failed to save conversation: %v", err)
+		}
+		ids = append(ids, conversation.ID)
+	}
+
+	summaries := store.List()
+	if len(summaries) != 3 || summaries[0].ID != ids[2] || summaries[2].ID != ids[0] {
+		t.Fatalf("Expected newest first, got %+v", summaries)
+	}
+	if summaries[0].Title != "question 2" || summaries[0].Messages != 2 {
+		t.Errorf("Unexpected summary: %+v", summaries[0])
+	}
+
+	// Saving again updates the entry instead of adding one
+	conversation, err := store.Load(ids[0])
+	if err != nil {
+		t.Fatalf("Failed to load conversation: %v", err)
+	}
+	conversation.Add("follow up", "more")
+	if err := store.Save(conversation); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	summaries = store.List()
+	if len(summaries) != 3 || summaries[0].ID != ids[0] || summaries[0].Messages != 4 {
+		t.Errorf("Expected the resumed conversation first, got %+v", summaries)
+	}
+
+	// Conversations can be loaded by number, ID or ID prefix
+	for _, ref := range []string{"1", ids[0], "20260101"} {
+		loaded, err := store.Load(ref)
+		if err != nil {
+			t.Errorf("Failed to load %q: %v", ref, err)
+			continue
+		}
+		if loaded.ID != ids[0] || len(loaded.Messages) != 4 {
+			t.Errorf("Loaded the wrong conversation for %q: %+v", ref, loaded)
+		}
+	}
+
+	for _, ref := range []string{"4", "2026", "nope"} {
+		if _, err := store.Load(ref); err == nil {
+			t.Errorf("Expected an error loading %q", ref)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// Ensure ExecClient implements the Client interface
+var _ Client = (*ExecClient)(nil)
+
+// ProviderExec is the name of the provider that runs a local command
+const ProviderExec = "exec"
+
+// execStderrSize is how much of the command's error output is kept for
+// error messages
+const execStderrSize = 2048
+
+// ExecClient answers by running a local command, such as llm or a
+// llamafile, through the shell. The prompt is written to the command's
+// standard input and the answer is read from its standard output as it is
+// printed. The system prompt and the question are also available to the
+// command as $BUDY_SYSTEM and $BUDY_PROMPT.
+type ExecClient struct {
+	command string
+}
+
+// NewExecClient creates a client running command through /bin/sh
+func NewExecClient(command string) *ExecClient {
+	return &ExecClient{command: command}
+}
+
+// Complete runs the command with the request as input and returns what it
+// prints, streaming it to req.OnToken when set
+func (c *ExecClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	if strings.TrimSpace(c.command) == "" {
+		return nil, fmt.Errorf("no command set for the exec provider (use config set exec_command <command>)")
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", c.command)
+	cmd.Env = append(os.Environ(),
+		"BUDY_SYSTEM="+req.System,
+		"BUDY_PROMPT="+req.LastUserMessage(),
+		"BUDY_MODEL="+req.Model,
+	)
+	cmd.Stdin = strings.NewReader(ExecPrompt(req))
+	// Don't wait forever for output pipes held open by the command's own
+	// children once it has been cancelled
+	cmd.WaitDelay = time.Second
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedWriter{buf: &stderr, limit: execStderrSize}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run %q: %v", c.command, err)
+	}
+
+	var text strings.Builder
+	buf := make([]byte, 4096)
+	var readErr error
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			chunk := string(buf[:n])
+			text.WriteString(chunk)
+			if readErr = req.token(chunk); readErr != nil {
+				break
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+	}
+	if readErr != nil && cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+
+	waitErr := cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if waitErr != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%q failed: %v: %s", c.command, waitErr, message)
+		}
+		return nil, fmt.Errorf("%q failed: %v", c.command, waitErr)
+	}
+
+	return &Response{
+		Text:         strings.TrimRight(text.String(), "\n"),
+		Model:        execModel(c.command),
+		FinishReason: "stop",
+	}, nil
+}
+
+// Check makes sure the command's program can be found
+func (c *ExecClient) Check(ctx context.Context) error {
+	fields := strings.Fields(c.command)
+	if len(fields) == 0 {
+		return fmt.Errorf("no command set for the exec provider")
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return fmt.Errorf("%s not found: %v", fields[0], err)
+	}
+	return nil
+}
+
+// ExecPrompt writes a request as plain text for a command's standard input:
+// the system prompt, then the conversation with each turn labelled. A
+// single question is written on its own.
+func ExecPrompt(req Request) string {
+	var parts []string
+	if req.System != "" {
+		parts = append(parts, req.System)
+	}
+	if len(req.Messages) == 1 {
+		parts = append(parts, req.Messages[0].Content)
+	} else {
+		for _, message := range req.Messages {
+			label := "User"
+			if message.Role == RoleAssistant {
+				label = "Assistant"
+			}
+			parts = append(parts, label+": "+message.Content)
+		}
+	}
+	return strings.Join(parts, "\n\n") + "\n"
+}
+
+// execModel names the program a command runs, for reporting as the model
+func execModel(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ProviderExec
+	}
+	return fields[0]
+}
+
+// limitedWriter keeps the first limit bytes written to it and discards
+// the rest
+type limitedWriter struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if room := w.limit - w.buf.Len(); room > 0 {
+		if len(p) > room {
+			w.buf.Write(p[:room])
+		} else {
+			w.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func init() {
+	RegisterProvider(Provider{
+		Name:        ProviderExec,
+		Description: "A local command such as llm or a llamafile, given the prompt on standard input",
+		Settings: []Setting{
+			{
+				Name:   "exec_command",
+				Usage:  "<command>",
+				Values: staticValues("llm", "ollama run llama3"),
+			},
+		},
+		New: func(c *storage.Config) (Client, error) {
+			command := c.Setting("exec_command")
+			if command == "" {
+				return nil, fmt.Errorf("no command set for the exec provider. Set one with 'config set exec_command <command>'")
+			}
+			return NewExecClient(command), nil
+		},
+		Check: func(ctx context.Context, c *storage.Config) error {
+			return NewExecClient(c.Setting("exec_command")).Check(ctx)
+		},
+		Describe: func(c *storage.Config) string {
+			return fmt.Sprintf("the command: %s", c.Setting("exec_command"))
+		},
+		Model: func(c *storage.Config) string {
+			return c.Setting("exec_command")
+		},
+		Help: "Set the command that answers prompts with 'config set exec_command <command>', for example 'config set exec_command llm -m mistral'",
+	})
+}
@@ -0,0 +1,84 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecClient(t *testing.T) {
+	client := NewExecClient(`cat; printf 'system=%s' "$BUDY_SYSTEM"`)
+
+	var streamed strings.Builder
+	req := NewRequest("How do I list files?")
+	req.System = "Be brief"
+	req.OnToken = func(token string) error {
+		streamed.WriteString(token)
+		return nil
+	}
+
+	resp, err := client.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "Be brief\n\nHow do I list files?\nsystem=Be brief"
+	if resp.Text != expected {
+		t.Errorf("Expected %q, got %q", expected, resp.Text)
+	}
+	if streamed.String() != expected {
+		t.Errorf("Expected the output to be streamed, got %q", streamed.String())
+	}
+	if model := execModel("llm -m mistral"); model != "llm" {
+		t.Errorf("Expected the program as model, got %q", model)
+	}
+}
+
+func TestExecClientFailure(t *testing.T) {
+	client := NewExecClient("echo 'model not found' >&2; exit 3")
+	_, err := client.Complete(context.Background(), NewRequest("hi"))
+	if err == nil || !strings.Contains(err.Error(), "model not found") {
+		t.Errorf("Expected the command's error output, got %v", err)
+	}
+
+	if _, err := NewExecClient("").Complete(context.Background(), NewRequest("hi")); err == nil {
+		t.Error("Expected an error without a command")
+	}
+
+	if err := NewExecClient("sh -c true").Check(context.Background()); err != nil {
+		t.Errorf("Expected sh to be found, got %v", err)
+	}
+	if err := NewExecClient("budy-no-such-program --flag").Check(context.Background()); err == nil {
+		t.Error("Expected an error for a missing program")
+	}
+}
+
+func TestExecClientCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := NewExecClient("sleep 5").Complete(ctx, NewRequest("hi"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the command to be stopped quickly, took %v", elapsed)
+	}
+}
+
+func TestExecPrompt(t *testing.T) {
+	req := Request{
+		Messages: []Message{
+			{Role: RoleUser, Content: "What is ls?"},
+			{Role: RoleAssistant, Content: "It lists files."},
+			{Role: RoleUser, Content: "And -a?"},
+		},
+	}
+	expected := "User: What is ls?\n\nAssistant: It lists files.\n\nUser: And -a?\n"
+	if got := ExecPrompt(req); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,213 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/shell"
+)
+
+// ExplainSystemPrompt asks the model to explain a command part by part
+const ExplainSystemPrompt = `You explain shell commands to the user of a terminal. Base the explanation on the documentation excerpts you are given, and say so when a flag is not covered by them. Reply with only a JSON object, without Markdown, of the form:
+{"summary": "<what the whole command does>", "parts": [{"token": "<a word of the command>", "meaning": "<what it does>"}], "side_effects": "<files, processes or settings it changes, or an empty string>", "destructive": <true if it deletes or overwrites data or cannot be undone>}
+List every program, flag and argument in "parts" in the order they appear. Explain combined short flags such as -xzvf one letter at a time.`
+
+// maxDocsExcerpt limits how much of each program's documentation is sent
+const maxDocsExcerpt = 4000
+
+// entryLines is how many lines of a flag's description are sent
+const entryLines = 6
+
+// Explanation is a model's breakdown of a command
+type Explanation struct {
+	Summary     string          `json:"summary"`
+	Parts       []ExplainedPart `json:"parts"`
+	SideEffects string          `json:"side_effects"`
+	Destructive bool            `json:"destructive"`
+}
+
+// ExplainedPart explains one token of a command
+type ExplainedPart struct {
+	Token   string `json:"token"`
+	Meaning string `json:"meaning"`
+}
+
+// ExplainTask describes a command to explain: the command, how it parses
+// and the parts of its programs' documentation that cover the flags used
+func ExplainTask(command string, tokens []shell.Token, docs []*shell.Docs) string {
+	var task strings.Builder
+	fmt.Fprintf(&task, "Explain this command:\n$ %s\n\nIt parses as:\n", command)
+	for _, token := range tokens {
+		fmt.Fprintf(&task, "  %s (%s)\n", token.Text, token.Kind)
+	}
+
+	for _, doc := range docs {
+		var flags []string
+		for _, token := range tokens {
+			if token.Kind == shell.TokenFlag && token.Program == doc.Program {
+				flags = append(flags, token.Text)
+			}
+		}
+		fmt.Fprintf(&task, "\nDocumentation of %s, from %s:\n%s\n", doc.Program, doc.Source, docsExcerpt(doc.Text, flags, maxDocsExcerpt))
+	}
+	return task.String()
+}
+
+// ExplainCommand asks the model to explain a command. docs looks up the
+// documentation of each program the command runs and may return nil.
+func ExplainCommand(ctx context.Context, client Client, system, command string, docs func(program string) *shell.Docs) (*Explanation, []*shell.Docs, error) {
+	tokens := shell.ParseCommandLine(command)
+
+	var found []*shell.Docs
+	for _, program := range shell.Programs(tokens) {
+		if doc := docs(program); doc != nil {
+			found = append(found, doc)
+		}
+	}
+
+	resp, err := client.Complete(ctx, newJSONRequest(system, ExplainTask(command, tokens, found)))
+	if err != nil {
+		return nil, found, err
+	}
+	explanation, err := ParseExplanation(resp.Text)
+	return explanation, found, err
+}
+
+// ParseExplanation reads an explanation from a model's answer. Text around
+// the JSON object, such as a Markdown code fence, is ignored.
+func ParseExplanation(text string) (*Explanation, error) {
+	object, err := jsonObject(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var explanation Explanation
+	if err := json.Unmarshal([]byte(object), &explanation); err != nil {
+		return nil, fmt.Errorf("error parsing explanation: %v", err)
+	}
+	if explanation.Summary == "" && len(explanation.Parts) == 0 {
+		return nil, fmt.Errorf("the answer did not include an explanation")
+	}
+	return &explanation, nil
+}
+
+// docsExcerpt picks the parts of a program's documentation worth sending:
+// its first lines, which name the program and show its usage, and the
+// entry for each flag. Combined short flags such as -xzvf are looked up one
+// letter at a time when there is no entry for them as a whole.
+func docsExcerpt(text string, flags []string, limit int) string {
+	lines := strings.Split(text, "\n")
+
+	var excerpt []string
+	for i := 0; i < len(lines) && len(excerpt) < entryLines; i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			excerpt = append(excerpt, lines[i])
+		}
+	}
+
+	included := make(map[int]bool)
+	for _, flag := range flags {
+		name := flagName(flag)
+		names := []string{name}
+		if findEntry(lines, name) < 0 && isCombinedFlags(name) {
+			names = nil
+			for _, letter := range name[1:] {
+				names = append(names, "-"+string(letter))
+			}
+		}
+
+		for _, name := range names {
+			start := findEntry(lines, name)
+			if start < 0 || included[start] {
+				continue
+			}
+			included[start] = true
+			excerpt = append(excerpt, "...")
+			excerpt = append(excerpt, entry(lines, start)...)
+		}
+	}
+
+	result := strings.Join(excerpt, "\n")
+	if len(result) > limit {
+		result = strings.ToValidUTF8(result[:limit], "") + "\n..."
+	}
+	return result
+}
+
+// flagName strips the value from a --flag=value flag
+func flagName(flag string) string {
+	if strings.HasPrefix(flag, "--") {
+		name, _, _ := strings.Cut(flag, "=")
+		return name
+	}
+	return flag
+}
+
+// isCombinedFlags reports whether flag looks like several short flags
+// written together, such as -xzvf
+func isCombinedFlags(flag string) bool {
+	if len(flag) < 3 || flag[0] != '-' || flag[1] == '-' {
+		return false
+	}
+	for _, c := range flag[1:] {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// findEntry returns the line that documents a flag, or -1. Such lines start
+// with the flag, or list it after another spelling of it, as in
+// "-x, --extract".
+func findEntry(lines []string, flag string) int {
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if startsWithFlag(trimmed, flag) {
+			return i
+		}
+		for _, sep := range []string{", ", " | "} {
+			if at := strings.Index(trimmed, sep+flag); at >= 0 && strings.HasPrefix(trimmed, "-") &&
+				startsWithFlag(trimmed[at+len(sep):], flag) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// startsWithFlag reports whether s starts with flag as a whole word
+func startsWithFlag(s, flag string) bool {
+	if !strings.HasPrefix(s, flag) {
+		return false
+	}
+	if len(s) == len(flag) {
+		return true
+	}
+	next := s[len(flag)]
+	return !(next >= 'a' && next <= 'z') && !(next >= 'A' && next <= 'Z') && !(next >= '0' && next <= '9') && next != '-'
+}
+
+// entry returns a flag's line and the more indented lines that follow it
+func entry(lines []string, start int) []string {
+	indent := indentation(lines[start])
+	result := []string{lines[start]}
+	for i := start + 1; i < len(lines) && len(result) < entryLines; i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+		if indentation(line) <= indent {
+			break
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// indentation returns the number of leading spaces and tabs of a line
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
@@ -0,0 +1,134 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/shell"
+)
+
+// tarDocs is an abridged tar man page
+const tarDocs = `TAR(1)                    GNU TAR Manual                    TAR(1)
+
+NAME
+       tar - an archiving utility
+
+SYNOPSIS
+       tar [-] A --catenate --concatenate | c --create | d --diff ...
+
+OPTIONS
+       -c, --create
+              Create a new archive.
+
+       -x, --extract, --get
+              Extract files from an archive.  Arguments are optional.
+              When given, they specify names of the archive members to
+              be extracted.
+
+       -f, --file=ARCHIVE
+              Use archive file or device ARCHIVE.
+
+       -v, --verbose
+              Verbosely list files processed.
+
+       -z, --gzip, --gunzip, --ungzip
+              Filter the archive through gzip(1).
+
+       -C, --directory=DIR
+              Change to DIR before performing any operations.`
+
+func TestDocsExcerpt(t *testing.T) {
+	excerpt := docsExcerpt(tarDocs, []string{"-xzvf", "--directory=/tmp"}, 4000)
+
+	for _, want := range []string{
+		"tar - an archiving utility",
+		"Extract files from an archive.",
+		"Filter the archive through gzip(1).",
+		"Verbosely list files processed.",
+		"Use archive file or device ARCHIVE.",
+		"Change to DIR before performing any operations.",
+	} {
+		if !strings.Contains(excerpt, want) {
+			t.Errorf("Expected the excerpt to contain %q, got:\n%s", want, excerpt)
+		}
+	}
+	if strings.Contains(excerpt, "Create a new archive.") {
+		t.Errorf("Expected flags that aren't used to be left out, got:\n%s", excerpt)
+	}
+
+	// Entries stop at the next flag
+	if strings.Count(excerpt, "-x, --extract") != 1 || strings.Contains(excerpt, "be extracted.\n       -f") {
+		t.Errorf("Unexpected entries:\n%s", excerpt)
+	}
+
+	if excerpt := docsExcerpt(tarDocs, []string{"-x"}, 100); len(excerpt) > 104 {
+		t.Errorf("Expected the excerpt to be limited, got %d bytes", len(excerpt))
+	}
+}
+
+func TestFindEntry(t *testing.T) {
+	lines := strings.Split(tarDocs, "\n")
+	tests := []struct {
+		flag  string
+		found bool
+	}{
+		{"-x", true},
+		{"--extract", true},
+		{"--get", true},
+		{"--file", true},
+		{"-q", false},
+		{"--ex", false},
+	}
+	for _, tt := range tests {
+		if found := findEntry(lines, tt.flag) >= 0; found != tt.found {
+			t.Errorf("Expected %s found=%v, got %v", tt.flag, tt.found, found)
+		}
+	}
+}
+
+func TestExplainCommand(t *testing.T) {
+	client := &fakeClient{text: "```json\n" + `{"summary":"Extracts foo.tgz into /tmp","parts":[{"token":"tar","meaning":"The archiver"},{"token":"-x","meaning":"Extract"}],"side_effects":"Writes files in /tmp","destructive":false}` + "\n```"}
+
+	var looked []string
+	docs := func(program string) *shell.Docs {
+		looked = append(looked, program)
+		if program == "tar" {
+			return &shell.Docs{Program: "tar", Source: shell.DocsMan, Text: tarDocs}
+		}
+		return nil
+	}
+
+	explanation, found, err := ExplainCommand(context.Background(), client, ExplainSystemPrompt, "tar -xzvf foo.tgz -C /tmp | wc -l", docs)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if explanation.Summary != "Extracts foo.tgz into /tmp" || len(explanation.Parts) != 2 || explanation.Destructive {
+		t.Errorf("Unexpected explanation: %+v", explanation)
+	}
+	if len(looked) != 2 || looked[0] != "tar" || looked[1] != "wc" {
+		t.Errorf("Expected documentation for tar and wc to be looked up, got %v", looked)
+	}
+	if len(found) != 1 || found[0].Program != "tar" {
+		t.Errorf("Expected tar's documentation, got %+v", found)
+	}
+
+	req := client.requests[0]
+	task := req.LastUserMessage()
+	for _, want := range []string{"$ tar -xzvf foo.tgz -C /tmp | wc -l", "-xzvf (flag)", "Documentation of tar, from man", "Extract files from an archive."} {
+		if !strings.Contains(task, want) {
+			t.Errorf("Expected the request to contain %q, got:\n%s", want, task)
+		}
+	}
+	if !req.JSON || req.System != ExplainSystemPrompt {
+		t.Errorf("Expected a JSON request with the explain prompt, got %+v", req)
+	}
+}
+
+func TestParseExplanationErrors(t *testing.T) {
+	for _, text := range []string{"tar extracts archives", `{"summary": ""}`, `{"parts": "tar"}`} {
+		if _, err := ParseExplanation(text); err == nil {
+			t.Errorf("Expected an error for %q, got nil", text)
+		}
+	}
+}
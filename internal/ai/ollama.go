@@ -2,11 +2,16 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/storage"
 )
 
 // Ensure OllamaClient implements the Client interface
@@ -18,20 +23,32 @@ type OllamaClient struct {
 	model     string
 }
 
-// OllamaRequest represents a request to Ollama API
+// OllamaRequest represents a request to the Ollama chat API
 type OllamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
-	System string `json:"system,omitempty"`
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Format   string         `json:"format,omitempty"`
+	Options  *OllamaOptions `json:"options,omitempty"`
+}
+
+// OllamaOptions holds model parameters for an Ollama request
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
 }
 
-// OllamaResponse represents a response from Ollama API
+// OllamaResponse represents a response from the Ollama chat API. When
+// streaming, each line of the response is one of these carrying the next
+// tokens, and the last one has Done set along with the token counts.
 type OllamaResponse struct {
-	Model     string `json:"model"`
-	Response  string `json:"response"`
-	Done      bool   `json:"done"`
-	CreatedAt string `json:"created_at,omitempty"`
+	Model           string  `json:"model"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason,omitempty"`
+	CreatedAt       string  `json:"created_at,omitempty"`
+	PromptEvalCount int     `json:"prompt_eval_count,omitempty"`
+	EvalCount       int     `json:"eval_count,omitempty"`
+	Error           string  `json:"error,omitempty"`
 }
 
 // NewOllamaClient creates a new Ollama client
@@ -50,34 +67,52 @@ func NewOllamaClient(serverURL string, model string) *OllamaClient {
 	}
 }
 
-// Ask sends a question to the Ollama API and displays the response
-func (c *OllamaClient) Ask(query string) error {
+// Complete sends a request to the Ollama API and returns the response,
+// streaming it to req.OnToken when set
+func (c *OllamaClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	model := req.Model
+	if model == "" {
+		model = c.model
+	}
+
 	// Create request
+	messages := []Message{}
+	if req.System != "" {
+		messages = append(messages, Message{Role: RoleSystem, Content: req.System})
+	}
 	reqBody := OllamaRequest{
-		Model:  c.model,
-		Prompt: query,
-		Stream: false,
-		System: "You are a helpful terminal assistant for Unix/Linux/macOS systems. Provide concise answers for command line usage.",
+		Model:    model,
+		Messages: append(messages, req.Messages...),
+		Stream:   req.OnToken != nil,
+	}
+	if req.JSON {
+		reqBody.Format = "json"
+	}
+	if req.Temperature != nil {
+		reqBody.Options = &OllamaOptions{Temperature: req.Temperature}
 	}
 
 	reqData, err := json.Marshal(reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", c.serverURL+"/api/generate", bytes.NewBuffer(reqData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.serverURL+"/api/chat", bytes.NewBuffer(reqData))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Content-Type", "application/json")
 
 	// Send request
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := client.Do(httpReq)
 	if err != nil {
-		return fmt.Errorf("failed to connect to Ollama server: %v", err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to connect to Ollama server: %v", err)
 	}
 
 	// Use a closure to properly handle the error from Body.Close()
@@ -88,24 +123,155 @@ func (c *OllamaClient) Ask(query string) error {
 		}
 	}()
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, streamError(ctx, err)
+		}
+		return nil, newStatusError(resp, body)
+	}
+
+	// A streamed response is a series of chunks, one per line; a complete
+	// response is a single chunk
+	result := &Response{Model: model}
+	var text strings.Builder
+	err = readNDJSON(resp.Body, func(line []byte) error {
+		var chunk OllamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return err
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("API error: %s", chunk.Error)
+		}
+		text.WriteString(chunk.Message.Content)
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Done {
+			result.FinishReason = chunk.DoneReason
+			result.Usage = Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+		}
+		return req.token(chunk.Message.Content)
+	})
 	if err != nil {
-		return err
+		return nil, streamError(ctx, err)
 	}
 
+	result.Text = text.String()
+	return result, nil
+}
+
+// OllamaModel describes a model installed on an Ollama server
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Size       int64              `json:"size"`
+	ModifiedAt string             `json:"modified_at,omitempty"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+// ollamaTagsResponse is the response of the Ollama /api/tags endpoint
+type ollamaTagsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
+
+// ListOllamaModels returns the names of the models installed on an Ollama
+// server. It uses a short timeout so it can be called interactively.
+func ListOllamaModels(serverURL string) ([]string, error) {
+	if serverURL == "" {
+		serverURL = "http://localhost:11434"
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(serverURL + "/api/tags")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Ollama server: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, body)
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp, body)
 	}
 
-	// Parse response
-	var ollamaResp OllamaResponse
-	if err := json.Unmarshal(body, &ollamaResp); err != nil {
-		return err
+	var tags ollamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
 	}
 
-	// Print the response
-	fmt.Println(ollamaResp.Response)
+	names := make([]string, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		names = append(names, model.Name)
+	}
+	return names, nil
+}
+
+// ollamaHelp explains how to get Ollama running
+const ollamaHelp = `Make sure Ollama is installed and running:
+1. Install from https://ollama.ai
+2. Verify Ollama is running (it should start automatically after installation)
+3. Check that the Ollama server URL is correct with 'config set ollama_url <url>'`
 
+func init() {
+	RegisterProvider(Provider{
+		Name:        storage.ProviderOllama,
+		Description: "Local models served by Ollama",
+		Settings: []Setting{
+			{
+				Name:  "ollama_url",
+				Usage: "<url>",
+				Parse: parseServerURL,
+				Get:   func(c *storage.Config) string { return c.OllamaURL },
+				Set:   func(c *storage.Config, value string) { c.OllamaURL = value },
+			},
+			{
+				Name:  "ollama_model",
+				Usage: "<model_name>",
+				Values: func(c *storage.Config) []string {
+					models, _ := ListOllamaModels(c.OllamaURL)
+					return models
+				},
+				Validate: func(c *storage.Config, value string) error {
+					ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+					defer cancel()
+					return checkOllamaModel(ctx, c.OllamaURL, value)
+				},
+				Get: func(c *storage.Config) string { return c.OllamaModel },
+				Set: func(c *storage.Config, value string) { c.OllamaModel = value },
+			},
+		},
+		New: func(c *storage.Config) (Client, error) {
+			return NewOllamaClient(c.OllamaURL, c.OllamaModel), nil
+		},
+		Check: func(ctx context.Context, c *storage.Config) error {
+			return CheckOllama(ctx, c.OllamaURL)
+		},
+		Describe: func(c *storage.Config) string {
+			return fmt.Sprintf("Ollama at %s with model: %s", c.OllamaURL, c.OllamaModel)
+		},
+		Model: func(c *storage.Config) string {
+			return NewOllamaClient(c.OllamaURL, c.OllamaModel).model
+		},
+		Help: ollamaHelp,
+	})
+}
+
+// CheckOllama checks that an Ollama server is answering
+func CheckOllama(ctx context.Context, serverURL string) error {
+	if serverURL == "" {
+		serverURL = "http://localhost:11434"
+	}
+	status, err := checkHTTP(ctx, serverURL+"/api/tags", nil)
+	if err != nil {
+		return fmt.Errorf("could not connect to Ollama at %s: %v", serverURL, err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected answer from Ollama at %s (status %d)", serverURL, status)
+	}
 	return nil
 }
@@ -0,0 +1,268 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// OllamaModelDetails describes what a model is
+type OllamaModelDetails struct {
+	Format            string `json:"format,omitempty"`
+	Family            string `json:"family,omitempty"`
+	ParameterSize     string `json:"parameter_size,omitempty"`
+	QuantizationLevel string `json:"quantization_level,omitempty"`
+}
+
+// OllamaModelInfo is what Ollama's /api/show reports about a model
+type OllamaModelInfo struct {
+	Details    OllamaModelDetails `json:"details"`
+	Parameters string             `json:"parameters,omitempty"`
+	Template   string             `json:"template,omitempty"`
+	License    string             `json:"license,omitempty"`
+	ModifiedAt string             `json:"modified_at,omitempty"`
+}
+
+// OllamaProgress is one update of a model download. Total and Completed
+// are set while a layer is being downloaded.
+type OllamaProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// OllamaModels returns the models installed on an Ollama server, sorted
+// by name
+func OllamaModels(ctx context.Context, serverURL string) ([]OllamaModel, error) {
+	var tags ollamaTagsResponse
+	if err := ollamaCall(ctx, serverURL, "GET", "/api/tags", nil, &tags); err != nil {
+		return nil, err
+	}
+	sort.Slice(tags.Models, func(i, j int) bool {
+		return tags.Models[i].Name < tags.Models[j].Name
+	})
+	return tags.Models, nil
+}
+
+// ShowOllamaModel returns what an Ollama server knows about a model
+func ShowOllamaModel(ctx context.Context, serverURL, name string) (*OllamaModelInfo, error) {
+	var info OllamaModelInfo
+	if err := ollamaCall(ctx, serverURL, "POST", "/api/show", map[string]string{"model": name}, &info); err != nil {
+		return nil, modelError(name, err)
+	}
+	return &info, nil
+}
+
+// DeleteOllamaModel removes a model from an Ollama server
+func DeleteOllamaModel(ctx context.Context, serverURL, name string) error {
+	return modelError(name, ollamaCall(ctx, serverURL, "DELETE", "/api/delete", map[string]string{"model": name}, nil))
+}
+
+// PullOllamaModel downloads a model to an Ollama server, passing each
+// progress update to progress as it streams in. Cancelling ctx stops the
+// download; Ollama keeps what it has and resumes from there next time.
+func PullOllamaModel(ctx context.Context, serverURL, name string, progress func(OllamaProgress)) error {
+	body, err := json.Marshal(map[string]interface{}{"model": name, "stream": true})
+	if err != nil {
+		return err
+	}
+	resp, err := ollamaRequest(ctx, serverURL, "POST", "/api/pull", body)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	err = readNDJSON(resp.Body, func(line []byte) error {
+		var update OllamaProgress
+		if err := json.Unmarshal(line, &update); err != nil {
+			return err
+		}
+		if update.Error != "" {
+			return fmt.Errorf("could not pull %s: %s", name, update.Error)
+		}
+		if progress != nil {
+			progress(update)
+		}
+		return nil
+	})
+	return streamError(ctx, err)
+}
+
+// ollamaCall sends a request to an Ollama endpoint, with body encoded as
+// JSON when set, and decodes the answer into result when set
+func ollamaCall(ctx context.Context, serverURL, method, path string, body interface{}, result interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := ollamaRequest(ctx, serverURL, method, path, data)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if result == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return streamError(ctx, err)
+	}
+	return nil
+}
+
+// ollamaRequest sends a request to an Ollama endpoint and returns the
+// response if it succeeded
+func ollamaRequest(ctx context.Context, serverURL, method, path string, body []byte) (*http.Response, error) {
+	if serverURL == "" {
+		serverURL = "http://localhost:11434"
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, serverURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to connect to Ollama server: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return nil, newStatusError(resp, data)
+	}
+	return resp, nil
+}
+
+// modelError explains an error from Ollama about a model that isn't
+// installed
+func modelError(name string, err error) error {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("model %s is not installed", name)
+	}
+	return err
+}
+
+// SameOllamaModel reports whether two model names refer to the same model.
+// A name without a tag means the "latest" tag, as in Ollama.
+func SameOllamaModel(a, b string) bool {
+	return withTag(a) == withTag(b)
+}
+
+// withTag adds the default tag to a model name that has none
+func withTag(name string) string {
+	if strings.Contains(name, ":") {
+		return name
+	}
+	return name + ":latest"
+}
+
+// ClosestModels returns up to three of the installed models whose names
+// are closest to name, for suggesting when a model isn't installed
+func ClosestModels(name string, installed []string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	var candidates []candidate
+	wanted := strings.ToLower(strings.TrimSuffix(name, ":latest"))
+	for _, model := range installed {
+		base := strings.ToLower(strings.TrimSuffix(model, ":latest"))
+		distance := editDistance(wanted, base)
+		if tagged := strings.IndexByte(base, ':'); tagged >= 0 {
+			// Compare against the name without its tag as well, so that
+			// "lama3" suggests "llama3:8b"
+			if d := editDistance(wanted, base[:tagged]); d < distance {
+				distance = d
+			}
+		}
+		if distance <= len(wanted)/3+1 || strings.HasPrefix(base, wanted) {
+			candidates = append(candidates, candidate{model, distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	var names []string
+	for i := 0; i < len(candidates) && i < 3; i++ {
+		names = append(names, candidates[i].name)
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// checkOllamaModel makes sure a model is installed on the configured
+// server, suggesting close matches when it isn't. A server that can't be
+// reached isn't an error, since the model may be pulled later.
+func checkOllamaModel(ctx context.Context, serverURL, name string) error {
+	models, err := OllamaModels(ctx, serverURL)
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(models))
+	for _, model := range models {
+		if SameOllamaModel(model.Name, name) {
+			return nil
+		}
+		names = append(names, model.Name)
+	}
+
+	message := fmt.Sprintf("model %s is not installed.", name)
+	if matches := ClosestModels(name, names); len(matches) > 0 {
+		message += " Did you mean " + strings.Join(matches, ", ") + "?"
+	}
+	return fmt.Errorf("%s Install it with 'models pull %s'", message, name)
+}
@@ -0,0 +1,213 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// fakeOllama stands in for an Ollama server with a set of installed
+// models. Pulling a model streams a few progress updates and installs it,
+// unless the name is "broken".
+type fakeOllama struct {
+	mu     sync.Mutex
+	models []string
+}
+
+func newFakeOllama(t *testing.T, models ...string) (*fakeOllama, *httptest.Server) {
+	t.Helper()
+	fake := &fakeOllama{models: models}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body struct {
+		Model string `json:"model"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /api/tags":
+		var tags ollamaTagsResponse
+		for _, name := range f.models {
+			tags.Models = append(tags.Models, OllamaModel{
+				Name:    name,
+				Size:    4 << 30,
+				Details: OllamaModelDetails{ParameterSize: "8B", QuantizationLevel: "Q4_0"},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(tags)
+	case "POST /api/show":
+		if f.find(body.Model) < 0 {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"details":{"family":"llama","parameter_size":"8B"},"parameters":"stop \"<|eot_id|>\""}`))
+	case "DELETE /api/delete":
+		i := f.find(body.Model)
+		if i < 0 {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			return
+		}
+		f.models = append(f.models[:i], f.models[i+1:]...)
+	case "POST /api/pull":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = w.Write([]byte(`{"status":"pulling manifest"}` + "\n"))
+		if body.Model == "broken" {
+			_, _ = w.Write([]byte(`{"error":"pull model manifest: file does not exist"}` + "\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":100,"completed":40}` + "\n"))
+		_, _ = w.Write([]byte(`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":100,"completed":100}` + "\n"))
+		_, _ = w.Write([]byte(`{"status":"success"}` + "\n"))
+		f.models = append(f.models, withTag(body.Model))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// find returns the index of an installed model, or -1
+func (f *fakeOllama) find(name string) int {
+	for i, model := range f.models {
+		if SameOllamaModel(model, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestOllamaModels(t *testing.T) {
+	_, server := newFakeOllama(t, "mistral:7b", "llama3:latest")
+
+	models, err := OllamaModels(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(models) != 2 || models[0].Name != "llama3:latest" || models[1].Name != "mistral:7b" {
+		t.Fatalf("Expected the models sorted by name, got %+v", models)
+	}
+	if models[0].Size != 4<<30 || models[0].Details.QuantizationLevel != "Q4_0" {
+		t.Errorf("Expected the model details, got %+v", models[0])
+	}
+}
+
+func TestPullOllamaModel(t *testing.T) {
+	fake, server := newFakeOllama(t)
+
+	var updates []OllamaProgress
+	err := PullOllamaModel(context.Background(), server.URL, "llama3", func(update OllamaProgress) {
+		updates = append(updates, update)
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(updates) != 4 || updates[1].Completed != 40 || updates[1].Total != 100 || updates[3].Status != "success" {
+		t.Errorf("Expected every progress update, got %+v", updates)
+	}
+	if fake.find("llama3") < 0 {
+		t.Error("Expected the model to be installed")
+	}
+
+	// Errors in the stream fail the pull
+	err = PullOllamaModel(context.Background(), server.URL, "broken", nil)
+	if err == nil || !strings.Contains(err.Error(), "file does not exist") {
+		t.Errorf("Expected the pull error, got %v", err)
+	}
+}
+
+func TestShowAndDeleteOllamaModel(t *testing.T) {
+	fake, server := newFakeOllama(t, "llama3:latest")
+
+	info, err := ShowOllamaModel(context.Background(), server.URL, "llama3")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Details.Family != "llama" || !strings.Contains(info.Parameters, "stop") {
+		t.Errorf("Unexpected model info: %+v", info)
+	}
+
+	if err := DeleteOllamaModel(context.Background(), server.URL, "llama3"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(fake.models) != 0 {
+		t.Errorf("Expected the model to be removed, got %v", fake.models)
+	}
+
+	// Missing models are reported by name
+	for _, err := range []error{
+		DeleteOllamaModel(context.Background(), server.URL, "llama3"),
+		func() error { _, err := ShowOllamaModel(context.Background(), server.URL, "llama3"); return err }(),
+	} {
+		if err == nil || err.Error() != "model llama3 is not installed" {
+			t.Errorf("Expected a missing model error, got %v", err)
+		}
+	}
+}
+
+func TestSameOllamaModel(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"llama3", "llama3:latest", true},
+		{"llama3:latest", "llama3:latest", true},
+		{"llama3:8b", "llama3", false},
+		{"llama3", "mistral", false},
+	}
+	for _, test := range tests {
+		if got := SameOllamaModel(test.a, test.b); got != test.expected {
+			t.Errorf("Expected SameOllamaModel(%q, %q) to be %v, got %v", test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func TestClosestModels(t *testing.T) {
+	installed := []string{"codellama:latest", "llama3:8b", "llama3:latest", "mistral:latest", "phi3:mini"}
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"lama3", "llama3:8b,llama3:latest"},
+		{"mistrel", "mistral:latest"},
+		{"phi", "phi3:mini"},
+		{"gemma", ""},
+	}
+	for _, test := range tests {
+		if got := strings.Join(ClosestModels(test.name, installed), ","); got != test.expected {
+			t.Errorf("Expected %q to suggest %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestOllamaModelSetting(t *testing.T) {
+	_, server := newFakeOllama(t, "llama3:latest", "mistral:latest")
+	config := &storage.Config{OllamaURL: server.URL}
+	_, setting, _ := FindSetting("ollama_model")
+
+	value, err := setting.Normalize(config, "llama3")
+	if err != nil || value != "llama3" {
+		t.Errorf("Expected an installed model to be accepted, got %q, %v", value, err)
+	}
+
+	_, err = setting.Normalize(config, "lama3")
+	if err == nil || !strings.Contains(err.Error(), "Did you mean llama3:latest?") || !strings.Contains(err.Error(), "models pull lama3") {
+		t.Errorf("Expected a suggestion, got %v", err)
+	}
+
+	// Without a server to ask, any name is accepted
+	config.OllamaURL = "http://127.0.0.1:1"
+	if _, err := setting.Normalize(config, "lama3"); err != nil {
+		t.Errorf("Expected no error without a server, got %v", err)
+	}
+}
@@ -1,9 +1,15 @@
 package ai
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestOllamaClient(t *testing.T) {
@@ -21,14 +27,14 @@ func TestOllamaClient(t *testing.T) {
 		}
 
 		// Check endpoint
-		if r.URL.Path != "/api/generate" {
-			t.Errorf("Expected /api/generate endpoint, got %s", r.URL.Path)
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("Expected /api/chat endpoint, got %s", r.URL.Path)
 		}
 
 		// Return mock response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		_, err := w.Write([]byte(`{"model":"llama3","response":"To list files in a directory, use the 'ls' command.","done":true}`))
+		_, err := w.Write([]byte(`{"model":"llama3","message":{"role":"assistant","content":"To list files in a directory, use the 'ls' command."},"done":true}`))
 		if err != nil {
 			t.Fatalf("Failed to write response: %v", err)
 		}
@@ -38,10 +44,69 @@ func TestOllamaClient(t *testing.T) {
 	// Create a client with the mock server URL
 	client := NewOllamaClient(server.URL, "llama3")
 
-	// Test Ask method
-	err := client.Ask("How do I list files?")
+	// Test Complete method
+	resp, err := client.Complete(context.Background(), NewRequest("How do I list files?"))
 	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if resp.Text != "To list files in a directory, use the 'ls' command." {
+		t.Errorf("Unexpected response text: %q", resp.Text)
+	}
+	if resp.Model != "llama3" {
+		t.Errorf("Expected model llama3, got %s", resp.Model)
+	}
+}
+
+func TestOllamaClientRequest(t *testing.T) {
+	var got OllamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"model":"mistral","message":{"role":"assistant","content":"ok"},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":3}`))
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, "llama3")
+	resp, err := client.Complete(context.Background(), Request{
+		System: "Be brief",
+		Messages: []Message{
+			{Role: RoleUser, Content: "hi"},
+			{Role: RoleAssistant, Content: "hello"},
+			{Role: RoleUser, Content: "list files"},
+		},
+		Model:       "mistral",
+		Temperature: Temperature(0),
+		JSON:        true,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got.Model != "mistral" || got.Stream || got.Format != "json" {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+	if got.Options == nil || got.Options.Temperature == nil || *got.Options.Temperature != 0 {
+		t.Errorf("Expected temperature 0 in options, got %+v", got.Options)
+	}
+	expectedMessages := []Message{
+		{Role: RoleSystem, Content: "Be brief"},
+		{Role: RoleUser, Content: "hi"},
+		{Role: RoleAssistant, Content: "hello"},
+		{Role: RoleUser, Content: "list files"},
+	}
+	if !reflect.DeepEqual(got.Messages, expectedMessages) {
+		t.Errorf("Expected messages %+v, got %+v", expectedMessages, got.Messages)
+	}
+
+	expected := Response{
+		Text:         "ok",
+		Model:        "mistral",
+		FinishReason: "stop",
+		Usage:        Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+	}
+	if *resp != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *resp)
 	}
 }
 
@@ -59,3 +124,136 @@ func TestOllamaClientDefaults(t *testing.T) {
 		t.Errorf("Expected default model to be llama3, got %s", client.model)
 	}
 }
+
+func TestOllamaClientStreaming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if !req.Stream {
+			t.Error("Expected a streaming request")
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writeChunks(t, w,
+			`{"model":"llama3","message":{"role":"assistant","content":"Use "},"done":false}`+"\n",
+			`{"model":"llama3","message":{"role":"assistant","content":"ls"},"done":false}`+"\n"+`{"model":"llama3","message":{"role":"assistant","content":" -la"},"done":false}`+"\n",
+			`{"model":"llama3","message":{"role":"assistant","content":""},"done":true}`+"\n",
+		)
+	}))
+	defer server.Close()
+
+	out := newTokenRecorder()
+	client := NewOllamaClient(server.URL, "llama3")
+	req := NewRequest("How do I list files?")
+	req.OnToken = out.Token
+
+	resp, err := client.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if out.String() != "Use ls -la" {
+		t.Errorf("Expected tokens %q, got %q", "Use ls -la", out.String())
+	}
+	if resp.Text != "Use ls -la" {
+		t.Errorf("Expected text %q, got %q", "Use ls -la", resp.Text)
+	}
+}
+
+func TestOllamaClientStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeChunks(t, w,
+			`{"message":{"role":"assistant","content":"Partial"},"done":false}`+"\n",
+			`{"error":"model crashed"}`+"\n",
+		)
+	}))
+	defer server.Close()
+
+	out := newTokenRecorder()
+	client := NewOllamaClient(server.URL, "llama3")
+	req := NewRequest("test")
+	req.OnToken = out.Token
+
+	_, err := client.Complete(context.Background(), req)
+	if err == nil || !strings.Contains(err.Error(), "model crashed") {
+		t.Errorf("Expected the stream error, got %v", err)
+	}
+	if out.String() != "Partial" {
+		t.Errorf("Expected the partial answer, got %q", out.String())
+	}
+}
+
+func TestOllamaClientStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"model 'nope' not found"}`))
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, "nope")
+
+	_, err := client.Complete(context.Background(), NewRequest("test"))
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("Expected a status error, got %v", err)
+	}
+}
+
+func TestOllamaClientCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeChunks(t, w, `{"message":{"role":"assistant","content":"Thinking"},"done":false}`+"\n")
+		// Hold the stream open like a slow model would
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	out := newTokenRecorder()
+	client := NewOllamaClient(server.URL, "llama3")
+	req := NewRequest("test")
+	req.OnToken = out.Token
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Complete(ctx, req)
+		done <- err
+	}()
+
+	<-out.first
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Complete did not return after cancellation")
+	}
+	if out.String() != "Thinking" {
+		t.Errorf("Expected the partial answer, got %q", out.String())
+	}
+}
+
+func TestListOllamaModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("Expected /api/tags endpoint, got %s", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"models":[{"name":"llama3:latest","size":1},{"name":"mistral:7b","size":2}]}`))
+	}))
+	defer server.Close()
+
+	models, err := ListOllamaModels(server.URL)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(models) != 2 || models[0] != "llama3:latest" || models[1] != "mistral:7b" {
+		t.Errorf("Unexpected models: %v", models)
+	}
+}
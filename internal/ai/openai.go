@@ -2,89 +2,175 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/storage"
 )
 
 // Ensure OpenAIClient implements the Client interface
 var _ Client = (*OpenAIClient)(nil)
 
-// OpenAIClient handles interactions with the OpenAI API
+// Defaults for the OpenAI API
+const (
+	DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+	DefaultOpenAIModel   = "gpt-3.5-turbo"
+)
+
+// OpenAIClient handles interactions with the OpenAI API and servers that
+// implement it, such as Azure OpenAI gateways, vLLM, llama.cpp and LM
+// Studio
 type OpenAIClient struct {
-	apiKey string
-}
+	apiKey  string
+	baseURL string
+	model   string
 
-// Message represents a message in the OpenAI chat
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	// headers are sent with every request, after the standard ones
+	headers map[string]string
 }
 
 // OpenAIRequest represents a request to OpenAI API
 type OpenAIRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model          string                `json:"model"`
+	Messages       []Message             `json:"messages"`
+	Temperature    *float64              `json:"temperature,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *OpenAIStreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// OpenAIResponseFormat asks for a particular kind of answer, such as a
+// JSON object
+type OpenAIResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// OpenAIStreamOptions controls what a streamed response includes
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIUsage reports the tokens used by a request
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 // OpenAIResponse represents a response from OpenAI API
 type OpenAIResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *OpenAIUsage `json:"usage,omitempty"`
 }
 
-// NewOpenAIClient creates a new OpenAI client
-func NewOpenAIClient(apiKey string) *OpenAIClient {
+// OpenAIStreamChunk represents one event of a streamed OpenAI response
+type OpenAIStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *OpenAIUsage `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// NewOpenAIClient creates a new OpenAI client. An empty baseURL or model
+// means OpenAI's own API and the default model. headers are sent with
+// every request and may be nil.
+func NewOpenAIClient(apiKey, baseURL, model string, headers map[string]string) *OpenAIClient {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	if model == "" {
+		model = DefaultOpenAIModel
+	}
 	return &OpenAIClient{
-		apiKey: apiKey,
+		apiKey:  apiKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		model:   model,
+		headers: headers,
 	}
 }
 
-// Ask sends a question to the OpenAI API and displays the response
-func (c *OpenAIClient) Ask(query string) error {
-	if c.apiKey == "" {
-		return fmt.Errorf("OpenAI API key not set (use export OPENAI_API_KEY=your_key)")
+// Complete sends a request to the OpenAI API and returns the response,
+// streaming it to req.OnToken when set. Servers other than OpenAI's may be
+// used without an API key.
+func (c *OpenAIClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	if c.apiKey == "" && c.baseURL == DefaultOpenAIBaseURL {
+		return nil, fmt.Errorf("OpenAI API key not set (use export OPENAI_API_KEY=your_key)")
+	}
+
+	model := req.Model
+	if model == "" {
+		model = c.model
 	}
 
 	// Create request
+	messages := []Message{}
+	if req.System != "" {
+		messages = append(messages, Message{Role: RoleSystem, Content: req.System})
+	}
 	reqBody := OpenAIRequest{
-		Model: "gpt-3.5-turbo",
-		Messages: []Message{
-			{
-				Role:    "system",
-				Content: "You are a helpful terminal assistant for Unix/Linux/macOS systems. Provide concise answers for command line usage.",
-			},
-			{
-				Role:    "user",
-				Content: query,
-			},
-		},
+		Model:       model,
+		Messages:    append(messages, req.Messages...),
+		Temperature: req.Temperature,
+	}
+	if req.OnToken != nil {
+		reqBody.Stream = true
+		reqBody.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
+	}
+	if req.JSON {
+		reqBody.ResponseFormat = &OpenAIResponseFormat{Type: "json_object"}
 	}
 
 	reqData, err := json.Marshal(reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(reqData))
+	endpoint, err := c.endpoint("chat/completions")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqData))
+	if err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if reqBody.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+	for name, value := range c.headers {
+		httpReq.Header.Set(name, value)
+	}
 
 	// Send request
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := client.Do(httpReq)
 	if err != nil {
-		return err
+		return nil, streamError(ctx, err)
 	}
 
 	// Use a closure to properly handle the error from Body.Close()
@@ -95,25 +181,211 @@ func (c *OpenAIClient) Ask(query string) error {
 		}
 	}()
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, streamError(ctx, err)
+		}
+		return nil, newStatusError(resp, body)
+	}
+
+	var result *Response
+	if reqBody.Stream {
+		result, err = c.readStream(resp.Body, req)
+	} else {
+		result, err = c.readResponse(resp.Body)
+	}
 	if err != nil {
-		return err
+		return nil, streamError(ctx, err)
+	}
+	if result.Model == "" {
+		result.Model = model
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API error: %s", body)
+	return result, nil
+}
+
+// endpoint returns the URL of an API path under the base URL, keeping any
+// query parameters of the base URL such as Azure's api-version
+func (c *OpenAIClient) endpoint(path string) (string, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid OpenAI base URL %q: %v", c.baseURL, err)
 	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+	return u.String(), nil
+}
 
-	// Parse response
+// readResponse parses a complete chat completion
+func (c *OpenAIClient) readResponse(body io.Reader) (*Response, error) {
 	var openAIResp OpenAIResponse
-	if err := json.Unmarshal(body, &openAIResp); err != nil {
-		return err
+	if err := json.NewDecoder(body).Decode(&openAIResp); err != nil {
+		return nil, err
 	}
 
+	result := &Response{Model: openAIResp.Model}
 	if len(openAIResp.Choices) > 0 {
-		fmt.Println(openAIResp.Choices[0].Message.Content)
+		result.Text = openAIResp.Choices[0].Message.Content
+		result.FinishReason = openAIResp.Choices[0].FinishReason
 	}
+	if openAIResp.Usage != nil {
+		result.Usage = Usage(*openAIResp.Usage)
+	}
+	return result, nil
+}
+
+// readStream parses a streamed chat completion, passing each delta to the
+// request's callback until the [DONE] marker
+func (c *OpenAIClient) readStream(body io.Reader, req Request) (*Response, error) {
+	result := &Response{}
+	var text strings.Builder
 
+	err := readSSE(body, func(data string) error {
+		if data == "[DONE]" {
+			return io.EOF
+		}
+		var chunk OpenAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("API error: %s", chunk.Error.Message)
+		}
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			result.Usage = Usage(*chunk.Usage)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				result.FinishReason = choice.FinishReason
+			}
+			text.WriteString(choice.Delta.Content)
+			if err := req.token(choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	result.Text = text.String()
+	return result, nil
+}
+
+func init() {
+	RegisterProvider(Provider{
+		Name:        storage.ProviderOpenAI,
+		Description: "OpenAI's API, or any server compatible with it",
+		Settings: []Setting{
+			{
+				Name:   "openai_key",
+				Usage:  "<your_api_key>",
+				Secret: true,
+				Get:    func(c *storage.Config) string { return c.OpenAIAPIKey },
+				Set:    func(c *storage.Config, value string) { c.OpenAIAPIKey = value },
+			},
+			{
+				Name:   "openai_base_url",
+				Usage:  "<url|default>",
+				Values: staticValues("default", "http://localhost:8080/v1", "http://localhost:1234/v1"),
+				Parse:  orDefault("default", parseServerURL),
+				Get:    func(c *storage.Config) string { return c.OpenAIBaseURL },
+				Set:    func(c *storage.Config, value string) { c.OpenAIBaseURL = value },
+			},
+			{
+				Name:   "openai_model",
+				Usage:  "<model|default>",
+				Values: staticValues("default"),
+				Parse:  orDefault("default", nil),
+				Get:    func(c *storage.Config) string { return c.OpenAIModel },
+				Set:    func(c *storage.Config, value string) { c.OpenAIModel = value },
+			},
+			{
+				Name:   "openai_organization",
+				Usage:  "<organization|none>",
+				Values: staticValues("none"),
+				Parse:  orDefault("none", nil),
+				Get:    func(c *storage.Config) string { return c.OpenAIOrganization },
+				Set:    func(c *storage.Config, value string) { c.OpenAIOrganization = value },
+			},
+			{
+				Name:  "openai_header",
+				Usage: "<name> [value]  (leave out the value to remove the header)",
+				Parse: parseHeader,
+				Get: func(c *storage.Config) string {
+					names := make([]string, 0, len(c.OpenAIHeaders))
+					for name := range c.OpenAIHeaders {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+					return strings.Join(names, ", ")
+				},
+				Set: func(c *storage.Config, value string) {
+					name, value, _ := strings.Cut(value, " ")
+					c.PutOpenAIHeader(name, value)
+				},
+			},
+		},
+		New: func(c *storage.Config) (Client, error) {
+			apiKey := storage.GetOpenAIKey(c)
+			if apiKey == "" && c.OpenAIBaseURL == "" {
+				return nil, fmt.Errorf("OpenAI API key not set. You need to set it with 'config set openai_key <your_key>'")
+			}
+			return NewOpenAIClient(apiKey, c.OpenAIBaseURL, c.OpenAIModel, c.OpenAIRequestHeaders()), nil
+		},
+		Check: func(ctx context.Context, c *storage.Config) error {
+			apiKey := storage.GetOpenAIKey(c)
+			if apiKey == "" && c.OpenAIBaseURL == "" {
+				return fmt.Errorf("OpenAI API key not set")
+			}
+			client := NewOpenAIClient(apiKey, c.OpenAIBaseURL, c.OpenAIModel, c.OpenAIRequestHeaders())
+			return client.Check(ctx)
+		},
+		Describe: func(c *storage.Config) string {
+			client := NewOpenAIClient("", c.OpenAIBaseURL, c.OpenAIModel, nil)
+			return fmt.Sprintf("OpenAI API at %s with model: %s", client.baseURL, client.model)
+		},
+		Model: func(c *storage.Config) string {
+			return NewOpenAIClient("", c.OpenAIBaseURL, c.OpenAIModel, nil).model
+		},
+		Help: "Set your API key with 'config set openai_key <your_key>', or point budy at a compatible server with 'config set openai_base_url <url>'",
+	})
+}
+
+// Check makes sure the server answers and accepts the API key, by listing
+// its models. Servers that don't list models are taken to be working.
+func (c *OpenAIClient) Check(ctx context.Context) error {
+	endpoint, err := c.endpoint("models")
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	if c.apiKey != "" {
+		headers["Authorization"] = "Bearer " + c.apiKey
+	}
+	for name, value := range c.headers {
+		headers[name] = value
+	}
+
+	status, err := checkHTTP(ctx, endpoint, headers)
+	if err != nil {
+		return fmt.Errorf("could not reach %s: %v", c.baseURL, err)
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return fmt.Errorf("%s rejected the API key (status %d)", c.baseURL, status)
+	}
 	return nil
 }
+
+// parseHeader checks a "name value" header setting
+func parseHeader(value string) (string, error) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(value), " ")
+	if name == "" || strings.ContainsAny(name, ":\t") {
+		return "", fmt.Errorf("invalid header name: %q", name)
+	}
+	return strings.TrimSpace(name + " " + strings.TrimSpace(rest)), nil
+}
@@ -1,23 +1,277 @@
 package ai
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewOpenAIClient(t *testing.T) {
-	client := NewOpenAIClient("test-api-key")
+	client := NewOpenAIClient("test-api-key", "", "", nil)
 
 	if client.apiKey != "test-api-key" {
 		t.Errorf("Expected API key 'test-api-key', got '%s'", client.apiKey)
 	}
+	if client.baseURL != DefaultOpenAIBaseURL || client.model != DefaultOpenAIModel {
+		t.Errorf("Expected the default base URL and model, got %s and %s", client.baseURL, client.model)
+	}
+
+	client = NewOpenAIClient("test-api-key", "http://localhost:8080/v1/", "qwen2.5-coder", nil)
+	if client.baseURL != "http://localhost:8080/v1" || client.model != "qwen2.5-coder" {
+		t.Errorf("Expected the configured base URL and model, got %s and %s", client.baseURL, client.model)
+	}
 }
 
 func TestAskNoAPIKey(t *testing.T) {
-	client := NewOpenAIClient("")
+	client := NewOpenAIClient("", "", "", nil)
 
-	err := client.Ask("test question")
+	_, err := client.Complete(context.Background(), NewRequest("test question"))
 
 	if err == nil {
 		t.Error("Expected error when API key is empty, got nil")
 	}
 }
+
+func TestOpenAIClientStreaming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("Expected /chat/completions endpoint, got %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer test-api-key" {
+			t.Errorf("Expected bearer authorization, got %q", auth)
+		}
+		var req OpenAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if !req.Stream {
+			t.Error("Expected a streaming request")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeChunks(t, w,
+			`data: {"choices":[{"delta":{"role":"assistant"},"finish_reason":null}]}`+"\n\n",
+			`data: {"choices":[{"delta":{"content":"Use "},"finish_reason":null}]}`+"\n\n",
+			": keep-alive\n\n",
+			`data: {"model":"gpt-4o-mini","choices":[{"delta":{"content":"ls"},"finish_reason":null}]}`+"\n\n"+
+				`data: {"choices":[{"delta":{},"finish_reason":"stop"}]}`+"\n\n",
+			`data: {"choices":[],"usage":{"prompt_tokens":20,"completion_tokens":2,"total_tokens":22}}`+"\n\n",
+			"data: [DONE]\n\n",
+		)
+	}))
+	defer server.Close()
+
+	out := newTokenRecorder()
+	client := NewOpenAIClient("test-api-key", server.URL, "", nil)
+	req := NewRequest("How do I list files?")
+	req.OnToken = out.Token
+
+	resp, err := client.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if out.String() != "Use ls" {
+		t.Errorf("Expected tokens %q, got %q", "Use ls", out.String())
+	}
+
+	expected := Response{
+		Text:         "Use ls",
+		Model:        "gpt-4o-mini",
+		FinishReason: "stop",
+		Usage:        Usage{PromptTokens: 20, CompletionTokens: 2, TotalTokens: 22},
+	}
+	if *resp != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *resp)
+	}
+}
+
+func TestOpenAIClientComplete(t *testing.T) {
+	var got OpenAIRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"model":"gpt-4o","choices":[{"message":{"role":"assistant","content":"Use ls"},"finish_reason":"stop"}],"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}`))
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient("test-api-key", server.URL, "", nil)
+	resp, err := client.Complete(context.Background(), Request{
+		System:      "Be brief",
+		Messages:    []Message{{Role: RoleUser, Content: "How do I list files?"}},
+		Model:       "gpt-4o",
+		Temperature: Temperature(0.2),
+		JSON:        true,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got.Model != "gpt-4o" || got.Stream || got.Temperature == nil || *got.Temperature != 0.2 {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+	if got.ResponseFormat == nil || got.ResponseFormat.Type != "json_object" {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+	if len(got.Messages) != 2 || got.Messages[0].Role != RoleSystem || got.Messages[0].Content != "Be brief" {
+		t.Errorf("Expected the system prompt first, got %+v", got.Messages)
+	}
+
+	expected := Response{
+		Text:         "Use ls",
+		Model:        "gpt-4o",
+		FinishReason: "stop",
+		Usage:        Usage{PromptTokens: 9, CompletionTokens: 2, TotalTokens: 11},
+	}
+	if *resp != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *resp)
+	}
+}
+
+func TestOpenAIClientStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"message":"Incorrect API key provided"}}`))
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient("bad-key", server.URL, "", nil)
+
+	_, err := client.Complete(context.Background(), NewRequest("test"))
+	if err == nil || !strings.Contains(err.Error(), "Incorrect API key") {
+		t.Errorf("Expected an API error, got %v", err)
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized || statusErr.Temporary() {
+		t.Errorf("Expected a permanent status error, got %#v", err)
+	}
+}
+
+func TestOpenAIClientRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":{"message":"Rate limit reached"}}`))
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient("test-api-key", server.URL, "", nil)
+
+	_, err := client.Complete(context.Background(), NewRequest("test"))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || !statusErr.Temporary() || statusErr.RetryAfter != 2*time.Second {
+		t.Errorf("Expected a temporary status error asking to wait 2s, got %#v", err)
+	}
+}
+
+func TestOpenAIClientCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeChunks(t, w, `data: {"choices":[{"delta":{"content":"Thinking"}}]}`+"\n\n")
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	out := newTokenRecorder()
+	client := NewOpenAIClient("test-api-key", server.URL, "", nil)
+	req := NewRequest("test")
+	req.OnToken = out.Token
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Complete(ctx, req)
+		done <- err
+	}()
+
+	<-out.first
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Complete did not return after cancellation")
+	}
+	if out.String() != "Thinking" {
+		t.Errorf("Expected the partial answer, got %q", out.String())
+	}
+}
+
+func TestOpenAIClientEndpointSettings(t *testing.T) {
+	var got OpenAIRequest
+	var gotURL string
+	var gotHeader http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		gotHeader = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	// An Azure-style gateway: a deployment path, a query parameter and an
+	// api-key header instead of a bearer token
+	client := NewOpenAIClient("", server.URL+"/openai/deployments/gpt4?api-version=2024-06-01", "gpt-4o", map[string]string{
+		"api-key":             "azure-secret",
+		"OpenAI-Organization": "org-123",
+	})
+	resp, err := client.Complete(context.Background(), NewRequest("test"))
+	if err != nil {
+		t.Fatalf("Expected no error without an API key for a custom server, got %v", err)
+	}
+
+	if gotURL != "/openai/deployments/gpt4/chat/completions?api-version=2024-06-01" {
+		t.Errorf("Unexpected request URL: %s", gotURL)
+	}
+	if got.Model != "gpt-4o" || resp.Model != "gpt-4o" {
+		t.Errorf("Expected the configured model, got %q and %q", got.Model, resp.Model)
+	}
+	if gotHeader.Get("Api-Key") != "azure-secret" || gotHeader.Get("OpenAI-Organization") != "org-123" {
+		t.Errorf("Expected the extra headers, got %v", gotHeader)
+	}
+	if auth := gotHeader.Get("Authorization"); auth != "" {
+		t.Errorf("Expected no authorization without an API key, got %q", auth)
+	}
+
+	// The request's model overrides the configured one
+	req := NewRequest("test")
+	req.Model = "gpt-4o-mini"
+	if _, err := client.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got.Model != "gpt-4o-mini" {
+		t.Errorf("Expected the request's model, got %q", got.Model)
+	}
+}
+
+func TestOpenAIClientHeadersOverride(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient("test-api-key", server.URL, "", map[string]string{"Authorization": "Token gateway"})
+	if _, err := client.Complete(context.Background(), NewRequest("test")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if auth != "Token gateway" {
+		t.Errorf("Expected the configured header to replace the bearer token, got %q", auth)
+	}
+}
@@ -0,0 +1,252 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// DefaultProvider is the provider used when the configured one is unknown
+// or can't be set up
+const DefaultProvider = storage.ProviderOllama
+
+// Provider describes an AI backend. Each backend registers itself with
+// RegisterProvider, and is then selected with "config set ai_provider".
+type Provider struct {
+	// Name is the value of the ai_provider setting that selects the
+	// provider
+	Name string
+
+	// Description says what the provider is, for listings
+	Description string
+
+	// Settings are the config options the provider reads
+	Settings []Setting
+
+	// New creates a client from the configuration. It fails when a
+	// required setting, such as an API key, is missing.
+	New func(config *storage.Config) (Client, error)
+
+	// Check reports whether the provider is ready to answer, for example
+	// that its server can be reached. It should return quickly.
+	Check func(ctx context.Context, config *storage.Config) error
+
+	// Describe says which server and model the provider will use
+	Describe func(config *storage.Config) string
+
+	// Model names the model the provider will answer with
+	Model func(config *storage.Config) string
+
+	// Help explains how to set the provider up, shown when it can't be
+	// reached. It may be empty.
+	Help string
+}
+
+// Setting describes a config option of a provider
+type Setting struct {
+	// Name is the option name used with "config set"
+	Name string
+
+	// Usage describes the value, as in "config set <name> <usage>"
+	Usage string
+
+	// Secret settings are masked when displayed, read without echo, and
+	// saved in the secrets file rather than config.json
+	Secret bool
+
+	// Values suggests values for Tab completion and may be nil
+	Values func(config *storage.Config) []string
+
+	// Parse checks a value typed by the user and returns the value to
+	// store. It may be nil to accept any value.
+	Parse func(value string) (string, error)
+
+	// Validate, if set, checks a parsed value against the rest of the
+	// configuration, such as a model against the ones installed
+	Validate func(config *storage.Config, value string) error
+
+	// Get returns the current value, or an empty string when unset, and
+	// Set stores a value parsed by Parse. When they are nil the value is
+	// kept in the config's Settings under the option name.
+	Get func(config *storage.Config) string
+	Set func(config *storage.Config, value string)
+}
+
+// providers holds the registered providers by name
+var providers = make(map[string]*Provider)
+
+// RegisterProvider makes a provider available. Registering a provider
+// with the name of an existing one replaces it.
+func RegisterProvider(provider Provider) {
+	for _, setting := range provider.Settings {
+		if setting.Secret && setting.Set == nil {
+			storage.RegisterSecretSetting(setting.Name)
+		}
+	}
+	providers[provider.Name] = &provider
+}
+
+// LookupProvider returns the provider registered under name
+func LookupProvider(name string) (*Provider, bool) {
+	provider, ok := providers[name]
+	return provider, ok
+}
+
+// Providers returns the registered providers sorted by name
+func Providers() []*Provider {
+	list := make([]*Provider, 0, len(providers))
+	for _, provider := range providers {
+		list = append(list, provider)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// ProviderNames returns the names of the registered providers, sorted
+func ProviderNames() []string {
+	names := make([]string, 0, len(providers))
+	for _, provider := range Providers() {
+		names = append(names, provider.Name)
+	}
+	return names
+}
+
+// FindSetting returns a provider option by name along with its provider
+func FindSetting(name string) (*Provider, *Setting, bool) {
+	for _, provider := range Providers() {
+		for i := range provider.Settings {
+			if provider.Settings[i].Name == name {
+				return provider, &provider.Settings[i], true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// NewClient creates a client for the configured provider
+func NewClient(config *storage.Config) (Client, error) {
+	provider, ok := LookupProvider(config.AIProvider)
+	if !ok {
+		return nil, fmt.Errorf("unknown AI provider %q (available: %s)", config.AIProvider, strings.Join(ProviderNames(), ", "))
+	}
+	return provider.New(config)
+}
+
+// Value returns the setting's current value, or an empty string when unset
+func (s *Setting) Value(config *storage.Config) string {
+	if s.Get == nil {
+		return config.Setting(s.Name)
+	}
+	return s.Get(config)
+}
+
+// Normalize checks a value typed by the user and returns the value to
+// store
+func (s *Setting) Normalize(config *storage.Config, value string) (string, error) {
+	if s.Parse != nil {
+		var err error
+		if value, err = s.Parse(value); err != nil {
+			return "", err
+		}
+	}
+	if s.Validate != nil {
+		if err := s.Validate(config, value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// Store saves a normalized value in config
+func (s *Setting) Store(config *storage.Config, value string) {
+	if s.Set == nil {
+		config.SetSetting(s.Name, value)
+		return
+	}
+	s.Set(config, value)
+}
+
+// Display returns a setting's current value for showing to the user, with
+// secrets masked
+func (s *Setting) Display(config *storage.Config) string {
+	value := s.Value(config)
+	if value == "" {
+		return "(not set)"
+	}
+	if s.Secret {
+		return MaskSecret(value)
+	}
+	return value
+}
+
+// MaskSecret hides all but the last few characters of a secret
+func MaskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
+
+// parseServerURL checks that value is an http or https URL
+func parseServerURL(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid URL: %s. Use an http or https URL such as http://localhost:8080", value)
+	}
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// orDefault returns a parser that stores an empty value for the word
+// given, such as "default" or "none", and passes others through next
+func orDefault(word string, next func(string) (string, error)) func(string) (string, error) {
+	return func(value string) (string, error) {
+		if value == word {
+			return "", nil
+		}
+		if next == nil {
+			return value, nil
+		}
+		return next(value)
+	}
+}
+
+// checkHTTP makes a GET request to target and fails unless it gets an answer
+// below status 500. headers are added to the request.
+func checkHTTP(ctx context.Context, target string, headers map[string]string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 500 {
+		return resp.StatusCode, fmt.Errorf("server error (status %d)", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// staticValues returns a Values function suggesting a fixed list
+func staticValues(values ...string) func(*storage.Config) []string {
+	return func(*storage.Config) []string {
+		return values
+	}
+}
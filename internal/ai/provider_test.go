@@ -0,0 +1,144 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+func TestProviders(t *testing.T) {
+	expected := []string{ProviderAnthropic, ProviderExec, storage.ProviderOllama, storage.ProviderOpenAI}
+	if names := ProviderNames(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected providers %v, got %v", expected, names)
+	}
+
+	for _, provider := range Providers() {
+		if provider.New == nil || provider.Check == nil || provider.Describe == nil || provider.Model == nil {
+			t.Errorf("Expected %s to be complete, got %+v", provider.Name, provider)
+		}
+	}
+
+	if _, ok := LookupProvider("nonexistent"); ok {
+		t.Error("Expected an unknown provider not to be found")
+	}
+	if _, err := NewClient(&storage.Config{AIProvider: "nonexistent"}); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("ANTHROPIC_API_KEY", "")
+
+	tests := []struct {
+		config  storage.Config
+		wantErr bool
+	}{
+		{storage.Config{AIProvider: storage.ProviderOllama}, false},
+		{storage.Config{AIProvider: storage.ProviderOpenAI}, true},
+		{storage.Config{AIProvider: storage.ProviderOpenAI, OpenAIAPIKey: "sk-test"}, false},
+		{storage.Config{AIProvider: storage.ProviderOpenAI, OpenAIBaseURL: "http://localhost:8080/v1"}, false},
+		{storage.Config{AIProvider: ProviderAnthropic}, true},
+		{storage.Config{AIProvider: ProviderAnthropic, Settings: map[string]string{"anthropic_key": "sk-ant"}}, false},
+		{storage.Config{AIProvider: ProviderExec}, true},
+		{storage.Config{AIProvider: ProviderExec, Settings: map[string]string{"exec_command": "cat"}}, false},
+	}
+
+	for _, test := range tests {
+		client, err := NewClient(&test.config)
+		if (err != nil) != test.wantErr {
+			t.Errorf("NewClient(%+v): expected error %v, got %v", test.config, test.wantErr, err)
+		}
+		if err == nil && client == nil {
+			t.Errorf("NewClient(%+v): expected a client", test.config)
+		}
+	}
+}
+
+func TestFindSetting(t *testing.T) {
+	provider, setting, ok := FindSetting("anthropic_model")
+	if !ok || provider.Name != ProviderAnthropic || setting.Name != "anthropic_model" {
+		t.Fatalf("Expected the anthropic_model setting, got %v %v %v", provider, setting, ok)
+	}
+	if _, _, ok := FindSetting("shell"); ok {
+		t.Error("Expected shell not to be a provider setting")
+	}
+
+	config := &storage.Config{}
+	value, err := setting.Normalize(config, "claude-3-5-sonnet-latest")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	setting.Store(config, value)
+	if got := config.Setting("anthropic_model"); got != "claude-3-5-sonnet-latest" {
+		t.Errorf("Expected the model to be stored in Settings, got %q", got)
+	}
+
+	// "default" clears the setting
+	value, _ = setting.Normalize(config, "default")
+	setting.Store(config, value)
+	if config.Settings != nil {
+		t.Errorf("Expected no settings left, got %v", config.Settings)
+	}
+
+	// Settings with a field of their own use it
+	_, setting, _ = FindSetting("ollama_url")
+	if _, err := setting.Normalize(config, "localhost:11434"); err == nil {
+		t.Error("Expected an error for a URL without a scheme")
+	}
+	value, _ = setting.Normalize(config, "http://localhost:11434/")
+	setting.Store(config, value)
+	if config.OllamaURL != "http://localhost:11434" || setting.Value(config) != "http://localhost:11434" {
+		t.Errorf("Expected the Ollama URL to be set, got %q", config.OllamaURL)
+	}
+
+	_, setting, _ = FindSetting("openai_header")
+	value, _ = setting.Normalize(config, "api-key  secret value")
+	setting.Store(config, value)
+	if config.OpenAIHeaders["Api-Key"] != "secret value" {
+		t.Errorf("Expected the header to be set, got %v", config.OpenAIHeaders)
+	}
+}
+
+func TestSettingDisplay(t *testing.T) {
+	config := &storage.Config{OpenAIAPIKey: "sk-1234567890abcdef"}
+
+	_, setting, _ := FindSetting("openai_key")
+	if got := setting.Display(config); got != "********cdef" {
+		t.Errorf("Expected a masked key, got %q", got)
+	}
+	_, setting, _ = FindSetting("openai_model")
+	if got := setting.Display(config); got != "(not set)" {
+		t.Errorf("Expected an unset model, got %q", got)
+	}
+
+	if got := MaskSecret("short"); got != "*****" {
+		t.Errorf("Expected a short secret to be masked entirely, got %q", got)
+	}
+}
+
+func TestCheckProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"models":[]}`))
+	}))
+	defer server.Close()
+
+	provider, _ := LookupProvider(storage.ProviderOllama)
+	if err := provider.Check(context.Background(), &storage.Config{OllamaURL: server.URL}); err != nil {
+		t.Errorf("Expected Ollama to be reachable, got %v", err)
+	}
+
+	url := server.URL
+	server.Close()
+	if err := provider.Check(context.Background(), &storage.Config{OllamaURL: url}); err == nil {
+		t.Error("Expected an error for a server that is down")
+	}
+}
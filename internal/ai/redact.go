@@ -0,0 +1,31 @@
+package ai
+
+import "context"
+
+// Ensure RedactedClient implements the Client interface
+var _ Client = (*RedactedClient)(nil)
+
+// RedactedClient masks secrets in the system prompt and messages of each
+// request before passing it to its client, so that commands, history and
+// questions that contain keys or passwords never leave the machine
+type RedactedClient struct {
+	client Client
+	redact func(string) string
+}
+
+// NewRedactedClient creates a client that masks requests to client with
+// redact
+func NewRedactedClient(client Client, redact func(string) string) *RedactedClient {
+	return &RedactedClient{client: client, redact: redact}
+}
+
+// Complete masks the request and sends it to the client
+func (c *RedactedClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	req.System = c.redact(req.System)
+	messages := make([]Message, len(req.Messages))
+	for i, message := range req.Messages {
+		messages[i] = Message{Role: message.Role, Content: c.redact(message.Content)}
+	}
+	req.Messages = messages
+	return c.client.Complete(ctx, req)
+}
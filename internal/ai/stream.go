@@ -0,0 +1,77 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+)
+
+// maxStreamLine is the longest line accepted in a streamed response
+const maxStreamLine = 1024 * 1024
+
+// readNDJSON calls fn with each non-empty line of a newline-delimited JSON
+// stream, as sent by Ollama
+func readNDJSON(r io.Reader, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readSSE calls fn with the data of each event in a server-sent events
+// stream, as sent by OpenAI. Data split over several lines is joined with
+// newlines, and comment lines are ignored.
+func readSSE(r io.Reader, fn func(data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		event := strings.Join(data, "\n")
+		data = data[:0]
+		return fn(event)
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			// A blank line ends the event
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment, often used as a keep-alive
+		case line == "data" || strings.HasPrefix(line, "data:"):
+			value := strings.TrimPrefix(line, "data")
+			value = strings.TrimPrefix(value, ":")
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return dispatch()
+}
+
+// streamError returns the context's error when it was cancelled, since
+// reads interrupted by cancellation report less useful errors
+func streamError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
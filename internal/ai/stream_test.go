@@ -0,0 +1,95 @@
+package ai
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tokenRecorder collects streamed tokens and signals when the first one
+// arrives
+type tokenRecorder struct {
+	mu    sync.Mutex
+	buf   strings.Builder
+	first chan struct{}
+	once  sync.Once
+}
+
+func newTokenRecorder() *tokenRecorder {
+	return &tokenRecorder{first: make(chan struct{})}
+}
+
+// Token records a token; it is used as Request.OnToken
+func (r *tokenRecorder) Token(token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.once.Do(func() { close(r.first) })
+	r.buf.WriteString(token)
+	return nil
+}
+
+func (r *tokenRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.String()
+}
+
+// writeChunks writes each chunk to a test server response and flushes it,
+// so the client receives a chunked response piece by piece
+func writeChunks(t *testing.T, w http.ResponseWriter, chunks ...string) {
+	t.Helper()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("Response writer does not support flushing")
+	}
+	for _, chunk := range chunks {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Errorf("Failed to write chunk: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// TestReadSSE tests parsing server-sent events
+func TestReadSSE(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"data: one\n\n" +
+		"data:two\r\n\r\n" +
+		"event: message\ndata: three\ndata: lines\n\n" +
+		"id: 4\n\n" +
+		"data: last"
+
+	var events []string
+	err := readSSE(strings.NewReader(stream), func(data string) error {
+		events = append(events, data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{"one", "two", "three\nlines", "last"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %q, got %q", expected, events)
+	}
+}
+
+// TestReadNDJSON tests parsing newline-delimited JSON
+func TestReadNDJSON(t *testing.T) {
+	var lines []string
+	err := readNDJSON(strings.NewReader("{\"a\":1}\n\n  {\"b\":2}\r\n{\"c\":3}"), func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+}
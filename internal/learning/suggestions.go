@@ -57,6 +57,9 @@ func (s *SuggestionEngine) getTimeSuggestions() []string {
 
 	// Look for commands frequently used at this hour
 	for _, entry := range history {
+		if failed(entry) {
+			continue
+		}
 		if entry.Timestamp.Hour() == currentHour {
 			hourlyCommands[entry.Command]++
 		}
@@ -85,6 +88,9 @@ func (s *SuggestionEngine) getDirectorySuggestions() []string {
 	// Count command occurrences
 	commandCounts := make(map[string]int)
 	for _, entry := range dirCommands {
+		if failed(entry) {
+			continue
+		}
 		commandCounts[entry.Command]++
 	}
 
@@ -97,3 +103,9 @@ func (s *SuggestionEngine) getDirectorySuggestions() []string {
 
 	return suggestions
 }
+
+// failed reports whether a history entry is known to have failed, so it
+// is not suggested again
+func failed(entry shell.CommandEntry) bool {
+	return entry.Result != nil && !entry.Result.Success()
+}
@@ -32,6 +32,11 @@ func (m *MockHistoryManager) RecordCommand(command string) error {
 	return nil
 }
 
+func (m *MockHistoryManager) RecordEntry(entry shell.CommandEntry) error {
+	m.commands = append(m.commands, entry)
+	return nil
+}
+
 func (m *MockHistoryManager) GetHistory() []shell.CommandEntry {
 	return m.commands
 }
@@ -122,6 +127,24 @@ func TestSuggestionEngine(t *testing.T) {
 		t.Errorf("Expected to find directory command suggestion, but didn't find it in: %v", suggestions)
 	}
 
+	// Failed commands are not suggested
+	failedHistory := NewMockHistoryManager()
+	for i := 0; i < 3; i++ {
+		failedHistory.commands = append(failedHistory.commands, shell.CommandEntry{
+			Command:   "broken-cmd",
+			Timestamp: now,
+			Result:    &shell.ExecResult{ExitCode: 1},
+		})
+		failedHistory.dirCommands = append(failedHistory.dirCommands, shell.CommandEntry{
+			Command:   "broken-cmd",
+			Timestamp: now,
+			Result:    &shell.ExecResult{ExitCode: 127},
+		})
+	}
+	if failedSuggestions := NewSuggestionEngine(failedHistory).GetSuggestions(); len(failedSuggestions) != 0 {
+		t.Errorf("Expected no suggestions for failed commands, got %v", failedSuggestions)
+	}
+
 	// Test empty history
 	emptyHistory := NewMockHistoryManager()
 	emptyEngine := NewSuggestionEngine(emptyHistory)
@@ -0,0 +1,304 @@
+// Package redact finds secrets such as API keys, passwords and tokens in
+// text and masks them, so they aren't written to history or sent to an AI
+// provider.
+package redact
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces each secret that is found
+const Mask = "[REDACTED]"
+
+// Finding is a secret found in text, at text[Start:End]
+type Finding struct {
+	Detector string
+	Start    int
+	End      int
+}
+
+// detector finds one kind of secret. The secret is the submatch numbered
+// group, so that a key name or URL around it can be kept. check, if set,
+// rejects matches that only look like secrets.
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	group   int
+	check   func(match []string) bool
+}
+
+// secretName matches names of settings and variables that hold secrets
+const secretName = `(?:password|passwd|passphrase|secrets?|tokens?|api[_-]?key|access[_-]?key|private[_-]?key|credentials?|auth[_-]?key)`
+
+// value matches a quoted or bare value, stopping at shell operators
+const value = `("[^"]*"|'[^']*'|[^\s"'&;|<>]+)`
+
+// builtin are the detectors that always run
+var builtin = []detector{
+	{
+		name:    "private key",
+		pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----(?s:.*?)(?:-----END [A-Z ]*PRIVATE KEY-----|$)`),
+	},
+	{name: "AWS access key", pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "GitHub token", pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{name: "GitLab token", pattern: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{name: "API key", pattern: regexp.MustCompile(`\bsk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}`)},
+	{name: "Slack token", pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{name: "Google API key", pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{name: "Stripe key", pattern: regexp.MustCompile(`\b[rs]k_(?:live|test)_[0-9A-Za-z]{16,}`)},
+	{name: "JWT", pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{
+		name:    "authorization header",
+		pattern: regexp.MustCompile(`(?i)\b(?:proxy-)?authorization\s*:\s*(?:(?:bearer|basic|token|digest)\s+)?([^\s"']+)`),
+		group:   1,
+		check:   func(match []string) bool { return !strings.HasPrefix(match[1], "$") },
+	},
+	{name: "bearer token", pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]{8,}=*)`), group: 1},
+	{
+		name:    "user password",
+		pattern: regexp.MustCompile(`(?:^|\s)(?:-u|--user)[\s=]+["']?[^\s:"']+:([^\s"']+)`),
+		group:   1,
+	},
+	{name: "URL credentials", pattern: regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]*:([^@\s/]+)@`), group: 1},
+	{
+		name:    "secret assignment",
+		pattern: regexp.MustCompile(`(?i)([A-Za-z0-9_.-]*` + secretName + `(?:[_.-][A-Za-z0-9_.-]*)?)["']?\s*[=:]\s*` + value),
+		group:   2,
+		check:   isSecretValue,
+	},
+	{
+		name:    "secret flag",
+		pattern: regexp.MustCompile(`(?i)(?:^|\s)--?((?:[a-z0-9]+-)*` + secretName + `(?:-[a-z0-9]+)*)\s+` + value),
+		group:   2,
+		check:   isSecretValue,
+	},
+	{name: "high-entropy string", pattern: regexp.MustCompile(`[A-Za-z0-9+/_-]{20,}={0,2}`), check: isHighEntropy},
+}
+
+// notSecret are name endings that describe a secret without holding one,
+// as in --token-file or PASSWORD_LENGTH
+var notSecret = []string{"file", "path", "dir", "type", "name", "url", "env", "length", "len", "size", "count", "id", "required", "prompt"}
+
+// isSecretValue checks a name and value match, rejecting names that only
+// refer to a secret and values that are empty, variable references or
+// booleans
+func isSecretValue(match []string) bool {
+	name := strings.ToLower(match[1])
+	for _, suffix := range notSecret {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+
+	v := strings.Trim(match[2], `"'`)
+	switch strings.ToLower(v) {
+	case "", "true", "false", "yes", "no", "null", "none":
+		return false
+	}
+	return !strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "<") && v != Mask
+}
+
+// isHighEntropy reports whether a run of token characters looks random
+// enough to be a key. Paths, hex digests such as commit hashes, and words
+// joined into identifiers are left alone.
+func isHighEntropy(match []string) bool {
+	s := match[0]
+	if strings.HasPrefix(s, "/") || strings.Contains(s, "//") {
+		return false
+	}
+
+	var upper, lower, digits bool
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digits = true
+		}
+	}
+	if !upper || !lower || !digits {
+		return false
+	}
+	return entropy(s) >= 4.2
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	var bits float64
+	n := float64(len(s))
+	for _, count := range counts {
+		p := float64(count) / n
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// Redactor masks secrets found by the built-in detectors and by patterns
+// the user adds. It is safe for concurrent use.
+type Redactor struct {
+	mu     sync.RWMutex
+	custom []*regexp.Regexp
+}
+
+// New creates a redactor that also masks matches of the given regular
+// expressions. When a pattern has a group, only the first group's match
+// is masked.
+func New(patterns []string) (*Redactor, error) {
+	r := &Redactor{}
+	if err := r.SetPatterns(patterns); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Default creates a redactor with only the built-in detectors
+func Default() *Redactor {
+	return &Redactor{}
+}
+
+// Compile checks a user pattern
+func Compile(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("pattern %q matches empty text", pattern)
+	}
+	return re, nil
+}
+
+// SetPatterns replaces the user patterns
+func (r *Redactor) SetPatterns(patterns []string) error {
+	custom := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := Compile(pattern)
+		if err != nil {
+			return err
+		}
+		custom = append(custom, re)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.custom = custom
+	return nil
+}
+
+// Detectors returns the names of the built-in detectors
+func Detectors() []string {
+	names := make([]string, 0, len(builtin))
+	for _, d := range builtin {
+		names = append(names, d.name)
+	}
+	return names
+}
+
+// Find returns the secrets in text, in order, with overlapping findings
+// merged
+func (r *Redactor) Find(text string) []Finding {
+	var findings []Finding
+	add := func(name string, start, end int) {
+		// Keep the quotes around a quoted value
+		if end-start >= 2 && (text[start] == '"' || text[start] == '\'') && text[end-1] == text[start] {
+			start, end = start+1, end-1
+		}
+		if start < end {
+			findings = append(findings, Finding{Detector: name, Start: start, End: end})
+		}
+	}
+
+	for _, d := range builtin {
+		for _, loc := range d.pattern.FindAllStringSubmatchIndex(text, -1) {
+			if d.check != nil && !d.check(submatches(text, loc)) {
+				continue
+			}
+			if start := loc[2*d.group]; start >= 0 {
+				add(d.name, start, loc[2*d.group+1])
+			}
+		}
+	}
+
+	r.mu.RLock()
+	for _, re := range r.custom {
+		group := 0
+		if re.NumSubexp() > 0 {
+			group = 1
+		}
+		for _, loc := range re.FindAllStringSubmatchIndex(text, -1) {
+			if start := loc[2*group]; start >= 0 {
+				add("custom pattern", start, loc[2*group+1])
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	return merge(findings)
+}
+
+// submatches returns the text of each submatch of a match
+func submatches(text string, loc []int) []string {
+	matches := make([]string, len(loc)/2)
+	for i := range matches {
+		if loc[2*i] >= 0 {
+			matches[i] = text[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return matches
+}
+
+// merge sorts findings and joins those that overlap, keeping the name of
+// the first
+func merge(findings []Finding) []Finding {
+	if len(findings) == 0 {
+		return nil
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Start != findings[j].Start {
+			return findings[i].Start < findings[j].Start
+		}
+		return findings[i].End > findings[j].End
+	})
+
+	merged := []Finding{findings[0]}
+	for _, f := range findings[1:] {
+		last := &merged[len(merged)-1]
+		if f.Start < last.End {
+			if f.End > last.End {
+				last.End = f.End
+			}
+			continue
+		}
+		merged = append(merged, f)
+	}
+	return merged
+}
+
+// Redact returns text with every secret replaced by Mask
+func (r *Redactor) Redact(text string) string {
+	findings := r.Find(text)
+	if len(findings) == 0 {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		b.WriteString(text[last:f.Start])
+		b.WriteString(Mask)
+		last = f.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
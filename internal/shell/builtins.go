@@ -0,0 +1,389 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BuiltinFunc implements a builtin command. It receives the executor that
+// dispatched it and the command arguments, excluding the command name.
+type BuiltinFunc func(e *ShellExecutor, args []string) error
+
+// Builtin describes a command handled inside the budy session instead of
+// being passed to the shell
+type Builtin struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         BuiltinFunc
+}
+
+// errNotBuiltin is returned by a builtin that leaves the command to the
+// shell, such as kill given process IDs rather than jobs
+var errNotBuiltin = errors.New("not handled by the builtin")
+
+// defaultBuiltins returns the builtins every executor starts with
+func defaultBuiltins() []Builtin {
+	return []Builtin{
+		{"cd", "cd [dir|-]", "Change the working directory", builtinCd},
+		{"pwd", "pwd", "Print the working directory", builtinPwd},
+		{"export", "export [name[=value] ...]", "Set or list session environment variables", builtinExport},
+		{"unset", "unset name ...", "Remove session environment variables", builtinUnset},
+		{"alias", "alias [name[=value] ...]", "Define or list aliases", builtinAlias},
+		{"unalias", "unalias name ...", "Remove aliases", builtinUnalias},
+		{"history", "history [n]", "Show command history", builtinHistory},
+		{"help", "help", "Show builtin commands", builtinHelp},
+		{"clear", "clear", "Clear the screen", builtinClear},
+		{"jobs", "jobs [-l]", "List background and stopped jobs", builtinJobs},
+		{"fg", "fg [%n]", "Bring a job to the foreground", builtinFg},
+		{"bg", "bg [%n]", "Continue a stopped job in the background", builtinBg},
+		{"kill", "kill [-signal] %n ...", "Send a signal to jobs", builtinKill},
+		{"wait", "wait [%n ...]", "Wait for jobs to finish", builtinWait},
+	}
+}
+
+// RegisterBuiltin adds or replaces a builtin command
+func (e *ShellExecutor) RegisterBuiltin(builtin Builtin) {
+	e.builtins[builtin.Name] = builtin
+}
+
+// Builtins returns the names of all registered builtins, sorted
+func (e *ShellExecutor) Builtins() []string {
+	names := make([]string, 0, len(e.builtins))
+	for name := range e.builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsBuiltin reports whether name is a registered builtin
+func (e *ShellExecutor) IsBuiltin(name string) bool {
+	_, ok := e.builtins[name]
+	return ok
+}
+
+// builtinCd changes the session directory
+func builtinCd(e *ShellExecutor, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("cd: too many arguments")
+	}
+
+	target := "~"
+	if len(args) == 1 {
+		target = args[0]
+	}
+
+	if target == "-" {
+		if e.session.PrevDir() == "" {
+			return fmt.Errorf("cd: OLDPWD not set")
+		}
+		target = e.session.PrevDir()
+		if err := e.session.Chdir(target); err != nil {
+			return fmt.Errorf("cd: %v", err)
+		}
+		_, err := fmt.Fprintln(e.output(), e.session.Dir())
+		return err
+	}
+
+	if err := e.session.Chdir(target); err != nil {
+		return fmt.Errorf("cd: %v", err)
+	}
+	return nil
+}
+
+// builtinPwd prints the session directory
+func builtinPwd(e *ShellExecutor, args []string) error {
+	_, err := fmt.Fprintln(e.output(), e.session.Dir())
+	return err
+}
+
+// builtinExport sets session environment variables or lists them
+func builtinExport(e *ShellExecutor, args []string) error {
+	if len(args) == 0 {
+		for _, kv := range e.session.Environ() {
+			name, value, _ := strings.Cut(kv, "=")
+			if _, err := fmt.Fprintf(e.output(), "export %s=%s\n", name, strconv.Quote(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !isValidName(name) {
+			return fmt.Errorf("export: not a valid identifier: %s", name)
+		}
+		if hasValue {
+			e.session.Setenv(name, value)
+		} else if _, ok := e.session.LookupEnv(name); !ok {
+			e.session.Setenv(name, "")
+		}
+	}
+	return nil
+}
+
+// builtinUnset removes session environment variables
+func builtinUnset(e *ShellExecutor, args []string) error {
+	for _, name := range args {
+		if !isValidName(name) {
+			return fmt.Errorf("unset: not a valid identifier: %s", name)
+		}
+		e.session.Unsetenv(name)
+	}
+	return nil
+}
+
+// builtinAlias defines aliases or lists them
+func builtinAlias(e *ShellExecutor, args []string) error {
+	if len(args) == 0 {
+		for _, name := range e.session.Aliases() {
+			value, _ := e.session.Alias(name)
+			if _, err := fmt.Fprintf(e.output(), "alias %s=%s\n", name, shellQuote(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name == "" || strings.ContainsAny(name, " \t'\"$/`") {
+			return fmt.Errorf("alias: invalid alias name: %s", name)
+		}
+		if hasValue {
+			e.session.SetAlias(name, value)
+			continue
+		}
+
+		value, ok := e.session.Alias(name)
+		if !ok {
+			return fmt.Errorf("alias: %s: not found", name)
+		}
+		if _, err := fmt.Fprintf(e.output(), "alias %s=%s\n", name, shellQuote(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// builtinUnalias removes aliases
+func builtinUnalias(e *ShellExecutor, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unalias: usage: unalias name ...")
+	}
+	for _, name := range args {
+		if !e.session.RemoveAlias(name) {
+			return fmt.Errorf("unalias: %s: not found", name)
+		}
+	}
+	return nil
+}
+
+// builtinHistory prints the command history with entry numbers. Commands
+// generated by the AI end with a "# ai" comment.
+func builtinHistory(e *ShellExecutor, args []string) error {
+	if e.history == nil {
+		return fmt.Errorf("history: not available")
+	}
+
+	entries := e.history.GetHistory()
+	start := 0
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fmt.Errorf("history: numeric argument required: %s", args[0])
+		}
+		if n < len(entries) {
+			start = len(entries) - n
+		}
+	}
+
+	for i := start; i < len(entries); i++ {
+		line := entries[i].Command
+		if entries[i].Source == SourceAI {
+			line += "  # ai"
+		}
+		if _, err := fmt.Fprintf(e.output(), "%5d  %s\n", i+1, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// builtinHelp lists the builtin commands
+func builtinHelp(e *ShellExecutor, args []string) error {
+	if _, err := fmt.Fprintln(e.output(), "Builtin commands:"); err != nil {
+		return err
+	}
+	for _, name := range e.Builtins() {
+		builtin := e.builtins[name]
+		if _, err := fmt.Fprintf(e.output(), "  %-28s %s\n", builtin.Usage, builtin.Description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// builtinClear clears the terminal screen
+func builtinClear(e *ShellExecutor, args []string) error {
+	_, err := fmt.Fprint(e.output(), "\033[H\033[2J")
+	return err
+}
+
+// builtinJobs lists the jobs, marking the current one with +. With -l the
+// process ID and output log of each are shown too.
+func builtinJobs(e *ShellExecutor, args []string) error {
+	long := false
+	for _, arg := range args {
+		if arg != "-l" {
+			return fmt.Errorf("jobs: usage: jobs [-l]")
+		}
+		long = true
+	}
+
+	current := e.jobs.current()
+	for _, job := range e.jobs.list() {
+		marker := byte(' ')
+		if job.ID == current {
+			marker = '+'
+		}
+		line := job.format(marker)
+		if long {
+			line = fmt.Sprintf("%s  (pid %d)", line, job.pid)
+			if job.Log != "" {
+				line += "\n      output in " + job.Log
+			}
+		}
+		if _, err := fmt.Fprintln(e.output(), line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// builtinFg continues a job in the foreground and waits until it exits or
+// is stopped again
+func builtinFg(e *ShellExecutor, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("fg: usage: fg [%%n]")
+	}
+	job, err := e.jobs.find(strings.Join(args, ""))
+	if err != nil {
+		return fmt.Errorf("fg: %w", err)
+	}
+
+	fmt.Fprintln(e.output(), job.Command)
+	after, err := e.foreground(job)
+	if err != nil {
+		return fmt.Errorf("fg: %w", err)
+	}
+	if after.State == JobStopped {
+		fmt.Fprintf(e.errorOutput(), "\n%s\n", after.format('+'))
+	}
+	return nil
+}
+
+// builtinBg continues a stopped job in the background
+func builtinBg(e *ShellExecutor, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("bg: usage: bg [%%n]")
+	}
+	job, err := e.jobs.find(strings.Join(args, ""))
+	if err != nil {
+		return fmt.Errorf("bg: %w", err)
+	}
+
+	if err := e.jobs.resume(job); err != nil {
+		return fmt.Errorf("bg: %w", err)
+	}
+	_, err = fmt.Fprintf(e.output(), "[%d]+ %s &\n", job.ID, job.Command)
+	return err
+}
+
+// builtinKill sends a signal, TERM unless given as -NAME, -n or -s NAME,
+// to the jobs named as %n. Anything else, such as process IDs or -l, is
+// left to the system kill command.
+func builtinKill(e *ShellExecutor, args []string) error {
+	name := "TERM"
+	var specs []string
+	others := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case strings.HasPrefix(arg, "%"):
+			specs = append(specs, arg)
+		case arg == "-s" && i+1 < len(args):
+			i++
+			name = args[i]
+		case strings.HasPrefix(arg, "-") && len(arg) > 1 && arg != "-l" && len(specs) == 0 && !others:
+			name = arg[1:]
+		default:
+			others = true
+		}
+	}
+	if len(specs) == 0 {
+		return errNotBuiltin
+	}
+	if others {
+		return fmt.Errorf("kill: give either jobs as %%n or process IDs, not both")
+	}
+
+	sig, err := parseSignal(name)
+	if err != nil {
+		return fmt.Errorf("kill: %w", err)
+	}
+	for _, spec := range specs {
+		job, err := e.jobs.find(spec)
+		if err != nil {
+			return fmt.Errorf("kill: %w", err)
+		}
+		if err := e.jobs.signal(job, sig); err != nil {
+			return fmt.Errorf("kill: %%%d: %w", job.ID, err)
+		}
+	}
+	return nil
+}
+
+// builtinWait waits for the named jobs, or every running job, to finish.
+// Ctrl-C stops waiting.
+func builtinWait(e *ShellExecutor, args []string) error {
+	var jobs []*Job
+	for _, spec := range args {
+		job, err := e.jobs.find(spec)
+		if err != nil {
+			return fmt.Errorf("wait: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if len(args) == 0 {
+		jobs = e.jobs.running()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for _, job := range jobs {
+		done, err := e.jobs.wait(ctx, job, func(j Job) bool {
+			return j.State != JobRunning
+		})
+		if err != nil {
+			return fmt.Errorf("wait: interrupted")
+		}
+		if done.State == JobStopped {
+			return fmt.Errorf("wait: %%%d is stopped", done.ID)
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes s for display as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
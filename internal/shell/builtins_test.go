@@ -0,0 +1,255 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestExecutor creates an executor writing to buffers
+func newTestExecutor(t *testing.T) (*ShellExecutor, *bytes.Buffer) {
+	t.Helper()
+
+	// Builtins change the process directory, so restore it afterwards
+	t.Chdir(t.TempDir())
+
+	var stdout bytes.Buffer
+	executor := NewExecutorWithShell("sh")
+	executor.stdout = &stdout
+	executor.stderr = &stdout
+	return executor, &stdout
+}
+
+// resolved returns path with symlinks resolved, for comparing directories
+func resolved(t *testing.T, path string) string {
+	t.Helper()
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", path, err)
+	}
+	return real
+}
+
+// TestBuiltinCd tests changing directories
+func TestBuiltinCd(t *testing.T) {
+	executor, stdout := newTestExecutor(t)
+	start := executor.Session().Dir()
+
+	if err := os.Mkdir(filepath.Join(start, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	// Relative cd changes both the session and process directories
+	if _, err := executor.Execute("cd sub"); err != nil {
+		t.Fatalf("cd sub failed: %v", err)
+	}
+	expected := filepath.Join(start, "sub")
+	if executor.Session().Dir() != expected {
+		t.Errorf("Expected session dir %s, got %s", expected, executor.Session().Dir())
+	}
+	if wd, _ := os.Getwd(); resolved(t, wd) != resolved(t, expected) {
+		t.Errorf("Expected process dir %s, got %s", expected, wd)
+	}
+	if executor.Session().Getenv("PWD") != expected || executor.Session().Getenv("OLDPWD") != start {
+		t.Errorf("PWD/OLDPWD not updated: %s %s", executor.Session().Getenv("PWD"), executor.Session().Getenv("OLDPWD"))
+	}
+
+	// External commands run in the session directory
+	stdout.Reset()
+	if _, err := executor.Execute("pwd -P | cat"); err != nil {
+		t.Fatalf("pwd failed: %v", err)
+	}
+	if strings.TrimSpace(stdout.String()) != resolved(t, expected) {
+		t.Errorf("Expected external pwd %s, got %q", expected, stdout.String())
+	}
+
+	// cd - goes back and prints the directory
+	stdout.Reset()
+	if _, err := executor.Execute("cd -"); err != nil {
+		t.Fatalf("cd - failed: %v", err)
+	}
+	if executor.Session().Dir() != start {
+		t.Errorf("Expected cd - to return to %s, got %s", start, executor.Session().Dir())
+	}
+	if strings.TrimSpace(stdout.String()) != start {
+		t.Errorf("Expected cd - to print %s, got %q", start, stdout.String())
+	}
+
+	// cd ~ goes to the home directory
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if _, err := executor.Execute("cd ~"); err != nil {
+		t.Fatalf("cd ~ failed: %v", err)
+	}
+	if resolved(t, executor.Session().Dir()) == resolved(t, start) {
+		t.Errorf("Expected cd ~ to leave %s", start)
+	}
+
+	// Missing directories are reported and leave the directory alone
+	dir := executor.Session().Dir()
+	if _, err := executor.Execute("cd /no/such/dir"); err == nil {
+		t.Error("Expected error for missing directory, got nil")
+	}
+	if executor.Session().Dir() != dir {
+		t.Errorf("Directory changed after failed cd: %s", executor.Session().Dir())
+	}
+}
+
+// TestBuiltinPwd tests printing the working directory
+func TestBuiltinPwd(t *testing.T) {
+	executor, stdout := newTestExecutor(t)
+
+	if _, err := executor.Execute("pwd"); err != nil {
+		t.Fatalf("pwd failed: %v", err)
+	}
+	if strings.TrimSpace(stdout.String()) != executor.Session().Dir() {
+		t.Errorf("Expected %s, got %q", executor.Session().Dir(), stdout.String())
+	}
+}
+
+// TestBuiltinExportUnset tests session environment variables
+func TestBuiltinExportUnset(t *testing.T) {
+	executor, stdout := newTestExecutor(t)
+
+	if _, err := executor.Execute("export BUDY_TEST='hello world'"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if executor.Session().Getenv("BUDY_TEST") != "hello world" {
+		t.Errorf("Expected BUDY_TEST to be set, got %q", executor.Session().Getenv("BUDY_TEST"))
+	}
+
+	// The variable is visible to external commands but not to budy itself
+	if _, err := executor.Execute(`echo "$BUDY_TEST"`); err != nil {
+		t.Fatalf("echo failed: %v", err)
+	}
+	if stdout.String() != "hello world\n" {
+		t.Errorf("Expected exported value in child, got %q", stdout.String())
+	}
+	if os.Getenv("BUDY_TEST") != "" {
+		t.Error("export should not change the budy process environment")
+	}
+
+	// Variables expand in builtin arguments
+	if _, err := executor.Execute("export BUDY_OTHER=$BUDY_TEST!"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if executor.Session().Getenv("BUDY_OTHER") != "hello world!" {
+		t.Errorf("Expected expanded value, got %q", executor.Session().Getenv("BUDY_OTHER"))
+	}
+
+	if _, err := executor.Execute("unset BUDY_TEST"); err != nil {
+		t.Fatalf("unset failed: %v", err)
+	}
+	stdout.Reset()
+	if _, err := executor.Execute(`echo "[$BUDY_TEST]"`); err != nil {
+		t.Fatalf("echo failed: %v", err)
+	}
+	if stdout.String() != "[]\n" {
+		t.Errorf("Expected unset variable to be empty, got %q", stdout.String())
+	}
+
+	if _, err := executor.Execute("export 1BAD=x"); err == nil {
+		t.Error("Expected error for invalid identifier, got nil")
+	}
+}
+
+// TestBuiltinAlias tests alias definition and expansion
+func TestBuiltinAlias(t *testing.T) {
+	executor, stdout := newTestExecutor(t)
+
+	if _, err := executor.Execute("alias greet='echo hi'"); err != nil {
+		t.Fatalf("alias failed: %v", err)
+	}
+	if _, err := executor.Execute("greet there"); err != nil {
+		t.Fatalf("aliased command failed: %v", err)
+	}
+	if stdout.String() != "hi there\n" {
+		t.Errorf("Expected alias expansion, got %q", stdout.String())
+	}
+
+	stdout.Reset()
+	if _, err := executor.Execute("alias"); err != nil {
+		t.Fatalf("alias listing failed: %v", err)
+	}
+	if stdout.String() != "alias greet='echo hi'\n" {
+		t.Errorf("Unexpected alias listing %q", stdout.String())
+	}
+
+	if _, err := executor.Execute("unalias greet"); err != nil {
+		t.Fatalf("unalias failed: %v", err)
+	}
+	if _, err := executor.Execute("unalias greet"); err == nil {
+		t.Error("Expected error removing missing alias, got nil")
+	}
+}
+
+// TestBuiltinHistory tests printing history
+func TestBuiltinHistory(t *testing.T) {
+	executor, stdout := newTestExecutor(t)
+
+	if _, err := executor.Execute("history"); err == nil {
+		t.Error("Expected error without a history manager, got nil")
+	}
+
+	executor.SetHistory(NewMockHistoryManager([]string{"ls", "pwd", "make"}))
+	stdout.Reset()
+	if _, err := executor.Execute("history 2"); err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	expected := "    2  pwd\n    3  make\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, stdout.String())
+	}
+
+	history := NewMockHistoryManager([]string{"ls"})
+	_ = history.RecordEntry(CommandEntry{Command: "find . -name '*.go'", Source: SourceAI})
+	executor.SetHistory(history)
+	stdout.Reset()
+	if _, err := executor.Execute("history"); err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	expected = "    1  ls\n    2  find . -name '*.go'  # ai\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, stdout.String())
+	}
+}
+
+// TestBuiltinHelpAndClear tests help and clear
+func TestBuiltinHelpAndClear(t *testing.T) {
+	executor, stdout := newTestExecutor(t)
+
+	if _, err := executor.Execute("help"); err != nil {
+		t.Fatalf("help failed: %v", err)
+	}
+	for _, name := range []string{"cd", "export", "unset", "pwd", "history", "clear", "alias"} {
+		if !strings.Contains(stdout.String(), name) {
+			t.Errorf("Expected help to mention %s", name)
+		}
+	}
+
+	stdout.Reset()
+	if _, err := executor.Execute("clear"); err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	if stdout.String() != "\033[H\033[2J" {
+		t.Errorf("Unexpected clear output %q", stdout.String())
+	}
+}
+
+// TestBuiltinInCompoundCommand tests that compound commands go to the shell
+func TestBuiltinInCompoundCommand(t *testing.T) {
+	executor, stdout := newTestExecutor(t)
+	start := executor.Session().Dir()
+
+	if _, err := executor.Execute("cd / && pwd"); err != nil {
+		t.Fatalf("compound command failed: %v", err)
+	}
+	if stdout.String() != "/\n" {
+		t.Errorf("Expected shell to run compound command, got %q", stdout.String())
+	}
+	if executor.Session().Dir() != start {
+		t.Errorf("Compound command should not change the session directory")
+	}
+}
@@ -0,0 +1,96 @@
+package shell
+
+import "strings"
+
+// Kinds of token in a parsed command line
+const (
+	TokenProgram    = "program"
+	TokenFlag       = "flag"
+	TokenArgument   = "argument"
+	TokenAssignment = "assignment"
+	TokenOperator   = "operator"
+	TokenRedirect   = "redirect"
+	TokenFile       = "file"
+)
+
+// Token is one word of a command line and the part it plays
+type Token struct {
+	Text string
+	Kind string
+
+	// Program is the program the token is passed to, for flags and
+	// arguments
+	Program string
+}
+
+// ParseCommandLine splits a command line into tokens. Quoted strings stay
+// whole and shell operators are tokens of their own. The first word of
+// each command in a pipeline or list is its program, after any variable
+// assignments; words starting with "-" are flags until a "--".
+func ParseCommandLine(line string) []Token {
+	var tokens []Token
+	program := ""
+	flags := true
+	redirect := false
+
+	for _, word := range historyWords(line) {
+		token := Token{Text: word}
+		switch {
+		case strings.ContainsAny(word[:1], "<>"):
+			token.Kind = TokenRedirect
+			redirect = true
+		case strings.ContainsAny(word[:1], "|&;()"):
+			token.Kind = TokenOperator
+			program = ""
+			flags = true
+		case redirect:
+			token.Kind = TokenFile
+			redirect = false
+		case program == "" && isAssignment(word):
+			token.Kind = TokenAssignment
+		case program == "":
+			token.Kind = TokenProgram
+			program = word
+		case flags && word == "--":
+			token.Kind = TokenFlag
+			token.Program = program
+			flags = false
+		case flags && len(word) > 1 && word[0] == '-':
+			token.Kind = TokenFlag
+			token.Program = program
+		default:
+			token.Kind = TokenArgument
+			token.Program = program
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// Programs returns the programs run by a parsed command line, without
+// repeats
+func Programs(tokens []Token) []string {
+	var programs []string
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		if token.Kind == TokenProgram && !seen[token.Text] {
+			seen[token.Text] = true
+			programs = append(programs, token.Text)
+		}
+	}
+	return programs
+}
+
+// isAssignment reports whether word is a NAME=value variable assignment
+func isAssignment(word string) bool {
+	name, _, found := strings.Cut(word, "=")
+	if !found || name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && (i == 0 || !(c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package shell
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []Token
+	}{
+		{
+			line: "tar -xzvf foo.tgz -C /tmp",
+			expected: []Token{
+				{Text: "tar", Kind: TokenProgram},
+				{Text: "-xzvf", Kind: TokenFlag, Program: "tar"},
+				{Text: "foo.tgz", Kind: TokenArgument, Program: "tar"},
+				{Text: "-C", Kind: TokenFlag, Program: "tar"},
+				{Text: "/tmp", Kind: TokenArgument, Program: "tar"},
+			},
+		},
+		{
+			line: `LC_ALL=C grep -rn "a b" . | sort > out.txt`,
+			expected: []Token{
+				{Text: "LC_ALL=C", Kind: TokenAssignment},
+				{Text: "grep", Kind: TokenProgram},
+				{Text: "-rn", Kind: TokenFlag, Program: "grep"},
+				{Text: `"a b"`, Kind: TokenArgument, Program: "grep"},
+				{Text: ".", Kind: TokenArgument, Program: "grep"},
+				{Text: "|", Kind: TokenOperator},
+				{Text: "sort", Kind: TokenProgram},
+				{Text: ">", Kind: TokenRedirect},
+				{Text: "out.txt", Kind: TokenFile},
+			},
+		},
+		{
+			line: "rm -f -- -weird && ls",
+			expected: []Token{
+				{Text: "rm", Kind: TokenProgram},
+				{Text: "-f", Kind: TokenFlag, Program: "rm"},
+				{Text: "--", Kind: TokenFlag, Program: "rm"},
+				{Text: "-weird", Kind: TokenArgument, Program: "rm"},
+				{Text: "&&", Kind: TokenOperator},
+				{Text: "ls", Kind: TokenProgram},
+			},
+		},
+		{
+			line: "echo a=b -",
+			expected: []Token{
+				{Text: "echo", Kind: TokenProgram},
+				{Text: "a=b", Kind: TokenArgument, Program: "echo"},
+				{Text: "-", Kind: TokenArgument, Program: "echo"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			tokens := ParseCommandLine(tt.line)
+			if !reflect.DeepEqual(tokens, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, tokens)
+			}
+		})
+	}
+}
+
+func TestPrograms(t *testing.T) {
+	programs := Programs(ParseCommandLine("git log | grep fix | git shortlog; FOO=1 make"))
+	expected := []string{"git", "grep", "make"}
+	if !reflect.DeepEqual(programs, expected) {
+		t.Errorf("Expected %v, got %v", expected, programs)
+	}
+}
@@ -0,0 +1,304 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sosadtsia/budy/pkg/utils"
+)
+
+// Completer suggests completions for the word at the cursor
+type Completer interface {
+	// Complete returns candidates for the word ending at byte offset pos
+	// in line. Each candidate replaces line[start:pos].
+	Complete(line string, pos int) (candidates []string, start int)
+}
+
+// CompleterFunc adapts a function to the Completer interface
+type CompleterFunc func(line string, pos int) ([]string, int)
+
+// Complete calls f(line, pos)
+func (f CompleterFunc) Complete(line string, pos int) ([]string, int) {
+	return f(line, pos)
+}
+
+// Completers tries each completer in order and returns the candidates of
+// the first one that has any
+type Completers []Completer
+
+// Complete returns the first non-empty set of candidates
+func (c Completers) Complete(line string, pos int) ([]string, int) {
+	for _, completer := range c {
+		if candidates, start := completer.Complete(line, pos); len(candidates) > 0 {
+			return candidates, start
+		}
+	}
+	return nil, pos
+}
+
+// wordAt splits line up to pos into the words before the cursor and the
+// partial word being typed, returning the byte offset where it starts
+func wordAt(line string, pos int) (before []string, word string, start int) {
+	head := line[:pos]
+	start = strings.LastIndexAny(head, " \t") + 1
+	return strings.Fields(head[:start]), head[start:], start
+}
+
+// NewCompleter returns the standard completer for a budy session: budy's
+// own grammar first, then commands for the first word, then paths and
+// previously used arguments for the rest
+func NewCompleter(executor *ShellExecutor, history HistoryManager, grammar Completer) Completer {
+	completers := Completers{}
+	if grammar != nil {
+		completers = append(completers, grammar)
+	}
+	return append(completers,
+		&CommandCompleter{executor: executor},
+		&ArgumentCompleter{session: executor.Session(), history: history},
+	)
+}
+
+// CommandCompleter completes the first word of a line with builtins,
+// aliases and executables found on the session's $PATH
+type CommandCompleter struct {
+	executor *ShellExecutor
+
+	// Executables are cached per $PATH value
+	path     string
+	commands []string
+}
+
+// Complete completes command names
+func (c *CommandCompleter) Complete(line string, pos int) ([]string, int) {
+	before, word, start := wordAt(line, pos)
+	if len(before) > 0 || strings.Contains(word, "/") {
+		return nil, pos
+	}
+
+	var candidates []string
+	for _, name := range c.executor.Builtins() {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, name)
+		}
+	}
+	for _, name := range c.executor.Session().Aliases() {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, name)
+		}
+	}
+	for _, name := range c.executables() {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, name)
+		}
+	}
+	return uniqueSorted(candidates), start
+}
+
+// executables lists the executable files on the session's $PATH
+func (c *CommandCompleter) executables() []string {
+	path := c.executor.Session().Getenv("PATH")
+	if path == c.path && c.commands != nil {
+		return c.commands
+	}
+
+	commands := []string{}
+	for _, dir := range filepath.SplitList(path) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			commands = append(commands, entry.Name())
+		}
+	}
+
+	c.path = path
+	c.commands = uniqueSorted(commands)
+	return c.commands
+}
+
+// ArgumentCompleter completes command arguments with file and directory
+// paths relative to the session's working directory, and with arguments
+// previously used with the same command
+type ArgumentCompleter struct {
+	session *Session
+	history HistoryManager
+}
+
+// maxHistoryScan limits how many history entries are searched for arguments
+const maxHistoryScan = 2000
+
+// Complete completes paths and previously used arguments
+func (c *ArgumentCompleter) Complete(line string, pos int) ([]string, int) {
+	before, word, start := wordAt(line, pos)
+	if len(before) == 0 && !strings.Contains(word, "/") {
+		return nil, pos
+	}
+
+	candidates := c.paths(word)
+	if len(before) > 0 && c.history != nil {
+		candidates = append(candidates, c.arguments(before[0], word)...)
+	}
+	return uniqueSorted(candidates), start
+}
+
+// paths lists files and directories matching the partial path word
+func (c *ArgumentCompleter) paths(word string) []string {
+	dirPart, prefix := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dirPart, prefix = word[:i+1], word[i+1:]
+	}
+
+	dir := dirPart
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := utils.ExpandPath(dir)
+	if err != nil {
+		return nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.session.Dir(), dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var candidates []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// Hidden files only when asked for
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if entry.IsDir() || (entry.Type()&os.ModeSymlink != 0 && utils.DirExists(filepath.Join(dir, name))) {
+			name += "/"
+		}
+		candidates = append(candidates, dirPart+name)
+	}
+	return candidates
+}
+
+// arguments lists arguments previously used with command that start with word
+func (c *ArgumentCompleter) arguments(command, word string) []string {
+	entries := c.history.GetHistory()
+	stop := 0
+	if len(entries) > maxHistoryScan {
+		stop = len(entries) - maxHistoryScan
+	}
+
+	var candidates []string
+	for i := len(entries) - 1; i >= stop; i-- {
+		fields := strings.Fields(entries[i].Command)
+		if len(fields) < 2 || fields[0] != command {
+			continue
+		}
+		for _, arg := range fields[1:] {
+			if arg != word && strings.HasPrefix(arg, word) {
+				candidates = append(candidates, arg)
+			}
+		}
+	}
+	return candidates
+}
+
+// ConfigCompleter completes budy's own commands of the form
+// "<command> <subcommand> <option> <value>", such as
+// "config set ollama_model llama3"
+type ConfigCompleter struct {
+	command    string
+	subcommand string
+	options    map[string]func() []string
+}
+
+// NewConfigCompleter creates a completer for "config set <option> <value>".
+// options maps each option name to a function listing its values, or nil
+// when values cannot be completed.
+func NewConfigCompleter(options map[string]func() []string) *ConfigCompleter {
+	return &ConfigCompleter{
+		command:    "config",
+		subcommand: "set",
+		options:    options,
+	}
+}
+
+// Complete completes the subcommand, option names and option values
+func (c *ConfigCompleter) Complete(line string, pos int) ([]string, int) {
+	before, word, start := wordAt(line, pos)
+	if len(before) == 0 || before[0] != c.command {
+		return nil, pos
+	}
+
+	var choices []string
+	switch len(before) {
+	case 1:
+		choices = []string{c.subcommand}
+	case 2:
+		if before[1] != c.subcommand {
+			return nil, pos
+		}
+		for option := range c.options {
+			choices = append(choices, option)
+		}
+	case 3:
+		if values := c.options[before[2]]; values != nil {
+			choices = values()
+		}
+	}
+
+	var candidates []string
+	for _, choice := range choices {
+		if strings.HasPrefix(choice, word) {
+			candidates = append(candidates, choice)
+		}
+	}
+	return uniqueSorted(candidates), start
+}
+
+// Values returns a function listing a fixed set of values, for use with
+// NewConfigCompleter
+func Values(values ...string) func() []string {
+	return func() []string {
+		return values
+	}
+}
+
+// uniqueSorted sorts values and removes duplicates
+func uniqueSorted(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Strings(values)
+	unique := values[:1]
+	for _, value := range values[1:] {
+		if value != unique[len(unique)-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// commonPrefix returns the longest prefix shared by all values
+func commonPrefix(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
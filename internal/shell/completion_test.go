@@ -0,0 +1,156 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCompleters tests that the first completer with candidates wins
+func TestCompleters(t *testing.T) {
+	none := CompleterFunc(func(line string, pos int) ([]string, int) {
+		return nil, pos
+	})
+	first := CompleterFunc(func(line string, pos int) ([]string, int) {
+		return []string{"first"}, 0
+	})
+	second := CompleterFunc(func(line string, pos int) ([]string, int) {
+		return []string{"second"}, 0
+	})
+
+	candidates, _ := Completers{none, first, second}.Complete("x", 1)
+	if !reflect.DeepEqual(candidates, []string{"first"}) {
+		t.Errorf("Expected [first], got %v", candidates)
+	}
+
+	candidates, start := Completers{none}.Complete("x", 1)
+	if candidates != nil || start != 1 {
+		t.Errorf("Expected no candidates at 1, got %v at %d", candidates, start)
+	}
+}
+
+// TestCommandCompleter tests completing command names
+func TestCommandCompleter(t *testing.T) {
+	executor, _ := newTestExecutor(t)
+
+	bin := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"budytool": 0755, "budydata": 0644} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	executor.Session().Setenv("PATH", bin)
+	executor.Session().SetAlias("budyalias", "ls")
+
+	completer := &CommandCompleter{executor: executor}
+
+	tests := []struct {
+		name     string
+		line     string
+		expected []string
+		start    int
+	}{
+		{"Executables", "budy", []string{"budyalias", "budytool"}, 0},
+		{"Builtin", "unal", []string{"unalias"}, 0},
+		{"Indented", "  cle", []string{"clear"}, 2},
+		{"Argument", "ls budy", nil, 3},
+		{"Path", "./budy", nil, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, start := completer.Complete(tt.line, len(tt.line))
+			if !reflect.DeepEqual(candidates, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, candidates)
+			}
+			if candidates != nil && start != tt.start {
+				t.Errorf("Expected start %d, got %d", tt.start, start)
+			}
+		})
+	}
+
+	// A new $PATH is rescanned
+	executor.Session().Setenv("PATH", t.TempDir())
+	if candidates, _ := completer.Complete("budyt", 5); candidates != nil {
+		t.Errorf("Expected no candidates after changing PATH, got %v", candidates)
+	}
+}
+
+// TestArgumentCompleter tests completing paths and history arguments
+func TestArgumentCompleter(t *testing.T) {
+	executor, _ := newTestExecutor(t)
+	dir := executor.Session().Dir()
+
+	for _, name := range []string{"notes.txt", "now.log", ".hidden", "src/main.go"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	history := NewMockHistoryManager([]string{"git checkout main", "git checkout -b feature", "ls -la"})
+	completer := &ArgumentCompleter{session: executor.Session(), history: history}
+
+	tests := []struct {
+		name     string
+		line     string
+		expected []string
+	}{
+		{"Files", "cat no", []string{"notes.txt", "now.log"}},
+		{"Directory", "cd s", []string{"src/"}},
+		{"InsideDirectory", "cat src/m", []string{"src/main.go"}},
+		{"HiddenSkipped", "cat ", []string{"notes.txt", "now.log", "src/"}},
+		{"HiddenRequested", "cat .h", []string{".hidden"}},
+		{"History", "git checkout ma", []string{"main"}},
+		{"HistoryFlags", "git -", []string{"-b"}},
+		{"HistoryOtherCommand", "ls -", []string{"-la"}},
+		{"FirstWordPath", "./sr", []string{"./src/"}},
+		{"FirstWord", "no", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, _ := completer.Complete(tt.line, len(tt.line))
+			if !reflect.DeepEqual(candidates, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, candidates)
+			}
+		})
+	}
+}
+
+// TestConfigCompleter tests completing budy's config command
+func TestConfigCompleter(t *testing.T) {
+	completer := NewConfigCompleter(map[string]func() []string{
+		"ai_provider":  Values("openai", "ollama"),
+		"ollama_model": Values("llama3", "llama3.1", "mistral"),
+		"ollama_url":   nil,
+	})
+
+	tests := []struct {
+		name     string
+		line     string
+		expected []string
+	}{
+		{"Subcommand", "config ", []string{"set"}},
+		{"Options", "config set ", []string{"ai_provider", "ollama_model", "ollama_url"}},
+		{"OptionPrefix", "config set oll", []string{"ollama_model", "ollama_url"}},
+		{"Values", "config set ai_provider o", []string{"ollama", "openai"}},
+		{"ValuePrefix", "config set ollama_model lla", []string{"llama3", "llama3.1"}},
+		{"NoValues", "config set ollama_url h", nil},
+		{"UnknownSubcommand", "config get ", nil},
+		{"OtherCommand", "git set ", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, _ := completer.Complete(tt.line, len(tt.line))
+			if !reflect.DeepEqual(candidates, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, candidates)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Where documentation for a program came from
+const (
+	DocsBuiltin = "builtin"
+	DocsMan     = "man"
+	DocsHelp    = "--help"
+)
+
+// docsTimeout limits how long man or --help may take
+const docsTimeout = 3 * time.Second
+
+// maxDocsSize limits how much documentation is kept for a program
+const maxDocsSize = 256 * 1024
+
+// Docs is the documentation of a program
+type Docs struct {
+	Program string
+	Source  string
+	Text    string
+}
+
+// formatting matches the overstrike and escape sequences man uses for bold
+// and underlined text
+var formatting = regexp.MustCompile(`.\x08|\x1b\[[0-9;]*m`)
+
+// Documentation returns the documentation of a program: the usage of a
+// budy builtin, its man page, or the output of "program --help" for
+// programs on the session's $PATH that have no man page. It returns nil
+// when none is found.
+func (e *ShellExecutor) Documentation(program string) *Docs {
+	if builtin, ok := e.builtins[program]; ok {
+		return &Docs{
+			Program: program,
+			Source:  DocsBuiltin,
+			Text:    fmt.Sprintf("%s - %s\nUsage: %s", builtin.Name, builtin.Description, builtin.Usage),
+		}
+	}
+
+	if text := e.runDocs("man", program); text != "" {
+		return &Docs{Program: program, Source: DocsMan, Text: text}
+	}
+
+	// Only programs found on $PATH are run, never scripts named by path
+	if strings.Contains(program, "/") {
+		return nil
+	}
+	path := e.lookPath(program)
+	if path == "" {
+		return nil
+	}
+	if text := e.runDocs(path, "--help"); text != "" {
+		return &Docs{Program: program, Source: DocsHelp, Text: text}
+	}
+	return nil
+}
+
+// runDocs runs a documentation command and returns its plain text output,
+// or an empty string if it fails
+func (e *ShellExecutor) runDocs(name string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), docsTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = e.session.Dir()
+	cmd.Env = append(e.session.Environ(), "MANPAGER=cat", "PAGER=cat", "MANWIDTH=80", "MAN_KEEP_FORMATTING=")
+
+	// Many programs print their usage to stderr, and some exit non-zero
+	// after printing it
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil || (err != nil && name == "man") {
+		return ""
+	}
+	if len(output) > maxDocsSize {
+		output = output[:maxDocsSize]
+	}
+	return strings.TrimSpace(strings.ToValidUTF8(formatting.ReplaceAllString(string(output), ""), ""))
+}
+
+// lookPath finds an executable on the session's $PATH
+func (e *ShellExecutor) lookPath(program string) string {
+	for _, dir := range filepath.SplitList(e.session.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, program)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return path
+		}
+	}
+	return ""
+}
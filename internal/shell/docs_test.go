@@ -0,0 +1,46 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDocumentation(t *testing.T) {
+	executor, _ := newTestExecutor(t)
+
+	// Builtins are described by their usage
+	docs := executor.Documentation("cd")
+	if docs == nil || docs.Source != DocsBuiltin || !strings.Contains(docs.Text, "cd [dir|-]") {
+		t.Errorf("Expected the usage of cd, got %+v", docs)
+	}
+
+	// Programs without a man page fall back to --help
+	bin := t.TempDir()
+	script := "#!/bin/sh\nif [ \"$1\" = --help ]; then\n  echo 'Usage: budytool [-q]'\n  echo '  -q   be quiet'\nfi\n"
+	if err := os.WriteFile(filepath.Join(bin, "budytool"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	executor.Session().Setenv("PATH", bin)
+
+	docs = executor.Documentation("budytool")
+	if docs == nil || docs.Source != DocsHelp || !strings.Contains(docs.Text, "-q   be quiet") {
+		t.Errorf("Expected the --help output, got %+v", docs)
+	}
+
+	// Programs named by path are never run
+	if docs := executor.Documentation(filepath.Join(bin, "budytool")); docs != nil {
+		t.Errorf("Expected no documentation for a path, got %+v", docs)
+	}
+	if docs := executor.Documentation("no-such-budy-program"); docs != nil {
+		t.Errorf("Expected no documentation for a missing program, got %+v", docs)
+	}
+}
+
+func TestDocsFormatting(t *testing.T) {
+	text := formatting.ReplaceAllString("N\bNA\bAM\bME\bE\n_\bf_\bi_\bl_\be \x1b[1mbold\x1b[0m", "")
+	if text != "NAME\nfile bold" {
+		t.Errorf("Expected formatting to be removed, got %q", text)
+	}
+}
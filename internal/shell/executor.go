@@ -1,38 +1,391 @@
 package shell
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
-// ShellExecutor implements the Executor interface
-type ShellExecutor struct{}
+// ShellExecutor implements the Executor interface by running commands
+// through a system shell, so pipes, quoting, redirections and globbing
+// behave exactly as they would in an interactive terminal. Builtin
+// commands such as cd and export are handled in-process first.
+type ShellExecutor struct {
+	shell    string
+	session  *Session
+	builtins map[string]Builtin
+	history  HistoryManager
+
+	// guard, if set, checks commands for risks before they run
+	guard *Guard
+
+	// jobs are the commands running in the background or stopped
+	jobs *jobTable
+
+	// Standard streams for executed commands; nil means the process streams
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// ExecResult describes how an executed command finished
+type ExecResult struct {
+	ExitCode int    `json:"exit_code"`
+	Signal   string `json:"signal,omitempty"`
+
+	// Duration is the wall-clock time the command took
+	Duration time.Duration `json:"duration"`
+
+	// StdoutBytes and StderrBytes are -1 when output went straight to a
+	// terminal
+	StdoutBytes int64 `json:"stdout_bytes"`
+	StderrBytes int64 `json:"stderr_bytes"`
+
+	// StderrTail holds the end of the error output of a failed command,
+	// at most StderrTailSize bytes. It is empty when error output went
+	// straight to a terminal.
+	StderrTail string `json:"stderr_tail,omitempty"`
+
+	// Job is the number of the job the command became when it was run in
+	// the background or stopped. How it finished is only known once the
+	// job is done.
+	Job int `json:"job,omitempty"`
+}
+
+// outputWaitDelay is how long output is still copied after a command
+// exits, while processes it started keep writing
+const outputWaitDelay = time.Second
+
+// StderrTailSize is how much of a failed command's error output is kept
+const StderrTailSize = 2048
+
+// Success reports whether the command exited with status zero
+func (r *ExecResult) Success() bool {
+	return r.ExitCode == 0 && r.Signal == ""
+}
 
 // Ensure ShellExecutor implements the Executor interface
 var _ Executor = (*ShellExecutor)(nil)
 
-// NewExecutor creates a new shell executor
+// NewExecutor creates a new shell executor using the user's default shell
 func NewExecutor() *ShellExecutor {
-	return &ShellExecutor{}
+	return NewExecutorWithShell("")
+}
+
+// NewExecutorWithShell creates a new shell executor that runs commands
+// through the named shell (e.g. bash, sh or zsh). An empty name selects
+// the shell from $SHELL, falling back to sh.
+func NewExecutorWithShell(name string) *ShellExecutor {
+	e := &ShellExecutor{
+		shell:    ResolveShell(name),
+		session:  NewSession(),
+		builtins: make(map[string]Builtin),
+		jobs:     newJobTable(),
+	}
+
+	for _, builtin := range defaultBuiltins() {
+		e.RegisterBuiltin(builtin)
+	}
+
+	return e
+}
+
+// ResolveShell returns the path of the shell binary for the given name
+func ResolveShell(name string) string {
+	if name == "" {
+		name = os.Getenv("SHELL")
+	}
+	if name == "" {
+		name = "sh"
+	}
+
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+
+	// Every POSIX system has /bin/sh
+	return "/bin/sh"
+}
+
+// Shell returns the path of the shell used to run commands
+func (e *ShellExecutor) Shell() string {
+	return e.shell
+}
+
+// SetShell switches the shell used for subsequent commands
+func (e *ShellExecutor) SetShell(name string) {
+	e.shell = ResolveShell(name)
+}
+
+// Session returns the session state shared by builtins and commands
+func (e *ShellExecutor) Session() *Session {
+	return e.session
+}
+
+// SetHistory sets the history manager used by the history builtin
+func (e *ShellExecutor) SetHistory(history HistoryManager) {
+	e.history = history
+}
+
+// SetGuard sets the guard that checks commands before they run
+func (e *ShellExecutor) SetGuard(guard *Guard) {
+	e.guard = guard
+}
+
+// SetJobDir sets the directory the output of background jobs is logged
+// to, and removes logs there older than JobLogMaxAge
+func (e *ShellExecutor) SetJobDir(dir string) {
+	e.jobs.dir = dir
+	pruneJobLogs(dir, JobLogMaxAge)
+}
+
+// Jobs returns the jobs running in the background or stopped, and those
+// done but not yet reported by FinishedJobs
+func (e *ShellExecutor) Jobs() []Job {
+	return e.jobs.list()
+}
+
+// FinishedJobs returns the jobs that are done since it was last called,
+// with how they finished
+func (e *ShellExecutor) FinishedJobs() []Job {
+	return e.jobs.finished()
 }
 
-// Execute runs a shell command and returns any error
-func (e *ShellExecutor) Execute(command string) error {
-	// Split the command into parts
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
-		return nil
+// Execute runs a shell command and reports how it finished. A non-zero
+// exit status is described by the result; the error is only set when the
+// command could not be run at all or a builtin failed.
+func (e *ShellExecutor) Execute(command string) (*ExecResult, error) {
+	if strings.TrimSpace(command) == "" {
+		return &ExecResult{}, nil
+	}
+
+	start := time.Now()
+	line := strings.TrimSpace(command)
+	command = e.expandAlias(command)
+
+	// Dangerous commands are stopped or confirmed first, including what an
+	// alias expands to
+	if e.guard != nil {
+		if err := e.guard.Check(command, e.errorOutput()); err != nil {
+			return nil, err
+		}
 	}
 
-	// Create the command
-	cmd := exec.Command(parts[0], parts[1:]...)
+	// A trailing & runs the whole line as a job, with its output logged
+	if background, ok := backgroundCommand(command); ok {
+		job, err := e.startJob(background, line)
+		if err != nil {
+			return &ExecResult{ExitCode: -1, Duration: time.Since(start)}, err
+		}
+		fmt.Fprintf(e.errorOutput(), "[%d] %d, output in %s\n", job.ID, job.pid, job.Log)
+		return &ExecResult{Duration: time.Since(start), StdoutBytes: -1, Job: job.ID}, nil
+	}
 
-	// Set up standard IO
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Dispatch simple builtin invocations in-process so they can change
+	// the session state. A builtin may leave a command to the shell.
+	words, simple, err := splitWords(command, e.session.Getenv)
+	if err == nil && simple && len(words) > 0 {
+		if builtin, ok := e.builtins[words[0]]; ok {
+			stdout := &countingWriter{w: e.output()}
+			err := builtin.Run(e.withOutput(stdout), words[1:])
+			if err != errNotBuiltin {
+				result := &ExecResult{
+					Duration:    time.Since(start),
+					StdoutBytes: stdout.n,
+				}
+				if err != nil {
+					result.ExitCode = 1
+					result.StderrTail = err.Error()
+				}
+				return result, err
+			}
+		}
+	}
 
-	// Execute the command
-	return cmd.Run()
+	// Let the shell handle quoting, pipelines, lists and redirections
+	cmd := exec.Command(e.shell, "-c", command)
+	cmd.Dir = e.session.Dir()
+	cmd.Env = e.session.Environ()
+
+	// Set up standard IO. Output to a terminal is passed through untouched
+	// so interactive programs keep their progress output and colors, and
+	// background processes they leave behind can't hold up the command;
+	// anything else is counted.
+	cmd.Stdin = e.stdin
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+	var stdout *countingWriter
+	if isTerminalWriter(e.output()) {
+		cmd.Stdout = e.output()
+	} else {
+		stdout = &countingWriter{w: e.output()}
+		cmd.Stdout = stdout
+	}
+	// Error output that isn't going to a terminal is also teed into a
+	// bounded buffer so the end of it can be recalled if the command fails
+	var stderr *countingWriter
+	var tail *tailBuffer
+	if isTerminalWriter(e.errorOutput()) {
+		cmd.Stderr = e.errorOutput()
+	} else {
+		tail = &tailBuffer{size: StderrTailSize}
+		stderr = &countingWriter{w: io.MultiWriter(e.errorOutput(), tail)}
+		cmd.Stderr = stderr
+	}
+	// Processes the command leaves behind may keep its output open; once
+	// it exits their output is only copied for a moment longer
+	cmd.WaitDelay = outputWaitDelay
+
+	// Execute the command in the foreground, in a process group of its own
+	exit, runErr := runForeground(cmd)
+
+	result := &ExecResult{
+		Duration:    time.Since(start),
+		StdoutBytes: -1,
+		StderrBytes: -1,
+	}
+	if stdout != nil {
+		result.StdoutBytes = stdout.n
+	}
+	if stderr != nil {
+		result.StderrBytes = stderr.n
+	}
+
+	if runErr != nil {
+		// The shell itself could not be started
+		result.ExitCode = -1
+		return result, runErr
+	}
+
+	result.ExitCode = exit.code
+	result.Signal = exit.signal
+	if exit.stopped {
+		// It carries on as a job, resumed with fg or bg
+		job := &Job{
+			Command: line,
+			Dir:     cmd.Dir,
+			Started: start,
+			State:   JobStopped,
+			cmd:     cmd,
+			pid:     cmd.Process.Pid,
+			stdout:  stdout,
+			stderr:  stderr,
+			tail:    tail,
+		}
+		e.jobs.add(job)
+		result.Job = job.ID
+		fmt.Fprintf(e.errorOutput(), "\n[%d]+ Stopped      %s\n", job.ID, line)
+	}
+	if !result.Success() && tail != nil {
+		result.StderrTail = tail.String()
+	}
+
+	return result, nil
+}
+
+// Close ends the jobs left stopped, as a shell does when it exits. Jobs
+// running in the background keep running.
+func (e *ShellExecutor) Close() {
+	e.jobs.hangUp()
+}
+
+// withOutput returns a shallow copy of the executor writing to w
+func (e *ShellExecutor) withOutput(w io.Writer) *ShellExecutor {
+	copied := *e
+	copied.stdout = w
+	return &copied
+}
+
+// expandAlias replaces a leading alias in the command with its value
+func (e *ShellExecutor) expandAlias(command string) string {
+	trimmed := strings.TrimLeft(command, " \t")
+	name := trimmed
+	if i := strings.IndexAny(trimmed, " \t;|&"); i >= 0 {
+		name = trimmed[:i]
+	}
+
+	value, ok := e.session.Alias(name)
+	if !ok {
+		return command
+	}
+	return value + trimmed[len(name):]
+}
+
+// output returns the writer for standard output
+func (e *ShellExecutor) output() io.Writer {
+	if e.stdout == nil {
+		return os.Stdout
+	}
+	return e.stdout
+}
+
+// errorOutput returns the writer for standard error
+func (e *ShellExecutor) errorOutput() io.Writer {
+	if e.stderr == nil {
+		return os.Stderr
+	}
+	return e.stderr
+}
+
+// isTerminalWriter reports whether w is a terminal device
+func isTerminalWriter(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes p to the underlying writer and counts the bytes written
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// tailBuffer keeps the last size bytes written to it
+type tailBuffer struct {
+	size int
+	buf  []byte
+}
+
+// Write appends p, discarding the oldest bytes beyond the size limit
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) >= t.size {
+		t.buf = append(t.buf[:0], p[len(p)-t.size:]...)
+		return n, nil
+	}
+	if over := len(t.buf) + len(p) - t.size; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	t.buf = append(t.buf, p...)
+	return n, nil
+}
+
+// String returns the kept bytes, starting at a line boundary when the
+// start was cut off
+func (t *tailBuffer) String() string {
+	data := t.buf
+	if len(data) == t.size {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 && i < len(data)-1 {
+			data = data[i+1:]
+		}
+	}
+	return strings.ToValidUTF8(string(data), "")
 }
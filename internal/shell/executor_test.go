@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewExecutor(t *testing.T) {
@@ -20,7 +22,7 @@ func TestNewExecutor(t *testing.T) {
 func TestExecuteEmptyCommand(t *testing.T) {
 	executor := NewExecutor()
 
-	err := executor.Execute("")
+	_, err := executor.Execute("")
 
 	if err != nil {
 		t.Errorf("Expected no error for empty command, got %v", err)
@@ -36,7 +38,7 @@ func TestExecuteEchoCommand(t *testing.T) {
 
 	executor := NewExecutor()
 
-	err := executor.Execute("echo test")
+	_, err := executor.Execute("echo test")
 
 	if err != nil {
 		t.Errorf("Expected no error for echo command, got %v", err)
@@ -59,12 +61,12 @@ func TestShellExecutor_Execute(t *testing.T) {
 	executor := NewExecutor()
 
 	// Test empty command
-	if err := executor.Execute(""); err != nil {
+	if _, err := executor.Execute(""); err != nil {
 		t.Errorf("Expected no error for empty command, got %v", err)
 	}
 
 	// Test a simple echo command
-	err := executor.Execute("echo test")
+	_, err := executor.Execute("echo test")
 
 	// Close the writer to capture the output
 	if err := w.Close(); err != nil {
@@ -86,6 +88,191 @@ func TestShellExecutor_Execute(t *testing.T) {
 	}
 }
 
+// TestShellConstructs tests that shell syntax is handled by the shell
+func TestShellConstructs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		command  string
+		expected string
+	}{
+		{"DoubleQuotes", `echo "foo   bar"`, "foo   bar\n"},
+		{"SingleQuotes", `echo '$HOME | x'`, "$HOME | x\n"},
+		{"EscapedSpace", `printf '%s\n' foo\ bar`, "foo bar\n"},
+		{"Pipeline", `printf 'a\nb\nc\n' | grep -v b | wc -l | tr -d ' '`, "2\n"},
+		{"AndList", `true && echo yes`, "yes\n"},
+		{"AndListShortCircuit", `false && echo yes; echo done`, "done\n"},
+		{"OrList", `false || echo fallback`, "fallback\n"},
+		{"Sequence", `echo one; echo two`, "one\ntwo\n"},
+		{"OutputRedirect", `echo saved > ` + dir + `/out.txt; cat ` + dir + `/out.txt`, "saved\n"},
+		{"AppendRedirect", `echo x > ` + dir + `/app.txt; echo y >> ` + dir + `/app.txt; cat ` + dir + `/app.txt`, "x\ny\n"},
+		{"InputRedirect", `tr a-z A-Z < ` + dir + `/a.txt`, "A.TXT"},
+		{"StderrRedirect", `ls ` + dir + `/missing 2>/dev/null || echo missing`, "missing\n"},
+		{"Glob", `cd ` + dir + ` && echo [ab].txt *.log`, "a.txt b.txt c.log\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			executor := NewExecutorWithShell("sh")
+			executor.stdout = &stdout
+			executor.stderr = &stderr
+
+			if _, err := executor.Execute(tt.command); err != nil {
+				t.Fatalf("Error executing %q: %v (stderr: %s)", tt.command, err, stderr.String())
+			}
+			if stdout.String() != tt.expected {
+				t.Errorf("Expected output %q, got %q", tt.expected, stdout.String())
+			}
+		})
+	}
+}
+
+// TestExecuteFailingCommand tests that a non-zero exit status is reported
+func TestExecuteFailingCommand(t *testing.T) {
+	executor := NewExecutorWithShell("sh")
+	executor.stderr = io.Discard
+
+	result, err := executor.Execute("exit 3")
+	if err != nil {
+		t.Fatalf("Expected exit status in result, got error %v", err)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", result.ExitCode)
+	}
+	if result.Success() {
+		t.Error("Expected failing command not to be successful")
+	}
+}
+
+// TestExecuteLeftBehind tests that processes a command leaves running
+// with its output open don't hold it up
+func TestExecuteLeftBehind(t *testing.T) {
+	var stdout bytes.Buffer
+	executor := NewExecutorWithShell("sh")
+	executor.stdin = strings.NewReader("")
+	executor.stdout = &stdout
+	executor.stderr = io.Discard
+
+	start := time.Now()
+	if _, err := executor.Execute("sleep 5 & echo started"); err != nil {
+		t.Fatalf("Error executing command: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > outputWaitDelay+time.Second {
+		t.Errorf("Expected the command to return after at most %v, took %v", outputWaitDelay, elapsed)
+	}
+	if stdout.String() != "started\n" {
+		t.Errorf("Expected 'started', got %q", stdout.String())
+	}
+}
+
+// TestExecuteResult tests the structured result of a command
+func TestExecuteResult(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	executor := NewExecutorWithShell("sh")
+	executor.stdout = &stdout
+	executor.stderr = &stderr
+
+	result, err := executor.Execute("printf hello; printf oops >&2; sleep 0.05")
+	if err != nil {
+		t.Fatalf("Error executing command: %v", err)
+	}
+	if !result.Success() {
+		t.Errorf("Expected success, got exit code %d", result.ExitCode)
+	}
+	if result.StdoutBytes != 5 {
+		t.Errorf("Expected 5 stdout bytes, got %d", result.StdoutBytes)
+	}
+	if result.StderrBytes != 4 {
+		t.Errorf("Expected 4 stderr bytes, got %d", result.StderrBytes)
+	}
+	if result.Duration < 50*time.Millisecond {
+		t.Errorf("Expected duration of at least 50ms, got %v", result.Duration)
+	}
+	if result.StderrTail != "" {
+		t.Errorf("Expected no stderr tail for a successful command, got %q", result.StderrTail)
+	}
+
+	// Failed commands keep the end of their error output
+	result, err = executor.Execute("echo first >&2; echo 'cannot open file' >&2; exit 2")
+	if err != nil {
+		t.Fatalf("Error executing command: %v", err)
+	}
+	if result.StderrTail != "first\ncannot open file\n" {
+		t.Errorf("Expected the error output as tail, got %q", result.StderrTail)
+	}
+
+	// Commands killed by a signal report it
+	result, err = executor.Execute("kill -TERM $$")
+	if err != nil {
+		t.Fatalf("Error executing command: %v", err)
+	}
+	if result.Signal != "terminated" {
+		t.Errorf("Expected signal 'terminated', got %q", result.Signal)
+	}
+	if result.Success() {
+		t.Error("Expected signalled command not to be successful")
+	}
+
+	// Builtin failures set a non-zero exit code and an error
+	result, err = executor.Execute("cd /no/such/dir")
+	if err == nil || result.ExitCode != 1 {
+		t.Errorf("Expected failed builtin with exit code 1, got %d (err %v)", result.ExitCode, err)
+	}
+	if err != nil && result.StderrTail != err.Error() {
+		t.Errorf("Expected the builtin error as tail, got %q", result.StderrTail)
+	}
+}
+
+// TestTailBuffer tests that only the end of the output is kept
+func TestTailBuffer(t *testing.T) {
+	tail := &tailBuffer{size: 16}
+	for _, chunk := range []string{"line one\n", "line two\n", "three\n"} {
+		if _, err := tail.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if tail.String() != "line two\nthree\n" {
+		t.Errorf("Expected the last whole lines, got %q", tail.String())
+	}
+
+	// A single large write keeps its end
+	tail = &tailBuffer{size: 4}
+	_, _ = tail.Write([]byte("abcdefgh"))
+	if tail.String() != "efgh" {
+		t.Errorf("Expected %q, got %q", "efgh", tail.String())
+	}
+
+	// Short output is kept whole
+	tail = &tailBuffer{size: 64}
+	_, _ = tail.Write([]byte("oops\n"))
+	if tail.String() != "oops\n" {
+		t.Errorf("Expected %q, got %q", "oops\n", tail.String())
+	}
+}
+
+// TestResolveShell tests shell lookup
+func TestResolveShell(t *testing.T) {
+	if path := ResolveShell("sh"); filepath.Base(path) != "sh" {
+		t.Errorf("Expected sh, got %s", path)
+	}
+
+	if path := ResolveShell("no-such-shell-budy"); path != "/bin/sh" {
+		t.Errorf("Expected fallback to /bin/sh, got %s", path)
+	}
+
+	t.Setenv("SHELL", "")
+	if path := ResolveShell(""); filepath.Base(path) != "sh" {
+		t.Errorf("Expected sh when $SHELL is empty, got %s", path)
+	}
+}
+
 // contains checks if a string contains a substring, trimming whitespace
 func contains(s, substr string) bool {
 	return bytes.Contains([]byte(s), []byte(substr))
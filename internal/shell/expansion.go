@@ -0,0 +1,428 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ExpandHistory performs bash-style history expansion of line against
+// history, which is ordered oldest first. It returns the expanded line and
+// whether any expansion took place.
+//
+// Supported event designators are !! (previous command), !n (command n as
+// numbered by the history builtin), !-n (n commands back), !prefix (most
+// recent command starting with prefix) and !?substring? (most recent
+// command containing substring). An event may be followed by a word
+// designator such as :0, :2-3, :^, :$ or :*, where the colon may be left
+// out before ^, $ and *, so !$ is the last word of the previous command.
+// The modifiers :h, :t, :r, :e and :s/old/new/ (or :gs to replace every
+// occurrence) can follow. A line starting with ^old^new^ repeats the
+// previous command with the first old replaced by new.
+//
+// As in bash, ! is left alone inside single quotes, after a backslash and
+// when followed by whitespace, = or (.
+func ExpandHistory(line string, history []CommandEntry) (string, bool, error) {
+	if strings.HasPrefix(line, "^") {
+		// Quick substitution is shorthand for !!:s^old^new^
+		expanded, _, err := ExpandHistory("!!:s"+line, history)
+		return expanded, err == nil, err
+	}
+	if !strings.Contains(line, "!") {
+		return line, false, nil
+	}
+
+	var b strings.Builder
+	expanded := false
+	inSingle, inDouble := false, false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && !inSingle:
+			// Keep the escape for the shell to remove
+			b.WriteString(line[i : i+2])
+			i++
+			continue
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+		case c == '!' && !inSingle && expandsAt(line, i, inDouble):
+			text, n, err := expandEvent(line[i:], history)
+			if err != nil {
+				return line, false, err
+			}
+			b.WriteString(text)
+			i += n - 1
+			expanded = true
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String(), expanded, nil
+}
+
+// expandsAt reports whether the ! at line[i] starts a history expansion
+func expandsAt(line string, i int, inDouble bool) bool {
+	if i+1 >= len(line) {
+		return false
+	}
+	switch line[i+1] {
+	case ' ', '\t', '\n', '\r', '=', '(':
+		return false
+	case '"':
+		return !inDouble
+	}
+	return true
+}
+
+// expandEvent expands the history reference at the start of s, which
+// begins with !, and returns the text and the number of bytes consumed
+func expandEvent(s string, history []CommandEntry) (string, int, error) {
+	event, p, err := findEvent(s, history)
+	if err != nil {
+		return "", 0, err
+	}
+
+	text := event
+
+	// An optional word designator
+	if p < len(s) {
+		start := p
+		if s[p] == ':' && p+1 < len(s) && isWordDesignator(s[p+1]) {
+			start = p + 1
+		}
+		if start > p || strings.IndexByte("^$*", s[p]) >= 0 {
+			selected, n, err := selectWords(s[start:], event)
+			if err != nil {
+				return "", 0, fmt.Errorf("%s: %v", s[:start+n], err)
+			}
+			text = selected
+			p = start + n
+		}
+	}
+
+	// Any number of modifiers
+	for p+1 < len(s) && s[p] == ':' && isModifier(s[p+1]) {
+		modified, n, err := applyModifier(s[p+1:], text)
+		if err != nil {
+			return "", 0, fmt.Errorf("%s: %v", s[:p+1+n], err)
+		}
+		text = modified
+		p += 1 + n
+	}
+
+	return text, p, nil
+}
+
+// findEvent resolves the event designator at the start of s, returning the
+// command it refers to and the number of bytes consumed
+func findEvent(s string, history []CommandEntry) (string, int, error) {
+	notFound := func(designator string) error {
+		return fmt.Errorf("%s: event not found", designator)
+	}
+
+	p := 1
+	switch c := s[p]; {
+	case c == '!':
+		p++
+		if len(history) == 0 {
+			return "", 0, notFound(s[:p])
+		}
+		return history[len(history)-1].Command, p, nil
+
+	case c >= '0' && c <= '9' || c == '-' && p+1 < len(s) && isDigit(s[p+1]):
+		p++
+		for p < len(s) && isDigit(s[p]) {
+			p++
+		}
+		n, err := strconv.Atoi(s[1:p])
+		if err != nil {
+			return "", 0, notFound(s[:p])
+		}
+		index := n - 1
+		if n < 0 {
+			index = len(history) + n
+		}
+		if index < 0 || index >= len(history) {
+			return "", 0, notFound(s[:p])
+		}
+		return history[index].Command, p, nil
+
+	case c == '?':
+		// The closing ? may be omitted at end of line
+		rest := s[p+1:]
+		query := rest
+		p = len(s)
+		if end := strings.IndexAny(rest, "?\n"); end >= 0 {
+			query = rest[:end]
+			p = 2 + end
+			if rest[end] == '?' {
+				p++
+			}
+		}
+		for i := len(history) - 1; i >= 0 && query != ""; i-- {
+			if strings.Contains(history[i].Command, query) {
+				return history[i].Command, p, nil
+			}
+		}
+		return "", 0, notFound(s[:p])
+
+	case strings.IndexByte(":^$*", c) >= 0:
+		// A word designator alone refers to the previous command
+		if len(history) == 0 {
+			return "", 0, notFound(s[:p+1])
+		}
+		return history[len(history)-1].Command, p, nil
+	}
+
+	end := strings.IndexAny(s[p:], " \t\n:;&|<>()'\"`")
+	if end < 0 {
+		end = len(s) - p
+	}
+	prefix := s[p : p+end]
+	p += end
+	for i := len(history) - 1; i >= 0; i-- {
+		if strings.HasPrefix(history[i].Command, prefix) {
+			return history[i].Command, p, nil
+		}
+	}
+	return "", 0, notFound(s[:p])
+}
+
+// isWordDesignator reports whether c can start a word designator after a colon
+func isWordDesignator(c byte) bool {
+	return isDigit(c) || strings.IndexByte("^$*-", c) >= 0
+}
+
+// isModifier reports whether c is a supported history modifier
+func isModifier(c byte) bool {
+	return strings.IndexByte("htresg", c) >= 0
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// selectWords applies the word designator at the start of s to command and
+// returns the selected words and the number of bytes consumed
+func selectWords(s string, command string) (string, int, error) {
+	words := historyWords(command)
+	last := len(words) - 1
+
+	// number parses a word number or $ at s[p]
+	number := func(p int) (int, int, bool) {
+		if p < len(s) && s[p] == '$' {
+			return last, p + 1, true
+		}
+		end := p
+		for end < len(s) && isDigit(s[end]) {
+			end++
+		}
+		if end == p {
+			return 0, p, false
+		}
+		n, err := strconv.Atoi(s[p:end])
+		return n, end, err == nil
+	}
+
+	var from, to, p int
+	switch s[0] {
+	case '^':
+		from, to, p = 1, 1, 1
+	case '*':
+		// All arguments, which may be none
+		if last < 1 {
+			return "", 1, nil
+		}
+		from, to, p = 1, last, 1
+	case '-':
+		n, end, ok := number(1)
+		if !ok {
+			n, end = last-1, 1
+		}
+		from, to, p = 0, n, end
+	default:
+		n, end, ok := number(0)
+		if !ok {
+			return "", 0, fmt.Errorf("bad word specifier")
+		}
+		from, to, p = n, n, end
+		if p < len(s) && s[p] == '*' {
+			// x* abbreviates x-$ and may be empty
+			if from > last {
+				return "", p + 1, nil
+			}
+			to, p = last, p+1
+		} else if p < len(s) && s[p] == '-' {
+			if n, end, ok := number(p + 1); ok {
+				to, p = n, end
+			} else {
+				// x- abbreviates x-$ without the last word
+				to, p = last-1, p+1
+			}
+		}
+	}
+
+	if from < 0 || to > last || from > to {
+		return "", p, fmt.Errorf("bad word specifier")
+	}
+	return strings.Join(words[from:to+1], " "), p, nil
+}
+
+// historyWords splits a command into words the way history expansion sees
+// them: quoted strings stay whole and shell operators are words of their own
+func historyWords(command string) []string {
+	var words []string
+	var word strings.Builder
+	quote := byte(0)
+
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote != 0:
+			word.WriteByte(c)
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(command) {
+				i++
+				word.WriteByte(command[i])
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			word.WriteByte(c)
+		case c == '\\' && i+1 < len(command):
+			word.WriteByte(c)
+			i++
+			word.WriteByte(command[i])
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case strings.IndexByte("|&;<>()", c) >= 0:
+			flush()
+			// Doubled operators such as && and >> are one word
+			word.WriteByte(c)
+			if i+1 < len(command) && command[i+1] == c && c != '(' && c != ')' {
+				i++
+				word.WriteByte(c)
+			}
+			flush()
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+
+	return words
+}
+
+// applyModifier applies the modifier at the start of s to text and returns
+// the result and the number of bytes consumed
+func applyModifier(s string, text string) (string, int, error) {
+	switch s[0] {
+	case 'h':
+		// Remove the trailing pathname component
+		if i := strings.LastIndex(text, "/"); i > 0 {
+			return text[:i], 1, nil
+		} else if i == 0 {
+			return "/", 1, nil
+		}
+		return text, 1, nil
+	case 't':
+		// Keep only the trailing pathname component
+		return text[strings.LastIndex(text, "/")+1:], 1, nil
+	case 'r':
+		// Remove a trailing .suffix
+		if i := strings.LastIndex(text, "."); i > strings.LastIndex(text, "/") {
+			return text[:i], 1, nil
+		}
+		return text, 1, nil
+	case 'e':
+		// Keep only the trailing .suffix
+		if i := strings.LastIndex(text, "."); i > strings.LastIndex(text, "/") {
+			return text[i:], 1, nil
+		}
+		return "", 1, nil
+	case 'g':
+		if len(s) < 2 || s[1] != 's' {
+			return "", 1, fmt.Errorf("unrecognized history modifier")
+		}
+		result, n, err := substitute(s[1:], text, true)
+		return result, n + 1, err
+	case 's':
+		return substitute(s, text, false)
+	}
+	return "", 1, fmt.Errorf("unrecognized history modifier")
+}
+
+// substitute applies an s/old/new/ modifier at the start of s. Any
+// character may be used as the delimiter, a backslash quotes it, & in new
+// stands for old, and the final delimiter may be omitted at end of line.
+func substitute(s string, text string, global bool) (string, int, error) {
+	if len(s) < 2 {
+		return "", len(s), fmt.Errorf("substitution failed")
+	}
+	delim := s[1]
+	p := 2
+
+	// field reads up to the next unquoted delimiter
+	field := func(replacement string) string {
+		var b strings.Builder
+		for p < len(s) && s[p] != delim && s[p] != '\n' {
+			switch {
+			case s[p] == '\\' && p+1 < len(s) && (s[p+1] == delim || s[p+1] == '&'):
+				p++
+				b.WriteByte(s[p])
+			case s[p] == '&' && replacement != "":
+				b.WriteString(replacement)
+			default:
+				b.WriteByte(s[p])
+			}
+			p++
+		}
+		if p < len(s) && s[p] == delim {
+			p++
+		}
+		return b.String()
+	}
+
+	old := field("")
+	replacement := field(old)
+	if old == "" || !strings.Contains(text, old) {
+		return "", p, fmt.Errorf("substitution failed")
+	}
+
+	if global {
+		return strings.ReplaceAll(text, old, replacement), p, nil
+	}
+	return strings.Replace(text, old, replacement, 1), p, nil
+}
+
+// expandInput applies history expansion to a line read by a terminal
+// reader. The expanded line is echoed so the user sees what will run; a
+// failed expansion is reported and the line discarded, as bash does.
+func expandInput(history HistoryManager, input string, out io.Writer, eol string) string {
+	if !strings.Contains(input, "!") && !strings.HasPrefix(input, "^") {
+		return input
+	}
+
+	expanded, ok, err := ExpandHistory(input, history.GetHistory())
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "budy: %v%s", err, eol)
+		return ""
+	}
+	if ok {
+		_, _ = fmt.Fprintf(out, "Executing: %s%s", expanded, eol)
+	}
+	return expanded
+}
@@ -0,0 +1,200 @@
+package shell
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestExpandHistory tests bash-style history expansion
+func TestExpandHistory(t *testing.T) {
+	var history []CommandEntry
+	for _, command := range []string{
+		"ls -la /tmp",                             // 1
+		"git commit -m 'fix the bug' --amend",     // 2
+		"cd /usr/local/lib",                       // 3
+		"tar xzf archive.tar.gz -C /opt",          // 4
+		"cat a.txt b.txt | grep foo && echo done", // 5
+		"echo one two three",                      // 6
+	} {
+		history = append(history, CommandEntry{Command: command})
+	}
+	for i := 7; i <= 12; i++ {
+		history = append(history, CommandEntry{Command: "make target" + strings.Repeat("x", i-7)})
+	}
+	// Entry 12 is "make targetxxxxx"
+	history = append(history, CommandEntry{Command: "vim /etc/hosts.conf"}) // 13
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		// Event designators
+		{"Previous", "!!", "vim /etc/hosts.conf"},
+		{"PreviousInLine", "sudo !!", "sudo vim /etc/hosts.conf"},
+		{"Number", "!1", "ls -la /tmp"},
+		{"MultiDigit", "!12", "make targetxxxxx"},
+		{"Relative", "!-1", "vim /etc/hosts.conf"},
+		{"RelativeMultiDigit", "!-12", "git commit -m 'fix the bug' --amend"},
+		{"Prefix", "!cd", "cd /usr/local/lib"},
+		{"PrefixNewest", "!make", "make targetxxxxx"},
+		{"PrefixThenText", "!cd; pwd", "cd /usr/local/lib; pwd"},
+		{"Substring", "!?archive?", "tar xzf archive.tar.gz -C /opt"},
+		{"SubstringUnclosed", "!?grep", "cat a.txt b.txt | grep foo && echo done"},
+		{"SubstringThenText", "!?local? -v", "cd /usr/local/lib -v"},
+
+		// Word designators
+		{"LastWord", "cat !$", "cat /etc/hosts.conf"},
+		{"FirstArgument", "echo !^", "echo /etc/hosts.conf"},
+		{"AllArguments", "echo !6:*", "echo one two three"},
+		{"AllArgumentsShort", "echo !*", "echo /etc/hosts.conf"},
+		{"WordZero", "!6:0 hi", "echo hi"},
+		{"WordNumber", "!6:2", "two"},
+		{"WordRange", "!6:1-2", "one two"},
+		{"WordRangeToLast", "!6:2-$", "two three"},
+		{"WordStar", "!6:2*", "two three"},
+		{"WordDash", "!6:1-", "one two"},
+		{"LeadingDash", "!6:-1", "echo one"},
+		{"QuotedWord", "!2:3", "'fix the bug'"},
+		{"OperatorWords", "!5:4", "grep"},
+		{"OperatorWord", "!5:3", "|"},
+		{"PrefixWithWord", "!tar:$", "/opt"},
+		{"DoubleBang", "!!:0", "vim"},
+		{"LastOfNumber", "!4$", "/opt"},
+
+		// Modifiers
+		{"Head", "cd !3:$:h", "cd /usr/local"},
+		{"Tail", "echo !3:$:t", "echo lib"},
+		{"RemoveSuffix", "echo !4:2:r", "echo archive.tar"},
+		{"Suffix", "echo !4:2:e", "echo .gz"},
+		{"Chained", "echo !4:2:r:r", "echo archive"},
+		{"Substitute", "!6:s/two/2/", "echo one 2 three"},
+		{"SubstituteDelimiter", "!3:s|local|share|", "cd /usr/share/lib"},
+		{"SubstituteAmpersand", "!6:s/one/&&/", "echo oneone two three"},
+		{"SubstituteGlobal", "!5:gs/txt/md/", "cat a.md b.md | grep foo && echo done"},
+		{"SubstituteUnclosed", "!6:s/three/3", "echo one two 3"},
+
+		// Quick substitution
+		{"Quick", "^hosts^passwd^", "vim /etc/passwd.conf"},
+		{"QuickUnclosed", "^vim^less", "less /etc/hosts.conf"},
+		{"QuickTrailing", "^vim^less^ -N", "less /etc/hosts.conf -N"},
+
+		// Left alone
+		{"NoBang", "echo hello", "echo hello"},
+		{"BangSpace", "echo hi ! there", "echo hi ! there"},
+		{"BangEnd", "echo hi!", "echo hi!"},
+		{"BangEquals", "[ a != b ]", "[ a != b ]"},
+		{"BangParen", "echo !(x)", "echo !(x)"},
+		{"SingleQuoted", "echo '!!'", "echo '!!'"},
+		{"Escaped", "echo \\!!", "echo \\!!"},
+		{"DoubleQuoted", "echo \"!!\"", "echo \"vim /etc/hosts.conf\""},
+		{"DoubleQuoteEnd", "echo \"hi!\"", "echo \"hi!\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _, err := ExpandHistory(tt.input, history)
+			if err != nil {
+				t.Fatalf("Error expanding %q: %v", tt.input, err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+// TestExpandHistoryErrors tests expansions that fail
+func TestExpandHistoryErrors(t *testing.T) {
+	history := []CommandEntry{{Command: "echo one two"}, {Command: "ls"}}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"NumberTooLarge", "!12", "!12: event not found"},
+		{"NumberZero", "!0", "!0: event not found"},
+		{"RelativeTooFar", "!-3", "!-3: event not found"},
+		{"NoPrefix", "!git", "!git: event not found"},
+		{"NoSubstring", "!?zzz?", "!?zzz?: event not found"},
+		{"BadWord", "!1:5", "!1:5: bad word specifier"},
+		{"BadCaret", "!2:^", "!2:^: bad word specifier"},
+		{"SubstituteFailed", "^foo^bar", "substitution failed"},
+		{"BadModifier", "!1:gx", "unrecognized history modifier"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, expanded, err := ExpandHistory(tt.input, history)
+			if err == nil {
+				t.Fatalf("Expected an error expanding %q, got %q", tt.input, result)
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %q", tt.expected, err.Error())
+			}
+			if expanded {
+				t.Errorf("Expected no expansion on error")
+			}
+		})
+	}
+
+	// Nothing to recall in an empty history
+	if _, _, err := ExpandHistory("!!", nil); err == nil {
+		t.Error("Expected an error expanding !! with no history")
+	}
+	if _, _, err := ExpandHistory("!$", nil); err == nil {
+		t.Error("Expected an error expanding !$ with no history")
+	}
+}
+
+// TestHistoryWords tests how commands are split into words
+func TestHistoryWords(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{"ls -la", []string{"ls", "-la"}},
+		{"  spaced   out  ", []string{"spaced", "out"}},
+		{`echo "a b" 'c d' e\ f`, []string{"echo", `"a b"`, "'c d'", `e\ f`}},
+		{"a|b&&c;d", []string{"a", "|", "b", "&&", "c", ";", "d"}},
+		{"cat <in >>out", []string{"cat", "<", "in", ">>", "out"}},
+		{`echo "say \"hi\""`, []string{"echo", `"say \"hi\""`}},
+	}
+
+	for _, tt := range tests {
+		words := historyWords(tt.command)
+		if strings.Join(words, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Errorf("historyWords(%q): expected %q, got %q", tt.command, tt.expected, words)
+		}
+	}
+}
+
+// TestExpandInput tests expansion as the terminal readers apply it
+func TestExpandInput(t *testing.T) {
+	history := NewMockHistoryManager([]string{"ls -la", "echo test"})
+
+	var out bytes.Buffer
+	if result := expandInput(history, "!1", &out, "\n"); result != "ls -la" {
+		t.Errorf("Expected 'ls -la', got %q", result)
+	}
+	if out.String() != "Executing: ls -la\n" {
+		t.Errorf("Expected the expansion to be echoed, got %q", out.String())
+	}
+
+	// A failed expansion is reported and the line discarded
+	out.Reset()
+	if result := expandInput(history, "!nope", &out, "\n"); result != "" {
+		t.Errorf("Expected an empty line, got %q", result)
+	}
+	if out.String() != "budy: !nope: event not found\n" {
+		t.Errorf("Expected an error message, got %q", out.String())
+	}
+
+	// Lines without expansions are returned quietly
+	out.Reset()
+	if result := expandInput(history, "echo hi!", &out, "\n"); result != "echo hi!" || out.Len() != 0 {
+		t.Errorf("Expected the line unchanged and no output, got %q and %q", result, out.String())
+	}
+}
@@ -1,9 +1,14 @@
 package shell
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/sosadtsia/budy/internal/redact"
 	"github.com/sosadtsia/budy/internal/storage"
 )
 
@@ -12,61 +17,286 @@ type CommandEntry struct {
 	Command   string    `json:"command"`
 	Timestamp time.Time `json:"timestamp"`
 	Directory string    `json:"directory"`
+
+	// Session identifies the budy session that ran the command
+	Session string `json:"session,omitempty"`
+
+	// Result is nil for entries recorded before results were tracked
+	Result *ExecResult `json:"result,omitempty"`
+
+	// Source tells where the command came from when the user didn't type
+	// it, such as SourceAI
+	Source string `json:"source,omitempty"`
 }
 
-// FileHistoryManager implements the HistoryManager interface using file storage
+// SourceAI marks commands generated by the AI and accepted by the user
+const SourceAI = "ai"
+
+// DefaultHistoryLimit is the number of entries kept when history is compacted
+const DefaultHistoryLimit = 50000
+
+// FileHistoryManager implements the HistoryManager interface using file
+// storage. When the storage supports journals, each command is appended
+// as a single line to history.jsonl instead of rewriting the whole file,
+// which lets several budy sessions share one history safely.
 type FileHistoryManager struct {
 	storage storage.Storage
+	journal *storage.Journal
 	history []CommandEntry
+	session string
+
+	// shared merges commands from other live sessions into this one as
+	// they are recorded, like zsh's SHARE_HISTORY
+	shared bool
+
+	// redactor masks secrets in commands before they are recorded
+	redactor *redact.Redactor
+
+	// limit is the number of entries kept by compaction, and lines the
+	// number of lines currently in the journal
+	limit int
+	lines int
+
+	// offset is how far the journal has been read, and file identifies
+	// the journal that was read so a compacted replacement is noticed
+	offset int64
+	file   os.FileInfo
 }
 
 // Ensure FileHistoryManager implements the HistoryManager interface
 var _ HistoryManager = (*FileHistoryManager)(nil)
 
 // NewHistoryManager creates a new history manager
-func NewHistoryManager(storage storage.Storage) *FileHistoryManager {
-	// Load existing history from storage
-	var history []CommandEntry
-	if err := storage.Load("history", &history); err != nil {
-		// If error, start with empty history
-		history = []CommandEntry{}
+func NewHistoryManager(store storage.Storage) *FileHistoryManager {
+	return newHistoryManager(store, DefaultHistoryLimit)
+}
+
+// newHistoryManager creates a history manager that keeps limit entries
+func newHistoryManager(store storage.Storage, limit int) *FileHistoryManager {
+	h := &FileHistoryManager{
+		storage:  store,
+		history:  []CommandEntry{},
+		session:  newSessionID(),
+		redactor: redact.Default(),
+		limit:    limit,
 	}
 
-	return &FileHistoryManager{
-		storage: storage,
-		history: history,
+	journalStore, ok := store.(storage.JournalStorage)
+	if !ok {
+		// Load existing history from storage
+		if err := store.Load("history", &h.history); err != nil {
+			// If error, start with empty history
+			h.history = []CommandEntry{}
+		}
+		return h
 	}
+
+	h.journal = journalStore.Journal("history")
+	if !h.journal.Exists() {
+		h.migrate(journalStore)
+	}
+	h.load()
+
+	// Compact right away if the journal grew past its limit last session
+	if h.needsCompaction() {
+		_ = h.compact()
+	}
+
+	return h
 }
 
-// RecordCommand adds a command to history
-func (h *FileHistoryManager) RecordCommand(command string) error {
-	// Get current directory
-	dir, err := os.Getwd()
+// newSessionID returns a random identifier for this budy session
+func newSessionID() string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.Itoa(os.Getpid())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// SessionID returns the identifier recorded with this session's commands
+func (h *FileHistoryManager) SessionID() string {
+	return h.session
+}
+
+// SetShared sets whether commands recorded by other live sessions are
+// merged into this session's history as they happen
+func (h *FileHistoryManager) SetShared(shared bool) {
+	h.shared = shared
+}
+
+// SetRedactor sets what masks secrets in recorded commands, such as one
+// with the user's own patterns
+func (h *FileHistoryManager) SetRedactor(redactor *redact.Redactor) {
+	h.redactor = redactor
+}
+
+// migrate converts a legacy history.json file into the journal
+func (h *FileHistoryManager) migrate(store storage.JournalStorage) {
+	var legacy []CommandEntry
+	if err := store.Load("history", &legacy); err != nil || len(legacy) == 0 {
+		return
+	}
+
+	if err := h.journal.Rewrite(entriesToValues(legacy)); err != nil {
+		return
+	}
+
+	// Keep the old file around as a backup, but stop loading it
+	_ = store.Archive("history")
+}
+
+// load replaces the in-memory history with the journal contents
+func (h *FileHistoryManager) load() {
+	h.history, h.lines, h.offset = h.readJournal()
+	h.file, _ = h.journal.Stat()
+}
+
+// readJournal reads all entries from the journal, skipping damaged lines
+func (h *FileHistoryManager) readJournal() ([]CommandEntry, int, int64) {
+	entries := []CommandEntry{}
+	offset, lines, _ := h.journal.ReadFrom(0, func(line []byte) error {
+		var entry CommandEntry
+		if err := json.Unmarshal(line, &entry); err == nil {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, lines, offset
+}
+
+// refresh merges commands other sessions have appended to the journal
+// since it was last read
+func (h *FileHistoryManager) refresh() {
+	if h.journal == nil || !h.shared {
+		return
+	}
+
+	info, err := h.journal.Stat()
 	if err != nil {
-		dir = "" // Use empty string if we can't get the directory
+		return
+	}
+
+	// Another session compacted the journal; start over from the new file
+	if h.file == nil || !os.SameFile(h.file, info) || info.Size() < h.offset {
+		h.load()
+		return
+	}
+
+	offset, lines, _ := h.journal.ReadFrom(h.offset, func(line []byte) error {
+		var entry CommandEntry
+		if err := json.Unmarshal(line, &entry); err == nil && entry.Session != h.session {
+			h.insert(entry)
+		}
+		return nil
+	})
+	h.offset = offset
+	h.lines += lines
+}
+
+// insert adds an entry from another session in timestamp order. Such
+// entries are almost always newer than everything else, so the search
+// starts from the end.
+func (h *FileHistoryManager) insert(entry CommandEntry) {
+	i := len(h.history)
+	for i > 0 && h.history[i-1].Timestamp.After(entry.Timestamp) {
+		i--
+	}
+	h.history = append(h.history, CommandEntry{})
+	copy(h.history[i+1:], h.history[i:])
+	h.history[i] = entry
+}
+
+// needsCompaction reports whether the journal holds enough surplus lines
+// to be worth rewriting. Allowing half the limit as slack keeps the cost
+// of compaction amortized to a constant per recorded command.
+func (h *FileHistoryManager) needsCompaction() bool {
+	return h.lines > h.limit+h.limit/2
+}
+
+// compact trims history to the limit and rewrites the journal. The
+// journal is re-read under its lock so commands other sessions recorded
+// are kept.
+func (h *FileHistoryManager) compact() error {
+	err := h.journal.Compact(func() ([]interface{}, error) {
+		entries, _, _ := h.readJournal()
+		if len(entries) > h.limit {
+			trimmed := make([]CommandEntry, h.limit)
+			copy(trimmed, entries[len(entries)-h.limit:])
+			entries = trimmed
+		}
+		h.history = entries
+		return entriesToValues(entries), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	h.lines = len(h.history)
+	h.file, _ = h.journal.Stat()
+	if h.file != nil {
+		h.offset = h.file.Size()
 	}
+	return nil
+}
+
+// RecordCommand adds a command to history
+func (h *FileHistoryManager) RecordCommand(command string) error {
+	return h.RecordEntry(CommandEntry{Command: command})
+}
 
-	// Create history entry
-	entry := CommandEntry{
-		Command:   command,
-		Timestamp: time.Now(),
-		Directory: dir,
+// RecordEntry adds a history entry, filling in the timestamp, directory
+// and session when they are not set. Secrets in the command and its error
+// output are masked first.
+func (h *FileHistoryManager) RecordEntry(entry CommandEntry) error {
+	entry.Command = h.redactor.Redact(entry.Command)
+	if entry.Result != nil && entry.Result.StderrTail != "" {
+		result := *entry.Result
+		result.StderrTail = h.redactor.Redact(result.StderrTail)
+		entry.Result = &result
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if entry.Directory == "" {
+		// Use empty string if we can't get the directory
+		entry.Directory, _ = os.Getwd()
 	}
+	if entry.Session == "" {
+		entry.Session = h.session
+	}
+
+	// Pick up other sessions' commands first so this one lands after them
+	h.refresh()
 
 	// Add to history
 	h.history = append(h.history, entry)
 
-	// Save to storage
-	return h.storage.Save("history", h.history)
+	if h.journal == nil {
+		// Save to storage
+		return h.storage.Save("history", h.history)
+	}
+
+	if err := h.journal.Append(entry); err != nil {
+		return err
+	}
+	h.lines++
+
+	if h.needsCompaction() {
+		return h.compact()
+	}
+	return nil
 }
 
 // GetHistory returns the command history
 func (h *FileHistoryManager) GetHistory() []CommandEntry {
+	h.refresh()
 	return h.history
 }
 
 // GetRecentCommands returns the n most recent commands
 func (h *FileHistoryManager) GetRecentCommands(n int) []CommandEntry {
+	h.refresh()
 	if len(h.history) <= n {
 		return h.history
 	}
@@ -75,6 +305,8 @@ func (h *FileHistoryManager) GetRecentCommands(n int) []CommandEntry {
 
 // GetDirectoryCommands returns commands executed in the current directory
 func (h *FileHistoryManager) GetDirectoryCommands() []CommandEntry {
+	h.refresh()
+
 	dir, err := os.Getwd()
 	if err != nil {
 		return []CommandEntry{}
@@ -88,3 +320,12 @@ func (h *FileHistoryManager) GetDirectoryCommands() []CommandEntry {
 	}
 	return dirCommands
 }
+
+// entriesToValues converts history entries for writing to a journal
+func entriesToValues(entries []CommandEntry) []interface{} {
+	values := make([]interface{}, len(entries))
+	for i := range entries {
+		values[i] = entries[i]
+	}
+	return values
+}
@@ -1,7 +1,16 @@
 package shell
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/sosadtsia/budy/internal/redact"
+	"github.com/sosadtsia/budy/internal/storage"
 )
 
 // MockStorage is a simple in-memory storage implementation for testing
@@ -94,3 +103,358 @@ func TestHistoryManager(t *testing.T) {
 		t.Errorf("History commands in wrong order: %v", all)
 	}
 }
+
+// TestHistoryManagerRecordEntry tests recording entries with results
+func TestHistoryManagerRecordEntry(t *testing.T) {
+	history := NewHistoryManager(NewMockStorage())
+
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	result := &ExecResult{ExitCode: 2, Duration: time.Second, StderrBytes: 10}
+	if err := history.RecordEntry(CommandEntry{
+		Command:   "make test",
+		Timestamp: started,
+		Directory: "/src",
+		Result:    result,
+	}); err != nil {
+		t.Fatalf("Error recording entry: %v", err)
+	}
+
+	entry := history.GetHistory()[0]
+	if !entry.Timestamp.Equal(started) || entry.Directory != "/src" {
+		t.Errorf("Explicit timestamp or directory was overwritten: %+v", entry)
+	}
+	if entry.Result == nil || entry.Result.ExitCode != 2 {
+		t.Errorf("Expected result with exit code 2, got %+v", entry.Result)
+	}
+
+	// Missing fields are filled in
+	if err := history.RecordCommand("ls"); err != nil {
+		t.Fatalf("Error recording command: %v", err)
+	}
+	entry = history.GetHistory()[1]
+	if entry.Timestamp.IsZero() || entry.Directory == "" {
+		t.Errorf("Expected timestamp and directory to be filled in: %+v", entry)
+	}
+	if entry.Result != nil {
+		t.Errorf("Expected no result, got %+v", entry.Result)
+	}
+}
+
+// TestHistoryManagerRedaction tests that secrets are masked before they
+// are recorded
+func TestHistoryManagerRedaction(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewFileStorageWithDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	history := NewHistoryManager(store)
+
+	command := `curl -H "Authorization: Bearer abc123def456ghi789" https://api.example.com`
+	result := &ExecResult{ExitCode: 1, StderrTail: "export API_TOKEN=hunter2hunter2 failed"}
+	if err := history.RecordEntry(CommandEntry{Command: command, Result: result}); err != nil {
+		t.Fatalf("Error recording entry: %v", err)
+	}
+
+	redactor, err := redact.New([]string{`ACME-[0-9]{6}`})
+	if err != nil {
+		t.Fatalf("Failed to create redactor: %v", err)
+	}
+	history.SetRedactor(redactor)
+	if err := history.RecordCommand("ticket ACME-123456"); err != nil {
+		t.Fatalf("Error recording command: %v", err)
+	}
+
+	entries := NewHistoryManager(store).GetHistory()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Command != `curl -H "Authorization: Bearer [REDACTED]" https://api.example.com` {
+		t.Errorf("Expected the token to be masked, got %q", entries[0].Command)
+	}
+	if entries[0].Result.StderrTail != "export API_TOKEN=[REDACTED] failed" {
+		t.Errorf("Expected the error output to be masked, got %q", entries[0].Result.StderrTail)
+	}
+	if result.StderrTail != "export API_TOKEN=hunter2hunter2 failed" {
+		t.Errorf("Expected the caller's result to be left alone, got %q", result.StderrTail)
+	}
+	if entries[1].Command != "ticket [REDACTED]" {
+		t.Errorf("Expected the custom pattern to be masked, got %q", entries[1].Command)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "history.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if strings.Contains(string(data), "abc123def456") || strings.Contains(string(data), "hunter2") {
+		t.Errorf("Expected no secrets on disk, got %s", data)
+	}
+}
+
+// TestHistoryManagerLegacyFile tests loading history written before
+// command results were recorded and before the journal format
+func TestHistoryManagerLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `[{"command":"ls -la","timestamp":"2025-01-02T03:04:05Z","directory":"/tmp"}]`
+	if err := os.WriteFile(filepath.Join(dir, "history.json"), []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write legacy history: %v", err)
+	}
+
+	store, err := storage.NewFileStorageWithDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	history := NewHistoryManager(store)
+
+	entries := history.GetHistory()
+	if len(entries) != 1 || entries[0].Command != "ls -la" || entries[0].Directory != "/tmp" {
+		t.Fatalf("Legacy history not loaded: %+v", entries)
+	}
+
+	// The legacy file is migrated into the journal and kept as a backup
+	if _, err := os.Stat(filepath.Join(dir, "history.jsonl")); err != nil {
+		t.Errorf("Expected history.jsonl after migration: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "history.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected history.json to be moved away, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "history.json.bak")); err != nil {
+		t.Errorf("Expected history.json.bak after migration: %v", err)
+	}
+	if entries[0].Result != nil {
+		t.Errorf("Expected nil result for legacy entry, got %+v", entries[0].Result)
+	}
+
+	// New entries with results are written alongside the old ones
+	if err := history.RecordEntry(CommandEntry{Command: "false", Result: &ExecResult{ExitCode: 1}}); err != nil {
+		t.Fatalf("Error recording entry: %v", err)
+	}
+	reloaded := NewHistoryManager(store).GetHistory()
+	if len(reloaded) != 2 || reloaded[1].Result == nil || reloaded[1].Result.ExitCode != 1 {
+		t.Errorf("Result not persisted: %+v", reloaded)
+	}
+}
+
+// newJournalHistory creates a history manager backed by a temporary directory
+func newJournalHistory(t testing.TB, dir string) *FileHistoryManager {
+	t.Helper()
+	store, err := storage.NewFileStorageWithDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return NewHistoryManager(store)
+}
+
+// TestHistoryManagerJournal tests that commands are appended one per line
+func TestHistoryManagerJournal(t *testing.T) {
+	dir := t.TempDir()
+	history := newJournalHistory(t, dir)
+
+	for i := 0; i < 3; i++ {
+		if err := history.RecordCommand(fmt.Sprintf("cmd %d", i)); err != nil {
+			t.Fatalf("Error recording command: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "history.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("Expected 3 journal lines, got %d", lines)
+	}
+
+	// Simulate a crash in the middle of an append
+	file, err := os.OpenFile(filepath.Join(dir, "history.jsonl"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	if _, err := file.WriteString(`{"command":"half`); err != nil {
+		t.Fatalf("Failed to write partial entry: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to close journal: %v", err)
+	}
+
+	reloaded := newJournalHistory(t, dir).GetHistory()
+	if len(reloaded) != 3 || reloaded[2].Command != "cmd 2" {
+		t.Errorf("Expected 3 intact entries after a partial write, got %+v", reloaded)
+	}
+}
+
+// TestHistoryManagerCompaction tests trimming the journal to the limit
+func TestHistoryManagerCompaction(t *testing.T) {
+	dir := t.TempDir()
+	history := newJournalHistory(t, dir)
+	history.limit = 10
+
+	for i := 0; i < 16; i++ {
+		if err := history.RecordCommand(fmt.Sprintf("cmd %d", i)); err != nil {
+			t.Fatalf("Error recording command: %v", err)
+		}
+	}
+
+	// The 16th command pushes the journal past limit + limit/2
+	entries := history.GetHistory()
+	if len(entries) != 10 || entries[0].Command != "cmd 6" || entries[9].Command != "cmd 15" {
+		t.Errorf("Expected the last 10 commands after compaction, got %d entries starting %q", len(entries), entries[0].Command)
+	}
+	if history.lines != 10 {
+		t.Errorf("Expected 10 journal lines after compaction, got %d", history.lines)
+	}
+
+	reloaded := newJournalHistory(t, dir).GetHistory()
+	if len(reloaded) != 10 || reloaded[0].Command != "cmd 6" {
+		t.Errorf("Compacted journal not persisted: %d entries", len(reloaded))
+	}
+}
+
+// TestHistoryManagerSessions tests several sessions sharing one history
+func TestHistoryManagerSessions(t *testing.T) {
+	dir := t.TempDir()
+	first := newJournalHistory(t, dir)
+	second := newJournalHistory(t, dir)
+
+	if first.SessionID() == "" || first.SessionID() == second.SessionID() {
+		t.Fatalf("Expected distinct session IDs, got %q and %q", first.SessionID(), second.SessionID())
+	}
+
+	if err := first.RecordCommand("make build"); err != nil {
+		t.Fatalf("Error recording command: %v", err)
+	}
+	if err := second.RecordCommand("go test ./..."); err != nil {
+		t.Fatalf("Error recording command: %v", err)
+	}
+
+	// Without sharing, each session only sees its own new commands
+	if entries := first.GetHistory(); len(entries) != 1 || entries[0].Session != first.SessionID() {
+		t.Errorf("Expected only the first session's command, got %+v", entries)
+	}
+
+	// With sharing, commands from the other session are merged in order
+	first.SetShared(true)
+	second.SetShared(true)
+	entries := first.GetHistory()
+	if len(entries) != 2 || entries[1].Command != "go test ./..." || entries[1].Session != second.SessionID() {
+		t.Fatalf("Expected the second session's command to be merged, got %+v", entries)
+	}
+
+	if err := first.RecordCommand("git push"); err != nil {
+		t.Fatalf("Error recording command: %v", err)
+	}
+	recent := second.GetRecentCommands(1)
+	if len(recent) != 1 || recent[0].Command != "git push" {
+		t.Errorf("Expected !! in the second session to see 'git push', got %+v", recent)
+	}
+	if len(second.GetHistory()) != 3 || len(first.GetHistory()) != 3 {
+		t.Errorf("Expected both sessions to see 3 commands, got %d and %d",
+			len(second.GetHistory()), len(first.GetHistory()))
+	}
+
+	// Compaction in one session keeps the other session's commands and the
+	// other session notices the replaced journal
+	first.limit = 2
+	if err := first.compact(); err != nil {
+		t.Fatalf("Error compacting: %v", err)
+	}
+	if err := second.RecordCommand("ls"); err != nil {
+		t.Fatalf("Error recording command: %v", err)
+	}
+	entries = first.GetHistory()
+	if len(entries) != 3 || entries[0].Command != "go test ./..." || entries[2].Command != "ls" {
+		t.Errorf("Unexpected history after compaction: %+v", entries)
+	}
+}
+
+// TestHistoryManagerConcurrentSessions tests that concurrent appends from
+// several sessions are all kept intact
+func TestHistoryManagerConcurrentSessions(t *testing.T) {
+	dir := t.TempDir()
+	const sessions, commands = 4, 50
+
+	var wg sync.WaitGroup
+	for s := 0; s < sessions; s++ {
+		history := newJournalHistory(t, dir)
+		history.limit = 60
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for i := 0; i < commands; i++ {
+				if err := history.RecordCommand(fmt.Sprintf("session %d command %d", s, i)); err != nil {
+					t.Errorf("Error recording command: %v", err)
+					return
+				}
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	// Every line must be a complete entry, and compaction must not have
+	// dropped anything newer than the limit
+	entries := newHistoryManager(mustFileStorage(t, dir), 1000).GetHistory()
+	if len(entries) < 60 {
+		t.Fatalf("Expected at least 60 entries, got %d", len(entries))
+	}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if seen[entry.Command] {
+			t.Errorf("Duplicate entry %q", entry.Command)
+		}
+		seen[entry.Command] = true
+	}
+	for s := 0; s < sessions; s++ {
+		last := fmt.Sprintf("session %d command %d", s, commands-1)
+		if !seen[last] {
+			t.Errorf("Missing most recent command %q", last)
+		}
+	}
+}
+
+// mustFileStorage creates a file storage in dir
+func mustFileStorage(t *testing.T, dir string) *storage.FileStorage {
+	t.Helper()
+	store, err := storage.NewFileStorageWithDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return store
+}
+
+// BenchmarkRecordCommand shows that recording a command costs the same
+// regardless of how much history already exists
+func BenchmarkRecordCommand(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("entries=%d", size), func(b *testing.B) {
+			dir := b.TempDir()
+			store, err := storage.NewFileStorageWithDir(dir)
+			if err != nil {
+				b.Fatalf("Failed to create storage: %v", err)
+			}
+
+			entries := make([]CommandEntry, size)
+			for i := range entries {
+				entries[i] = CommandEntry{
+					Command:   fmt.Sprintf("go test ./... -run Test%d", i),
+					Timestamp: time.Now(),
+					Directory: "/src/project",
+				}
+			}
+			if err := store.Journal("history").Rewrite(entriesToValues(entries)); err != nil {
+				b.Fatalf("Failed to seed history: %v", err)
+			}
+
+			// Keep compaction out of the measurement
+			history := newHistoryManager(store, size+b.N)
+			if len(history.GetHistory()) != size {
+				b.Fatalf("Expected %d entries, got %d", size, len(history.GetHistory()))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := history.RecordCommand("git status"); err != nil {
+					b.Fatalf("Error recording command: %v", err)
+				}
+			}
+		})
+	}
+}
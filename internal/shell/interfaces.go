@@ -2,12 +2,13 @@ package shell
 
 // Executor defines the interface for shell command execution
 type Executor interface {
-	Execute(command string) error
+	Execute(command string) (*ExecResult, error)
 }
 
 // HistoryManager defines the interface for command history management
 type HistoryManager interface {
 	RecordCommand(command string) error
+	RecordEntry(entry CommandEntry) error
 	GetHistory() []CommandEntry
 	GetRecentCommands(n int) []CommandEntry
 	GetDirectoryCommands() []CommandEntry
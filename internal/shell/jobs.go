@@ -0,0 +1,477 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	JobRunning = "running"
+	JobStopped = "stopped"
+	JobDone    = "done"
+)
+
+// JobLogMaxAge is how long the output logs of background jobs are kept
+const JobLogMaxAge = 7 * 24 * time.Hour
+
+// Job is a command budy keeps track of while reading other commands: one
+// started in the background with a trailing &, or one stopped with Ctrl-Z
+type Job struct {
+	ID      int
+	Command string
+	Dir     string
+	Started time.Time
+	State   string
+
+	// Log is the file a background command's output goes to. It is empty
+	// for stopped commands, which keep writing where they did before.
+	Log string
+
+	// Result is set once the job is done
+	Result *ExecResult
+
+	cmd *exec.Cmd
+	pid int
+
+	// The output counters of a command started in the foreground
+	stdout *countingWriter
+	stderr *countingWriter
+	tail   *tailBuffer
+}
+
+// Status describes the job the way shells list jobs, such as "Running",
+// "Exit 2" or "Terminated"
+func (j Job) Status() string {
+	switch {
+	case j.State == JobRunning:
+		return "Running"
+	case j.State == JobStopped:
+		return "Stopped"
+	case j.Result == nil || j.Result.Success():
+		return "Done"
+	case j.Result.Signal != "":
+		return strings.ToUpper(j.Result.Signal[:1]) + j.Result.Signal[1:]
+	}
+	return fmt.Sprintf("Exit %d", j.Result.ExitCode)
+}
+
+// String formats the job the way the jobs builtin lists it
+func (j Job) String() string {
+	return j.format(' ')
+}
+
+// format formats the job with a marker after its number, + for the
+// current job
+func (j Job) format(marker byte) string {
+	return fmt.Sprintf("[%d]%c %-12s %s", j.ID, marker, j.Status(), j.Command)
+}
+
+// result describes how the job finished
+func (j *Job) result(exit *processExit) *ExecResult {
+	result := &ExecResult{
+		ExitCode:    exit.code,
+		Signal:      exit.signal,
+		Duration:    time.Since(j.Started),
+		StdoutBytes: -1,
+		Job:         j.ID,
+	}
+
+	switch {
+	case j.Log != "":
+		// Output and errors share the log, so its end is what's kept
+		if info, err := os.Stat(j.Log); err == nil {
+			result.StdoutBytes = info.Size()
+		}
+		if !result.Success() {
+			result.StderrTail = readTail(j.Log, StderrTailSize)
+		}
+	default:
+		result.StderrBytes = -1
+		if j.stdout != nil {
+			result.StdoutBytes = j.stdout.n
+		}
+		if j.stderr != nil {
+			result.StderrBytes = j.stderr.n
+		}
+		if !result.Success() && j.tail != nil {
+			result.StderrTail = j.tail.String()
+		}
+	}
+	return result
+}
+
+// jobTable keeps track of an executor's jobs until they are done and
+// reported
+type jobTable struct {
+	mu   sync.Mutex
+	jobs []*Job
+
+	// changed is closed and replaced whenever a job changes state
+	changed chan struct{}
+
+	// dir is where the output of background jobs is logged
+	dir string
+}
+
+// newJobTable creates an empty job table
+func newJobTable() *jobTable {
+	return &jobTable{
+		changed: make(chan struct{}),
+		dir:     filepath.Join(os.TempDir(), "budy-jobs"),
+	}
+}
+
+// add gives job the next free number and watches its process until it
+// exits
+func (t *jobTable) add(job *Job) {
+	t.mu.Lock()
+	job.ID = 1
+	for _, other := range t.jobs {
+		if other.ID >= job.ID {
+			job.ID = other.ID + 1
+		}
+	}
+	t.jobs = append(t.jobs, job)
+	t.mu.Unlock()
+
+	go func() {
+		for {
+			state, exit := waitJob(job)
+			t.setState(job, state, exit)
+			if state == JobDone {
+				return
+			}
+		}
+	}()
+}
+
+// setState records a job's new state and wakes whoever waits for it
+func (t *jobTable) setState(job *Job, state string, exit *processExit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	job.State = state
+	if state == JobDone {
+		job.Result = job.result(exit)
+	}
+	t.notify()
+}
+
+// notify wakes whoever waits for a job to change state. The caller holds
+// the lock.
+func (t *jobTable) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// list returns a copy of every job, in order of their numbers
+func (t *jobTable) list() []Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	jobs := make([]Job, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+// current returns the number of the job that fg, bg and kill act on when
+// none is given: the one added last
+func (t *jobTable) current() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.jobs) == 0 {
+		return 0
+	}
+	return t.jobs[len(t.jobs)-1].ID
+}
+
+// running returns the jobs that are running
+func (t *jobTable) running() []*Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var running []*Job
+	for _, job := range t.jobs {
+		if job.State == JobRunning {
+			running = append(running, job)
+		}
+	}
+	return running
+}
+
+// finished removes the jobs that are done from the table and returns them
+func (t *jobTable) finished() []Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var done []Job
+	kept := t.jobs[:0]
+	for _, job := range t.jobs {
+		if job.State == JobDone {
+			done = append(done, *job)
+		} else {
+			kept = append(kept, job)
+		}
+	}
+	t.jobs = kept
+	sort.Slice(done, func(i, j int) bool { return done[i].ID < done[j].ID })
+	return done
+}
+
+// find returns the job named by spec: %n for job n, %prefix for the
+// unfinished job whose command starts with prefix, or %%, %+ or nothing
+// for the current job
+func (t *jobTable) find(spec string) (*Job, error) {
+	if spec == "" || spec == "%%" || spec == "%+" {
+		if id := t.current(); id > 0 {
+			spec = fmt.Sprintf("%%%d", id)
+		} else {
+			return nil, fmt.Errorf("no current job")
+		}
+	}
+	if !strings.HasPrefix(spec, "%") || len(spec) == 1 {
+		return nil, fmt.Errorf("%s: not a job; name jobs as %%n", spec)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name := spec[1:]
+	var found *Job
+	for _, job := range t.jobs {
+		if fmt.Sprint(job.ID) == name {
+			return job, nil
+		}
+		if job.State != JobDone && strings.HasPrefix(job.Command, name) {
+			if found != nil {
+				return nil, fmt.Errorf("%s: more than one job matches", spec)
+			}
+			found = job
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+// wait blocks until done reports true for job, or ctx is cancelled, and
+// returns a copy of the job as it was then
+func (t *jobTable) wait(ctx context.Context, job *Job, done func(Job) bool) (Job, error) {
+	for {
+		t.mu.Lock()
+		snapshot, changed := *job, t.changed
+		t.mu.Unlock()
+		if done(snapshot) {
+			return snapshot, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return snapshot, ctx.Err()
+		}
+	}
+}
+
+// resume marks a stopped job as running and continues it
+func (t *jobTable) resume(job *Job) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job.State != JobStopped {
+		return nil
+	}
+	if err := continueJob(job); err != nil {
+		return err
+	}
+	job.State = JobRunning
+	t.notify()
+	return nil
+}
+
+// signal sends sig to a job that isn't done yet
+func (t *jobTable) signal(job *Job, sig os.Signal) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job.State == JobDone {
+		return fmt.Errorf("job has already finished")
+	}
+	continued, err := signalJob(job, sig)
+	if continued {
+		job.State = JobRunning
+		t.notify()
+	}
+	return err
+}
+
+// hangUp ends the stopped jobs, as a shell does when it exits. Jobs still
+// running are left to finish, logging their output.
+func (t *jobTable) hangUp() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, job := range t.jobs {
+		if job.State == JobStopped {
+			hangUp(job.pid)
+		}
+	}
+}
+
+// backgroundCommand reports whether command ends with a lone &, which runs
+// the whole line as a background job, and returns it without the &
+func backgroundCommand(command string) (string, bool) {
+	words := historyWords(command)
+	if len(words) < 2 || words[len(words)-1] != "&" {
+		return command, false
+	}
+	trimmed := strings.TrimRight(command, " \t\n")
+	return strings.TrimRight(trimmed[:len(trimmed)-1], " \t"), true
+}
+
+// startJob runs command in the background with its output going to a new
+// log file, and adds it to the job table as line
+func (e *ShellExecutor) startJob(command, line string) (*Job, error) {
+	started := time.Now()
+	if err := os.MkdirAll(e.jobs.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create job log directory: %w", err)
+	}
+	log, err := os.CreateTemp(e.jobs.dir, started.Format("20060102-150405-")+"*.log")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job log: %w", err)
+	}
+	// The command has its own copy of the file once started
+	defer log.Close()
+
+	// Background jobs read nothing; a nil Stdin is the null device
+	cmd := exec.Command(e.shell, "-c", command)
+	cmd.Dir = e.session.Dir()
+	cmd.Env = e.session.Environ()
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := startJob(cmd); err != nil {
+		_ = os.Remove(log.Name())
+		return nil, err
+	}
+
+	job := &Job{
+		Command: line,
+		Dir:     cmd.Dir,
+		Started: started,
+		State:   JobRunning,
+		Log:     log.Name(),
+		cmd:     cmd,
+		pid:     cmd.Process.Pid,
+	}
+	e.jobs.add(job)
+	return job, nil
+}
+
+// foreground continues a job with the terminal given to it, copying what
+// it logs to the output, until it exits or is stopped again
+func (e *ShellExecutor) foreground(job *Job) (Job, error) {
+	stdin := e.stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	restore := giveTerminal(stdin, job.pid)
+	defer restore()
+	stop := forwardSignals(job.pid)
+	defer stop()
+	if job.Log != "" {
+		stopFollowing := followLog(job.Log, e.output())
+		defer stopFollowing()
+	}
+
+	if err := e.jobs.resume(job); err != nil {
+		return Job{}, err
+	}
+	return e.jobs.wait(context.Background(), job, func(j Job) bool {
+		return j.State != JobRunning
+	})
+}
+
+// followLog copies what is appended to the file at path to w until the
+// returned function is called
+func followLog(path string, w io.Writer) func() {
+	file, err := os.Open(path)
+	if err != nil {
+		return func() {}
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			_, _ = io.Copy(w, file)
+			select {
+			case <-ticker.C:
+			case <-done:
+				// Copy what was written since the last tick
+				_, _ = io.Copy(w, file)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		file.Close()
+	}
+}
+
+// readTail returns at most the last size bytes of the file at path
+func readTail(path string, size int64) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.Size() > size {
+		if _, err := file.Seek(-size, io.SeekEnd); err != nil {
+			return ""
+		}
+	}
+	data, _ := io.ReadAll(file)
+	return string(data)
+}
+
+// pruneJobLogs removes job logs in dir older than maxAge
+func pruneJobLogs(dir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		if time.Since(info.ModTime()) > maxAge {
+			_ = os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackgroundCommand(t *testing.T) {
+	tests := []struct {
+		command    string
+		expected   string
+		background bool
+	}{
+		{"sleep 10 &", "sleep 10", true},
+		{"make  & ", "make", true},
+		{"cd src && make >build.log 2>&1 &", "cd src && make >build.log 2>&1", true},
+		{"sleep 10", "sleep 10", false},
+		{"make && make install", "make && make install", false},
+		{"ls 2>&1", "ls 2>&1", false},
+		{"echo 'a &'", "echo 'a &'", false},
+		{`echo a \&`, `echo a \&`, false},
+		{"&", "&", false},
+	}
+
+	for _, test := range tests {
+		command, background := backgroundCommand(test.command)
+		if command != test.expected || background != test.background {
+			t.Errorf("%q: expected %q, %v, got %q, %v", test.command, test.expected, test.background, command, background)
+		}
+	}
+}
+
+func TestJobStatus(t *testing.T) {
+	tests := []struct {
+		job      Job
+		expected string
+	}{
+		{Job{State: JobRunning}, "Running"},
+		{Job{State: JobStopped}, "Stopped"},
+		{Job{State: JobDone, Result: &ExecResult{}}, "Done"},
+		{Job{State: JobDone, Result: &ExecResult{ExitCode: 2}}, "Exit 2"},
+		{Job{State: JobDone, Result: &ExecResult{ExitCode: -1, Signal: "terminated"}}, "Terminated"},
+	}
+
+	for _, test := range tests {
+		if status := test.job.Status(); status != test.expected {
+			t.Errorf("Expected %q for %+v, got %q", test.expected, test.job, status)
+		}
+	}
+
+	job := Job{ID: 3, State: JobRunning, Command: "sleep 10 &"}
+	if expected := "[3]  Running      sleep 10 &"; job.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, job.String())
+	}
+}
+
+func TestPruneJobLogs(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.log")
+	recent := filepath.Join(dir, "recent.log")
+	other := filepath.Join(dir, "notes.txt")
+	for _, path := range []string{old, recent, other} {
+		if err := os.WriteFile(path, []byte("output\n"), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	past := time.Now().Add(-2 * JobLogMaxAge)
+	for _, path := range []string{old, other} {
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatalf("Failed to age %s: %v", path, err)
+		}
+	}
+
+	pruneJobLogs(dir, JobLogMaxAge)
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("Expected the old log to be removed")
+	}
+	for _, path := range []string{recent, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", path, err)
+		}
+	}
+}
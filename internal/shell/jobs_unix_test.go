@@ -0,0 +1,212 @@
+//go:build unix
+
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newJobExecutor creates an executor that logs job output to a temporary
+// directory and writes its own output to out
+func newJobExecutor(t *testing.T, out *bytes.Buffer) *ShellExecutor {
+	t.Helper()
+	executor := newSignalExecutor()
+	executor.stdout = out
+	executor.stderr = out
+	executor.SetJobDir(t.TempDir())
+	t.Cleanup(func() {
+		for _, job := range executor.Jobs() {
+			if job.State != JobDone {
+				_ = syscall.Kill(-job.pid, syscall.SIGKILL)
+			}
+		}
+	})
+	return executor
+}
+
+// waitForJob waits for job id to be done and returns it
+func waitForJob(t *testing.T, executor *ShellExecutor, id int) Job {
+	t.Helper()
+	return waitForState(t, executor, id, JobDone)
+}
+
+// waitForState waits for job id to reach state and returns it
+func waitForState(t *testing.T, executor *ShellExecutor, id int, state string) Job {
+	t.Helper()
+	job, err := executor.jobs.find(fmt.Sprintf("%%%d", id))
+	if err != nil {
+		t.Fatalf("Failed to find job %d: %v", id, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	found, err := executor.jobs.wait(ctx, job, func(j Job) bool { return j.State == state })
+	if err != nil {
+		t.Fatalf("Expected job %d to be %s, got %+v", id, state, found)
+	}
+	return found
+}
+
+// execute runs a command that is expected to succeed
+func execute(t *testing.T, executor *ShellExecutor, command string) *ExecResult {
+	t.Helper()
+	result, err := executor.Execute(command)
+	if err != nil {
+		t.Fatalf("Error executing %q: %v", command, err)
+	}
+	return result
+}
+
+func TestBackgroundJob(t *testing.T) {
+	var out bytes.Buffer
+	executor := newJobExecutor(t, &out)
+
+	start := time.Now()
+	result := execute(t, executor, `sleep 0.2; echo output; echo failure >&2; exit 3 &`)
+	if result.Job != 1 || time.Since(start) > time.Second {
+		t.Fatalf("Expected the command to start as job 1 at once, got %+v", result)
+	}
+	if !strings.HasPrefix(out.String(), "[1] ") {
+		t.Errorf("Expected the job to be announced, got %q", out.String())
+	}
+
+	job := waitForJob(t, executor, 1)
+	data, err := os.ReadFile(job.Log)
+	if err != nil {
+		t.Fatalf("Failed to read job log: %v", err)
+	}
+	if string(data) != "output\nfailure\n" {
+		t.Errorf("Expected the output to be logged, got %q", data)
+	}
+	if job.Result.ExitCode != 3 || job.Result.Job != 1 || !strings.Contains(job.Result.StderrTail, "failure") {
+		t.Errorf("Expected the job to exit with 3 and keep its errors, got %+v", job.Result)
+	}
+
+	finished := executor.FinishedJobs()
+	if len(finished) != 1 || finished[0].Command != `sleep 0.2; echo output; echo failure >&2; exit 3 &` {
+		t.Fatalf("Expected the job to be reported once, got %+v", finished)
+	}
+	if status := finished[0].Status(); status != "Exit 3" {
+		t.Errorf("Expected status Exit 3, got %q", status)
+	}
+	if len(executor.FinishedJobs()) != 0 || len(executor.Jobs()) != 0 {
+		t.Error("Expected reported jobs to be removed")
+	}
+}
+
+func TestJobBuiltins(t *testing.T) {
+	var out bytes.Buffer
+	executor := newJobExecutor(t, &out)
+
+	// The jobs exec sleep so that stopping them can't catch the shell
+	// between forking and running it
+	execute(t, executor, "exec sleep 10 &")
+	execute(t, executor, "exec sleep 20 &")
+	out.Reset()
+	execute(t, executor, "jobs")
+	expected := "[1]  Running      exec sleep 10 &\n[2]+ Running      exec sleep 20 &\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	// Stopping and continuing a job
+	execute(t, executor, "kill -STOP %1")
+	waitForState(t, executor, 1, JobStopped)
+	execute(t, executor, "bg %1")
+	if job := waitForState(t, executor, 1, JobRunning); job.Status() != "Running" {
+		t.Errorf("Expected job 1 to run again, got %s", job.Status())
+	}
+
+	// Killing jobs by number or command
+	execute(t, executor, "kill %1")
+	if job := waitForJob(t, executor, 1); job.Status() != "Terminated" {
+		t.Errorf("Expected job 1 to be terminated, got %s", job.Status())
+	}
+	execute(t, executor, "kill -s KILL %exec")
+	if job := waitForJob(t, executor, 2); job.Status() != "Killed" {
+		t.Errorf("Expected job 2 to be killed, got %s", job.Status())
+	}
+
+	// Anything else is left to the system kill
+	if result := execute(t, executor, "kill -0 $$"); !result.Success() {
+		t.Errorf("Expected kill with a process ID to run, got %+v", result)
+	}
+
+	errors := []string{"fg", "bg %9", "kill %9", "kill %1 1234", "kill -BOGUS %1", "wait 1234"}
+	executor.FinishedJobs()
+	for _, command := range errors {
+		if _, err := executor.Execute(command); err == nil {
+			t.Errorf("Expected %q to fail", command)
+		}
+	}
+}
+
+func TestWaitBuiltin(t *testing.T) {
+	var out bytes.Buffer
+	executor := newJobExecutor(t, &out)
+
+	execute(t, executor, "sleep 0.2 &")
+	execute(t, executor, "sleep 0.3 &")
+	execute(t, executor, "wait")
+	for _, job := range executor.Jobs() {
+		if job.State != JobDone {
+			t.Errorf("Expected wait to wait for %q, got %s", job.Command, job.State)
+		}
+	}
+
+	// Ctrl-C stops waiting
+	execute(t, executor, "sleep 10 &")
+	done := make(chan error, 1)
+	go func() {
+		_, err := executor.Execute("wait %3")
+		done <- err
+	}()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err == nil || !strings.Contains(err.Error(), "interrupted") {
+				t.Errorf("Expected wait to be interrupted, got %v", err)
+			}
+			return
+		case <-ticker.C:
+			_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected wait to stop after Ctrl-C")
+		}
+	}
+}
+
+func TestForegroundJob(t *testing.T) {
+	var out bytes.Buffer
+	executor := newJobExecutor(t, &out)
+
+	// A background job's output is followed while it is in the foreground
+	execute(t, executor, "sleep 0.3; echo finished &")
+	out.Reset()
+	execute(t, executor, "fg")
+	if out.String() != "sleep 0.3; echo finished &\nfinished\n" {
+		t.Errorf("Expected fg to show the job's output, got %q", out.String())
+	}
+	if job := waitForJob(t, executor, 1); !job.Result.Success() {
+		t.Errorf("Expected the job to succeed, got %+v", job.Result)
+	}
+
+	// A command stopped with Ctrl-Z is continued and can be interrupted
+	runSignalled(t, executor, "sleep 10", syscall.SIGTSTP)
+	result := runSignalled(t, executor, "fg %2", syscall.SIGINT)
+	if !result.Success() {
+		t.Errorf("Expected fg to succeed, got %+v", result)
+	}
+	if job := waitForJob(t, executor, 2); job.Result.Signal != "interrupt" {
+		t.Errorf("Expected the job to be interrupted, got %+v", job.Result)
+	}
+}
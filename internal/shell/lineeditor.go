@@ -0,0 +1,587 @@
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Control keys understood by the line editor
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlG     = 7
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyCtrlJ     = 10
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlR     = 18
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// Keys sent as escape sequences, mapped to values above the Unicode range
+const (
+	keyUp rune = iota + unicode.MaxRune + 1
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	keyUnknown
+)
+
+// LineEditor is a readline-like terminal reader. It puts the terminal in
+// raw mode while a line is being edited and supports cursor movement,
+// history navigation, emacs-style editing keys, incremental reverse
+// history search and Tab completion.
+type LineEditor struct {
+	history   HistoryManager
+	completer Completer
+	in        *bufio.Reader
+	out       io.Writer
+
+	// fd is the input terminal, or -1 when input is not a terminal
+	fd int
+
+	// State of the line being edited
+	prompt  string
+	buf     []rune
+	pos     int
+	entries []CommandEntry
+	index   int
+	saved   []rune
+}
+
+// Ensure LineEditor implements the TerminalReader, TextReader and
+// SecretReader interfaces
+var (
+	_ TerminalReader = (*LineEditor)(nil)
+	_ TextReader     = (*LineEditor)(nil)
+	_ SecretReader   = (*LineEditor)(nil)
+)
+
+// NewLineEditor creates a line editor reading from the terminal on stdin.
+// completer may be nil to disable Tab completion.
+func NewLineEditor(history HistoryManager, completer Completer) *LineEditor {
+	editor := newLineEditor(history, os.Stdin, os.Stdout, int(os.Stdin.Fd()))
+	editor.completer = completer
+	return editor
+}
+
+// newLineEditor creates a line editor on the given streams
+func newLineEditor(history HistoryManager, in io.Reader, out io.Writer, fd int) *LineEditor {
+	return &LineEditor{
+		history: history,
+		in:      bufio.NewReader(in),
+		out:     out,
+		fd:      fd,
+	}
+}
+
+// ReadLine reads a line of input with editing and history support
+func (e *LineEditor) ReadLine(prompt string) (string, error) {
+	if e.fd >= 0 {
+		restore, err := makeRaw(e.fd)
+		if err != nil {
+			return e.readPlain(prompt)
+		}
+		defer func() {
+			_ = restore()
+		}()
+	}
+
+	input, err := e.edit(prompt, "")
+	if err != nil {
+		return "", err
+	}
+
+	return expandInput(e.history, input, e.out, "\r\n"), nil
+}
+
+// ReadLineWithText reads a line that starts out as text, with the cursor
+// at its end. The line is returned as entered, without history expansion.
+func (e *LineEditor) ReadLineWithText(prompt, text string) (string, error) {
+	if e.fd >= 0 {
+		restore, err := makeRaw(e.fd)
+		if err != nil {
+			return editPlain(e, prompt, text)
+		}
+		defer func() {
+			_ = restore()
+		}()
+	}
+
+	return e.edit(prompt, text)
+}
+
+// ReadReply reads a line with editing support but without history
+// expansion
+func (e *LineEditor) ReadReply(prompt string) (string, error) {
+	return e.ReadLineWithText(prompt, "")
+}
+
+// ReadSecret reads a line without showing it. Backspace and Ctrl-U still
+// edit it, and Ctrl-C abandons it and returns an empty line.
+func (e *LineEditor) ReadSecret(prompt string) (string, error) {
+	if e.fd >= 0 {
+		restore, err := makeRaw(e.fd)
+		if err != nil {
+			return e.readPlain(prompt)
+		}
+		defer func() {
+			_ = restore()
+		}()
+	}
+
+	e.write(prompt)
+	var secret []rune
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case keyEnter, keyCtrlJ:
+			e.write("\r\n")
+			return string(secret), nil
+		case keyCtrlC:
+			e.write("^C\r\n")
+			return "", nil
+		case keyCtrlD:
+			if len(secret) == 0 {
+				e.write("\r\n")
+				return "", io.EOF
+			}
+		case keyBackspace, keyCtrlH:
+			if len(secret) > 0 {
+				secret = secret[:len(secret)-1]
+			}
+		case keyCtrlU:
+			secret = secret[:0]
+		default:
+			if key >= ' ' && key <= unicode.MaxRune {
+				secret = append(secret, key)
+			}
+		}
+	}
+}
+
+// readPlain reads a line without editing, for when raw mode is unavailable
+func (e *LineEditor) readPlain(prompt string) (string, error) {
+	e.write(prompt)
+	defer whileReading(func() {
+		e.write("\n" + lastLine(prompt))
+	})()
+
+	input, err := e.in.ReadString('\n')
+	if err != nil && (err != io.EOF || input == "") {
+		return "", err
+	}
+	return strings.TrimRight(input, "\r\n"), nil
+}
+
+// edit runs the editing loop until a line is entered, starting from text
+func (e *LineEditor) edit(prompt, text string) (string, error) {
+	e.write(prompt)
+
+	// Only the last line of the prompt is redrawn while editing
+	e.prompt = lastLine(prompt)
+	e.setLine([]rune(text))
+	e.write(text)
+	e.entries = e.history.GetHistory()
+	e.index = len(e.entries)
+	e.saved = nil
+
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+
+		if key == keyCtrlR {
+			if key, err = e.reverseSearch(); err != nil {
+				return "", err
+			}
+		}
+
+		switch key {
+		case keyEnter, keyCtrlJ:
+			e.write("\r\n")
+			return string(e.buf), nil
+
+		case keyCtrlC:
+			// Abandon the line and start over with an empty one
+			e.write("^C\r\n")
+			return "", nil
+
+		case keyCtrlD:
+			if len(e.buf) == 0 {
+				e.write("\r\n")
+				return "", io.EOF
+			}
+			e.deleteForward()
+
+		case keyTab:
+			e.complete()
+
+		default:
+			e.handleKey(key)
+		}
+	}
+}
+
+// lastLine returns the last line of a prompt, which is all that is shown
+// again when the line is redrawn
+func lastLine(prompt string) string {
+	return prompt[strings.LastIndex(prompt, "\n")+1:]
+}
+
+// handleKey applies an editing key to the line
+func (e *LineEditor) handleKey(key rune) {
+	switch key {
+	case keyLeft, keyCtrlB:
+		if e.pos > 0 {
+			e.pos--
+		}
+	case keyRight, keyCtrlF:
+		if e.pos < len(e.buf) {
+			e.pos++
+		}
+	case keyHome, keyCtrlA:
+		e.pos = 0
+	case keyEnd, keyCtrlE:
+		e.pos = len(e.buf)
+	case keyUp, keyCtrlP:
+		e.historyPrev()
+	case keyDown, keyCtrlN:
+		e.historyNext()
+	case keyBackspace, keyCtrlH:
+		if e.pos > 0 {
+			e.buf = append(e.buf[:e.pos-1], e.buf[e.pos:]...)
+			e.pos--
+		}
+	case keyDelete:
+		e.deleteForward()
+	case keyCtrlK:
+		e.buf = e.buf[:e.pos]
+	case keyCtrlU:
+		e.buf = append([]rune{}, e.buf[e.pos:]...)
+		e.pos = 0
+	case keyCtrlW:
+		e.deleteWordBack()
+	case keyCtrlL:
+		e.write("\033[H\033[2J")
+	default:
+		if !unicode.IsPrint(key) {
+			// Ignore other control keys and unknown sequences
+			return
+		}
+		e.insert(key)
+	}
+	e.refresh()
+}
+
+// insert adds a character at the cursor
+func (e *LineEditor) insert(r rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = r
+	e.pos++
+}
+
+// deleteForward deletes the character under the cursor
+func (e *LineEditor) deleteForward() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+	e.refresh()
+}
+
+// deleteWordBack deletes the word before the cursor, along with any
+// whitespace between it and the cursor
+func (e *LineEditor) deleteWordBack() {
+	start := e.pos
+	for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+		start--
+	}
+	e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+	e.pos = start
+}
+
+// historyPrev replaces the line with the previous history entry
+func (e *LineEditor) historyPrev() {
+	if e.index == 0 {
+		return
+	}
+	if e.index == len(e.entries) {
+		// Remember what was typed so Down can bring it back
+		e.saved = append([]rune{}, e.buf...)
+	}
+	e.index--
+	e.setLine([]rune(e.entries[e.index].Command))
+}
+
+// historyNext replaces the line with the next history entry, or with the
+// line being typed once the end of history is reached
+func (e *LineEditor) historyNext() {
+	if e.index >= len(e.entries) {
+		return
+	}
+	e.index++
+	if e.index == len(e.entries) {
+		e.setLine(e.saved)
+		return
+	}
+	e.setLine([]rune(e.entries[e.index].Command))
+}
+
+// setLine replaces the line and moves the cursor to its end
+func (e *LineEditor) setLine(line []rune) {
+	e.buf = append([]rune{}, line...)
+	e.pos = len(e.buf)
+}
+
+// reverseSearch runs an incremental reverse search through history
+// (Ctrl-R). Typing narrows the search, Ctrl-R finds older matches and
+// Ctrl-G or Ctrl-C cancels. Any other key accepts the match and is
+// returned so the caller can act on it, e.g. Enter runs the match.
+func (e *LineEditor) reverseSearch() (rune, error) {
+	original, originalPos := append([]rune{}, e.buf...), e.pos
+	var query []rune
+	match := -1
+	failing := false
+
+	for {
+		e.renderSearch(string(query), match, failing)
+
+		key, err := e.readKey()
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case key == keyCtrlR:
+			if len(query) > 0 && match >= 0 {
+				if next := e.searchBack(string(query), match-1, e.entries[match].Command); next >= 0 {
+					match = next
+				}
+			}
+
+		case key == keyBackspace || key == keyCtrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				match = e.searchBack(string(query), len(e.entries)-1, "")
+				failing = false
+			}
+
+		case key == keyCtrlG || key == keyCtrlC:
+			e.buf, e.pos = original, originalPos
+			e.refresh()
+			return 0, nil
+
+		case unicode.IsPrint(key):
+			query = append(query, key)
+			from := len(e.entries) - 1
+			if match >= 0 {
+				from = match
+			}
+			if next := e.searchBack(string(query), from, ""); next >= 0 {
+				match = next
+				failing = false
+			} else {
+				failing = true
+			}
+
+		default:
+			if match >= 0 {
+				e.setLine([]rune(e.entries[match].Command))
+				e.index = match
+			}
+			e.refresh()
+			if key == keyEscape {
+				return 0, nil
+			}
+			return key, nil
+		}
+	}
+}
+
+// searchBack returns the index of the newest history entry at or before
+// from that contains query and differs from skip, or -1
+func (e *LineEditor) searchBack(query string, from int, skip string) int {
+	if query == "" {
+		return -1
+	}
+	for i := from; i >= 0; i-- {
+		command := e.entries[i].Command
+		if command != skip && strings.Contains(command, query) {
+			return i
+		}
+	}
+	return -1
+}
+
+// renderSearch draws the reverse search prompt
+func (e *LineEditor) renderSearch(query string, match int, failing bool) {
+	label := "reverse-i-search"
+	if failing {
+		label = "failing " + label
+	}
+	found := ""
+	if match >= 0 {
+		found = e.entries[match].Command
+	}
+	e.write(fmt.Sprintf("\r(%s)`%s': %s\033[K", label, query, found))
+}
+
+// complete replaces the word before the cursor with its completion. A
+// single candidate is inserted in full; several are narrowed to their
+// common prefix, or listed below the line when there is nothing to add.
+func (e *LineEditor) complete() {
+	if e.completer == nil {
+		e.write("\a")
+		return
+	}
+
+	line := string(e.buf[:e.pos])
+	candidates, start := e.completer.Complete(line, len(line))
+	if len(candidates) == 0 || start < 0 || start > len(line) {
+		e.write("\a")
+		return
+	}
+
+	insert := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(insert, "/") {
+		insert += " "
+	}
+
+	if insert == line[start:] && len(candidates) > 1 {
+		// Nothing more to complete, so show the choices
+		e.write("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+		e.refresh()
+		return
+	}
+	if len(insert) < len(line[start:]) {
+		// Never shorten what was typed
+		e.write("\a")
+		return
+	}
+
+	head := []rune(line[:start] + insert)
+	e.buf = append(head, e.buf[e.pos:]...)
+	e.pos = len(head)
+	e.refresh()
+}
+
+// refresh redraws the current line and places the cursor
+func (e *LineEditor) refresh() {
+	var b strings.Builder
+	b.WriteString("\r")
+	b.WriteString(e.prompt)
+	b.WriteString(string(e.buf))
+	b.WriteString("\033[K")
+	if n := len(e.buf) - e.pos; n > 0 {
+		fmt.Fprintf(&b, "\033[%dD", n)
+	}
+	e.write(b.String())
+}
+
+// write writes terminal output, ignoring errors as there is nowhere to
+// report them
+func (e *LineEditor) write(s string) {
+	_, _ = io.WriteString(e.out, s)
+}
+
+// readKey reads a single key press, decoding escape sequences
+func (e *LineEditor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if r != keyEscape {
+		return r, nil
+	}
+
+	// A lone Escape arrives by itself; sequences arrive all at once
+	if e.in.Buffered() == 0 {
+		return keyEscape, nil
+	}
+
+	next, err := e.in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch next {
+	case 'O':
+		final, err := e.in.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return decodeSequence("", final), nil
+
+	case '[':
+		// Read parameters up to the final byte of the control sequence
+		var params strings.Builder
+		for {
+			b, err := e.in.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if b >= 0x40 && b <= 0x7e {
+				return decodeSequence(params.String(), b), nil
+			}
+			params.WriteByte(b)
+		}
+	}
+
+	// Alt-modified keys are not bound to anything
+	return keyUnknown, nil
+}
+
+// decodeSequence maps an escape sequence's parameters and final byte to a key
+func decodeSequence(params string, final byte) rune {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "3":
+			return keyDelete
+		}
+	}
+	return keyUnknown
+}
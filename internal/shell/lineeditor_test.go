@@ -0,0 +1,274 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Escape sequences sent by terminals for special keys
+const (
+	seqUp     = "\x1b[A"
+	seqDown   = "\x1b[B"
+	seqRight  = "\x1b[C"
+	seqLeft   = "\x1b[D"
+	seqHome   = "\x1b[H"
+	seqEnd    = "\x1b[F"
+	seqDelete = "\x1b[3~"
+)
+
+// TestLineEditorKeys tests editing keys
+func TestLineEditorKeys(t *testing.T) {
+	history := []string{"ls -la", "cd /tmp", "echo test"}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Typing", "hello\r", "hello"},
+		{"LineFeed", "hello\n", "hello"},
+		{"LeftInsert", "helo" + seqLeft + "l\r", "hello"},
+		{"RightMove", "ac" + seqLeft + seqLeft + seqRight + "b\r", "abc"},
+		{"HomeEnd", "world" + seqHome + "hello " + seqEnd + "!\r", "hello world!"},
+		{"HomeEndTilde", "b\x1b[1~a\x1b[4~c\r", "abc"},
+		{"HomeEndSS3", "b\x1bOHa\x1bOFc\r", "abc"},
+		{"CtrlACtrlE", "world\x01hello \x05!\r", "hello world!"},
+		{"CtrlBCtrlF", "ac\x02\x02\x06b\r", "abc"},
+		{"Backspace", "helllo\x7f\x7f\x7flo\r", "hello"},
+		{"BackspaceCtrlH", "ab\x08c\r", "ac"},
+		{"Delete", "abxc" + seqLeft + seqLeft + seqDelete + "\r", "abc"},
+		{"CtrlDDeletes", "abxc" + seqLeft + seqLeft + "\x04\r", "abc"},
+		{"CtrlK", "hello world\x01\x06\x06\x06\x06\x06\x0b\r", "hello"},
+		{"CtrlU", "abc def" + seqLeft + seqLeft + seqLeft + "\x15\r", "def"},
+		{"CtrlW", "git commit -m\x17\r", "git commit "},
+		{"CtrlWTrailingSpace", "git commit   \x17\r", "git "},
+		{"HistoryUp", seqUp + "\r", "echo test"},
+		{"HistoryUpTwice", seqUp + seqUp + "\r", "cd /tmp"},
+		{"HistoryTop", seqUp + seqUp + seqUp + seqUp + seqUp + "\r", "ls -la"},
+		{"HistoryDownRestores", "typed" + seqUp + seqUp + seqDown + seqDown + "\r", "typed"},
+		{"HistoryCtrlPCtrlN", "\x10\x10\x0e\r", "echo test"},
+		{"HistoryEdit", seqUp + " -n\r", "echo test -n"},
+		{"Unicode", "héllo" + seqLeft + "→\r", "héll→o"},
+		{"UnknownSequence", "a\x1b[15~b\r", "ab"},
+		{"ControlIgnored", "a\x0fb\r", "ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			editor := newLineEditor(NewMockHistoryManager(history), strings.NewReader(tt.input), &out, -1)
+
+			line, err := editor.ReadLine("> ")
+			if err != nil {
+				t.Fatalf("Error reading line: %v", err)
+			}
+			if line != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, line)
+			}
+		})
+	}
+}
+
+// TestLineEditorReverseSearch tests Ctrl-R incremental search
+func TestLineEditorReverseSearch(t *testing.T) {
+	history := []string{"make build", "git status", "make test", "git log"}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"NewestMatch", "\x12make\r", "make test"},
+		{"OlderMatch", "\x12make\x12\r", "make build"},
+		{"NoOlderMatch", "\x12make\x12\x12\r", "make build"},
+		{"Narrowing", "\x12git s\r", "git status"},
+		{"BackspaceWidens", "\x12git s\x7f\x7f\r", "git log"},
+		{"AcceptAndEdit", "\x12status\x05 -s\r", "git status -s"},
+		{"AcceptWithArrow", "\x12build" + seqLeft + seqLeft + seqLeft + seqLeft + seqLeft + seqLeft + "x\r", "makex build"},
+		{"CancelCtrlG", "abc\x12git\x07\r", "abc"},
+		{"CancelCtrlC", "abc\x12git\x03d\r", "abcd"},
+		{"AcceptAltKey", "\x12log\x1b!\r", "git log"},
+		{"NoMatch", "x\x12zzz\r", "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			editor := newLineEditor(NewMockHistoryManager(history), strings.NewReader(tt.input), &out, -1)
+
+			line, err := editor.ReadLine("> ")
+			if err != nil {
+				t.Fatalf("Error reading line: %v", err)
+			}
+			if line != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, line)
+			}
+		})
+	}
+
+	// The search prompt is shown while searching
+	var out bytes.Buffer
+	editor := newLineEditor(NewMockHistoryManager(history), strings.NewReader("\x12mak\r"), &out, -1)
+	if _, err := editor.ReadLine("> "); err != nil {
+		t.Fatalf("Error reading line: %v", err)
+	}
+	if !strings.Contains(out.String(), "(reverse-i-search)`mak': make test") {
+		t.Errorf("Search prompt not shown: %q", out.String())
+	}
+}
+
+// TestLineEditorSession tests reading several lines and control keys
+func TestLineEditorSession(t *testing.T) {
+	history := NewMockHistoryManager([]string{"ls"})
+	var out bytes.Buffer
+	editor := newLineEditor(history, strings.NewReader("one\rtw\x03two\r!!\r\x04"), &out, -1)
+
+	expected := []string{"one", "", "two", "ls"}
+	for _, want := range expected {
+		line, err := editor.ReadLine("\n> ")
+		if err != nil {
+			t.Fatalf("Error reading line: %v", err)
+		}
+		if line != want {
+			t.Errorf("Expected %q, got %q", want, line)
+		}
+	}
+
+	// Ctrl-D on an empty line ends input
+	if _, err := editor.ReadLine("> "); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	// Only the last prompt line is redrawn while editing
+	if !strings.Contains(out.String(), "\r> one\x1b[K") || strings.Contains(out.String(), "\r\n> one") {
+		t.Errorf("Unexpected prompt redraws: %q", out.String())
+	}
+}
+
+// TestLineEditorReadLineWithText tests editing a line that starts with text
+func TestLineEditorReadLineWithText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		input    string
+		expected string
+	}{
+		{"keep", "ls -l", "\r", "ls -l"},
+		{"append", "ls -l", "a\r", "ls -la"},
+		{"replace", "ls -l", "\x15pwd\r", "pwd"},
+		{"no expansion", "echo !!", "\r", "echo !!"},
+		{"cancel", "rm -rf build", "\x03", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			editor := newLineEditor(NewMockHistoryManager([]string{"ls"}), strings.NewReader(tt.input), &out, -1)
+
+			line, err := editor.ReadLineWithText("edit> ", tt.text)
+			if err != nil {
+				t.Fatalf("Error reading line: %v", err)
+			}
+			if line != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, line)
+			}
+			if !strings.HasPrefix(out.String(), "edit> "+tt.text) {
+				t.Errorf("Expected the text after the prompt, got %q", out.String())
+			}
+		})
+	}
+}
+
+// TestLineEditorReadSecret tests reading a line without showing it
+func TestLineEditorReadSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"typed", "sk-secret\r", "sk-secret"},
+		{"backspace", "sk-secreX\x7ft\r", "sk-secret"},
+		{"clear", "wrong\x15sk-secret\r", "sk-secret"},
+		{"no expansion", "abc!!\r", "abc!!"},
+		{"cancel", "sk-sec\x03", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			editor := newLineEditor(NewMockHistoryManager([]string{"ls"}), strings.NewReader(tt.input), &out, -1)
+
+			secret, err := ReadSecret(editor, "Key: ")
+			if err != nil {
+				t.Fatalf("Error reading secret: %v", err)
+			}
+			if secret != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, secret)
+			}
+			if strings.Contains(out.String(), "sec") {
+				t.Errorf("Expected the secret not to be shown, got %q", out.String())
+			}
+		})
+	}
+}
+
+// TestLineEditorTab tests Tab completion
+func TestLineEditorTab(t *testing.T) {
+	completer := CompleterFunc(func(line string, pos int) ([]string, int) {
+		_, word, start := wordAt(line, pos)
+		var candidates []string
+		for _, choice := range []string{"status", "stash", "src/", "log"} {
+			if strings.HasPrefix(choice, word) {
+				candidates = append(candidates, choice)
+			}
+		}
+		return candidates, start
+	})
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		output   string
+	}{
+		{"Single", "git l\t\r", "git log ", ""},
+		{"Directory", "cd sr\t\r", "cd src/", ""},
+		{"CommonPrefix", "git s\t\r", "git s", "status  stash  src/"},
+		{"ExtendPrefix", "git st\t\r", "git sta", ""},
+		{"ListThenComplete", "git sta\tt\t\r", "git status ", "status  stash"},
+		{"NoMatch", "git x\t\r", "git x", "\a"},
+		{"MidLine", "git l --oneline" + seqHome + "\x06\x06\x06\x06\x06\t\r", "git log  --oneline", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			editor := newLineEditor(NewMockHistoryManager(nil), strings.NewReader(tt.input), &out, -1)
+			editor.completer = completer
+
+			line, err := editor.ReadLine("> ")
+			if err != nil {
+				t.Fatalf("Error reading line: %v", err)
+			}
+			if line != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, line)
+			}
+			if !strings.Contains(out.String(), tt.output) {
+				t.Errorf("Expected output to contain %q, got %q", tt.output, out.String())
+			}
+		})
+	}
+
+	// Without a completer Tab just rings the bell
+	var out bytes.Buffer
+	editor := newLineEditor(NewMockHistoryManager(nil), strings.NewReader("ls\t\r"), &out, -1)
+	line, err := editor.ReadLine("> ")
+	if err != nil {
+		t.Fatalf("Error reading line: %v", err)
+	}
+	if line != "ls" || !strings.Contains(out.String(), "\a") {
+		t.Errorf("Expected %q and a bell, got %q and %q", "ls", line, out.String())
+	}
+}
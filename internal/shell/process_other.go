@@ -0,0 +1,94 @@
+//go:build !unix
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// shellSignals are the signals budy catches for the whole session, so that
+// Ctrl-C at the prompt doesn't end it
+var shellSignals = []os.Signal{os.Interrupt}
+
+// runForeground runs cmd and waits for it to exit. Process groups and
+// stopping commands aren't supported on this platform.
+func runForeground(cmd *exec.Cmd) (*processExit, error) {
+	err := cmd.Run()
+	if cmd.ProcessState == nil {
+		return nil, err
+	}
+
+	return exitStatus(cmd.ProcessState), nil
+}
+
+// exitStatus describes how a process that has been waited for exited
+func exitStatus(state *os.ProcessState) *processExit {
+	exit := &processExit{code: state.ExitCode()}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		exit.signal = status.Signal().String()
+	}
+	return exit
+}
+
+// forwardSignals does nothing; the console delivers Ctrl-C to commands
+// itself on this platform
+func forwardSignals(pid int) func() {
+	return func() {}
+}
+
+// startJob starts a background job's command
+func startJob(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+// waitJob waits until a job's process exits. Jobs can't be stopped on this
+// platform.
+func waitJob(job *Job) (string, *processExit) {
+	_ = job.cmd.Wait()
+	if job.cmd.ProcessState == nil {
+		return JobDone, &processExit{code: -1}
+	}
+	return JobDone, exitStatus(job.cmd.ProcessState)
+}
+
+// continueJob fails; jobs can't be stopped on this platform
+func continueJob(job *Job) error {
+	return fmt.Errorf("stopping and continuing jobs isn't supported on this platform")
+}
+
+// signalJob sends sig to a job's process. Only killing it is supported on
+// this platform, and jobs are never stopped, so none is continued.
+func signalJob(job *Job, sig os.Signal) (bool, error) {
+	return false, job.cmd.Process.Signal(sig)
+}
+
+// giveTerminal does nothing; the console is shared on this platform
+func giveTerminal(r io.Reader, pgid int) func() {
+	return func() {}
+}
+
+// parseSignal returns the signal named like KILL, SIGKILL or 9
+func parseSignal(name string) (os.Signal, error) {
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "KILL", "9":
+		return os.Kill, nil
+	case "INT", "2":
+		return os.Interrupt, nil
+	case "TERM", "15":
+		return syscall.SIGTERM, nil
+	}
+	return nil, fmt.Errorf("unknown signal %s", name)
+}
+
+// hangUp kills a stopped process; commands can't be stopped on this
+// platform, so there are none
+func hangUp(pid int) {
+	if process, err := os.FindProcess(pid); err == nil {
+		_ = process.Kill()
+	}
+}
@@ -0,0 +1,233 @@
+//go:build unix
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// shellSignals are the signals budy catches for the whole session, so that
+// Ctrl-C and Ctrl-Z at the prompt don't end or suspend it
+var shellSignals = []os.Signal{os.Interrupt, syscall.SIGTSTP}
+
+// runForeground starts cmd in a process group of its own and waits until
+// it exits or is stopped. When cmd reads from the terminal budy is using,
+// the group is given the terminal while it runs so that Ctrl-C and Ctrl-Z
+// reach the command rather than budy, and the terminal is taken back and
+// its settings restored afterwards. Signals sent to budy itself meanwhile
+// are passed on to the command.
+func runForeground(cmd *exec.Cmd) (*processExit, error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	tty := foregroundTerminal(cmd.Stdin)
+	if tty >= 0 {
+		// budy must be able to take the terminal back from the background
+		signal.Ignore(syscall.SIGTTOU)
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = tty
+		restore := saveTerminal(tty)
+		defer func() {
+			_ = setTerminalGroup(tty, syscall.Getpgrp())
+			restore()
+		}()
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	stop := forwardSignals(pid)
+	defer stop()
+
+	status, err := waitProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	if status.Stopped() {
+		return &processExit{code: -1, signal: status.StopSignal().String(), stopped: true}, nil
+	}
+
+	awaitOutput(cmd)
+	return exitStatus(status), nil
+}
+
+// awaitOutput waits for the output of a command whose process has been
+// reaped to be copied, for at most cmd.WaitDelay if processes it left
+// behind keep the output open. The process is released first so that
+// cmd.Wait doesn't wait for it again: without a pidfd that could reap
+// another child of budy, such as a job, given the same process ID.
+func awaitOutput(cmd *exec.Cmd) {
+	_ = cmd.Process.Release()
+	_ = cmd.Wait()
+}
+
+// exitStatus describes how a process that has been waited for exited
+func exitStatus(status syscall.WaitStatus) *processExit {
+	if status.Signaled() {
+		return &processExit{code: -1, signal: status.Signal().String()}
+	}
+	return &processExit{code: status.ExitStatus()}
+}
+
+// startJob starts a background job's command in a process group of its
+// own, away from the terminal
+func startJob(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd.Start()
+}
+
+// waitJob waits until a job's process stops or exits. Once it has exited
+// the job's output has been copied.
+func waitJob(job *Job) (string, *processExit) {
+	status, err := waitProcess(job.pid)
+	if err != nil {
+		return JobDone, &processExit{code: -1}
+	}
+	if status.Stopped() {
+		return JobStopped, nil
+	}
+	awaitOutput(job.cmd)
+	return JobDone, exitStatus(status)
+}
+
+// continueJob lets a stopped job run again
+func continueJob(job *Job) error {
+	return syscall.Kill(-job.pid, syscall.SIGCONT)
+}
+
+// signalJob sends sig to every process in a job. A stopped job is
+// continued as well so that it can act on the signal; it reports whether
+// it was.
+func signalJob(job *Job, sig os.Signal) (bool, error) {
+	if err := syscall.Kill(-job.pid, sig.(syscall.Signal)); err != nil {
+		return false, err
+	}
+	if job.State != JobStopped || sig == syscall.SIGKILL || sig == syscall.SIGSTOP || sig == syscall.SIGTSTP {
+		return false, nil
+	}
+	return true, continueJob(job)
+}
+
+// giveTerminal makes pgid the foreground process group of the terminal r
+// reads from, when budy is in its foreground, and returns a function that
+// takes the terminal back and restores its settings
+func giveTerminal(r io.Reader, pgid int) func() {
+	tty := foregroundTerminal(r)
+	if tty < 0 {
+		return func() {}
+	}
+	signal.Ignore(syscall.SIGTTOU)
+	restore := saveTerminal(tty)
+	_ = setTerminalGroup(tty, pgid)
+	return func() {
+		_ = setTerminalGroup(tty, syscall.Getpgrp())
+		restore()
+	}
+}
+
+// signalNames maps the names kill accepts, without their SIG prefix, to
+// signals
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"CONT": syscall.SIGCONT,
+	"STOP": syscall.SIGSTOP,
+	"TSTP": syscall.SIGTSTP,
+}
+
+// parseSignal returns the signal named like HUP, SIGHUP or 1
+func parseSignal(name string) (os.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signal %s", name)
+}
+
+// waitProcess waits for a child to exit or stop
+func waitProcess(pid int) (syscall.WaitStatus, error) {
+	var status syscall.WaitStatus
+	for {
+		_, err := syscall.Wait4(pid, &status, syscall.WUNTRACED, nil)
+		if err != syscall.EINTR {
+			return status, err
+		}
+	}
+}
+
+// forwardSignals passes SIGINT, SIGTERM and SIGTSTP sent to budy on to a
+// process group until the returned function is called
+func forwardSignals(pgid int) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGTSTP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				_ = syscall.Kill(-pgid, sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// hangUp ends a stopped process group the way a shell does when it exits
+func hangUp(pgid int) {
+	_ = syscall.Kill(-pgid, syscall.SIGHUP)
+	_ = syscall.Kill(-pgid, syscall.SIGCONT)
+}
+
+// foregroundTerminal returns the descriptor of the terminal r reads from
+// when budy is in its foreground, or -1
+func foregroundTerminal(r io.Reader) int {
+	file, ok := r.(*os.File)
+	if !ok {
+		return -1
+	}
+	fd := int(file.Fd())
+	pgrp, err := terminalGroup(fd)
+	if err != nil || pgrp != syscall.Getpgrp() {
+		return -1
+	}
+	return fd
+}
+
+// terminalGroup returns the foreground process group of the terminal fd
+func terminalGroup(fd int) (int, error) {
+	var pgrp int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
+
+// setTerminalGroup makes pgrp the foreground process group of the
+// terminal fd
+func setTerminalGroup(fd, pgrp int) error {
+	group := int32(pgrp)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&group))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+//go:build unix
+
+package shell
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runSignalled runs a command and sends sig to the test process until the
+// command returns, as when budy is interrupted while a command runs
+func runSignalled(t *testing.T, executor *ShellExecutor, command string, sig syscall.Signal) *ExecResult {
+	t.Helper()
+
+	// Keep the signal from ending or stopping the test itself
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, sig)
+	defer signal.Stop(caught)
+
+	done := make(chan *ExecResult, 1)
+	go func() {
+		result, err := executor.Execute(command)
+		if err != nil {
+			t.Errorf("Error executing %q: %v", command, err)
+		}
+		done <- result
+	}()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case result := <-done:
+			return result
+		case <-ticker.C:
+			_ = syscall.Kill(os.Getpid(), sig)
+		case <-deadline:
+			t.Fatalf("Expected %q to end after %v", command, sig)
+		}
+	}
+}
+
+// newSignalExecutor creates an executor that doesn't read from a terminal
+func newSignalExecutor() *ShellExecutor {
+	executor := NewExecutorWithShell("sh")
+	executor.stdin = strings.NewReader("")
+	executor.stdout = io.Discard
+	executor.stderr = io.Discard
+	return executor
+}
+
+func TestForwardSignals(t *testing.T) {
+	tests := []struct {
+		sig      syscall.Signal
+		expected string
+	}{
+		{syscall.SIGINT, "interrupt"},
+		{syscall.SIGTERM, "terminated"},
+	}
+
+	for _, test := range tests {
+		executor := newSignalExecutor()
+		start := time.Now()
+		result := runSignalled(t, executor, "sleep 10", test.sig)
+		if result.Signal != test.expected {
+			t.Errorf("Expected sleep to end with %q after %v, got %+v", test.expected, test.sig, result)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("Expected sleep to be interrupted, took %v", time.Since(start))
+		}
+
+		// budy keeps running commands afterwards
+		var out bytes.Buffer
+		executor.stdout = &out
+		if _, err := executor.Execute("echo still here"); err != nil || out.String() != "still here\n" {
+			t.Errorf("Expected the executor to keep working, got %q, %v", out.String(), err)
+		}
+	}
+}
+
+func TestStoppedCommand(t *testing.T) {
+	executor := newSignalExecutor()
+	result := runSignalled(t, executor, "sleep 10", syscall.SIGTSTP)
+	if result.Signal != syscall.SIGTSTP.String() || result.Success() {
+		t.Fatalf("Expected sleep to be stopped, got %+v", result)
+	}
+	jobs := executor.Jobs()
+	if len(jobs) != 1 || result.Job != jobs[0].ID || jobs[0].State != JobStopped {
+		t.Fatalf("Expected sleep to become a stopped job, got %+v", jobs)
+	}
+
+	// Exiting hangs up the stopped command
+	executor.Close()
+	job := waitForJob(t, executor, jobs[0].ID)
+	if job.Result.Signal != syscall.SIGHUP.String() {
+		t.Errorf("Expected the stopped command to be hung up, got %+v", job.Result)
+	}
+}
+
+func TestProcessGroup(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("Skipping test that needs /proc")
+	}
+
+	var out bytes.Buffer
+	executor := newSignalExecutor()
+	executor.stdout = &out
+	if _, err := executor.Execute(`echo $$ $(cut -d' ' -f5 /proc/$$/stat)`); err != nil {
+		t.Fatalf("Error executing command: %v", err)
+	}
+
+	fields := strings.Fields(out.String())
+	if len(fields) != 2 || fields[0] != fields[1] {
+		t.Errorf("Expected the shell to lead its own process group, got %q", out.String())
+	}
+	if len(fields) == 2 && fields[1] == strconv.Itoa(syscall.Getpgrp()) {
+		t.Errorf("Expected a process group apart from the test's, got %s", fields[1])
+	}
+}
+
+// TestAwaitOutput tests waiting for the output of a reaped process without
+// waiting for the process again
+func TestAwaitOutput(t *testing.T) {
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", "echo output; exit 3")
+	cmd.Stdout = &out
+	cmd.WaitDelay = outputWaitDelay
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+
+	status, err := waitProcess(cmd.Process.Pid)
+	if err != nil || status.ExitStatus() != 3 {
+		t.Fatalf("Expected exit status 3, got %v, %v", status.ExitStatus(), err)
+	}
+	awaitOutput(cmd)
+	if out.String() != "output\n" {
+		t.Errorf("Expected the output to be copied, got %q", out.String())
+	}
+	if cmd.Process.Pid != -1 {
+		t.Errorf("Expected the process to be released, got PID %d", cmd.Process.Pid)
+	}
+}
@@ -0,0 +1,145 @@
+//go:build linux
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPty opens a pseudo-terminal and returns its controlling and
+// terminal sides
+func openPty(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("Skipping test that needs a pseudo-terminal: %v", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+
+	var n, unlock uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Fatalf("Failed to unlock pseudo-terminal: %v", errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Fatalf("Failed to find pseudo-terminal: %v", errno)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open pseudo-terminal: %v", err)
+	}
+	t.Cleanup(func() { _ = tty.Close() })
+	return master, tty
+}
+
+// TestExecuteTerminalOutput tests that output to a terminal reaches
+// commands as the terminal itself
+func TestExecuteTerminalOutput(t *testing.T) {
+	master, tty := openPty(t)
+	go func() {
+		// Keep the terminal from filling up
+		buf := make([]byte, 1024)
+		for {
+			if _, err := master.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	executor := NewExecutorWithShell("sh")
+	executor.stdin = strings.NewReader("")
+	executor.stdout = tty
+	executor.stderr = tty
+
+	result, err := executor.Execute("test -t 1 && test -t 2")
+	if err != nil || !result.Success() {
+		t.Errorf("Expected the command to see terminals, got %+v, %v", result, err)
+	}
+
+	// Nothing is counted or kept from output that went to the terminal
+	result, err = executor.Execute("echo failed >&2; exit 1")
+	if err != nil {
+		t.Fatalf("Error executing command: %v", err)
+	}
+	if result.StdoutBytes != -1 || result.StderrBytes != -1 || result.StderrTail != "" {
+		t.Errorf("Expected terminal output not to be captured, got %+v", result)
+	}
+
+	// Processes left behind don't hold the command up
+	start := time.Now()
+	if _, err := executor.Execute("sleep 3 & echo started"); err != nil {
+		t.Fatalf("Error executing command: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the command to return at once, took %v", elapsed)
+	}
+}
+
+// TestRawModeNotTerminal tests that pipes are not treated as terminals
+func TestRawModeNotTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	defer func() {
+		_ = r.Close()
+		_ = w.Close()
+	}()
+
+	if isTerminalFd(int(r.Fd())) {
+		t.Error("Expected pipe not to be a terminal")
+	}
+	if _, err := makeRaw(int(r.Fd())); err == nil {
+		t.Error("Expected error putting a pipe into raw mode")
+	}
+
+	// A line editor on a non-terminal still reads plain lines
+	editor := newLineEditor(NewMockHistoryManager(nil), r, os.Stderr, int(r.Fd()))
+	if _, err := w.WriteString("plain input\n"); err != nil {
+		t.Fatalf("Failed to write to pipe: %v", err)
+	}
+	line, err := editor.ReadLine("")
+	if err != nil || line != "plain input" {
+		t.Errorf("Expected 'plain input', got %q (err %v)", line, err)
+	}
+}
+
+// TestDisableEcho tests turning echo off for secrets and back on
+func TestDisableEcho(t *testing.T) {
+	_, tty := openPty(t)
+	fd := int(tty.Fd())
+
+	restore, err := disableEcho(fd)
+	if err != nil {
+		t.Fatalf("Failed to disable echo: %v", err)
+	}
+	termios, err := getTermios(fd)
+	if err != nil {
+		t.Fatalf("Failed to read terminal attributes: %v", err)
+	}
+	if termios.Lflag&syscall.ECHO != 0 || termios.Lflag&syscall.ICANON == 0 {
+		t.Errorf("Expected echo off with line editing kept, got lflag %#x", termios.Lflag)
+	}
+
+	if err := restore(); err != nil {
+		t.Fatalf("Failed to restore echo: %v", err)
+	}
+	if termios, err := getTermios(fd); err != nil || termios.Lflag&syscall.ECHO == 0 {
+		t.Errorf("Expected echo to be back on, got %+v, %v", termios, err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if _, err := disableEcho(int(r.Fd())); err == nil {
+		t.Error("Expected an error disabling echo on a pipe")
+	}
+}
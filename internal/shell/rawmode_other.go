@@ -0,0 +1,30 @@
+//go:build !linux && !darwin
+
+package shell
+
+import "errors"
+
+// errRawUnsupported is returned where raw terminal mode is not implemented
+var errRawUnsupported = errors.New("raw terminal mode is not supported on this platform")
+
+// isTerminalFd reports whether fd refers to a terminal. Without termios
+// support every descriptor is treated as a plain stream.
+func isTerminalFd(fd int) bool {
+	return false
+}
+
+// makeRaw is not supported on this platform
+func makeRaw(fd int) (func() error, error) {
+	return nil, errRawUnsupported
+}
+
+// disableEcho is not supported on this platform
+func disableEcho(fd int) (func() error, error) {
+	return nil, errRawUnsupported
+}
+
+// saveTerminal can't read the terminal's attributes here, so there is
+// nothing to restore
+func saveTerminal(fd int) func() {
+	return func() {}
+}
@@ -0,0 +1,93 @@
+//go:build linux || darwin
+
+package shell
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// getTermios reads the terminal attributes of fd
+func getTermios(fd int) (*syscall.Termios, error) {
+	var termios syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlGetTermios, uintptr(unsafe.Pointer(&termios))); errno != 0 {
+		return nil, errno
+	}
+	return &termios, nil
+}
+
+// setTermios applies terminal attributes to fd
+func setTermios(fd int, termios *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlSetTermios, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminalFd reports whether fd refers to a terminal
+func isTerminalFd(fd int) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+// makeRaw puts the terminal into raw mode so keys are delivered one at a
+// time without echo or signal generation, and returns a function that
+// restores the previous state. Output processing is left on so newlines
+// printed by the rest of budy still return the carriage.
+func makeRaw(fd int) (func() error, error) {
+	old, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := *old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := setTermios(fd, &raw); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return setTermios(fd, old)
+	}, nil
+}
+
+// disableEcho stops the terminal showing what is typed, while lines are
+// still edited and delivered as usual, and returns a function that turns
+// echo back on
+func disableEcho(fd int) (func() error, error) {
+	old, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	quiet := *old
+	quiet.Lflag &^= syscall.ECHO
+	quiet.Lflag |= syscall.ECHONL
+	if err := setTermios(fd, &quiet); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return setTermios(fd, old)
+	}, nil
+}
+
+// saveTerminal records the attributes of the terminal fd and returns a
+// function that puts them back, for after a command that may leave the
+// terminal in raw mode
+func saveTerminal(fd int) func() {
+	old, err := getTermios(fd)
+	if err != nil {
+		return func() {}
+	}
+	return func() {
+		_ = setTermios(fd, old)
+	}
+}
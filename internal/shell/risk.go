@@ -0,0 +1,823 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sosadtsia/budy/internal/storage"
+	"github.com/sosadtsia/budy/pkg/utils"
+)
+
+// Risk levels of a command, from least to most dangerous
+const (
+	RiskLow      = "low"
+	RiskMedium   = "medium"
+	RiskHigh     = "high"
+	RiskCritical = "critical"
+)
+
+// Actions the guard takes for a risk level, from least to most strict
+const (
+	ActionAllow   = "allow"
+	ActionWarn    = "warn"
+	ActionConfirm = "confirm"
+	ActionBlock   = "block"
+)
+
+var (
+	riskLevels   = []string{RiskLow, RiskMedium, RiskHigh, RiskCritical}
+	guardActions = []string{ActionAllow, ActionWarn, ActionConfirm, ActionBlock}
+)
+
+var (
+	// ErrCommandBlocked is returned for commands the policy doesn't let run
+	ErrCommandBlocked = errors.New("command blocked")
+
+	// ErrCommandDeclined is returned when the user doesn't confirm a risky
+	// command
+	ErrCommandDeclined = errors.New("command not confirmed")
+)
+
+// RiskLevels returns the risk levels, from least to most dangerous
+func RiskLevels() []string {
+	return append([]string{}, riskLevels...)
+}
+
+// GuardActions returns the actions a policy can take, from least to most
+// strict
+func GuardActions() []string {
+	return append([]string{}, guardActions...)
+}
+
+// DefaultRiskPolicy returns the action taken at each risk level unless
+// changed
+func DefaultRiskPolicy() map[string]string {
+	return map[string]string{
+		RiskLow:      ActionAllow,
+		RiskMedium:   ActionWarn,
+		RiskHigh:     ActionConfirm,
+		RiskCritical: ActionBlock,
+	}
+}
+
+// rank returns the position of value in list, or -1
+func rank(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// Risk is one reason a command is dangerous
+type Risk struct {
+	Rule   string
+	Level  string
+	Reason string
+}
+
+// riskCommand is one simple command of a command line, with its words
+// unquoted
+type riskCommand struct {
+	// words is the program and its arguments, after any wrappers
+	words []string
+
+	// wrappers are the programs that run the command, such as sudo or xargs
+	wrappers []string
+
+	// input is the program whose output is piped into the command
+	input string
+
+	// outputs are the files output is redirected to
+	outputs []string
+}
+
+// program returns the name of the program run, without its directory
+func (c *riskCommand) program() string {
+	if len(c.words) == 0 {
+		return ""
+	}
+	return path.Base(c.words[0])
+}
+
+// args returns the arguments passed to the program
+func (c *riskCommand) args() []string {
+	if len(c.words) == 0 {
+		return nil
+	}
+	return c.words[1:]
+}
+
+// script returns the command line a shell or eval is asked to run, so it
+// can be checked as well
+func (c *riskCommand) script() (string, bool) {
+	args := c.args()
+	switch c.program() {
+	case "eval":
+		return strings.Join(args, " "), len(args) > 0
+	case "sh", "bash", "zsh", "dash", "ksh", "fish":
+		for i, arg := range args {
+			if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.Contains(arg, "c") && i+1 < len(args) {
+				return args[i+1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// wrapperOptions are programs that run the command given after their own
+// options, with the short options that take a value
+var wrapperOptions = map[string]string{
+	"sudo":    "CDghprtUu",
+	"doas":    "Cu",
+	"pkexec":  "",
+	"env":     "CSu",
+	"nice":    "n",
+	"nohup":   "",
+	"time":    "",
+	"timeout": "ks",
+	"command": "",
+	"builtin": "",
+	"exec":    "a",
+	"xargs":   "adEILnPs",
+	"stdbuf":  "eio",
+	"ionice":  "cnp",
+}
+
+// unwrap splits the wrappers and variable assignments in front of a
+// command from the command itself
+func unwrap(words []string) (wrappers, command []string) {
+	for len(words) > 0 {
+		if isAssignment(words[0]) {
+			words = words[1:]
+			continue
+		}
+		name := path.Base(words[0])
+		valued, ok := wrapperOptions[name]
+		if !ok {
+			break
+		}
+		wrappers = append(wrappers, name)
+		words = words[1:]
+
+		for len(words) > 0 && len(words[0]) > 1 && words[0][0] == '-' {
+			option := words[0]
+			words = words[1:]
+			if option == "--" {
+				break
+			}
+			if len(option) == 2 && strings.Contains(valued, option[1:]) && len(words) > 0 {
+				words = words[1:]
+			}
+		}
+		// timeout takes the time limit before the command
+		if name == "timeout" && len(words) > 0 {
+			words = words[1:]
+		}
+	}
+	return wrappers, words
+}
+
+// unquote removes the quotes and backslashes from a word, leaving
+// variable references as they are
+func unquote(word string) string {
+	words, _, err := splitWords(word, nil)
+	if err != nil || len(words) != 1 {
+		return word
+	}
+	return words[0]
+}
+
+// parseRiskCommands splits a command line into its simple commands,
+// noting which are piped into which and where output is redirected
+func parseRiskCommands(line string) []*riskCommand {
+	var commands []*riskCommand
+	var words []string
+	current := &riskCommand{}
+	redirect, output := false, false
+
+	end := func(pipe bool) {
+		current.wrappers, current.words = unwrap(words)
+		next := &riskCommand{}
+		if pipe {
+			next.input = current.program()
+		}
+		if len(words) > 0 || len(current.outputs) > 0 {
+			commands = append(commands, current)
+		}
+		current, words = next, nil
+		redirect = false
+	}
+
+	for _, text := range strings.Split(line, "\n") {
+		for _, word := range historyWords(text) {
+			switch {
+			case redirect && word == "&":
+				// A descriptor copy such as 2>&1
+			case word == "|":
+				end(true)
+			case strings.ContainsAny(word[:1], "|&;()"):
+				end(false)
+			case redirect:
+				if output {
+					current.outputs = append(current.outputs, unquote(word))
+				}
+				redirect = false
+			case word[0] == '<' || word[0] == '>':
+				redirect, output = true, word[0] == '>'
+			default:
+				words = append(words, unquote(word))
+			}
+		}
+		end(false)
+	}
+	return commands
+}
+
+// hasOption reports whether args include one of the short options in
+// letters, alone or combined as in -rf, or one of the long options
+func hasOption(args []string, letters string, long ...string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "--") {
+			name, _, _ := strings.Cut(arg, "=")
+			for _, option := range long {
+				if name == option {
+					return true
+				}
+			}
+			continue
+		}
+		if len(arg) > 1 && arg[0] == '-' && strings.ContainsAny(arg[1:], letters) {
+			return true
+		}
+	}
+	return false
+}
+
+// operands returns the arguments that aren't options
+func operands(args []string) []string {
+	var result []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(result, args[i+1:]...)
+		}
+		if len(arg) > 1 && arg[0] == '-' {
+			continue
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// anyOperand reports whether any operand in args satisfies match
+func anyOperand(args []string, match func(string) bool) bool {
+	for _, operand := range operands(args) {
+		if match(operand) {
+			return true
+		}
+	}
+	return false
+}
+
+// systemDirs are directories whose loss breaks the system or the user's
+// account
+var systemDirs = map[string]bool{
+	"/": true, "/bin": true, "/boot": true, "/dev": true, "/etc": true,
+	"/home": true, "/lib": true, "/lib64": true, "/opt": true, "/proc": true,
+	"/root": true, "/sbin": true, "/srv": true, "/sys": true, "/usr": true,
+	"/var": true, "/Applications": true, "/Library": true, "/System": true,
+	"/Users": true, "/Volumes": true, "/private": true,
+	"~": true, "$HOME": true, "${HOME}": true,
+}
+
+// isSystemPath reports whether a path names a system or home directory, or
+// everything in one as in /*
+func isSystemPath(p string) bool {
+	p = strings.TrimSuffix(p, "*")
+	if strings.HasPrefix(p, "/") {
+		p = path.Clean(p)
+	} else {
+		p = strings.TrimRight(p, "/")
+	}
+	return systemDirs[p]
+}
+
+// isWorkingDirPath reports whether a path names the current or parent
+// directory, or everything in them
+func isWorkingDirPath(p string) bool {
+	p = strings.TrimSuffix(p, "*")
+	// A lone / is the root directory, not an empty path
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	switch p {
+	case "", ".", "..":
+		return true
+	}
+	return false
+}
+
+// isSystemFile reports whether a path is inside a directory holding the
+// system's own files
+func isSystemFile(p string) bool {
+	for _, dir := range []string{"/etc/", "/boot/", "/usr/", "/bin/", "/sbin/", "/lib/", "/System/"} {
+		if strings.HasPrefix(path.Clean(p), dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// diskDevice matches the device files of whole disks and partitions
+var diskDevice = regexp.MustCompile(`^/dev/(?:[sh]d[a-z]|x?vd[a-z]|nvme\d|mmcblk\d|r?disk\d|md\d|dm-\d|mapper/|loop\d)`)
+
+// isWorldWritable reports whether a chmod mode lets every user write
+func isWorldWritable(mode string) bool {
+	if mode != "" && strings.Trim(mode, "01234567") == "" {
+		return strings.ContainsAny(mode[len(mode)-1:], "2367")
+	}
+	for _, clause := range strings.Split(mode, ",") {
+		who, perms, found := strings.Cut(clause, "+")
+		if !found {
+			who, perms, found = strings.Cut(clause, "=")
+		}
+		if found && strings.ContainsAny(who, "ao") && strings.Contains(perms, "w") {
+			return true
+		}
+	}
+	return false
+}
+
+// gitCommand returns the git subcommand and its arguments, skipping the
+// options given to git itself
+func gitCommand(args []string) (string, []string) {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-C" || args[i] == "-c":
+			i++
+		case strings.HasPrefix(args[i], "-"):
+		default:
+			return args[i], args[i+1:]
+		}
+	}
+	return "", nil
+}
+
+// riskRule flags commands that match it
+type riskRule struct {
+	name   string
+	level  string
+	reason string
+	match  func(c *riskCommand) bool
+}
+
+// commandRules are the built-in rules checked against each simple command
+var commandRules = []riskRule{
+	{
+		name:   "system directory delete",
+		level:  RiskCritical,
+		reason: "recursively deletes a system or home directory",
+		match: func(c *riskCommand) bool {
+			return c.program() == "rm" && (hasOption(c.args(), "", "--no-preserve-root") ||
+				hasOption(c.args(), "rR", "--recursive") && anyOperand(c.args(), isSystemPath))
+		},
+	},
+	{
+		name:   "system permissions change",
+		level:  RiskCritical,
+		reason: "changes permissions or owners throughout a system or home directory",
+		match: func(c *riskCommand) bool {
+			switch c.program() {
+			case "chmod", "chown", "chgrp":
+				return hasOption(c.args(), "R", "--recursive") && anyOperand(c.args(), isSystemPath)
+			}
+			return false
+		},
+	},
+	{
+		name:   "disk overwrite",
+		level:  RiskHigh,
+		reason: "writes straight to a disk device, destroying what is on it",
+		match: func(c *riskCommand) bool {
+			for _, output := range c.outputs {
+				if diskDevice.MatchString(output) {
+					return true
+				}
+			}
+			if c.program() != "dd" {
+				return false
+			}
+			for _, arg := range c.args() {
+				if of, ok := strings.CutPrefix(arg, "of="); ok && diskDevice.MatchString(of) {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		name:   "disk format",
+		level:  RiskHigh,
+		reason: "formats or wipes a disk",
+		match: func(c *riskCommand) bool {
+			switch program := c.program(); {
+			case program == "mkfs" || strings.HasPrefix(program, "mkfs."):
+				return true
+			case program == "mke2fs" || program == "mkswap" || program == "wipefs":
+				return true
+			case program == "diskutil":
+				sub := strings.ToLower(strings.Join(operands(c.args()), " "))
+				return strings.HasPrefix(sub, "erase") || strings.HasPrefix(sub, "zerodisk") ||
+					strings.HasPrefix(sub, "randomdisk") || strings.HasPrefix(sub, "secureerase")
+			}
+			return false
+		},
+	},
+	{
+		name:   "download piped to a shell",
+		level:  RiskHigh,
+		reason: "runs a downloaded script without showing it first",
+		match: func(c *riskCommand) bool {
+			switch c.input {
+			case "curl", "wget", "fetch":
+			default:
+				return false
+			}
+			switch c.program() {
+			case "sh", "bash", "zsh", "dash", "ksh", "fish", "python", "python3", "perl", "ruby", "node":
+				return true
+			}
+			return false
+		},
+	},
+	{
+		name:   "force push",
+		level:  RiskHigh,
+		reason: "overwrites the history of a remote branch",
+		match: func(c *riskCommand) bool {
+			if c.program() != "git" {
+				return false
+			}
+			sub, args := gitCommand(c.args())
+			if sub != "push" {
+				return false
+			}
+			return hasOption(args, "f", "--force") || anyOperand(args, func(ref string) bool {
+				return strings.HasPrefix(ref, "+")
+			})
+		},
+	},
+	{
+		name:   "working directory delete",
+		level:  RiskHigh,
+		reason: "recursively deletes everything in the current or parent directory",
+		match: func(c *riskCommand) bool {
+			return c.program() == "rm" && hasOption(c.args(), "rR", "--recursive") && anyOperand(c.args(), isWorkingDirPath)
+		},
+	},
+	{
+		name:   "shutdown",
+		level:  RiskHigh,
+		reason: "shuts down or restarts the machine",
+		match: func(c *riskCommand) bool {
+			args := operands(c.args())
+			switch c.program() {
+			case "shutdown", "reboot", "halt", "poweroff":
+				return true
+			case "init", "telinit":
+				return len(args) > 0 && (args[0] == "0" || args[0] == "6")
+			case "systemctl":
+				return len(args) > 0 && rank([]string{"poweroff", "reboot", "halt", "kexec"}, args[0]) >= 0
+			}
+			return false
+		},
+	},
+	{
+		name:   "kill everything",
+		level:  RiskHigh,
+		reason: "kills every process you are allowed to",
+		match: func(c *riskCommand) bool {
+			args := c.args()
+			return c.program() == "kill" && len(args) > 1 && rank(args[1:], "-1") >= 0
+		},
+	},
+	{
+		name:   "system file overwrite",
+		level:  RiskHigh,
+		reason: "overwrites a system file",
+		match: func(c *riskCommand) bool {
+			for _, output := range c.outputs {
+				if isSystemFile(output) {
+					return true
+				}
+			}
+			return c.program() == "tee" && anyOperand(c.args(), isSystemFile)
+		},
+	},
+	{
+		name:   "privilege escalation",
+		level:  RiskMedium,
+		reason: "runs with administrator privileges",
+		match: func(c *riskCommand) bool {
+			for _, wrapper := range c.wrappers {
+				if wrapper == "sudo" || wrapper == "doas" || wrapper == "pkexec" {
+					return true
+				}
+			}
+			return c.program() == "su"
+		},
+	},
+	{
+		name:   "forced recursive delete",
+		level:  RiskMedium,
+		reason: "deletes files recursively without asking",
+		match: func(c *riskCommand) bool {
+			return c.program() == "rm" && hasOption(c.args(), "rR", "--recursive") && hasOption(c.args(), "f", "--force") &&
+				!anyOperand(c.args(), isSystemPath) && !anyOperand(c.args(), isWorkingDirPath)
+		},
+	},
+	{
+		name:   "world-writable permissions",
+		level:  RiskMedium,
+		reason: "lets every user change the files",
+		match: func(c *riskCommand) bool {
+			return c.program() == "chmod" && anyOperand(c.args(), isWorldWritable)
+		},
+	},
+	{
+		name:   "discard changes",
+		level:  RiskMedium,
+		reason: "throws away uncommitted changes",
+		match: func(c *riskCommand) bool {
+			if c.program() != "git" {
+				return false
+			}
+			switch sub, args := gitCommand(c.args()); sub {
+			case "reset":
+				return hasOption(args, "", "--hard")
+			case "clean":
+				return hasOption(args, "f", "--force")
+			}
+			return false
+		},
+	},
+	{
+		name:   "crontab removal",
+		level:  RiskMedium,
+		reason: "removes all of your cron jobs",
+		match: func(c *riskCommand) bool {
+			return c.program() == "crontab" && hasOption(c.args(), "r")
+		},
+	},
+	{
+		name:   "infrastructure delete",
+		level:  RiskMedium,
+		reason: "deletes cloud or cluster resources",
+		match: func(c *riskCommand) bool {
+			args := operands(c.args())
+			switch c.program() {
+			case "kubectl":
+				return len(args) > 0 && args[0] == "delete"
+			case "terraform":
+				return len(args) > 0 && args[0] == "destroy"
+			}
+			return false
+		},
+	},
+	{
+		name:   "recursive delete",
+		level:  RiskLow,
+		reason: "deletes files recursively",
+		match: func(c *riskCommand) bool {
+			return c.program() == "rm" && hasOption(c.args(), "rR", "--recursive") && !hasOption(c.args(), "f", "--force")
+		},
+	},
+}
+
+// riskPattern flags command lines that match a regular expression. check,
+// if set, rejects matches that only look dangerous.
+type riskPattern struct {
+	name    string
+	level   string
+	reason  string
+	pattern *regexp.Regexp
+	check   func(match []string) bool
+}
+
+// funcName matches a shell function name
+const funcName = `([A-Za-z_:][A-Za-z0-9_:]*)`
+
+// builtinPatterns are the built-in rules checked against whole lines
+var builtinPatterns = []riskPattern{
+	{
+		name:    "fork bomb",
+		level:   RiskCritical,
+		reason:  "starts copies of itself until the system runs out of processes",
+		pattern: regexp.MustCompile(funcName + `\s*\(\)\s*\{\s*` + funcName + `\s*\|\s*` + funcName + `\s*&`),
+		check: func(match []string) bool {
+			return match[1] == match[2] && match[2] == match[3]
+		},
+	},
+	{
+		name:    "download piped to a shell",
+		level:   RiskHigh,
+		reason:  "runs a downloaded script without showing it first",
+		pattern: regexp.MustCompile(`(?:^|[\s;&|(])(?:(?:ba|z|da|k)?sh|source|\.|eval)\s+(?:-\w+\s+)*(?:<\(|["']?\$\(|["']?` + "`" + `)\s*(?:curl|wget)\b`),
+	},
+}
+
+// analyzeLine returns the risks the built-in rules find in a command line,
+// including the commands it asks a shell or eval to run
+func analyzeLine(line string, depth int) []Risk {
+	var risks []Risk
+	for _, p := range builtinPatterns {
+		match := p.pattern.FindStringSubmatch(line)
+		if match != nil && (p.check == nil || p.check(match)) {
+			risks = append(risks, Risk{Rule: p.name, Level: p.level, Reason: p.reason})
+		}
+	}
+
+	for _, c := range parseRiskCommands(line) {
+		for _, rule := range commandRules {
+			if rule.match(c) {
+				risks = append(risks, Risk{Rule: rule.name, Level: rule.level, Reason: rule.reason})
+			}
+		}
+		if script, ok := c.script(); ok && depth < 3 {
+			risks = append(risks, analyzeLine(script, depth+1)...)
+		}
+	}
+	return risks
+}
+
+// BuiltinRiskRules describes the built-in rules
+func BuiltinRiskRules() []Risk {
+	var rules []Risk
+	seen := make(map[string]bool)
+	for _, rule := range commandRules {
+		seen[rule.name] = true
+		rules = append(rules, Risk{Rule: rule.name, Level: rule.level, Reason: rule.reason})
+	}
+	for _, p := range builtinPatterns {
+		if !seen[p.name] {
+			rules = append(rules, Risk{Rule: p.name, Level: p.level, Reason: p.reason})
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rank(riskLevels, rules[i].Level) > rank(riskLevels, rules[j].Level)
+	})
+	return rules
+}
+
+// Guard checks commands for risks before they run, and applies a policy
+// to each risk level: the command runs, runs with a warning, runs once the
+// user confirms it, or doesn't run. It is safe for concurrent use.
+type Guard struct {
+	mu     sync.RWMutex
+	policy map[string]string
+	rules  []riskPattern
+
+	// confirm asks the user whether to run a risky command
+	confirm func(prompt string) bool
+}
+
+// NewGuard creates a guard with the given policy and user rules
+func NewGuard(config storage.GuardConfig) (*Guard, error) {
+	g := &Guard{confirm: utils.Confirm}
+	if err := g.SetConfig(config); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// SetConfig replaces the policy and user rules. Levels the policy leaves
+// out keep their default action.
+func (g *Guard) SetConfig(config storage.GuardConfig) error {
+	policy := DefaultRiskPolicy()
+	for level, action := range config.Policy {
+		if rank(riskLevels, level) < 0 {
+			return fmt.Errorf("unknown risk level %q; use one of %s", level, strings.Join(riskLevels, ", "))
+		}
+		if rank(guardActions, action) < 0 {
+			return fmt.Errorf("unknown action %q for %s risk; use one of %s", action, level, strings.Join(guardActions, ", "))
+		}
+		policy[level] = action
+	}
+
+	rules := make([]riskPattern, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		if rank(riskLevels, rule.Level) < 0 {
+			return fmt.Errorf("rule %q has unknown risk level %q", rule.Pattern, rule.Level)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid rule pattern %q: %v", rule.Pattern, err)
+		}
+		if re.MatchString("") {
+			return fmt.Errorf("rule pattern %q matches every command", rule.Pattern)
+		}
+		reason := rule.Reason
+		if reason == "" {
+			reason = "matches your rule " + rule.Pattern
+		}
+		rules = append(rules, riskPattern{name: rule.Pattern, level: rule.Level, reason: reason, pattern: re})
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.policy = policy
+	g.rules = rules
+	return nil
+}
+
+// Action returns the action taken for commands at a risk level
+func (g *Guard) Action(level string) string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if action, ok := g.policy[level]; ok {
+		return action
+	}
+	return ActionAllow
+}
+
+// Analyze returns the risks found in a command line, most dangerous first
+func (g *Guard) Analyze(command string) []Risk {
+	risks := analyzeLine(command, 0)
+	g.mu.RLock()
+	for _, rule := range g.rules {
+		if rule.pattern.MatchString(command) {
+			risks = append(risks, Risk{Rule: rule.name, Level: rule.level, Reason: rule.reason})
+		}
+	}
+	g.mu.RUnlock()
+
+	// The same rule can match several commands of a line
+	var unique []Risk
+	seen := make(map[string]bool)
+	for _, risk := range risks {
+		if !seen[risk.Rule] {
+			seen[risk.Rule] = true
+			unique = append(unique, risk)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		return rank(riskLevels, unique[i].Level) > rank(riskLevels, unique[j].Level)
+	})
+	return unique
+}
+
+// Decide returns the strictest action the policy takes for the risks
+func (g *Guard) Decide(risks []Risk) string {
+	action := ActionAllow
+	for _, risk := range risks {
+		if a := g.Action(risk.Level); rank(guardActions, a) > rank(guardActions, action) {
+			action = a
+		}
+	}
+	return action
+}
+
+// Check applies the policy to a command before it runs. Risks that aren't
+// allowed are described on out, and the user is asked to confirm when the
+// policy says so. It returns ErrCommandBlocked or ErrCommandDeclined when
+// the command must not run.
+func (g *Guard) Check(command string, out io.Writer) error {
+	risks := g.Analyze(command)
+	action := g.Decide(risks)
+	if action == ActionAllow {
+		return nil
+	}
+
+	blocked := ""
+	for _, risk := range risks {
+		riskAction := g.Action(risk.Level)
+		if riskAction == ActionAllow {
+			continue
+		}
+		if riskAction == ActionBlock && blocked == "" {
+			blocked = risk.Level
+		}
+		fmt.Fprintf(out, "%s%s risk: this command %s\n", strings.ToUpper(risk.Level[:1]), risk.Level[1:], risk.Reason)
+	}
+
+	switch action {
+	case ActionBlock:
+		fmt.Fprintf(out, "Blocked; type 'guard set %s confirm' to be asked instead\n", blocked)
+		return ErrCommandBlocked
+	case ActionConfirm:
+		if !g.confirm("Run it anyway?") {
+			return ErrCommandDeclined
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sosadtsia/budy/internal/storage"
+)
+
+// riskyCommands holds commands with the rule expected to flag them and
+// the highest risk level found
+var riskyCommands = []struct {
+	command string
+	rule    string
+	level   string
+}{
+	{`rm -rf /`, "system directory delete", RiskCritical},
+	{`sudo rm -rf --no-preserve-root /`, "system directory delete", RiskCritical},
+	{`rm -r -f /*`, "system directory delete", RiskCritical},
+	{`rm -fr ~/`, "system directory delete", RiskCritical},
+	{`rm --recursive "$HOME"`, "system directory delete", RiskCritical},
+	{`cd /tmp && sudo /bin/rm -Rf /etc/`, "system directory delete", RiskCritical},
+	{`chmod -R 777 /`, "system permissions change", RiskCritical},
+	{`sudo chown -R nobody /usr`, "system permissions change", RiskCritical},
+	{`:(){ :|:& };:`, "fork bomb", RiskCritical},
+	{`bomb() { bomb | bomb & }; bomb`, "fork bomb", RiskCritical},
+	{`bash -c 'rm -rf /'`, "system directory delete", RiskCritical},
+	{`eval "rm -rf /"`, "system directory delete", RiskCritical},
+	{`dd if=/dev/zero of=/dev/sda bs=1M`, "disk overwrite", RiskHigh},
+	{`sudo dd if=ubuntu.iso of=/dev/disk2`, "disk overwrite", RiskHigh},
+	{`cat image.img > /dev/nvme0n1`, "disk overwrite", RiskHigh},
+	{`mkfs.ext4 /dev/sdb1`, "disk format", RiskHigh},
+	{`diskutil eraseDisk APFS Backup disk3`, "disk format", RiskHigh},
+	{`curl -fsSL https://example.com/install.sh | sh`, "download piped to a shell", RiskHigh},
+	{`wget -qO- https://example.com/x | sudo bash -s -- --yes`, "download piped to a shell", RiskHigh},
+	{`bash <(curl -s https://example.com/install.sh)`, "download piped to a shell", RiskHigh},
+	{`sh -c "$(curl -fsSL https://example.com/install.sh)"`, "download piped to a shell", RiskHigh},
+	{`git push --force origin main`, "force push", RiskHigh},
+	{`git -C repo push -f`, "force push", RiskHigh},
+	{`git push origin +main`, "force push", RiskHigh},
+	{`rm -rf *`, "working directory delete", RiskHigh},
+	{`rm -r ..`, "working directory delete", RiskHigh},
+	{`sudo shutdown -h now`, "shutdown", RiskHigh},
+	{`systemctl reboot`, "shutdown", RiskHigh},
+	{`kill -9 -1`, "kill everything", RiskHigh},
+	{`echo "nameserver 1.1.1.1" > /etc/resolv.conf`, "system file overwrite", RiskHigh},
+	{`echo 127.0.0.1 example | sudo tee -a /etc/hosts`, "system file overwrite", RiskHigh},
+	{`sudo apt install ripgrep`, "privilege escalation", RiskMedium},
+	{`su -`, "privilege escalation", RiskMedium},
+	{`rm -rf node_modules build`, "forced recursive delete", RiskMedium},
+	{`find . -name '*.tmp' | xargs rm -rf`, "forced recursive delete", RiskMedium},
+	{`chmod 777 deploy.sh`, "world-writable permissions", RiskMedium},
+	{`chmod o+w shared`, "world-writable permissions", RiskMedium},
+	{`git reset --hard HEAD~1`, "discard changes", RiskMedium},
+	{`git clean -fdx`, "discard changes", RiskMedium},
+	{`crontab -r`, "crontab removal", RiskMedium},
+	{`kubectl delete namespace staging`, "infrastructure delete", RiskMedium},
+	{`terraform destroy -auto-approve`, "infrastructure delete", RiskMedium},
+	{`rm -r old`, "recursive delete", RiskLow},
+}
+
+// safeCommands holds commands no rule should flag
+var safeCommands = []string{
+	`ls -la /`,
+	`rm file.txt`,
+	`rm -f /tmp/build.log`,
+	`echo "rm -rf /"`,
+	`grep -r "sudo" .`,
+	`git push origin main`,
+	`git push --force-with-lease origin feature`,
+	`git reset --soft HEAD~1`,
+	`curl -fsSL https://example.com/install.sh -o install.sh`,
+	`curl https://example.com/data.json | jq .`,
+	`dd if=/dev/zero of=disk.img bs=1M count=10`,
+	`ls 2>&1 | tee out.log`,
+	`echo hi > /dev/null`,
+	`chmod 755 script.sh`,
+	`chmod u+x script.sh`,
+	`kill -9 1234`,
+	`kubectl get pods`,
+	`cat /etc/hosts`,
+	`make clean && make`,
+}
+
+func TestAnalyzeRiskyCommands(t *testing.T) {
+	guard, err := NewGuard(storage.GuardConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+
+	for _, test := range riskyCommands {
+		risks := guard.Analyze(test.command)
+		if len(risks) == 0 {
+			t.Errorf("%s: expected rule %q to match, got no risks", test.command, test.rule)
+			continue
+		}
+		found := false
+		for _, risk := range risks {
+			found = found || risk.Rule == test.rule
+		}
+		if !found {
+			t.Errorf("%s: expected rule %q to match, got %+v", test.command, test.rule, risks)
+		}
+		if risks[0].Level != test.level {
+			t.Errorf("%s: expected %s risk, got %s", test.command, test.level, risks[0].Level)
+		}
+	}
+}
+
+// TestAnalyzeRootDelete tests that deleting the root directory is reported
+// for that reason alone, not as a working directory delete
+func TestAnalyzeRootDelete(t *testing.T) {
+	guard, err := NewGuard(storage.GuardConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+
+	for _, command := range []string{`rm -rf /`, `rm -rf /*`} {
+		risks := guard.Analyze(command)
+		if len(risks) != 1 || risks[0].Rule != "system directory delete" {
+			t.Errorf("%s: expected only the system directory delete rule, got %+v", command, risks)
+		}
+	}
+}
+
+func TestAnalyzeSafeCommands(t *testing.T) {
+	guard, err := NewGuard(storage.GuardConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+
+	for _, command := range safeCommands {
+		if risks := guard.Analyze(command); len(risks) > 0 {
+			t.Errorf("Expected %q to be safe, got %+v", command, risks)
+		}
+	}
+}
+
+func TestGuardPolicy(t *testing.T) {
+	guard, err := NewGuard(storage.GuardConfig{
+		Policy: map[string]string{RiskMedium: ActionConfirm},
+		Rules:  []storage.GuardRule{{Pattern: `terraform\s+apply`, Level: RiskHigh, Reason: "changes infrastructure"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+	var asked []string
+	answer := false
+	guard.confirm = func(prompt string) bool {
+		asked = append(asked, prompt)
+		return answer
+	}
+
+	tests := []struct {
+		command  string
+		expected error
+		asked    bool
+		output   string
+	}{
+		{"ls -la", nil, false, ""},
+		{"rm -r old", nil, false, ""},
+		{"sudo apt update", ErrCommandDeclined, true, "Medium risk: this command runs with administrator privileges"},
+		{"terraform apply -auto-approve", ErrCommandDeclined, true, "High risk: this command changes infrastructure"},
+		{"sudo rm -rf /", ErrCommandBlocked, false, "Blocked; type 'guard set critical confirm'"},
+	}
+	for _, test := range tests {
+		asked = nil
+		var out bytes.Buffer
+		if err := guard.Check(test.command, &out); err != test.expected {
+			t.Errorf("%s: expected error %v, got %v", test.command, test.expected, err)
+		}
+		if (len(asked) > 0) != test.asked {
+			t.Errorf("%s: expected asked to be %v, got %v", test.command, test.asked, asked)
+		}
+		if !strings.Contains(out.String(), test.output) {
+			t.Errorf("%s: expected output to contain %q, got %q", test.command, test.output, out.String())
+		}
+	}
+
+	answer = true
+	if err := guard.Check("sudo apt update", &bytes.Buffer{}); err != nil {
+		t.Errorf("Expected a confirmed command to run, got %v", err)
+	}
+
+	// Warnings are shown without asking
+	if err := guard.SetConfig(storage.GuardConfig{}); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	asked = nil
+	var out bytes.Buffer
+	if err := guard.Check("git reset --hard", &out); err != nil || len(asked) > 0 || !strings.Contains(out.String(), "Medium risk") {
+		t.Errorf("Expected a warning only, got %v, asked %v, output %q", err, asked, out.String())
+	}
+}
+
+func TestGuardConfigErrors(t *testing.T) {
+	configs := []storage.GuardConfig{
+		{Policy: map[string]string{"extreme": ActionBlock}},
+		{Policy: map[string]string{RiskHigh: "panic"}},
+		{Rules: []storage.GuardRule{{Pattern: `(`, Level: RiskHigh}}},
+		{Rules: []storage.GuardRule{{Pattern: `.*`, Level: RiskHigh}}},
+		{Rules: []storage.GuardRule{{Pattern: `deploy`, Level: "severe"}}},
+	}
+	for _, config := range configs {
+		if _, err := NewGuard(config); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+}
+
+func TestExecuteWithGuard(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	guard, err := NewGuard(storage.GuardConfig{
+		Rules: []storage.GuardRule{{Pattern: `^touch `, Level: RiskCritical}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	executor := NewExecutorWithShell("sh")
+	executor.stderr = &stderr
+	executor.SetGuard(guard)
+
+	if _, err := executor.Execute("touch " + marker); !errors.Is(err, ErrCommandBlocked) {
+		t.Errorf("Expected ErrCommandBlocked, got %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Expected the blocked command not to run")
+	}
+	if !strings.Contains(stderr.String(), "Critical risk") {
+		t.Errorf("Expected the risk to be described, got %q", stderr.String())
+	}
+
+	// Aliases are checked after they are expanded
+	executor.stdout = &bytes.Buffer{}
+	if _, err := executor.Execute("alias mark='touch " + marker + "'"); err != nil {
+		t.Fatalf("Failed to define alias: %v", err)
+	}
+	if _, err := executor.Execute("mark"); !errors.Is(err, ErrCommandBlocked) {
+		t.Errorf("Expected the alias to be blocked, got %v", err)
+	}
+}
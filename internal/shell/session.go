@@ -0,0 +1,142 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sosadtsia/budy/pkg/utils"
+)
+
+// Session holds the state that builtins change and that external commands
+// inherit: the working directory, environment variables and aliases
+type Session struct {
+	dir     string
+	prevDir string
+	env     map[string]string
+	aliases map[string]string
+}
+
+// NewSession creates a session from the current process state
+func NewSession() *Session {
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "/"
+	}
+
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+
+	return &Session{
+		dir:     dir,
+		env:     env,
+		aliases: make(map[string]string),
+	}
+}
+
+// Dir returns the session's working directory
+func (s *Session) Dir() string {
+	return s.dir
+}
+
+// PrevDir returns the previous working directory used by 'cd -'
+func (s *Session) PrevDir() string {
+	return s.prevDir
+}
+
+// Chdir changes the session's working directory. The path may be relative
+// to the current directory or start with ~. The process directory is kept
+// in sync so history records the directory a command actually ran in.
+func (s *Session) Chdir(path string) error {
+	expanded, err := utils.ExpandPath(path)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(expanded) {
+		expanded = filepath.Join(s.dir, expanded)
+	}
+	expanded = filepath.Clean(expanded)
+
+	if !utils.DirExists(expanded) {
+		if _, err := os.Stat(expanded); err == nil {
+			return fmt.Errorf("not a directory: %s", path)
+		}
+		return fmt.Errorf("no such file or directory: %s", path)
+	}
+
+	if err := os.Chdir(expanded); err != nil {
+		return err
+	}
+
+	s.prevDir = s.dir
+	s.dir = expanded
+	s.env["OLDPWD"] = s.prevDir
+	s.env["PWD"] = s.dir
+	return nil
+}
+
+// Getenv returns the value of a session environment variable
+func (s *Session) Getenv(name string) string {
+	return s.env[name]
+}
+
+// LookupEnv returns the value of a session environment variable and
+// whether it is set
+func (s *Session) LookupEnv(name string) (string, bool) {
+	value, ok := s.env[name]
+	return value, ok
+}
+
+// Setenv sets a session environment variable
+func (s *Session) Setenv(name, value string) {
+	s.env[name] = value
+}
+
+// Unsetenv removes a session environment variable
+func (s *Session) Unsetenv(name string) {
+	delete(s.env, name)
+}
+
+// Environ returns the session environment in "name=value" form, sorted by name
+func (s *Session) Environ() []string {
+	env := make([]string, 0, len(s.env))
+	for name, value := range s.env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Alias returns the replacement text for an alias
+func (s *Session) Alias(name string) (string, bool) {
+	value, ok := s.aliases[name]
+	return value, ok
+}
+
+// SetAlias defines an alias
+func (s *Session) SetAlias(name, value string) {
+	s.aliases[name] = value
+}
+
+// RemoveAlias removes an alias and reports whether it existed
+func (s *Session) RemoveAlias(name string) bool {
+	_, ok := s.aliases[name]
+	delete(s.aliases, name)
+	return ok
+}
+
+// Aliases returns the names of all defined aliases, sorted
+func (s *Session) Aliases() []string {
+	names := make([]string, 0, len(s.aliases))
+	for name := range s.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
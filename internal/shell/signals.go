@@ -0,0 +1,63 @@
+package shell
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// processExit describes how a command run in the foreground ended
+type processExit struct {
+	code   int
+	signal string
+
+	// stopped is set when the command was suspended, as with Ctrl-Z,
+	// rather than ending
+	stopped bool
+}
+
+var (
+	interruptMu sync.Mutex
+
+	// onInterrupt is called when Ctrl-C is pressed at a prompt that can't
+	// see the key itself
+	onInterrupt func()
+)
+
+// HandleSignals keeps budy running through the signals an interactive
+// shell survives. Ctrl-C at a prompt abandons the line being typed and
+// Ctrl-Z is ignored; while a command runs, signals are passed on to it.
+// Call it once at startup.
+func HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, shellSignals...)
+	go func() {
+		for sig := range signals {
+			if sig != os.Interrupt {
+				continue
+			}
+			interruptMu.Lock()
+			f := onInterrupt
+			interruptMu.Unlock()
+			if f != nil {
+				f()
+			}
+		}
+	}()
+}
+
+// whileReading calls f each time Ctrl-C is pressed until the returned
+// function is called. Readers whose input the terminal's line discipline
+// edits use it to show a fresh prompt, as the terminal has already thrown
+// the typed line away.
+func whileReading(f func()) func() {
+	interruptMu.Lock()
+	onInterrupt = f
+	interruptMu.Unlock()
+
+	return func() {
+		interruptMu.Lock()
+		onInterrupt = nil
+		interruptMu.Unlock()
+	}
+}
@@ -13,12 +13,84 @@ type TerminalReader interface {
 	ReadLine(prompt string) (string, error)
 }
 
-// NewTerminalReader creates a new platform-specific terminal reader
-func NewTerminalReader(history HistoryManager) TerminalReader {
+// TextReader is a TerminalReader that can start a line with text already
+// entered, for the user to edit before pressing Enter
+type TextReader interface {
+	TerminalReader
+	ReadLineWithText(prompt, text string) (string, error)
+}
+
+// SecretReader is a TerminalReader that can read a line without showing
+// it, for API keys and passphrases
+type SecretReader interface {
+	TerminalReader
+	ReadSecret(prompt string) (string, error)
+}
+
+// ReplyReader is a TerminalReader that can read a short answer to a
+// question, such as y or n, as typed
+type ReplyReader interface {
+	TerminalReader
+	ReadReply(prompt string) (string, error)
+}
+
+// ReadReply reads the answer to a question: a single line shown as typed,
+// without history expansion or the recent commands some readers list
+// before a command. Other readers read a normal line.
+func ReadReply(reader TerminalReader, prompt string) (string, error) {
+	if replier, ok := reader.(ReplyReader); ok {
+		return replier.ReadReply(prompt)
+	}
+	return reader.ReadLine(prompt)
+}
+
+// ReadSecret reads a line without echoing it and without history
+// expansion. Readers that can't hide input read a normal line.
+func ReadSecret(reader TerminalReader, prompt string) (string, error) {
+	if secret, ok := reader.(SecretReader); ok {
+		return secret.ReadSecret(prompt)
+	}
+	return reader.ReadLine(prompt)
+}
+
+// EditLine lets the user edit text and returns the line they enter. Readers
+// that can't start a line with text show it instead, and an empty line
+// keeps it unchanged.
+func EditLine(reader TerminalReader, prompt, text string) (string, error) {
+	if editor, ok := reader.(TextReader); ok {
+		return editor.ReadLineWithText(prompt, text)
+	}
+	return editPlain(reader, prompt, text)
+}
+
+// editPlain shows text and reads a replacement for it, keeping text when
+// the line is empty
+func editPlain(reader TerminalReader, prompt, text string) (string, error) {
+	fmt.Printf("Current: %s\nType a replacement, or press Enter to keep it\n", text)
+	line, err := reader.ReadLine(prompt)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(line) == "" {
+		return text, nil
+	}
+	return line, nil
+}
+
+// NewTerminalReader creates a new platform-specific terminal reader.
+// completer is used for Tab completion where the reader supports it and
+// may be nil.
+func NewTerminalReader(history HistoryManager, completer Completer) TerminalReader {
 	switch runtime.GOOS {
 	case "darwin":
 		// Use macOS specific implementation on Darwin
 		return NewMacOSTerminalReader(history)
+	case "linux":
+		// Use the raw-mode line editor when reading from a terminal
+		if isTerminalFd(int(os.Stdin.Fd())) {
+			return NewLineEditor(history, completer)
+		}
+		return NewSimpleTerminalReader(history)
 	default:
 		// Fall back to simple implementation on other platforms
 		return NewSimpleTerminalReader(history)
@@ -43,8 +115,11 @@ func NewMacOSTerminalReader(history HistoryManager) *MacOSTerminalReader {
 
 // ReadLine reads a line of input
 func (t *MacOSTerminalReader) ReadLine(prompt string) (string, error) {
-	// Display prompt
+	// Display prompt, again after Ctrl-C throws the line away
 	fmt.Print(prompt)
+	defer whileReading(func() {
+		fmt.Print("\n" + lastLine(prompt))
+	})()
 
 	// Read input line
 	input, err := t.reader.ReadString('\n')
@@ -56,48 +131,38 @@ func (t *MacOSTerminalReader) ReadLine(prompt string) (string, error) {
 	input = strings.TrimSuffix(input, "\n")
 	input = strings.TrimSuffix(input, "\r")
 
-	// Handle history command shortcuts
-	if strings.HasPrefix(input, "!") {
-		historyCmd := t.expandHistoryCommand(input)
-		if historyCmd != input {
-			fmt.Printf("Executing: %s\n", historyCmd)
-			return historyCmd, nil
-		}
-	}
-
-	return input, nil
+	return t.expandHistoryCommand(input), nil
 }
 
-// expandHistoryCommand handles history expansion (!n commands)
-func (t *MacOSTerminalReader) expandHistoryCommand(input string) string {
-	history := t.history.GetHistory()
-	if len(history) == 0 {
-		return input
-	}
-
-	// Handle !! for most recent command
-	if input == "!!" && len(history) > 0 {
-		return history[len(history)-1].Command
+// ReadReply reads a line as typed, without history expansion
+func (t *MacOSTerminalReader) ReadReply(prompt string) (string, error) {
+	fmt.Print(prompt)
+	input, err := t.reader.ReadString('\n')
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimRight(input, "\r\n"), nil
+}
 
-	// Handle !n for command recall
-	if len(input) > 1 && input[1] >= '1' && input[1] <= '9' {
-		n := int(input[1] - '0')
-		if n <= len(history) {
-			// Adjust for 1-based indexing
-			return history[len(history)-n].Command
-		}
+// ReadSecret reads a line without showing it and without history
+// expansion
+func (t *MacOSTerminalReader) ReadSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if restore, err := disableEcho(int(os.Stdin.Fd())); err == nil {
+		defer func() {
+			_ = restore()
+		}()
 	}
-
-	// Handle !-n for nth previous command
-	if len(input) > 2 && input[1] == '-' && input[2] >= '1' && input[2] <= '9' {
-		n := int(input[2] - '0')
-		if n <= len(history) {
-			return history[len(history)-n].Command
-		}
+	input, err := t.reader.ReadString('\n')
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimRight(input, "\r\n"), nil
+}
 
-	return input
+// expandHistoryCommand applies history expansion to the input
+func (t *MacOSTerminalReader) expandHistoryCommand(input string) string {
+	return expandInput(t.history, input, os.Stdout, "\n")
 }
 
 // SimpleTerminalReader provides a basic readline-like interface with history
@@ -116,17 +181,24 @@ func NewSimpleTerminalReader(history HistoryManager) *SimpleTerminalReader {
 
 // ReadLine reads a line of input with basic history management
 func (t *SimpleTerminalReader) ReadLine(prompt string) (string, error) {
-	// Show command history suggestions
-	recentCommands := t.history.GetRecentCommands(5)
-	if len(recentCommands) > 0 {
+	// Show recent commands numbered as !n recalls them
+	entries := t.history.GetHistory()
+	if len(entries) > 0 {
+		first := len(entries) - 5
+		if first < 0 {
+			first = 0
+		}
 		fmt.Println("\nRecent commands (use !n to recall):")
-		for i, cmd := range recentCommands {
-			fmt.Printf("  !%d: %s\n", i+1, cmd.Command)
+		for i := first; i < len(entries); i++ {
+			fmt.Printf("  !%d: %s\n", i+1, entries[i].Command)
 		}
 	}
 
-	// Display prompt
+	// Display prompt, again after Ctrl-C throws the line away
 	fmt.Print(prompt)
+	defer whileReading(func() {
+		fmt.Print("\n" + lastLine(prompt))
+	})()
 
 	// Get input
 	if !t.scanner.Scan() {
@@ -135,33 +207,26 @@ func (t *SimpleTerminalReader) ReadLine(prompt string) (string, error) {
 
 	input := strings.TrimSpace(t.scanner.Text())
 
-	// Process history expansion
-	if strings.HasPrefix(input, "!") {
-		return t.expandHistory(input, recentCommands)
-	}
-
-	return input, nil
+	return t.expandHistory(input), nil
 }
 
-// expandHistory handles history expansion (!n commands)
-func (t *SimpleTerminalReader) expandHistory(input string, recentCommands []CommandEntry) (string, error) {
-	// Handle !n for command recall
-	if len(input) > 1 && input[1] >= '1' && input[1] <= '9' {
-		n := int(input[1] - '0')
-		if n <= len(recentCommands) {
-			// Adjust for 1-based indexing
-			cmd := recentCommands[n-1].Command
-			fmt.Printf("Executing: %s\n", cmd)
-			return cmd, nil
-		}
+// ReadReply reads a line without listing recent commands first and
+// without history expansion
+func (t *SimpleTerminalReader) ReadReply(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if !t.scanner.Scan() {
+		return "", fmt.Errorf("error reading input")
 	}
+	return strings.TrimSpace(t.scanner.Text()), nil
+}
 
-	// Handle !! for most recent command
-	if input == "!!" && len(recentCommands) > 0 {
-		cmd := recentCommands[0].Command
-		fmt.Printf("Executing: %s\n", cmd)
-		return cmd, nil
-	}
+// ReadSecret reads a line without history expansion. Input that isn't
+// from a terminal isn't shown anyway.
+func (t *SimpleTerminalReader) ReadSecret(prompt string) (string, error) {
+	return t.ReadReply(prompt)
+}
 
-	return input, nil
+// expandHistory applies history expansion to the input
+func (t *SimpleTerminalReader) expandHistory(input string) string {
+	return expandInput(t.history, input, os.Stdout, "\n")
 }
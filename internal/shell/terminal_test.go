@@ -3,6 +3,7 @@ package shell
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -27,6 +28,11 @@ func (m *MockHistoryManager) RecordCommand(command string) error {
 	return nil
 }
 
+func (m *MockHistoryManager) RecordEntry(entry CommandEntry) error {
+	m.commands = append(m.commands, entry)
+	return nil
+}
+
 func (m *MockHistoryManager) GetHistory() []CommandEntry {
 	return m.commands
 }
@@ -45,7 +51,7 @@ func (m *MockHistoryManager) GetDirectoryCommands() []CommandEntry {
 // TestNewTerminalReader tests that the appropriate reader is created based on platform
 func TestNewTerminalReader(t *testing.T) {
 	history := NewMockHistoryManager([]string{})
-	reader := NewTerminalReader(history)
+	reader := NewTerminalReader(history, nil)
 
 	// We can't test platform-specific behavior easily, so just check it's not nil
 	if reader == nil {
@@ -66,23 +72,26 @@ func TestSimpleTerminalReader(t *testing.T) {
 		t.Errorf("Expected 3 commands in history, got %d", len(history.GetRecentCommands(5)))
 	}
 
-	// Test expandHistory with !1
-	result, err := reader.expandHistory("!1", history.GetRecentCommands(5))
-	if err != nil {
-		t.Errorf("Error expanding history: %v", err)
-	}
+	// Test expandHistory with !1, numbered as the history builtin shows it
+	result := reader.expandHistory("!1")
 	if result != "ls -la" {
 		t.Errorf("Expected 'ls -la', got '%s'", result)
 	}
 
 	// Test expandHistory with !!
-	result, err = reader.expandHistory("!!", history.GetRecentCommands(5))
-	if err != nil {
-		t.Errorf("Error expanding history: %v", err)
-	}
+	result = reader.expandHistory("!!")
 	if result != "echo test" {
 		t.Errorf("Expected 'echo test', got '%s'", result)
 	}
+
+	// Test expandHistory with more than one digit
+	for i := 0; i < 10; i++ {
+		_ = history.RecordCommand(fmt.Sprintf("echo %d", i))
+	}
+	result = reader.expandHistory("!12")
+	if result != "echo 8" {
+		t.Errorf("Expected 'echo 8', got '%s'", result)
+	}
 }
 
 // TestMacOSTerminalReader tests the MacOSTerminalReader
@@ -93,10 +102,10 @@ func TestMacOSTerminalReader(t *testing.T) {
 	// Create reader
 	reader := NewMacOSTerminalReader(history)
 
-	// Test expandHistoryCommand with !1
+	// Test expandHistoryCommand with !1, which both readers number the same
 	result := reader.expandHistoryCommand("!1")
-	if result != "echo test" {
-		t.Errorf("Expected 'echo test', got '%s'", result)
+	if result != "ls -la" {
+		t.Errorf("Expected 'ls -la', got '%s'", result)
 	}
 
 	// Test expandHistoryCommand with !!
@@ -182,3 +191,72 @@ func TestSimpleTerminalReaderReadLine(t *testing.T) {
 		t.Errorf("Prompt not found in output: %s", buf.String())
 	}
 }
+
+// TestEditLine tests editing text with readers that can't start a line
+// with it
+func TestEditLine(t *testing.T) {
+	reader := &SimpleTerminalReader{
+		history: NewMockHistoryManager(nil),
+		scanner: bufio.NewScanner(strings.NewReader("\nls -la\n")),
+	}
+
+	// An empty line keeps the text
+	line, err := EditLine(reader, "edit> ", "ls -l")
+	if err != nil {
+		t.Fatalf("Error reading line: %v", err)
+	}
+	if line != "ls -l" {
+		t.Errorf("Expected 'ls -l', got %q", line)
+	}
+
+	// Anything else replaces it
+	line, err = EditLine(reader, "edit> ", "ls -l")
+	if err != nil {
+		t.Fatalf("Error reading line: %v", err)
+	}
+	if line != "ls -la" {
+		t.Errorf("Expected 'ls -la', got %q", line)
+	}
+}
+
+// TestReadReply tests that answers to questions are read as typed, without
+// listing recent commands or expanding history
+func TestReadReply(t *testing.T) {
+	history := NewMockHistoryManager([]string{"rm -rf build"})
+
+	// Capture stdout
+	oldStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create stdout pipe: %v", err)
+	}
+	os.Stdout = w
+
+	reader := &SimpleTerminalReader{
+		history: history,
+		scanner: bufio.NewScanner(strings.NewReader("!!\n")),
+	}
+	reply, err := ReadReply(reader, "Run it? ")
+
+	os.Stdout = oldStdout
+	if err := w.Close(); err != nil {
+		t.Errorf("Error closing stdout pipe writer: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Errorf("Error copying from stdout pipe: %v", err)
+	}
+
+	if err != nil || reply != "!!" {
+		t.Errorf("Expected '!!', got %q, %v", reply, err)
+	}
+	if buf.String() != "Run it? " {
+		t.Errorf("Expected only the prompt, got %q", buf.String())
+	}
+
+	var out bytes.Buffer
+	editor := newLineEditor(history, strings.NewReader("!!\r"), &out, -1)
+	if reply, err := ReadReply(editor, "Run it? "); err != nil || reply != "!!" {
+		t.Errorf("Expected '!!', got %q, %v", reply, err)
+	}
+}
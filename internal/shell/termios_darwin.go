@@ -0,0 +1,9 @@
+package shell
+
+import "syscall"
+
+// The ioctl requests that read and set terminal attributes
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
@@ -0,0 +1,154 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitWords splits a command line into words the way a POSIX shell
+// would for a simple command: whitespace separates words, quotes and
+// backslashes group them, and $NAME / ${NAME} are expanded through lookup
+// outside single quotes. A nil lookup leaves variable references as-is.
+//
+// The simple result is false when the line contains unquoted shell syntax
+// (pipes, lists, redirections, subshells or command substitution) that
+// only a real shell can interpret.
+func splitWords(line string, lookup func(string) string) (words []string, simple bool, err error) {
+	var (
+		current strings.Builder
+		inWord  bool
+		quote   byte
+	)
+	simple = true
+
+	flush := func() {
+		if inWord {
+			words = append(words, current.String())
+			current.Reset()
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch quote {
+		case '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				current.WriteByte(c)
+			}
+			continue
+
+		case '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(line) && strings.IndexByte("$`\"\\\n", line[i+1]) >= 0:
+				i++
+				current.WriteByte(line[i])
+			case c == '$' && lookup != nil:
+				i = expandVariable(line, i, lookup, &current)
+			case c == '`' || (c == '$' && i+1 < len(line) && line[i+1] == '('):
+				simple = false
+				current.WriteByte(c)
+			default:
+				current.WriteByte(c)
+			}
+			continue
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\':
+			inWord = true
+			if i+1 < len(line) {
+				i++
+				current.WriteByte(line[i])
+			}
+		case c == '#' && !inWord:
+			// Comment runs to the end of the line
+			flush()
+			return words, simple, nil
+		case strings.IndexByte("|&;<>()`", c) >= 0:
+			simple = false
+			flush()
+			words = append(words, string(c))
+		case c == '$' && i+1 < len(line) && line[i+1] == '(':
+			simple = false
+			inWord = true
+			current.WriteByte(c)
+		case c == '$' && lookup != nil:
+			inWord = true
+			i = expandVariable(line, i, lookup, &current)
+		default:
+			inWord = true
+			current.WriteByte(c)
+		}
+	}
+
+	if quote != 0 {
+		return nil, false, fmt.Errorf("unterminated %c quote", quote)
+	}
+
+	flush()
+	return words, simple, nil
+}
+
+// expandVariable expands the variable reference starting at line[i] (a '$')
+// into out and returns the index of the last byte consumed
+func expandVariable(line string, i int, lookup func(string) string, out *strings.Builder) int {
+	rest := line[i+1:]
+
+	// ${NAME}
+	if strings.HasPrefix(rest, "{") {
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			out.WriteByte('$')
+			return i
+		}
+		out.WriteString(lookup(rest[1:end]))
+		return i + 1 + end
+	}
+
+	// $NAME
+	n := 0
+	for n < len(rest) && isNameByte(rest[n], n == 0) {
+		n++
+	}
+	if n == 0 {
+		out.WriteByte('$')
+		return i
+	}
+	out.WriteString(lookup(rest[:n]))
+	return i + n
+}
+
+// isNameByte reports whether c may appear in a shell variable name
+func isNameByte(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+// isValidName reports whether name is a valid shell variable or alias name
+func isValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameByte(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package shell
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSplitWords tests command line word splitting
+func TestSplitWords(t *testing.T) {
+	env := map[string]string{"HOME": "/home/user", "EMPTY": ""}
+	lookup := func(name string) string { return env[name] }
+
+	tests := []struct {
+		line   string
+		words  []string
+		simple bool
+	}{
+		{"cd /tmp", []string{"cd", "/tmp"}, true},
+		{"  echo   a  b ", []string{"echo", "a", "b"}, true},
+		{`echo "a b" 'c d'`, []string{"echo", "a b", "c d"}, true},
+		{`echo a\ b`, []string{"echo", "a b"}, true},
+		{`echo "x\"y"`, []string{"echo", `x"y`}, true},
+		{`cd $HOME/src`, []string{"cd", "/home/user/src"}, true},
+		{`cd ${HOME}x`, []string{"cd", "/home/userx"}, true},
+		{`echo '$HOME'`, []string{"echo", "$HOME"}, true},
+		{`echo "$HOME"`, []string{"echo", "/home/user"}, true},
+		{`echo $EMPTY""`, []string{"echo", ""}, true},
+		{`echo $ 5`, []string{"echo", "$", "5"}, true},
+		{"ls # comment", []string{"ls"}, true},
+		{"ls | wc", []string{"ls", "|", "wc"}, false},
+		{"cd a && ls", []string{"cd", "a", "&", "&", "ls"}, false},
+		{"echo x > out", []string{"echo", "x", ">", "out"}, false},
+		{"echo $(pwd)", []string{"echo", "$", "(", "pwd", ")"}, false},
+		{`echo "a|b"`, []string{"echo", "a|b"}, true},
+	}
+
+	for _, tt := range tests {
+		words, simple, err := splitWords(tt.line, lookup)
+		if err != nil {
+			t.Errorf("splitWords(%q) returned error: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(words, tt.words) {
+			t.Errorf("splitWords(%q) = %q, expected %q", tt.line, words, tt.words)
+		}
+		if simple != tt.simple {
+			t.Errorf("splitWords(%q) simple = %v, expected %v", tt.line, simple, tt.simple)
+		}
+	}
+
+	// Without a lookup, variables are kept literally
+	words, _, err := splitWords(`echo $HOME`, nil)
+	if err != nil || !reflect.DeepEqual(words, []string{"echo", "$HOME"}) {
+		t.Errorf("Expected literal $HOME, got %q (err %v)", words, err)
+	}
+
+	// Unterminated quotes are errors
+	if _, _, err := splitWords(`echo "abc`, lookup); err == nil {
+		t.Error("Expected error for unterminated quote, got nil")
+	}
+}
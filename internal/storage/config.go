@@ -2,16 +2,136 @@ package storage
 
 import (
 	"encoding/json"
+	"net/textproto"
 	"os"
 	"path/filepath"
 )
 
-// Config holds application configuration
+// Config holds application configuration. API keys and other secrets are
+// saved in the secrets file rather than config.json.
 type Config struct {
-	OpenAIAPIKey string `json:"openai_api_key"`
+	OpenAIAPIKey string `json:"openai_api_key,omitempty"`
 	AIProvider   string `json:"ai_provider"`
 	OllamaURL    string `json:"ollama_url"`
 	OllamaModel  string `json:"ollama_model"`
+	Shell        string `json:"shell,omitempty"`
+	ShareHistory bool   `json:"share_history,omitempty"`
+
+	// OpenAI-compatible endpoint settings; empty values mean OpenAI's own
+	// API and default model
+	OpenAIBaseURL      string            `json:"openai_base_url,omitempty"`
+	OpenAIModel        string            `json:"openai_model,omitempty"`
+	OpenAIOrganization string            `json:"openai_organization,omitempty"`
+	OpenAIHeaders      map[string]string `json:"openai_headers,omitempty"`
+
+	// AIFallback lists the providers tried, in order, when AIProvider
+	// fails. nil means Ollama, and an empty list turns fallback off.
+	AIFallback *[]string `json:"ai_fallback,omitempty"`
+
+	// Settings holds the options of AI providers that have no field of
+	// their own, by option name
+	Settings map[string]string `json:"settings,omitempty"`
+
+	// Context selects what is sent to the AI along with questions; nil
+	// means the defaults
+	Context *ContextConfig `json:"context,omitempty"`
+
+	// Cache limits the cache of AI answers; nil means the defaults
+	Cache *CacheConfig `json:"cache,omitempty"`
+
+	// RedactPatterns are regular expressions for secrets to mask in
+	// history and AI prompts, on top of the built-in detectors
+	RedactPatterns []string `json:"redact_patterns,omitempty"`
+
+	// Guard sets what happens to dangerous commands before they run; nil
+	// means the defaults
+	Guard *GuardConfig `json:"guard,omitempty"`
+}
+
+// ContextConfig holds the toggles for each item of terminal context that
+// is added to AI prompts
+type ContextConfig struct {
+	Directory   bool `json:"directory"`
+	Listing     bool `json:"listing"`
+	System      bool `json:"system"`
+	Shell       bool `json:"shell"`
+	LastFailure bool `json:"last_failure"`
+
+	// History is the number of recent commands to include; 0 turns it off
+	History int `json:"history"`
+}
+
+// DefaultContextConfig returns the context settings used unless changed
+func DefaultContextConfig() ContextConfig {
+	return ContextConfig{
+		Directory:   true,
+		Listing:     true,
+		System:      true,
+		Shell:       true,
+		LastFailure: true,
+		History:     5,
+	}
+}
+
+// ContextSettings returns the configured context settings, or the defaults
+func (c *Config) ContextSettings() ContextConfig {
+	if c.Context == nil {
+		return DefaultContextConfig()
+	}
+	return *c.Context
+}
+
+// CacheConfig limits how long AI answers are reused and how much space
+// they take
+type CacheConfig struct {
+	// TTLMinutes is how long an answer is reused; 0 turns the cache off
+	TTLMinutes int `json:"ttl_minutes"`
+
+	// MaxSize is the most bytes of answers kept; the oldest are removed
+	// first
+	MaxSize int64 `json:"max_size"`
+}
+
+// DefaultCacheConfig returns the cache settings used unless changed
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		TTLMinutes: 24 * 60,
+		MaxSize:    10 << 20,
+	}
+}
+
+// CacheSettings returns the configured cache settings, or the defaults
+func (c *Config) CacheSettings() CacheConfig {
+	if c.Cache == nil {
+		return DefaultCacheConfig()
+	}
+	return *c.Cache
+}
+
+// GuardConfig holds the action taken for commands at each risk level and
+// the user's own rules for spotting dangerous commands
+type GuardConfig struct {
+	// Policy maps a risk level to allow, warn, confirm or block; levels
+	// left out keep their default action
+	Policy map[string]string `json:"policy,omitempty"`
+
+	// Rules are checked along with the built-in rules
+	Rules []GuardRule `json:"rules,omitempty"`
+}
+
+// GuardRule flags commands that match a regular expression
+type GuardRule struct {
+	Pattern string `json:"pattern"`
+	Level   string `json:"level"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// GuardSettings returns the configured guard settings, or the defaults
+func (c *Config) GuardSettings() GuardConfig {
+	if c.Guard == nil {
+		return GuardConfig{}
+	}
+	return *c.Guard
 }
 
 // Default AI provider values
@@ -20,8 +140,32 @@ const (
 	ProviderOllama = "ollama"
 )
 
-// LoadConfig loads application configuration from disk
+// Supported shells for command execution. An empty Shell setting means
+// the user's login shell from $SHELL.
+const (
+	ShellBash = "bash"
+	ShellSh   = "sh"
+	ShellZsh  = "zsh"
+)
+
+// LoadConfig loads application configuration from disk, along with the
+// secrets. Encrypted secrets are only included once unlocked.
 func LoadConfig(dataDir string) (*Config, error) {
+	config, err := loadPublicConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := loadSecrets(dataDir)
+	if err != nil && err != ErrSecretsLocked {
+		return nil, err
+	}
+	applySecrets(config, values)
+	return config, nil
+}
+
+// loadPublicConfig loads config.json, applying defaults for missing values
+func loadPublicConfig(dataDir string) (*Config, error) {
 	configPath := filepath.Join(dataDir, "config.json")
 
 	// If config file doesn't exist, return default config
@@ -62,19 +206,63 @@ func LoadConfig(dataDir string) (*Config, error) {
 // SaveConfig saves application configuration to disk
 func SaveConfig(dataDir string, config *Config) error {
 	// Create config directory if it doesn't exist
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return err
 	}
 
-	// Marshal config to JSON
-	data, err := json.MarshalIndent(config, "", "  ")
+	unlock, err := lockFile(filepath.Join(dataDir, "config.json.lock"))
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	// Write config file
-	configPath := filepath.Join(dataDir, "config.json")
-	return os.WriteFile(configPath, data, 0644)
+	return writeConfig(dataDir, config)
+}
+
+// UpdateConfig applies change to the configuration and saves it. The
+// config file is locked and re-read first, so settings changed by other
+// budy sessions are kept rather than overwritten, and config is refreshed
+// with the result.
+func UpdateConfig(dataDir string, config *Config, change func(*Config)) error {
+	// Create config directory if it doesn't exist
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(filepath.Join(dataDir, "config.json.lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := LoadConfig(dataDir)
+	if err != nil {
+		return err
+	}
+	change(current)
+
+	if err := writeConfig(dataDir, current); err != nil {
+		return err
+	}
+	*config = *current
+	return nil
+}
+
+// writeConfig writes the secrets file and then the config file without
+// the secrets, both readable only by the user and replaced atomically so a
+// crash never leaves a truncated file behind; callers hold the lock
+func writeConfig(dataDir string, config *Config) error {
+	values, public := splitSecrets(config)
+	if err := saveSecrets(dataDir, values); err != nil {
+		return err
+	}
+
+	// Marshal config to JSON
+	data, err := json.MarshalIndent(public, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(filepath.Join(dataDir, "config.json"), data)
 }
 
 // GetOpenAIKey gets the OpenAI API key from either environment or config
@@ -91,23 +279,186 @@ func GetOpenAIKey(config *Config) string {
 
 // SetOpenAIKey sets the OpenAI API key in the config
 func SetOpenAIKey(dataDir string, config *Config, key string) error {
-	config.OpenAIAPIKey = key
-	return SaveConfig(dataDir, config)
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		c.OpenAIAPIKey = key
+	})
+}
+
+// OpenAIRequestHeaders returns the extra headers to send with OpenAI
+// requests, including the organization when one is set
+func (c *Config) OpenAIRequestHeaders() map[string]string {
+	headers := make(map[string]string, len(c.OpenAIHeaders)+1)
+	if c.OpenAIOrganization != "" {
+		headers[textproto.CanonicalMIMEHeaderKey("OpenAI-Organization")] = c.OpenAIOrganization
+	}
+	for name, value := range c.OpenAIHeaders {
+		headers[name] = value
+	}
+	return headers
+}
+
+// SetOpenAIBaseURL sets the base URL of the OpenAI-compatible API; an empty
+// URL means OpenAI's own
+func SetOpenAIBaseURL(dataDir string, config *Config, url string) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		c.OpenAIBaseURL = url
+	})
+}
+
+// SetOpenAIModel sets the model used with the OpenAI-compatible API; an
+// empty model means the client's default
+func SetOpenAIModel(dataDir string, config *Config, model string) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		c.OpenAIModel = model
+	})
+}
+
+// SetOpenAIOrganization sets the OpenAI organization requests are billed
+// to; an empty organization means the account's default
+func SetOpenAIOrganization(dataDir string, config *Config, organization string) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		c.OpenAIOrganization = organization
+	})
+}
+
+// SetOpenAIHeader sets an extra header sent with OpenAI requests, or
+// removes it when value is empty
+func SetOpenAIHeader(dataDir string, config *Config, name, value string) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		c.PutOpenAIHeader(name, value)
+	})
+}
+
+// PutOpenAIHeader sets an extra header sent with OpenAI requests, or
+// removes it when value is empty, without saving the config
+func (c *Config) PutOpenAIHeader(name, value string) {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if value == "" {
+		delete(c.OpenAIHeaders, name)
+		if len(c.OpenAIHeaders) == 0 {
+			c.OpenAIHeaders = nil
+		}
+		return
+	}
+	if c.OpenAIHeaders == nil {
+		c.OpenAIHeaders = make(map[string]string)
+	}
+	c.OpenAIHeaders[name] = value
+}
+
+// Setting returns the value of a provider option kept in Settings, or an
+// empty string
+func (c *Config) Setting(name string) string {
+	return c.Settings[name]
+}
+
+// SetSetting sets a provider option kept in Settings, or removes it when
+// value is empty
+func (c *Config) SetSetting(name, value string) {
+	if value == "" {
+		delete(c.Settings, name)
+		if len(c.Settings) == 0 {
+			c.Settings = nil
+		}
+		return
+	}
+	if c.Settings == nil {
+		c.Settings = make(map[string]string)
+	}
+	c.Settings[name] = value
 }
 
 // SetAIProvider sets the AI provider in the config
 func SetAIProvider(dataDir string, config *Config, provider string) error {
-	config.AIProvider = provider
-	return SaveConfig(dataDir, config)
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		c.AIProvider = provider
+	})
+}
+
+// FallbackProviders returns the providers to try, in order, when the
+// configured one fails
+func (c *Config) FallbackProviders() []string {
+	if c.AIFallback == nil {
+		return []string{ProviderOllama}
+	}
+	return *c.AIFallback
+}
+
+// SetAIFallback sets the providers tried when the configured one fails.
+// nil restores the default.
+func SetAIFallback(dataDir string, config *Config, providers []string) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		if providers == nil {
+			c.AIFallback = nil
+			return
+		}
+		list := append([]string{}, providers...)
+		c.AIFallback = &list
+	})
 }
 
 // SetOllamaSettings sets Ollama URL and model in the config
 func SetOllamaSettings(dataDir string, config *Config, url string, model string) error {
-	if url != "" {
-		config.OllamaURL = url
-	}
-	if model != "" {
-		config.OllamaModel = model
-	}
-	return SaveConfig(dataDir, config)
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		if url != "" {
+			c.OllamaURL = url
+		}
+		if model != "" {
+			c.OllamaModel = model
+		}
+	})
+}
+
+// SetShell sets the shell used to execute commands in the config
+func SetShell(dataDir string, config *Config, shell string) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		c.Shell = shell
+	})
+}
+
+// SetShareHistory sets whether history is shared live between sessions
+func SetShareHistory(dataDir string, config *Config, share bool) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		c.ShareHistory = share
+	})
+}
+
+// SetContextConfig changes which context items are sent to the AI
+func SetContextConfig(dataDir string, config *Config, change func(*ContextConfig)) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		settings := c.ContextSettings()
+		change(&settings)
+		c.Context = &settings
+	})
+}
+
+// SetCacheConfig changes the cache settings, starting from the defaults
+// when they haven't been set
+func SetCacheConfig(dataDir string, config *Config, change func(*CacheConfig)) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		settings := c.CacheSettings()
+		change(&settings)
+		c.Cache = &settings
+	})
+}
+
+// SetGuardConfig changes the guard settings, starting from the defaults
+// when they haven't been set
+func SetGuardConfig(dataDir string, config *Config, change func(*GuardConfig)) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		settings := c.GuardSettings()
+		change(&settings)
+		c.Guard = &settings
+	})
+}
+
+// SetRedactPatterns sets the user patterns for secrets to mask
+func SetRedactPatterns(dataDir string, config *Config, patterns []string) error {
+	return UpdateConfig(dataDir, config, func(c *Config) {
+		if len(patterns) == 0 {
+			c.RedactPatterns = nil
+			return
+		}
+		c.RedactPatterns = append([]string{}, patterns...)
+	})
 }
@@ -3,6 +3,7 @@ package storage
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -273,4 +274,270 @@ func TestConfigFunctions(t *testing.T) {
 				loadedConfig.OllamaModel, "llama3")
 		}
 	})
+
+	// Test SetShell
+	t.Run("SetShell", func(t *testing.T) {
+		config := &Config{}
+
+		if err := SetShell(tempDir, config, ShellZsh); err != nil {
+			t.Fatalf("Failed to set shell: %v", err)
+		}
+
+		if config.Shell != ShellZsh {
+			t.Errorf("Config shell not updated. Got: %s, Expected: %s", config.Shell, ShellZsh)
+		}
+
+		// Verify by loading the config
+		loadedConfig, err := LoadConfig(tempDir)
+		if err != nil {
+			t.Fatalf("Failed to load config after setting shell: %v", err)
+		}
+
+		if loadedConfig.Shell != ShellZsh {
+			t.Errorf("Loaded config shell doesn't match. Got: %s, Expected: %s", loadedConfig.Shell, ShellZsh)
+		}
+	})
+
+	t.Run("SetContextConfig", func(t *testing.T) {
+		dir := t.TempDir()
+		config, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+
+		// Everything is on by default
+		if config.ContextSettings() != DefaultContextConfig() {
+			t.Errorf("Expected default context settings, got %+v", config.ContextSettings())
+		}
+
+		if err := SetContextConfig(dir, config, func(c *ContextConfig) {
+			c.Listing = false
+			c.History = 10
+		}); err != nil {
+			t.Fatalf("Failed to set context config: %v", err)
+		}
+
+		loadedConfig, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		expected := DefaultContextConfig()
+		expected.Listing = false
+		expected.History = 10
+		if loadedConfig.ContextSettings() != expected || config.ContextSettings() != expected {
+			t.Errorf("Expected %+v, got %+v", expected, loadedConfig.ContextSettings())
+		}
+	})
+
+	t.Run("SetOpenAISettings", func(t *testing.T) {
+		dir := t.TempDir()
+		config, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+
+		if err := SetOpenAIBaseURL(dir, config, "http://localhost:8080/v1"); err != nil {
+			t.Fatalf("Failed to set base URL: %v", err)
+		}
+		if err := SetOpenAIModel(dir, config, "gpt-4o"); err != nil {
+			t.Fatalf("Failed to set model: %v", err)
+		}
+		if err := SetOpenAIOrganization(dir, config, "org-123"); err != nil {
+			t.Fatalf("Failed to set organization: %v", err)
+		}
+		if err := SetOpenAIHeader(dir, config, "x-gateway-team", "shell"); err != nil {
+			t.Fatalf("Failed to set header: %v", err)
+		}
+		if err := SetOpenAIHeader(dir, config, "api-key", "secret"); err != nil {
+			t.Fatalf("Failed to set header: %v", err)
+		}
+		if err := SetOpenAIHeader(dir, config, "Api-Key", ""); err != nil {
+			t.Fatalf("Failed to remove header: %v", err)
+		}
+
+		loadedConfig, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if loadedConfig.OpenAIBaseURL != "http://localhost:8080/v1" || loadedConfig.OpenAIModel != "gpt-4o" || loadedConfig.OpenAIOrganization != "org-123" {
+			t.Errorf("Unexpected OpenAI settings: %+v", loadedConfig)
+		}
+
+		headers := loadedConfig.OpenAIRequestHeaders()
+		expected := map[string]string{"X-Gateway-Team": "shell", "Openai-Organization": "org-123"}
+		if len(headers) != len(expected) {
+			t.Errorf("Expected headers %v, got %v", expected, headers)
+		}
+		for name, value := range expected {
+			if headers[name] != value {
+				t.Errorf("Expected header %s: %s, got %v", name, value, headers)
+			}
+		}
+
+		// Removing the last header clears the setting
+		if err := SetOpenAIHeader(dir, config, "X-Gateway-Team", ""); err != nil {
+			t.Fatalf("Failed to remove header: %v", err)
+		}
+		if config.OpenAIHeaders != nil {
+			t.Errorf("Expected no headers, got %v", config.OpenAIHeaders)
+		}
+	})
+
+	// Test that updates from separate sessions don't overwrite each other
+	t.Run("ProviderSettings", func(t *testing.T) {
+		dir := t.TempDir()
+		config, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+
+		if err := UpdateConfig(dir, config, func(c *Config) {
+			c.SetSetting("exec_command", "llm -m mistral")
+			c.SetSetting("anthropic_model", "claude-test")
+		}); err != nil {
+			t.Fatalf("Failed to update config: %v", err)
+		}
+		if err := UpdateConfig(dir, config, func(c *Config) {
+			c.SetSetting("anthropic_model", "")
+		}); err != nil {
+			t.Fatalf("Failed to update config: %v", err)
+		}
+
+		loadedConfig, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if loadedConfig.Setting("exec_command") != "llm -m mistral" || len(loadedConfig.Settings) != 1 {
+			t.Errorf("Expected only exec_command to be kept, got %v", loadedConfig.Settings)
+		}
+		if loadedConfig.Setting("missing") != "" {
+			t.Errorf("Expected an unset option to be empty, got %q", loadedConfig.Setting("missing"))
+		}
+	})
+
+	t.Run("SetAIFallback", func(t *testing.T) {
+		dir := t.TempDir()
+		config, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if got := config.FallbackProviders(); len(got) != 1 || got[0] != ProviderOllama {
+			t.Errorf("Expected Ollama as the default fallback, got %v", got)
+		}
+
+		tests := []struct {
+			providers []string
+			expected  []string
+		}{
+			{[]string{"anthropic", "ollama"}, []string{"anthropic", "ollama"}},
+			{[]string{}, []string{}},
+			{nil, []string{ProviderOllama}},
+		}
+		for _, test := range tests {
+			if err := SetAIFallback(dir, config, test.providers); err != nil {
+				t.Fatalf("Failed to set fallback: %v", err)
+			}
+			loadedConfig, err := LoadConfig(dir)
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if got := loadedConfig.FallbackProviders(); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("Expected fallback %v after saving %v, got %v", test.expected, test.providers, got)
+			}
+		}
+	})
+
+	t.Run("SetRedactPatterns", func(t *testing.T) {
+		dir := t.TempDir()
+		config, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+
+		patterns := []string{`ACME-[0-9]{6}`}
+		if err := SetRedactPatterns(dir, config, patterns); err != nil {
+			t.Fatalf("Failed to set patterns: %v", err)
+		}
+		patterns[0] = "changed"
+		loadedConfig, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if len(loadedConfig.RedactPatterns) != 1 || loadedConfig.RedactPatterns[0] != `ACME-[0-9]{6}` {
+			t.Errorf("Expected the saved pattern, got %v", loadedConfig.RedactPatterns)
+		}
+
+		if err := SetRedactPatterns(dir, config, nil); err != nil {
+			t.Fatalf("Failed to clear patterns: %v", err)
+		}
+		if loadedConfig, _ := LoadConfig(dir); loadedConfig.RedactPatterns != nil {
+			t.Errorf("Expected no patterns, got %v", loadedConfig.RedactPatterns)
+		}
+	})
+
+	t.Run("SetGuardConfig", func(t *testing.T) {
+		dir := t.TempDir()
+		config, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if settings := config.GuardSettings(); settings.Policy != nil || settings.Rules != nil {
+			t.Errorf("Expected empty guard settings by default, got %+v", settings)
+		}
+
+		if err := SetGuardConfig(dir, config, func(g *GuardConfig) {
+			g.Policy = map[string]string{"high": "block"}
+			g.Rules = append(g.Rules, GuardRule{Pattern: `terraform\s+destroy`, Level: "high", Reason: "destroys infrastructure"})
+		}); err != nil {
+			t.Fatalf("Failed to set guard config: %v", err)
+		}
+		loadedConfig, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		settings := loadedConfig.GuardSettings()
+		if settings.Policy["high"] != "block" || len(settings.Rules) != 1 || settings.Rules[0].Reason != "destroys infrastructure" {
+			t.Errorf("Expected the saved guard settings, got %+v", settings)
+		}
+	})
+
+	t.Run("UpdateConfigMergesSessions", func(t *testing.T) {
+		dir := t.TempDir()
+		first, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		second, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+
+		if err := SetShell(dir, first, ShellBash); err != nil {
+			t.Fatalf("Failed to set shell: %v", err)
+		}
+		if err := SetOllamaSettings(dir, second, "", "mistral"); err != nil {
+			t.Fatalf("Failed to set Ollama model: %v", err)
+		}
+
+		loadedConfig, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if loadedConfig.Shell != ShellBash || loadedConfig.OllamaModel != "mistral" {
+			t.Errorf("Expected both changes to be kept, got shell %q and model %q",
+				loadedConfig.Shell, loadedConfig.OllamaModel)
+		}
+
+		// The session that saved last also sees the other session's change
+		if second.Shell != ShellBash {
+			t.Errorf("Expected in-memory config to be refreshed, got shell %q", second.Shell)
+		}
+
+		if err := SetShareHistory(dir, first, true); err != nil {
+			t.Fatalf("Failed to set share history: %v", err)
+		}
+		if !first.ShareHistory || first.OllamaModel != "mistral" {
+			t.Errorf("Unexpected config after update: %+v", first)
+		}
+	})
 }
@@ -26,9 +26,14 @@ func NewFileStorage() (*FileStorage, error) {
 		return nil, err
 	}
 
-	// Create a directory for our app if it doesn't exist
-	dataDir := filepath.Join(usr.HomeDir, ".budy")
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	return NewFileStorageWithDir(filepath.Join(usr.HomeDir, ".budy"))
+}
+
+// NewFileStorageWithDir creates a new file storage rooted at dataDir
+func NewFileStorageWithDir(dataDir string) (*FileStorage, error) {
+	// Create a directory for our app if it doesn't exist, private as it
+	// holds conversations and command history
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return nil, err
 	}
 
@@ -52,7 +57,7 @@ func (s *FileStorage) Save(key string, data interface{}) error {
 
 	// Write to file
 	filePath := filepath.Join(s.dataDir, key+".json")
-	return os.WriteFile(filePath, jsonData, 0644)
+	return os.WriteFile(filePath, jsonData, 0600)
 }
 
 // Load retrieves data stored under the given key
@@ -51,6 +51,7 @@ func TestFileStorage(t *testing.T) {
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		t.Errorf("Expected file %s to exist", filePath)
 	}
+	checkMode(t, filePath, 0600)
 
 	// Verify file contents
 	fileData, err := os.ReadFile(filePath)
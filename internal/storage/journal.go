@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// maxJournalLine is the longest line a journal will read
+const maxJournalLine = 1024 * 1024
+
+// JournalStorage is implemented by storages that can keep append-only
+// journals next to regular keys
+type JournalStorage interface {
+	Storage
+	Journal(key string) *Journal
+	Archive(key string) error
+}
+
+// Journal is an append-only file holding one JSON value per line. Each
+// append is a single write to a file opened with O_APPEND, so records are
+// never interleaved and a crash can at worst leave a truncated last line,
+// which readers skip.
+type Journal struct {
+	path string
+}
+
+// Ensure FileStorage implements the JournalStorage interface
+var _ JournalStorage = (*FileStorage)(nil)
+
+// Journal returns the journal stored under the given key
+func (s *FileStorage) Journal(key string) *Journal {
+	return &Journal{
+		path: filepath.Join(s.dataDir, key+".jsonl"),
+	}
+}
+
+// Archive renames the file stored under the given key to a .bak file so
+// it is no longer loaded
+func (s *FileStorage) Archive(key string) error {
+	filePath := filepath.Join(s.dataDir, key+".json")
+	return os.Rename(filePath, filePath+".bak")
+}
+
+// Path returns the journal file path
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Exists reports whether the journal file exists
+func (j *Journal) Exists() bool {
+	_, err := os.Stat(j.path)
+	return err == nil
+}
+
+// Append adds a value to the end of the journal
+func (j *Journal) Append(value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	// Hold the lock so an append never lands in a journal that another
+	// process is about to replace
+	unlock, err := lockFile(j.lockPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Write the whole record in one call so concurrent appends stay intact
+	if _, err := file.Write(line); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadAll calls fn with every complete line of the journal in order and
+// returns the number of lines read. A missing journal has no lines.
+func (j *Journal) ReadAll(fn func(line []byte) error) (int, error) {
+	_, lines, err := j.ReadFrom(0, fn)
+	return lines, err
+}
+
+// ReadFrom calls fn with every complete line starting at byte offset and
+// returns the offset just past the last complete line, so callers can
+// follow the journal as other processes append to it. A trailing line
+// without a newline is left for the next read. Lines longer than
+// maxJournalLine are counted and skipped without calling fn.
+func (j *Journal) ReadFrom(offset int64, fn func(line []byte) error) (int64, int, error) {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return offset, 0, nil
+	}
+	if err != nil {
+		return offset, 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, 0, err
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	lines := 0
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Long line: collect the rest of it
+			long := append([]byte(nil), line...)
+			for err == bufio.ErrBufferFull && len(long) <= maxJournalLine {
+				line, err = reader.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if err == bufio.ErrBufferFull {
+			// Too long to keep: skip to the end of the line
+			skipped := int64(len(line))
+			for err == bufio.ErrBufferFull {
+				line, err = reader.ReadSlice('\n')
+				skipped += int64(len(line))
+			}
+			if err != nil {
+				return offset, lines, ignoreEOF(err)
+			}
+			offset += skipped
+			lines++
+			continue
+		}
+		if err != nil {
+			return offset, lines, ignoreEOF(err)
+		}
+
+		offset += int64(len(line))
+		lines++
+		if err := fn(line[:len(line)-1]); err != nil {
+			return offset, lines, err
+		}
+	}
+}
+
+// ignoreEOF returns err, or nil when it only marks the end of the file
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Stat returns information about the journal file. Comparing results with
+// os.SameFile tells whether another process has replaced the journal.
+func (j *Journal) Stat() (os.FileInfo, error) {
+	return os.Stat(j.path)
+}
+
+// Rewrite atomically replaces the journal contents with the given values
+func (j *Journal) Rewrite(values []interface{}) error {
+	return j.Compact(func() ([]interface{}, error) {
+		return values, nil
+	})
+}
+
+// Compact atomically replaces the journal contents with the values
+// returned by build. The journal is locked while build runs, so it can
+// re-read the journal knowing no other process will append meanwhile.
+// The new contents are written to a temporary file and renamed into
+// place, so readers see either the old or the new journal, never a
+// partial one.
+func (j *Journal) Compact(build func() ([]interface{}, error)) error {
+	unlock, err := lockFile(j.lockPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	values, err := build()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// Clean up the temporary file on any failure
+	fail := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, value := range values {
+		if err := encoder.Encode(value); err != nil {
+			return fail(fmt.Errorf("encoding journal record: %w", err))
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// lockPath returns the path of the lock file guarding the journal
+func (j *Journal) lockPath() string {
+	return j.path + ".lock"
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkMode checks that the file at path has mode perm
+func checkMode(t *testing.T, path string, perm os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	if mode := info.Mode().Perm(); mode != perm {
+		t.Errorf("Expected %s to have mode %04o, got %04o", path, perm, mode)
+	}
+}
+
+// TestJournal tests appending to and reading from a journal
+func TestJournal(t *testing.T) {
+	storage, err := NewFileStorageWithDir(filepath.Join(t.TempDir(), "data"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	checkMode(t, storage.GetDataDir(), 0700)
+
+	type record struct {
+		N int `json:"n"`
+	}
+
+	journal := storage.Journal("events")
+	if journal.Path() != filepath.Join(storage.GetDataDir(), "events.jsonl") {
+		t.Errorf("Unexpected journal path %s", journal.Path())
+	}
+	if journal.Exists() {
+		t.Error("Expected new journal not to exist")
+	}
+
+	// Reading a missing journal yields nothing
+	lines, err := journal.ReadAll(func(line []byte) error {
+		t.Errorf("Unexpected line %s", line)
+		return nil
+	})
+	if err != nil || lines != 0 {
+		t.Errorf("Expected no lines and no error, got %d, %v", lines, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := journal.Append(record{N: i}); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+
+	read := func() []int {
+		var values []int
+		if _, err := journal.ReadAll(func(line []byte) error {
+			var r record
+			if err := json.Unmarshal(line, &r); err != nil {
+				return nil
+			}
+			values = append(values, r.N)
+			return nil
+		}); err != nil {
+			t.Fatalf("Failed to read journal: %v", err)
+		}
+		return values
+	}
+
+	if values := read(); len(values) != 3 || values[0] != 1 || values[2] != 3 {
+		t.Errorf("Expected [1 2 3], got %v", values)
+	}
+	checkMode(t, journal.Path(), 0600)
+
+	// Rewrite replaces the contents atomically
+	if err := journal.Rewrite([]interface{}{record{N: 7}, record{N: 8}}); err != nil {
+		t.Fatalf("Failed to rewrite: %v", err)
+	}
+	if values := read(); len(values) != 2 || values[0] != 7 || values[1] != 8 {
+		t.Errorf("Expected [7 8] after rewrite, got %v", values)
+	}
+	checkMode(t, journal.Path(), 0600)
+
+	// No temporary files are left behind
+	matches, _ := filepath.Glob(filepath.Join(storage.GetDataDir(), "*.tmp*"))
+	if len(matches) != 0 {
+		t.Errorf("Temporary files left behind: %v", matches)
+	}
+
+	// A truncated last line, as left by a crash, does not hide earlier records
+	file, err := os.OpenFile(journal.Path(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	if _, err := file.WriteString(`{"n":`); err != nil {
+		t.Fatalf("Failed to write partial record: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to close journal: %v", err)
+	}
+	if err := journal.Append(record{N: 9}); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if values := read(); len(values) != 2 || values[1] != 8 {
+		t.Errorf("Expected intact records before the damaged line, got %v", values)
+	}
+}
+
+// TestJournalLongLines tests that long lines are read and that lines too
+// long to read don't hide the ones after them
+func TestJournalLongLines(t *testing.T) {
+	storage, err := NewFileStorageWithDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	type record struct {
+		S string `json:"s"`
+	}
+	journal := storage.Journal("events")
+	long := strings.Repeat("x", 100*1024)
+	for _, value := range []string{long, strings.Repeat("y", 2*maxJournalLine), "after", "last"} {
+		if err := journal.Append(record{S: value}); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+
+	var values []string
+	lines, err := journal.ReadAll(func(line []byte) error {
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			t.Errorf("Unexpected line of %d bytes", len(line))
+			return nil
+		}
+		values = append(values, r.S)
+		return nil
+	})
+	if err != nil || lines != 4 {
+		t.Fatalf("Expected 4 lines and no error, got %d, %v", lines, err)
+	}
+	if len(values) != 3 || values[0] != long || values[1] != "after" || values[2] != "last" {
+		t.Errorf("Expected the long line and the entries after the oversized one, got %d values", len(values))
+	}
+}
+
+// TestArchive tests moving a stored key out of the way
+func TestArchive(t *testing.T) {
+	storage, err := NewFileStorageWithDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if err := storage.Save("old", []string{"a"}); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	if err := storage.Archive("old"); err != nil {
+		t.Fatalf("Failed to archive: %v", err)
+	}
+
+	var data []string
+	if err := storage.Load("old", &data); !os.IsNotExist(err) {
+		t.Errorf("Expected archived key to be gone, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storage.GetDataDir(), "old.json.bak")); err != nil {
+		t.Errorf("Expected backup file: %v", err)
+	}
+}
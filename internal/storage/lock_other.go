@@ -0,0 +1,8 @@
+//go:build !unix
+
+package storage
+
+// lockFile is a no-op on platforms without flock
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
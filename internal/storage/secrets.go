@@ -0,0 +1,458 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretsFileName is the file holding API keys and other secrets, apart
+// from the rest of the configuration so that config.json can be shared or
+// shown without them
+const secretsFileName = "secrets.json"
+
+// openAIKeySecret is the name the OpenAI API key is saved under
+const openAIKeySecret = "openai_api_key"
+
+// openAIHeaderSecret prefixes the names extra OpenAI request headers are
+// saved under. Their values are often credentials, such as an Azure
+// api-key or an Authorization header.
+const openAIHeaderSecret = "openai_header:"
+
+// secretsKDF names the key derivation used for encrypted secrets
+const secretsKDF = "pbkdf2-sha256"
+
+// secretKeyIterations is the number of PBKDF2 rounds used for new
+// passphrases. Tests lower it to run quickly.
+var secretKeyIterations = 600000
+
+var (
+	// ErrSecretsLocked is returned when secrets are encrypted and the
+	// passphrase hasn't been given this session
+	ErrSecretsLocked = errors.New("secrets are encrypted; unlock them with your passphrase first")
+
+	// ErrWrongPassphrase is returned when a passphrase doesn't decrypt
+	// the secrets
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// secretsFile is the contents of the secrets file. Values holds the
+// secrets in plain text unless they are encrypted.
+type secretsFile struct {
+	Values    map[string]string `json:"values,omitempty"`
+	Encrypted *sealedSecrets    `json:"encrypted,omitempty"`
+}
+
+// sealedSecrets is the secrets encrypted with AES-GCM, under a key derived
+// from a passphrase
+type sealedSecrets struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// secretKey is a key derived from a passphrase, with the salt and rounds
+// it was derived with
+type secretKey struct {
+	salt       []byte
+	iterations int
+	key        []byte
+}
+
+var (
+	secretsMu sync.Mutex
+
+	// secretSettings are the provider options in Settings that hold secrets
+	secretSettings = make(map[string]bool)
+
+	// unlocked holds the keys derived from passphrases this session, by
+	// data directory
+	unlocked = make(map[string]*secretKey)
+)
+
+// RegisterSecretSetting marks a provider option kept in Settings as a
+// secret, so that it is saved in the secrets file rather than config.json
+func RegisterSecretSetting(name string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secretSettings[name] = true
+}
+
+// isSecretSetting reports whether a provider option holds a secret
+func isSecretSetting(name string) bool {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	return secretSettings[name]
+}
+
+// unlockedKey returns the key given for dataDir this session, if any
+func unlockedKey(dataDir string) *secretKey {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	return unlocked[dataDir]
+}
+
+// setUnlockedKey remembers the key for dataDir, or forgets it when nil
+func setUnlockedKey(dataDir string, key *secretKey) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if key == nil {
+		delete(unlocked, dataDir)
+		return
+	}
+	unlocked[dataDir] = key
+}
+
+// splitSecrets returns the secrets in config, and a copy of config
+// without them for writing to config.json
+func splitSecrets(config *Config) (map[string]string, *Config) {
+	values := make(map[string]string)
+	public := *config
+	if config.OpenAIAPIKey != "" {
+		values[openAIKeySecret] = config.OpenAIAPIKey
+		public.OpenAIAPIKey = ""
+	}
+	for name, value := range config.OpenAIHeaders {
+		values[openAIHeaderSecret+name] = value
+	}
+	public.OpenAIHeaders = nil
+
+	public.Settings = nil
+	for name, value := range config.Settings {
+		if isSecretSetting(name) {
+			values[name] = value
+			continue
+		}
+		if public.Settings == nil {
+			public.Settings = make(map[string]string)
+		}
+		public.Settings[name] = value
+	}
+	return values, &public
+}
+
+// applySecrets puts secrets read from the secrets file into config
+func applySecrets(config *Config, values map[string]string) {
+	for name, value := range values {
+		if name == openAIKeySecret {
+			config.OpenAIAPIKey = value
+			continue
+		}
+		if header, ok := strings.CutPrefix(name, openAIHeaderSecret); ok {
+			config.PutOpenAIHeader(header, value)
+			continue
+		}
+		config.SetSetting(name, value)
+	}
+}
+
+// readSecretsFile reads the secrets file, which may not exist yet
+func readSecretsFile(dataDir string) (*secretsFile, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, secretsFileName))
+	if os.IsNotExist(err) {
+		return &secretsFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file secretsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", secretsFileName, err)
+	}
+	return &file, nil
+}
+
+// loadSecrets returns the secrets saved for dataDir, decrypting them with
+// the key given this session when they are encrypted
+func loadSecrets(dataDir string) (map[string]string, error) {
+	file, err := readSecretsFile(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if file.Encrypted == nil {
+		return file.Values, nil
+	}
+
+	key := unlockedKey(dataDir)
+	if key == nil {
+		return nil, ErrSecretsLocked
+	}
+	return file.Encrypted.open(key.key)
+}
+
+// saveSecrets writes the secrets for dataDir, encrypted when a passphrase
+// was given this session. While encrypted secrets are locked they are left
+// as they are, and new secrets can't be saved.
+func saveSecrets(dataDir string, values map[string]string) error {
+	key := unlockedKey(dataDir)
+	if key == nil && SecretsEncrypted(dataDir) {
+		if len(values) > 0 {
+			return ErrSecretsLocked
+		}
+		return nil
+	}
+	if key == nil && len(values) == 0 {
+		if _, err := os.Stat(filepath.Join(dataDir, secretsFileName)); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	return writeSecretsFile(dataDir, values, key)
+}
+
+// writeSecretsFile writes the secrets file readable only by the user,
+// sealing the secrets with key unless it is nil
+func writeSecretsFile(dataDir string, values map[string]string, key *secretKey) error {
+	file := secretsFile{Values: values}
+	if key != nil {
+		sealed, err := seal(values, key)
+		if err != nil {
+			return err
+		}
+		file = secretsFile{Encrypted: sealed}
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(filepath.Join(dataDir, secretsFileName), data)
+}
+
+// writePrivateFile writes a file readable only by the user, atomically
+func writePrivateFile(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// deriveKey derives an AES-256 key from a passphrase
+func deriveKey(passphrase string, salt []byte, iterations int) (*secretKey, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &secretKey{salt: salt, iterations: iterations, key: key}, nil
+}
+
+// newSecretKey derives a key from a passphrase with a fresh salt
+func newSecretKey(passphrase string) (*secretKey, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return deriveKey(passphrase, salt, secretKeyIterations)
+}
+
+// seal encrypts secrets with a key
+func seal(values map[string]string, key *secretKey) (*sealedSecrets, error) {
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &sealedSecrets{
+		KDF:        secretsKDF,
+		Iterations: key.iterations,
+		Salt:       key.salt,
+		Nonce:      nonce,
+		Data:       gcm.Seal(nil, nonce, plaintext, []byte(secretsKDF)),
+	}, nil
+}
+
+// open decrypts sealed secrets with a key
+func (s *sealedSecrets) open(key []byte) (map[string]string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%s is damaged", secretsFileName)
+	}
+	plaintext, err := gcm.Open(nil, s.Nonce, s.Data, []byte(s.KDF))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// newGCM creates an AES-GCM cipher for a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SecretsEncrypted reports whether the secrets in dataDir are encrypted
+// with a passphrase
+func SecretsEncrypted(dataDir string) bool {
+	file, err := readSecretsFile(dataDir)
+	return err == nil && file.Encrypted != nil
+}
+
+// SecretsLocked reports whether the secrets in dataDir are encrypted and
+// the passphrase hasn't been given this session
+func SecretsLocked(dataDir string) bool {
+	return SecretsEncrypted(dataDir) && unlockedKey(dataDir) == nil
+}
+
+// Unlock checks the passphrase for encrypted secrets and remembers the key
+// for the rest of the session. Call it before LoadConfig so the config
+// includes the secrets.
+func Unlock(dataDir, passphrase string) error {
+	file, err := readSecretsFile(dataDir)
+	if err != nil || file.Encrypted == nil {
+		return err
+	}
+	if file.Encrypted.KDF != secretsKDF {
+		return fmt.Errorf("%s uses an unknown key derivation %q", secretsFileName, file.Encrypted.KDF)
+	}
+
+	key, err := deriveKey(passphrase, file.Encrypted.Salt, file.Encrypted.Iterations)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Encrypted.open(key.key); err != nil {
+		return err
+	}
+	setUnlockedKey(dataDir, key)
+	return nil
+}
+
+// EncryptSecrets encrypts the secrets with a key derived from passphrase,
+// or changes the passphrase when they are already encrypted and unlocked
+func EncryptSecrets(dataDir string, config *Config, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("the passphrase can't be empty")
+	}
+	key, err := newSecretKey(passphrase)
+	if err != nil {
+		return err
+	}
+	return rewriteSecrets(dataDir, config, key)
+}
+
+// DecryptSecrets stores the secrets unencrypted again, still readable only
+// by the user
+func DecryptSecrets(dataDir string, config *Config) error {
+	return rewriteSecrets(dataDir, config, nil)
+}
+
+// rewriteSecrets writes the secrets again with a new key, or unencrypted
+// when key is nil
+func rewriteSecrets(dataDir string, config *Config, key *secretKey) error {
+	if SecretsLocked(dataDir) {
+		return ErrSecretsLocked
+	}
+
+	unlock, err := lockFile(filepath.Join(dataDir, "config.json.lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := LoadConfig(dataDir)
+	if err != nil {
+		return err
+	}
+	values, _ := splitSecrets(current)
+	if err := writeSecretsFile(dataDir, values, key); err != nil {
+		return err
+	}
+	setUnlockedKey(dataDir, key)
+
+	// Move any secrets still in config.json over as well
+	if err := writeConfig(dataDir, current); err != nil {
+		return err
+	}
+	*config = *current
+	return nil
+}
+
+// SecretNames returns the names of the secrets that are set, sorted
+func (c *Config) SecretNames() []string {
+	values, _ := splitSecrets(c)
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MigrateSecrets moves secrets saved in config.json by older versions into
+// the secrets file, and reports whether there were any
+func MigrateSecrets(dataDir string, config *Config) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, "config.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var saved Config
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return false, err
+	}
+	if values, _ := splitSecrets(&saved); len(values) == 0 {
+		return false, nil
+	}
+
+	// Rewriting the config splits the secrets out
+	return true, UpdateConfig(dataDir, config, func(*Config) {})
+}
+
+// CheckPermissions returns a warning for each file in dataDir holding
+// configuration or secrets that other users can read or change
+func CheckPermissions(dataDir string) []string {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	var warnings []string
+	for _, name := range []string{secretsFileName, "config.json"} {
+		path := filepath.Join(dataDir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if mode := info.Mode().Perm(); mode&0077 != 0 {
+			warnings = append(warnings, fmt.Sprintf("%s can be accessed by other users (mode %04o); fix it with 'chmod 600 %s'", path, mode, path))
+		}
+	}
+	return warnings
+}
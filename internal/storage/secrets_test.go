@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// Keep key derivation quick in tests
+	secretKeyIterations = 1000
+	RegisterSecretSetting("test_key")
+}
+
+// readFile returns the contents of a file in dir
+func readFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestSecretsFile(t *testing.T) {
+	dir := t.TempDir()
+	config, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if err := UpdateConfig(dir, config, func(c *Config) {
+		c.OpenAIAPIKey = "sk-openai-secret"
+		c.SetSetting("test_key", "sk-test-secret")
+		c.SetSetting("test_model", "big")
+		c.PutOpenAIHeader("api-key", "azure-secret")
+	}); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	public := readFile(t, dir, "config.json")
+	if strings.Contains(public, "secret") || !strings.Contains(public, "test_model") {
+		t.Errorf("Expected config.json without secrets, got %s", public)
+	}
+	if secrets := readFile(t, dir, secretsFileName); !strings.Contains(secrets, "sk-openai-secret") || !strings.Contains(secrets, "sk-test-secret") || !strings.Contains(secrets, "azure-secret") {
+		t.Errorf("Expected the secrets in %s, got %s", secretsFileName, secrets)
+	}
+	for _, name := range []string{"config.json", secretsFileName} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("Expected %s to have mode 0600, got %04o", name, mode)
+		}
+	}
+	if warnings := CheckPermissions(dir); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+
+	loaded, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loaded.OpenAIAPIKey != "sk-openai-secret" || loaded.Setting("test_key") != "sk-test-secret" || loaded.Setting("test_model") != "big" || loaded.OpenAIHeaders["Api-Key"] != "azure-secret" {
+		t.Errorf("Expected the secrets to be loaded, got %+v", loaded)
+	}
+	if names := strings.Join(loaded.SecretNames(), ","); names != "openai_api_key,openai_header:Api-Key,test_key" {
+		t.Errorf("Expected the secret names, got %s", names)
+	}
+}
+
+func TestEncryptSecrets(t *testing.T) {
+	dir := t.TempDir()
+	config, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := SetOpenAIKey(dir, config, "sk-openai-secret"); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	if err := EncryptSecrets(dir, config, "correct horse"); err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if secrets := readFile(t, dir, secretsFileName); strings.Contains(secrets, "sk-openai-secret") {
+		t.Errorf("Expected the secrets to be encrypted, got %s", secrets)
+	}
+	if !SecretsEncrypted(dir) || SecretsLocked(dir) {
+		t.Error("Expected the secrets to be encrypted and unlocked")
+	}
+
+	// A new session starts locked, without the secrets
+	setUnlockedKey(dir, nil)
+	locked, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !SecretsLocked(dir) || locked.OpenAIAPIKey != "" {
+		t.Errorf("Expected locked secrets, got key %q", locked.OpenAIAPIKey)
+	}
+
+	// Other settings can still change, and secrets can't
+	if err := SetShell(dir, locked, ShellBash); err != nil {
+		t.Errorf("Expected settings to be saved while locked, got %v", err)
+	}
+	if err := SetOpenAIKey(dir, locked, "sk-other"); !errors.Is(err, ErrSecretsLocked) {
+		t.Errorf("Expected ErrSecretsLocked, got %v", err)
+	}
+
+	if err := Unlock(dir, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+	if err := Unlock(dir, "correct horse"); err != nil {
+		t.Fatalf("Failed to unlock: %v", err)
+	}
+	unlocked, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if unlocked.OpenAIAPIKey != "sk-openai-secret" || unlocked.Shell != ShellBash {
+		t.Errorf("Expected the secrets and the new shell, got %+v", unlocked)
+	}
+
+	// Changes made while unlocked stay encrypted
+	if err := SetOpenAIKey(dir, unlocked, "sk-new-secret"); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	if secrets := readFile(t, dir, secretsFileName); strings.Contains(secrets, "sk-new-secret") {
+		t.Errorf("Expected the new key to be encrypted, got %s", secrets)
+	}
+
+	if err := DecryptSecrets(dir, unlocked); err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if SecretsEncrypted(dir) || !strings.Contains(readFile(t, dir, secretsFileName), "sk-new-secret") {
+		t.Error("Expected the secrets to be stored unencrypted")
+	}
+}
+
+func TestMigrateSecrets(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `{"openai_api_key": "sk-legacy-secret", "ai_provider": "openai", "settings": {"test_key": "sk-test"}, "openai_headers": {"Authorization": "Bearer sk-header"}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	if warnings := CheckPermissions(dir); len(warnings) != 1 || !strings.Contains(warnings[0], "chmod 600") {
+		t.Errorf("Expected a warning about config.json, got %v", warnings)
+	}
+
+	config, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	moved, err := MigrateSecrets(dir, config)
+	if err != nil || !moved {
+		t.Fatalf("Expected the secrets to be moved, got %v, %v", moved, err)
+	}
+	if public := readFile(t, dir, "config.json"); strings.Contains(public, "sk-") {
+		t.Errorf("Expected config.json without secrets, got %s", public)
+	}
+	if config.OpenAIAPIKey != "sk-legacy-secret" || config.Setting("test_key") != "sk-test" || config.OpenAIHeaders["Authorization"] != "Bearer sk-header" || config.AIProvider != "openai" {
+		t.Errorf("Expected the config to be unchanged, got %+v", config)
+	}
+	if warnings := CheckPermissions(dir); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+
+	if moved, err := MigrateSecrets(dir, config); err != nil || moved {
+		t.Errorf("Expected nothing left to move, got %v, %v", moved, err)
+	}
+}
@@ -53,7 +53,7 @@ func GetDataDir() (string, error) {
 	}
 
 	dataDir := filepath.Join(usr.HomeDir, ".budy")
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return "", err
 	}
 